@@ -3,22 +3,26 @@ package benchmark
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"math"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/squarefactory/benchmark-api/try"
 )
 
 const (
-	User        = "root"
-	GBtoMB      = 1000
-	JobName     = "HPL-Benchmark"
-	DatFilePath = "hpl.dat"
+	User    = "root"
+	GBtoMB  = 1000
+	JobName = "HPL-Benchmark"
 )
 
 var benchmarkMemoryUsePercentage = []float64{
@@ -46,26 +50,140 @@ func NewBenchmark(
 	}
 }
 
-func (b *Benchmark) Run(ctx context.Context, files *BenchmarkFile) error {
+// Run writes the benchmark files in the workspace, submits the benchmark and
+// returns a handle on the submitted job.
+func (b *Benchmark) Run(ctx context.Context, files *BenchmarkFile) (*scheduler.Job, error) {
 
-	if err := os.WriteFile(DatFilePath, []byte(files.DatFile), 0644); err != nil {
-		return err
+	datFile := filepath.Join(b.Sbatch.Workspace, DatFileName)
+	if err := os.WriteFile(datFile, []byte(files.DatFile), 0644); err != nil {
+		return nil, err
+	}
+
+	sbatchFile := filepath.Join(b.Sbatch.Workspace, SbatchFileName)
+	if err := os.WriteFile(sbatchFile, []byte(files.SbatchFile), 0644); err != nil {
+		return nil, err
 	}
 
+	submitTime := time.Now()
 	out, err := b.SlurmClient.Submit(ctx, &scheduler.SubmitRequest{
-		Name: JobName,
-		User: User,
-		Body: files.SbatchFile,
+		Name:   JobName,
+		User:   User,
+		Body:   files.SbatchFile,
+		Output: filepath.Join(b.Sbatch.Workspace, JobOutputName),
+		Files: map[string]string{
+			DatFileName: files.DatFile,
+		},
 	})
 	if err != nil {
 		log.Printf("Failed to run benchmark: %s", err)
-		return err
+		return nil, err
+	}
+
+	// sbatch --parsable prints "jobid" or "jobid;cluster"
+	jobID, err := strconv.Atoi(strings.Split(out, ";")[0])
+	if err != nil {
+		log.Printf("Failed to parse job ID %s: %s", out, err)
+		return nil, err
+	}
+
+	outputFile, err := b.SlurmClient.FindJobOutputFile(ctx, jobID)
+	if err != nil {
+		log.Printf("Failed to find output file of job %d: %s", jobID, err)
+		return nil, err
+	}
+
+	log.Printf("Successfully started benchmark: %d", jobID)
+	return &scheduler.Job{
+		ID:         jobID,
+		Name:       JobName,
+		SubmitTime: submitTime,
+		OutputFile: outputFile,
+	}, nil
+}
+
+// Wait polls the scheduler until the job is no longer queued or running, or
+// the context is done. A failed lookup does not tell that the job is finished,
+// so it is retried as well.
+func (b *Benchmark) Wait(
+	ctx context.Context,
+	job *scheduler.Job,
+	tries int,
+	delay time.Duration,
+) error {
+	_, err := try.DoContext(ctx, func() (int, error) {
+		_, err := b.SlurmClient.FindRunningJobByID(
+			ctx,
+			&scheduler.FindRunningJobByIDRequest{
+				JobID: job.ID,
+				User:  User,
+			},
+		)
+		if errors.Is(err, scheduler.ErrJobNotRunning) {
+			return 0, nil
+		}
+		if err != nil {
+			log.Printf("failed to find benchmark %d: %s", job.ID, err)
+			return 0, err
+		}
+
+		log.Printf("benchmark %d is still running", job.ID)
+		return 0, errors.New("benchmark is still running")
+	}, tries, delay)
+	if err != nil {
+		return fmt.Errorf("job %d did not finish in time: %w", job.ID, err)
 	}
 
-	log.Printf("Successfully started benchmark: %s", out)
 	return nil
 }
 
+// StateTries and StateDelay bound the lookups of the final state of a job.
+var (
+	StateTries = 5
+	StateDelay = 10 * time.Second
+)
+
+// State fetches the final state of a finished job. Accounting may lag behind
+// the queue, still reporting the job as running or completing, so the lookup
+// is retried until the state is final.
+func (b *Benchmark) State(ctx context.Context, job *scheduler.Job) (*scheduler.JobState, error) {
+	state, err := try.DoContext(ctx, func() (*scheduler.JobState, error) {
+		state, err := b.SlurmClient.FindJobState(ctx, job.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !state.Finished() {
+			return nil, fmt.Errorf("job %d is still %s in accounting", job.ID, state.State)
+		}
+		return state, nil
+	}, StateTries, StateDelay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find state of job %d: %w", job.ID, err)
+	}
+
+	return state, nil
+}
+
+// CollectOutput writes the output of a finished job to its output file, when
+// the scheduler does not.
+func (b *Benchmark) CollectOutput(ctx context.Context, job *scheduler.Job) error {
+	collector, ok := b.SlurmClient.(OutputCollector)
+	if !ok {
+		return nil
+	}
+	if err := collector.CollectJobOutput(ctx, job.ID); err != nil {
+		return fmt.Errorf("failed to collect output of job %d: %w", job.ID, err)
+	}
+	return nil
+}
+
+// Cancel cancels the job.
+func (b *Benchmark) Cancel(ctx context.Context, job *scheduler.Job) error {
+	return b.SlurmClient.CancelJob(ctx, &scheduler.CancelRequest{
+		JobID: job.ID,
+		User:  User,
+	})
+}
+
 func (b *Benchmark) GenerateFiles(ctx context.Context) (BenchmarkFile, error) {
 
 	DatFile, err := b.GenerateDAT()
@@ -98,6 +216,16 @@ func (b *Benchmark) GenerateFiles(ctx context.Context) (BenchmarkFile, error) {
 
 func (b *Benchmark) GenerateDAT() (string, error) {
 
+	grids := b.Dat.Grids
+	if len(grids) == 0 {
+		grids = []Grid{{P: b.Dat.P, Q: b.Dat.Q}}
+	}
+	var ps, qs []int
+	for _, grid := range grids {
+		ps = append(ps, grid.P)
+		qs = append(qs, grid.Q)
+	}
+
 	DATTmpl := template.Must(template.New("jobTemplate").Parse(DatTmpl))
 	var DatFile bytes.Buffer
 	if err := DATTmpl.Execute(&DatFile, struct {
@@ -105,15 +233,20 @@ func (b *Benchmark) GenerateDAT() (string, error) {
 		ProblemSize  string
 		NBlockSize   int
 		BlockSize    string
-		P            int
-		Q            int
+		NGrids       int
+		Ps           string
+		Qs           string
+		datKnobsTemplate
 	}{
 		NProblemSize: b.Dat.NProblemSize,
 		ProblemSize:  b.Dat.ProblemSize,
 		NBlockSize:   b.Dat.NBlockSize,
 		BlockSize:    b.Dat.BlockSize,
-		P:            b.Dat.P,
-		Q:            b.Dat.Q,
+		NGrids:       len(grids),
+		Ps:           joinInts(ps),
+		Qs:           joinInts(qs),
+
+		datKnobsTemplate: b.Dat.Knobs.template(),
 	}); err != nil {
 		log.Printf("dat templating failed: %s", err)
 		return "", err
@@ -122,28 +255,51 @@ func (b *Benchmark) GenerateDAT() (string, error) {
 	return DatFile.String(), nil
 }
 
+func (b *Benchmark) templates() JobTemplates {
+	if b.Templates == (JobTemplates{}) {
+		return SlurmTemplates
+	}
+	return b.Templates
+}
+
 func (b *Benchmark) GenerateMultiNodeSBATCH() (string, error) {
 
-	SbatchTmpl := template.Must(template.New("jobTemplate").Parse(MultiNodeTmpl))
+	SbatchTmpl := template.Must(template.New("jobTemplate").Parse(b.templates().MultiNode))
 	var SbatchFile bytes.Buffer
 	if err := SbatchTmpl.Execute(&SbatchFile, struct {
-		ContainerPath string
-		Workspace     string
-		Node          int
-		CpusPerTasks  int
-		GpusPerNode   int
-		NtasksPerNode int
-		GpuAffinity   string
-		CpuAffinity   string
+		ContainerPath    string
+		ContainerRuntime string
+		Workspace        string
+		Node             int
+		CpusPerTasks     int
+		GpusPerNode      int
+		NtasksPerNode    int
+		GpuAffinity      string
+		CpuAffinity      string
+		Partition        string
+		NodeList         string
+		Exclude          string
+		Constraint       string
+		Reservation      string
+		Account          string
+		Power            PowerSampling
 	}{
-		ContainerPath: b.Sbatch.ContainerPath,
-		Workspace:     b.Sbatch.Workspace,
-		Node:          b.Sbatch.Node,
-		CpusPerTasks:  b.Sbatch.CpusPerTasks,
-		GpusPerNode:   b.Sbatch.GpusPerNode,
-		NtasksPerNode: b.Sbatch.NtasksPerNode,
-		GpuAffinity:   b.Sbatch.GpuAffinity,
-		CpuAffinity:   b.Sbatch.CpuAffinity,
+		ContainerPath:    b.Sbatch.ContainerPath,
+		ContainerRuntime: b.Sbatch.ContainerRuntime,
+		Workspace:        b.Sbatch.Workspace,
+		Node:             b.Sbatch.Node,
+		CpusPerTasks:     b.Sbatch.CpusPerTasks,
+		GpusPerNode:      b.Sbatch.GpusPerNode,
+		NtasksPerNode:    b.Sbatch.NtasksPerNode,
+		GpuAffinity:      b.Sbatch.GpuAffinity,
+		CpuAffinity:      b.Sbatch.CpuAffinity,
+		Partition:        b.Sbatch.Partition,
+		NodeList:         b.Sbatch.NodeList,
+		Exclude:          b.Sbatch.Exclude,
+		Constraint:       b.Sbatch.Constraint,
+		Reservation:      b.Sbatch.Reservation,
+		Account:          b.Sbatch.Account,
+		Power:            b.Sbatch.Power,
 	}); err != nil {
 		log.Printf("sbatch templating failed: %s", err)
 		return "", err
@@ -154,26 +310,42 @@ func (b *Benchmark) GenerateMultiNodeSBATCH() (string, error) {
 }
 
 func (b *Benchmark) GenerateSingleNodeSBATCH() (string, error) {
-	SbatchTmpl := template.Must(template.New("jobTemplate").Parse(SingleNodeTmpl))
+	SbatchTmpl := template.Must(template.New("jobTemplate").Parse(b.templates().SingleNode))
 	var SbatchFile bytes.Buffer
 	if err := SbatchTmpl.Execute(&SbatchFile, struct {
-		ContainerPath string
-		Workspace     string
-		Node          int
-		CpusPerTasks  int
-		GpusPerNode   int
-		NtasksPerNode int
-		GpuAffinity   string
-		CpuAffinity   string
+		ContainerPath    string
+		ContainerRuntime string
+		Workspace        string
+		Node             int
+		CpusPerTasks     int
+		GpusPerNode      int
+		NtasksPerNode    int
+		GpuAffinity      string
+		CpuAffinity      string
+		Partition        string
+		NodeList         string
+		Exclude          string
+		Constraint       string
+		Reservation      string
+		Account          string
+		Power            PowerSampling
 	}{
-		ContainerPath: b.Sbatch.ContainerPath,
-		Workspace:     b.Sbatch.Workspace,
-		Node:          b.Sbatch.Node,
-		CpusPerTasks:  b.Sbatch.CpusPerTasks,
-		GpusPerNode:   b.Sbatch.GpusPerNode,
-		NtasksPerNode: b.Sbatch.NtasksPerNode,
-		GpuAffinity:   b.Sbatch.GpuAffinity,
-		CpuAffinity:   b.Sbatch.CpuAffinity,
+		ContainerPath:    b.Sbatch.ContainerPath,
+		ContainerRuntime: b.Sbatch.ContainerRuntime,
+		Workspace:        b.Sbatch.Workspace,
+		Node:             b.Sbatch.Node,
+		CpusPerTasks:     b.Sbatch.CpusPerTasks,
+		GpusPerNode:      b.Sbatch.GpusPerNode,
+		NtasksPerNode:    b.Sbatch.NtasksPerNode,
+		GpuAffinity:      b.Sbatch.GpuAffinity,
+		CpuAffinity:      b.Sbatch.CpuAffinity,
+		Partition:        b.Sbatch.Partition,
+		NodeList:         b.Sbatch.NodeList,
+		Exclude:          b.Sbatch.Exclude,
+		Constraint:       b.Sbatch.Constraint,
+		Reservation:      b.Sbatch.Reservation,
+		Account:          b.Sbatch.Account,
+		Power:            b.Sbatch.Power,
 	}); err != nil {
 		log.Printf("sbatch templating failed: %s", err)
 		return "", err
@@ -183,6 +355,11 @@ func (b *Benchmark) GenerateSingleNodeSBATCH() (string, error) {
 }
 
 func (b *Benchmark) CalculateBenchmarkParams(ctx context.Context) error {
+	if err := b.CheckNodeResources(ctx); err != nil {
+		log.Printf("Failed to check node resources: %s", err)
+		return err
+	}
+
 	if err := b.CalculateDATParams(ctx); err != nil {
 		log.Printf("Failed to calculate dat params: %s", err)
 		return err
@@ -196,7 +373,52 @@ func (b *Benchmark) CalculateBenchmarkParams(ctx context.Context) error {
 	return nil
 }
 
+// CheckNodeResources warns when the nodes which may run the benchmark differ
+// in memory, GPU or CPU count, as the benchmark is sized for the smallest of
+// them. It fails instead when RefuseHeterogeneous is set.
+func (b *Benchmark) CheckNodeResources(ctx context.Context) error {
+	nodes, err := b.SlurmClient.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("failed to find node resources: %s", err)
+		return err
+	}
+	b.Nodes = nodes
+
+	if !scheduler.Heterogeneous(nodes) {
+		return nil
+	}
+
+	for _, node := range nodes {
+		log.Printf(
+			"node %s: %d MB, %d GPUs, %d CPUs",
+			node.Name,
+			node.Memory,
+			node.GPUs,
+			node.CPUs,
+		)
+	}
+	if b.RefuseHeterogeneous {
+		return fmt.Errorf("the %d selected nodes are heterogeneous", len(nodes))
+	}
+
+	smallest := scheduler.MinNodeResources(nodes)
+	log.Printf(
+		"warning: the %d selected nodes are heterogeneous, sizing the benchmark for %d MB, %d GPUs and %d CPUs per node",
+		len(nodes),
+		smallest.Memory,
+		smallest.GPUs,
+		smallest.CPUs,
+	)
+	return nil
+}
+
 func (b *Benchmark) CalculateDATParams(ctx context.Context) error {
+	// The problem size is rounded to the block sizes
+	blockSizes := b.Space.blockSizes()
+	b.Dat.NBlockSize = len(blockSizes)
+	b.Dat.BlockSize = joinInts(blockSizes)
+	b.Dat.Knobs = b.Space.Knobs
+
 	if err := b.CalculateProblemSize(ctx); err != nil {
 		return err
 	}
@@ -205,9 +427,6 @@ func (b *Benchmark) CalculateDATParams(ctx context.Context) error {
 		return err
 	}
 
-	b.Dat.NBlockSize = 10
-	b.Dat.BlockSize = "64 128 224 256 384 512 640 768 896 1024"
-
 	return nil
 }
 
@@ -241,6 +460,34 @@ func (b *Benchmark) CalculateProcessGrid(ctx context.Context) error {
 	}
 	totalGPUS := numGPUs * b.Sbatch.Node
 
+	if len(b.Space.Grids) > 0 {
+		for _, grid := range b.Space.Grids {
+			if grid.P*grid.Q != totalGPUS {
+				return fmt.Errorf(
+					"process grid %dx%d does not match the %d GPUs",
+					grid.P,
+					grid.Q,
+					totalGPUS,
+				)
+			}
+		}
+		b.Dat.Grids = b.Space.Grids
+		b.Dat.P = b.Space.Grids[0].P
+		b.Dat.Q = b.Space.Grids[0].Q
+		return nil
+	}
+
+	if !b.Space.GridSweep.Disabled {
+		grids := b.Space.GridSweep.Grids(totalGPUS)
+		if len(grids) == 0 {
+			return fmt.Errorf("no process grid of %d GPUs matches the sweep filters", totalGPUS)
+		}
+		b.Dat.Grids = grids
+		b.Dat.P = grids[0].P
+		b.Dat.Q = grids[0].Q
+		return nil
+	}
+
 	if totalGPUS == 1 {
 		b.Dat.P = 1
 		b.Dat.Q = 1
@@ -262,8 +509,18 @@ func (b *Benchmark) CalculateProcessGrid(ctx context.Context) error {
 	return nil // If no other valid P is found, default to 2
 }
 
-// Calculates the problem size from the ram available
+// Calculates the problem size from the ram available, or from the GPU memory
+// depending on the sizing model
 func (b *Benchmark) CalculateProblemSize(ctx context.Context) error {
+	if len(b.Space.ProblemSizes) > 0 {
+		b.Dat.NProblemSize = len(b.Space.ProblemSizes)
+		b.Dat.ProblemSize = joinInts(b.Space.ProblemSizes)
+		return nil
+	}
+
+	if b.Sizing.device() {
+		return b.calculateDeviceProblemSize(ctx)
+	}
 
 	mem, err := b.SlurmClient.FindMemPerNode(ctx)
 	if err != nil {
@@ -271,8 +528,9 @@ func (b *Benchmark) CalculateProblemSize(ctx context.Context) error {
 		return err
 	}
 
-	b.Dat.NProblemSize = len(benchmarkMemoryUsePercentage)
-	for _, values := range benchmarkMemoryUsePercentage {
+	fractions := b.Space.memoryFractions()
+	b.Dat.NProblemSize = len(fractions)
+	for _, values := range fractions {
 		problemSize := int(
 			math.Sqrt(float64(mem*b.Sbatch.Node)/8)*values,
 		) * GBtoMB
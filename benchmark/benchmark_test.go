@@ -3,13 +3,20 @@ package benchmark_test
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/squarefactory/benchmark-api/benchmark"
 	"github.com/squarefactory/benchmark-api/mocks"
 	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
@@ -55,29 +62,142 @@ func (suite *ServiceTestSuite) BeforeTest(suiteName, testName string) {
 func (suite *ServiceTestSuite) TestRun() {
 
 	// Arrange
+	workspace := suite.T().TempDir()
+	suite.impl.Sbatch.Workspace = workspace
 	files := benchmark.BenchmarkFile{
 		DatFile:    "testdatfile",
 		SbatchFile: "testsbatchfile",
 	}
 
 	expectedSubmitRequest := &scheduler.SubmitRequest{
-		Name: JobName,
-		User: admin,
-		Body: "testsbatchfile",
+		Name:   JobName,
+		User:   admin,
+		Body:   "testsbatchfile",
+		Output: filepath.Join(workspace, benchmark.JobOutputName),
+		Files: map[string]string{
+			benchmark.DatFileName: "testdatfile",
+		},
 	}
 
 	suite.scheduler.On(
 		"Submit",
 		mock.Anything,
 		expectedSubmitRequest,
-	).Return("test submit response", nil)
+	).Return("123", nil)
+
+	suite.scheduler.On(
+		"FindJobOutputFile",
+		mock.Anything,
+		123,
+	).Return("/tmp/benchmark-123.log", nil)
 
 	// Act
-	err := suite.impl.Run(context.Background(), &files)
+	job, err := suite.impl.Run(context.Background(), &files)
 
 	// Assert
 	suite.NoError(err)
 	suite.scheduler.AssertExpectations(suite.T())
+	suite.Equal(123, job.ID)
+	suite.Equal(JobName, job.Name)
+	suite.Equal("/tmp/benchmark-123.log", job.OutputFile)
+	dat, err := os.ReadFile(filepath.Join(workspace, benchmark.DatFileName))
+	suite.NoError(err)
+	suite.Equal("testdatfile", string(dat))
+	sbatch, err := os.ReadFile(filepath.Join(workspace, benchmark.SbatchFileName))
+	suite.NoError(err)
+	suite.Equal("testsbatchfile", string(sbatch))
+}
+
+func (suite *ServiceTestSuite) TestWait() {
+	tests := []struct {
+		name    string
+		errs    []error
+		isError bool
+	}{
+		{
+			name: "Finished",
+			errs: []error{nil, scheduler.ErrJobNotRunning},
+		},
+		{
+			name: "Transient scheduler failure",
+			errs: []error{errors.New("slurmctld unreachable"), nil, scheduler.ErrJobNotRunning},
+		},
+		{
+			name:    "Scheduler down",
+			errs:    []error{errors.New("slurmctld unreachable"), errors.New("slurmctld unreachable")},
+			isError: true,
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			suite.BeforeTest("", "")
+			for _, err := range tt.errs {
+				suite.scheduler.On("FindRunningJobByID", mock.Anything, mock.Anything).
+					Return(123, err).
+					Once()
+			}
+
+			// Act
+			err := suite.impl.Wait(context.Background(), &scheduler.Job{ID: 123}, len(tt.errs), 0)
+
+			// Assert
+			if tt.isError {
+				suite.ErrorContains(err, "slurmctld unreachable")
+				return
+			}
+			suite.NoError(err)
+		})
+	}
+}
+
+func (suite *ServiceTestSuite) TestState() {
+	tests := []struct {
+		name     string
+		states   []string
+		expected string
+		isError  bool
+	}{
+		{
+			name:     "Finished",
+			states:   []string{scheduler.JobStateCompleted},
+			expected: scheduler.JobStateCompleted,
+		},
+		{
+			name:     "Accounting lagging behind the queue",
+			states:   []string{"RUNNING", "COMPLETING", scheduler.JobStateFailed},
+			expected: scheduler.JobStateFailed,
+		},
+		{
+			name:    "Never finished",
+			states:  []string{"RUNNING", "RUNNING", "RUNNING", "RUNNING", "RUNNING"},
+			isError: true,
+		},
+	}
+	defer func(delay time.Duration) { benchmark.StateDelay = delay }(benchmark.StateDelay)
+	benchmark.StateDelay = 0
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			suite.BeforeTest("", "")
+			for _, state := range tt.states {
+				suite.scheduler.On("FindJobState", mock.Anything, 123).
+					Return(&scheduler.JobState{State: state}, nil).
+					Once()
+			}
+
+			// Act
+			state, err := suite.impl.State(context.Background(), &scheduler.Job{ID: 123})
+
+			// Assert
+			if tt.isError {
+				suite.ErrorContains(err, "job 123 is still RUNNING in accounting")
+				return
+			}
+			suite.NoError(err)
+			suite.Equal(tt.expected, state.State)
+		})
+	}
 }
 
 func (suite *ServiceTestSuite) TestGenerateDAT() {
@@ -140,11 +260,39 @@ HPL.out      output file name (if any)
 	suite.Equal(expectedBuffer.String(), result)
 }
 
+func (suite *ServiceTestSuite) TestGenerateDATKnobs() {
+	// Arrange
+	swap, threshold := 0, -16.0
+	suite.impl.Dat.Knobs = benchmark.DATKnobs{
+		Threshold: &threshold,
+		PFacts:    []int{0, 1, 2},
+		NBMins:    []int{2, 4},
+		BCasts:    []int{1, 3},
+		Depths:    []int{0, 1},
+		Swap:      &swap,
+	}
+
+	// Act
+	result, err := suite.impl.GenerateDAT()
+
+	// Assert
+	suite.NoError(err)
+	suite.Contains(result, "-16.0         threshold\n")
+	suite.Contains(result, "3            # of panel fact\n0 1 2            PFACTs")
+	suite.Contains(result, "2            # of recursive stopping criterium\n2 4            NBMINs")
+	suite.Contains(result, "1            # of panels in recursion\n2            NDIVs")
+	suite.Contains(result, "2            # of broadcast\n1 3            BCASTs")
+	suite.Contains(result, "2            # of lookahead depth\n0 1            DEPTHs")
+	suite.Contains(result, "0            SWAP")
+	suite.Contains(result, "8            memory alignment")
+}
+
 func (suite *ServiceTestSuite) TestGenerateSBATCH() {
 	// Arrange
 	expectedTemplate := `#!/bin/sh
 
 #SBATCH -N 1
+#SBATCH --chdir=/etc/hpl-benchmark
 #SBATCH --ntasks-per-node=2
 #SBATCH --gpus-per-node=2
 #SBATCH --mem=0
@@ -180,9 +328,114 @@ srun  --mpi=pmix_v4 --cpu-bind=none --gpu-bind=none --container-image="/etc/hpl-
 	suite.Equal(expectedBuffer.String(), result)
 }
 
+func (suite *ServiceTestSuite) TestGenerateSBATCHNodeSelection() {
+	// Arrange
+	suite.impl.Sbatch.Partition = "gpu"
+	suite.impl.Sbatch.Exclude = "gpu[03-04]"
+	suite.impl.Sbatch.Constraint = "a100&ib"
+	suite.impl.Sbatch.Account = "benchmark"
+
+	// Act
+	result, err := suite.impl.GenerateSingleNodeSBATCH()
+
+	// Assert
+	suite.NoError(err)
+	suite.Contains(result, `#SBATCH -N 1
+#SBATCH --partition=gpu
+#SBATCH --exclude=gpu[03-04]
+#SBATCH --constraint=a100&ib
+#SBATCH --account=benchmark
+#SBATCH --chdir=/etc/hpl-benchmark
+`)
+}
+
+func (suite *ServiceTestSuite) TestGenerateSBATCHPowerSampling() {
+	// Arrange
+	suite.impl.Sbatch.Power = benchmark.PowerSampling{
+		Enabled:  true,
+		Interval: 500 * time.Millisecond,
+		IPMI:     true,
+	}
+
+	// Act
+	result, err := suite.impl.GenerateMultiNodeSBATCH()
+
+	// Assert
+	suite.NoError(err)
+	suite.Contains(result, "srun --overlap -N 1 --ntasks-per-node=1 --gpus-per-node=2 sh -c '\n")
+	suite.Contains(result, `nvidia-smi --query-gpu=timestamp,index,power.draw --format=csv,noheader,nounits -lms 500 > "$out.gpu.csv" &`)
+	suite.Contains(result, "    sleep 0.5\n  done > \"$out.ipmi.txt\" &\n")
+	suite.NotContains(result, "intel-rapl")
+	suite.True(strings.HasSuffix(result, "--dat \"/test.dat\"'\nstatus=$?\nkill $POWER_PID\nwait $POWER_PID\nexit $status\n"))
+}
+
+func (suite *ServiceTestSuite) TestGenerateSBATCHPowerSamplingRAPL() {
+	// Arrange
+	suite.impl.Sbatch.Power = benchmark.PowerSampling{Enabled: true, RAPL: true}
+
+	for _, generate := range []func() (string, error){
+		suite.impl.GenerateMultiNodeSBATCH,
+		suite.impl.GenerateSingleNodeSBATCH,
+	} {
+		// Act
+		result, err := generate()
+
+		// Assert
+		suite.NoError(err)
+		suite.Contains(result, "  if cat /sys/class/powercap/intel-rapl:[0-9]/energy_uj > /dev/null 2>&1; then\n")
+		suite.Contains(result, "    done > \"$out.rapl.txt\" &\n  else\n")
+		suite.Contains(result, "are not readable, they may be restricted to root\" >&2\n  fi\n")
+	}
+}
+
+func (suite *ServiceTestSuite) TestGeneratePBSSBATCH() {
+	// Arrange
+	suite.impl.Templates = benchmark.PBSTemplates
+
+	// Act
+	result, err := suite.impl.GenerateSingleNodeSBATCH()
+
+	// Assert
+	suite.NoError(err)
+	suite.Contains(result, "#PBS -l select=1:ngpus=2:mpiprocs=2:ompthreads=8\n")
+	suite.Contains(result, `enroot start --mount "/etc/hpl-benchmark/hpl.dat:/test.dat" "/etc/hpl-benchmark/hpc-benchmarks:hpl.sqsh"`)
+	suite.Contains(result, "--cpu-affinity 6-7:2-3 --cpu-cores-per-rank 8 --gpu-affinity 0:1 ")
+}
+
+func (suite *ServiceTestSuite) TestGenerateLocalSBATCH() {
+	tests := []struct {
+		runtime  string
+		expected string
+	}{
+		{
+			runtime:  "",
+			expected: `  enroot start --mount "/etc/hpl-benchmark/hpl.dat:/test.dat" "/etc/hpl-benchmark/hpc-benchmarks:hpl.sqsh" \`,
+		},
+		{
+			runtime:  "apptainer",
+			expected: `  apptainer exec --nv --bind "/etc/hpl-benchmark/hpl.dat:/test.dat" "/etc/hpl-benchmark/hpc-benchmarks:hpl.sqsh" \`,
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.runtime, func() {
+			// Arrange
+			suite.impl.Templates = benchmark.LocalTemplates
+			suite.impl.Sbatch.ContainerRuntime = tt.runtime
+
+			// Act
+			result, err := suite.impl.GenerateSingleNodeSBATCH()
+
+			// Assert
+			suite.NoError(err)
+			suite.Contains(result, "mpirun --allow-run-as-root --bind-to none -np 2 \\\n"+tt.expected+"\n")
+		})
+	}
+}
+
 func (suite *ServiceTestSuite) TestCalculateProcessGrid() {
 	// Arrange
 	P, Q := 2, 2
+	suite.impl.Space.GridSweep = benchmark.GridSweep{Disabled: true}
 
 	suite.scheduler.On(
 		"FindGPUPerNode",
@@ -197,6 +450,78 @@ func (suite *ServiceTestSuite) TestCalculateProcessGrid() {
 	suite.scheduler.AssertExpectations(suite.T())
 	suite.Equal(P, suite.impl.Dat.P)
 	suite.Equal(Q, suite.impl.Dat.Q)
+	suite.Empty(suite.impl.Dat.Grids)
+}
+
+func (suite *ServiceTestSuite) TestCalculateProcessGridSweep() {
+	// Arrange
+	suite.impl.Sbatch.Node = 2
+
+	suite.scheduler.On(
+		"FindGPUPerNode",
+		mock.Anything,
+	).Return(4)
+
+	// Act
+	err := suite.impl.CalculateProcessGrid(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.scheduler.AssertExpectations(suite.T())
+	suite.Equal([]benchmark.Grid{{P: 2, Q: 4}, {P: 4, Q: 2}, {P: 1, Q: 8}, {P: 8, Q: 1}}, suite.impl.Dat.Grids)
+	suite.Equal(2, suite.impl.Dat.P)
+	suite.Equal(4, suite.impl.Dat.Q)
+
+	dat, err := suite.impl.GenerateDAT()
+	suite.NoError(err)
+	suite.Contains(dat, "4            # of process grids (P x Q)\n2 4 1 8     Ps\n4 2 8 1      Qs")
+}
+
+func TestGridSweep(t *testing.T) {
+	tests := []struct {
+		name      string
+		sweep     benchmark.GridSweep
+		processes int
+		expected  []benchmark.Grid
+	}{
+		{
+			name:      "All",
+			sweep:     benchmark.GridSweep{},
+			processes: 12,
+			expected: []benchmark.Grid{
+				{P: 3, Q: 4}, {P: 4, Q: 3}, {P: 2, Q: 6}, {P: 6, Q: 2}, {P: 1, Q: 12}, {P: 12, Q: 1},
+			},
+		},
+		{
+			name:      "P <= Q",
+			sweep:     benchmark.GridSweep{PLessOrEqualQ: true},
+			processes: 12,
+			expected:  []benchmark.Grid{{P: 3, Q: 4}, {P: 2, Q: 6}, {P: 1, Q: 12}},
+		},
+		{
+			name:      "Max aspect ratio",
+			sweep:     benchmark.GridSweep{PLessOrEqualQ: true, MaxAspectRatio: 3},
+			processes: 12,
+			expected:  []benchmark.Grid{{P: 3, Q: 4}, {P: 2, Q: 6}},
+		},
+		{
+			name:      "Single process",
+			sweep:     benchmark.GridSweep{},
+			processes: 1,
+			expected:  []benchmark.Grid{{P: 1, Q: 1}},
+		},
+		{
+			name:      "Filtered out",
+			sweep:     benchmark.GridSweep{MaxAspectRatio: 2},
+			processes: 7,
+			expected:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.sweep.Grids(tt.processes))
+		})
+	}
 }
 
 func (suite *ServiceTestSuite) TestCalculateProblemSize() {
@@ -216,6 +541,212 @@ func (suite *ServiceTestSuite) TestCalculateProblemSize() {
 	suite.Equal(expectedMem, suite.impl.Dat.ProblemSize)
 }
 
+func (suite *ServiceTestSuite) TestCalculateProblemSizeDevice() {
+	tests := []struct {
+		sizing   benchmark.SizingModel
+		expected string
+	}{
+		{
+			sizing:   benchmark.SizingDeviceFP64,
+			expected: "253819 255506 257181 258846 260500 262144 263777 265400 267013 268617 ",
+		},
+		{
+			sizing:   benchmark.SizingDeviceFP32,
+			expected: "358955 361340 363710 366064 368403 370727 373037 375333 377614 379882 ",
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(string(tt.sizing), func() {
+			// Arrange
+			suite.BeforeTest("", "")
+			suite.impl.Sizing = tt.sizing
+			suite.impl.Sbatch.Node = 2
+			suite.scheduler.On(
+				"FindGPUMemory",
+				mock.Anything,
+			).Return(81920, nil)
+			suite.scheduler.On(
+				"FindGPUPerNode",
+				mock.Anything,
+			).Return(4)
+
+			// Act
+			err := suite.impl.CalculateProblemSize(context.Background())
+
+			// Assert
+			suite.NoError(err)
+			suite.Equal(10, suite.impl.Dat.NProblemSize)
+			suite.Equal(tt.expected, suite.impl.Dat.ProblemSize)
+		})
+	}
+}
+
+func TestRoundProblemSize(t *testing.T) {
+	tests := []struct {
+		n, nb, expected int
+	}{
+		{n: 46341, nb: 224, expected: 46144},
+		{n: 46341, nb: 1024, expected: 46080},
+		{n: 414000, nb: 896, expected: 413952},
+		{n: 100, nb: 512, expected: 100},
+		{n: 100, nb: 0, expected: 100},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.n, tt.nb), func(t *testing.T) {
+			assert.Equal(t, tt.expected, benchmark.RoundProblemSize(tt.n, tt.nb))
+		})
+	}
+}
+
+func TestParseSizingModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected benchmark.SizingModel
+		isError  bool
+	}{
+		{name: "", expected: benchmark.SizingHost},
+		{name: "host", expected: benchmark.SizingHost},
+		{name: "device-fp32", expected: benchmark.SizingDeviceFP32},
+		{name: "gpu", isError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := benchmark.ParseSizingModel(tt.name)
+
+			if (err != nil) != tt.isError {
+				t.Fatalf("ParseSizingModel() error = %v, isError %v", err, tt.isError)
+			}
+			if model != tt.expected {
+				t.Errorf("ParseSizingModel() = %s, expected %s", model, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	knobs := benchmark.DATKnobs{
+		PFacts: []int{0, 1, 2},
+		NBMins: []int{2, 4, 16},
+		RFacts: []int{1},
+		BCasts: []int{1, 3},
+		Depths: []int{0, 1},
+	}
+	tests := []struct {
+		name     string
+		knobs    benchmark.DATKnobs
+		variant  string
+		expected benchmark.DATKnobs
+		wantErr  bool
+	}{
+		{
+			name:    "HPL",
+			knobs:   knobs,
+			variant: "WR13C2L4",
+			expected: benchmark.DATKnobs{
+				PFacts: []int{0},
+				NBMins: []int{4},
+				RFacts: []int{1},
+				BCasts: []int{3},
+				Depths: []int{1},
+			},
+		},
+		{
+			name:    "Multi-digit NBMIN",
+			knobs:   knobs,
+			variant: "WC11C2R16",
+			expected: benchmark.DATKnobs{
+				PFacts: []int{2},
+				NBMins: []int{16},
+				RFacts: []int{1},
+				BCasts: []int{1},
+				Depths: []int{1},
+			},
+		},
+		{
+			name:    "Unknown",
+			knobs:   knobs,
+			variant: "WRC01",
+			wantErr: true,
+		},
+		{
+			name:     "Nothing swept",
+			knobs:    benchmark.DATKnobs{NBMins: []int{4}},
+			variant:  "WRC01",
+			expected: benchmark.DATKnobs{NBMins: []int{4}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.knobs.SelectVariant(tt.variant)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func (suite *ServiceTestSuite) TestCheckNodeResources() {
+	homogeneous := []scheduler.NodeResources{
+		{Name: "gpu01", Memory: 1031000, GPUs: 8, CPUs: 128},
+		{Name: "gpu02", Memory: 1031000, GPUs: 8, CPUs: 128},
+	}
+	heterogeneous := []scheduler.NodeResources{
+		{Name: "gpu01", Memory: 1031000, GPUs: 8, CPUs: 128},
+		{Name: "gpu03", Memory: 385024, GPUs: 4, CPUs: 48},
+	}
+	tests := []struct {
+		name    string
+		nodes   []scheduler.NodeResources
+		refuse  bool
+		isError bool
+	}{
+		{
+			name:  "Homogeneous",
+			nodes: homogeneous,
+		},
+		{
+			name:   "Homogeneous refused",
+			nodes:  homogeneous,
+			refuse: true,
+		},
+		{
+			name:  "Heterogeneous warning",
+			nodes: heterogeneous,
+		},
+		{
+			name:    "Heterogeneous refused",
+			nodes:   heterogeneous,
+			refuse:  true,
+			isError: true,
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			suite.BeforeTest("", "")
+			suite.impl.RefuseHeterogeneous = tt.refuse
+			suite.scheduler.On(
+				"FindNodeResources",
+				mock.Anything,
+			).Return(tt.nodes, nil)
+
+			// Act
+			err := suite.impl.CheckNodeResources(context.Background())
+
+			// Assert
+			if tt.isError {
+				suite.Error(err)
+			} else {
+				suite.NoError(err)
+			}
+			suite.Equal(tt.nodes, suite.impl.Nodes)
+		})
+	}
+}
+
 func (suite *ServiceTestSuite) TestCalculateAffinity() {
 
 	expectedCpu := "6-7:2-3"
@@ -237,6 +768,39 @@ func (suite *ServiceTestSuite) TestCalculateAffinity() {
 	suite.Equal(expectedGpu, suite.impl.Sbatch.GpuAffinity)
 }
 
+func TestRunDir(t *testing.T) {
+	base := t.TempDir()
+
+	runDir, err := benchmark.NewRunDir(base)
+	if err != nil {
+		t.Fatalf("NewRunDir() error = %v", err)
+	}
+
+	if filepath.Dir(runDir.Path) != base {
+		t.Errorf("run directory %s is not in %s", runDir.Path, base)
+	}
+
+	sub, err := runDir.SubDir("first_set")
+	if err != nil {
+		t.Fatalf("SubDir() error = %v", err)
+	}
+	if _, err := os.Stat(sub); err != nil {
+		t.Errorf("SubDir() did not create %s", sub)
+	}
+
+	if err := runDir.WriteMetadata(&benchmark.RunMetadata{ID: runDir.ID}); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+	meta, err := os.ReadFile(runDir.File(benchmark.MetadataFileName))
+	if err != nil {
+		t.Fatalf("WriteMetadata() did not create the metadata file")
+	}
+	// The end time of an unfinished run is omitted
+	if strings.Contains(string(meta), "endTime") {
+		t.Errorf("WriteMetadata() wrote the end time of an unfinished run: %s", meta)
+	}
+}
+
 func TestServiceTestSuite(t *testing.T) {
 	suite.Run(t, &ServiceTestSuite{})
 }
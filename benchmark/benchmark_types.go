@@ -10,21 +10,45 @@ type SlurmScheduler interface {
 	Submit(ctx context.Context, req *scheduler.SubmitRequest) (string, error)
 	CancelJob(ctx context.Context, req *scheduler.CancelRequest) error
 	HealthCheck(ctx context.Context) error
-	FindRunningJobByName(
+	FindRunningJobByID(
 		ctx context.Context,
-		req *scheduler.FindRunningJobByNameRequest,
+		req *scheduler.FindRunningJobByIDRequest,
 	) (int, error)
+	FindNodeResources(ctx context.Context) ([]scheduler.NodeResources, error)
 	FindMemPerNode(ctx context.Context) (int, error)
 	FindGPUPerNode(ctx context.Context) (int, error)
 	FindCPUPerNode(ctx context.Context) (int, error)
+	FindGPUMemory(ctx context.Context) (int, error)
+	FindGPUModel(ctx context.Context) (string, error)
 	FindCPUAffinity(ctx context.Context) (string, error)
 	FindJobOutputFile(ctx context.Context, jobID int) (string, error)
+	FindJobState(ctx context.Context, jobID int) (*scheduler.JobState, error)
+}
+
+// OutputCollector is implemented by the schedulers whose jobs do not write
+// their output to a shared file system, which must be collected once they are
+// finished.
+type OutputCollector interface {
+	CollectJobOutput(ctx context.Context, jobID int) error
 }
 
 type Benchmark struct {
 	Dat         DATParams
 	Sbatch      SBATCHParams
 	SlurmClient SlurmScheduler
+	// Templates of the job scripts, defaults to SlurmTemplates
+	Templates JobTemplates
+	// Space is the search space of the first set
+	Space SearchSpace
+	// Sizing selects the memory against which the problem size is sized,
+	// defaults to SizingHost
+	Sizing SizingModel
+	// RefuseHeterogeneous fails the benchmark instead of warning when the
+	// nodes differ in memory, GPU or CPU count
+	RefuseHeterogeneous bool
+	// Nodes are the resources of the nodes which may run the benchmark, found
+	// by CheckNodeResources
+	Nodes []scheduler.NodeResources
 }
 
 type BenchmarkFile struct {
@@ -39,15 +63,30 @@ type DATParams struct {
 	BlockSize    string
 	P            int
 	Q            int
+	// Grids are the process grids of the DAT, defaults to P x Q
+	Grids []Grid
+	// Knobs are the other tuning parameters of the DAT
+	Knobs DATKnobs
 }
 
 type SBATCHParams struct {
 	ContainerPath string
-	Workspace     string
-	Node          int
-	NtasksPerNode int
-	GpusPerNode   int
-	CpusPerTasks  int
-	GpuAffinity   string
-	CpuAffinity   string
+	// ContainerRuntime is used by the local templates, enroot or apptainer
+	ContainerRuntime string
+	Workspace        string
+	Node             int
+	NtasksPerNode    int
+	GpusPerNode      int
+	CpusPerTasks     int
+	GpuAffinity      string
+	CpuAffinity      string
+	// Node selection, omitted from the job script when empty
+	Partition   string
+	NodeList    string
+	Exclude     string
+	Constraint  string
+	Reservation string
+	Account     string
+	// Power configures the power samplers of the Slurm templates
+	Power PowerSampling
 }
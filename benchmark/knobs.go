@@ -0,0 +1,197 @@
+package benchmark
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DATKnobs are the HPL.dat tuning parameters besides N, NB and the process
+// grids. HPL sweeps the lists in a single run, like N and NB, while the other
+// parameters take a single value. Empty lists and nil values fall back to the
+// defaults of the DAT template.
+type DATKnobs struct {
+	// PMap is the process mapping, 0 for row-major, 1 for column-major
+	PMap *int `json:"pmap,omitempty"`
+	// Threshold of the residual check
+	Threshold *float64 `json:"threshold,omitempty"`
+	// PFacts are the panel factorizations, 0 for left, 1 for Crout, 2 for right
+	PFacts []int `json:"pfacts,omitempty"`
+	// NBMins are the recursive stopping criteria
+	NBMins []int `json:"nbmins,omitempty"`
+	// NDivs are the numbers of panels in recursion
+	NDivs []int `json:"ndivs,omitempty"`
+	// RFacts are the recursive panel factorizations, as PFacts
+	RFacts []int `json:"rfacts,omitempty"`
+	// BCasts are the broadcasts, 0=1rg, 1=1rM, 2=2rg, 3=2rM, 4=Lng, 5=LnM
+	BCasts []int `json:"bcasts,omitempty"`
+	// Depths are the lookahead depths
+	Depths []int `json:"depths,omitempty"`
+	// Swap is the swapping algorithm, 0 for binary exchange, 1 for long, 2 for
+	// mix
+	Swap *int `json:"swap,omitempty"`
+	// SwappingThreshold is the number of columns above which the long
+	// swapping algorithm is used
+	SwappingThreshold *int `json:"swappingThreshold,omitempty"`
+	// L1 is the form of the panel, 0 for transposed, 1 for no-transposed
+	L1 *int `json:"l1,omitempty"`
+	// U is the form of U, as L1
+	U *int `json:"u,omitempty"`
+	// Equilibration, 0 for no, 1 for yes
+	Equilibration *int `json:"equilibration,omitempty"`
+	// Alignment of the memory in doubles
+	Alignment *int `json:"alignment,omitempty"`
+}
+
+// datKnobsTemplate holds the knobs rendered in the DAT template.
+type datKnobsTemplate struct {
+	PMap              int
+	Threshold         string
+	NPFacts           int
+	PFacts            string
+	NNBMins           int
+	NBMins            string
+	NNDivs            int
+	NDivs             string
+	NRFacts           int
+	RFacts            string
+	NBCasts           int
+	BCasts            string
+	NDepths           int
+	Depths            string
+	Swap              int
+	SwappingThreshold int
+	L1                int
+	U                 int
+	Equilibration     int
+	Alignment         int
+}
+
+func intOr(v *int, def int) int {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+func listOr(v []int, def ...int) []int {
+	if len(v) == 0 {
+		return def
+	}
+	return v
+}
+
+// template returns the knobs with the defaults of the DAT template.
+func (k *DATKnobs) template() datKnobsTemplate {
+	threshold := 16.0
+	if k.Threshold != nil {
+		threshold = *k.Threshold
+	}
+	pfacts := listOr(k.PFacts, 2)
+	nbmins := listOr(k.NBMins, 4)
+	ndivs := listOr(k.NDivs, 2)
+	rfacts := listOr(k.RFacts, 1)
+	bcasts := listOr(k.BCasts, 1)
+	depths := listOr(k.Depths, 1)
+
+	return datKnobsTemplate{
+		PMap:              intOr(k.PMap, 0),
+		Threshold:         strconv.FormatFloat(threshold, 'f', 1, 64),
+		NPFacts:           len(pfacts),
+		PFacts:            joinInts(pfacts),
+		NNBMins:           len(nbmins),
+		NBMins:            joinInts(nbmins),
+		NNDivs:            len(ndivs),
+		NDivs:             joinInts(ndivs),
+		NRFacts:           len(rfacts),
+		RFacts:            joinInts(rfacts),
+		NBCasts:           len(bcasts),
+		BCasts:            joinInts(bcasts),
+		NDepths:           len(depths),
+		Depths:            joinInts(depths),
+		Swap:              intOr(k.Swap, 2),
+		SwappingThreshold: intOr(k.SwappingThreshold, 64),
+		L1:                intOr(k.L1, 1),
+		U:                 intOr(k.U, 0),
+		Equilibration:     intOr(k.Equilibration, 1),
+		Alignment:         intOr(k.Alignment, 8),
+	}
+}
+
+// Validate checks the ranges of the knobs, reporting all the invalid ones.
+func (k *DATKnobs) Validate() error {
+	var errs []error
+	check := func(name string, v *int, min, max int) {
+		if v != nil && (*v < min || *v > max) {
+			errs = append(errs, fmt.Errorf("%s: %d is not in [%d, %d]", name, *v, min, max))
+		}
+	}
+	checkList := func(name string, values []int, min, max int) {
+		for i := range values {
+			check(fmt.Sprintf("%s[%d]", name, i), &values[i], min, max)
+		}
+	}
+	const unbounded = int(^uint(0) >> 1)
+
+	check("pmap", k.PMap, 0, 1)
+	checkList("pfacts", k.PFacts, 0, 2)
+	checkList("nbmins", k.NBMins, 1, unbounded)
+	checkList("ndivs", k.NDivs, 2, unbounded)
+	checkList("rfacts", k.RFacts, 0, 2)
+	checkList("bcasts", k.BCasts, 0, 5)
+	checkList("depths", k.Depths, 0, unbounded)
+	check("swap", k.Swap, 0, 2)
+	check("swappingThreshold", k.SwappingThreshold, 0, unbounded)
+	check("l1", k.L1, 0, 1)
+	check("u", k.U, 0, 1)
+	check("equilibration", k.Equilibration, 0, 1)
+	check("alignment", k.Alignment, 1, unbounded)
+
+	return errors.Join(errs...)
+}
+
+// variantRegex matches the T/V column of HPL, printed as
+// W<order><depth><bcast><rfact><ndiv><pfact><nbmin>, e.g. WR01C2R4 for a row
+// major process mapping, a lookahead depth of 0, the 1rM broadcast, a Crout
+// recursive factorization in 2 panels, and a right panel factorization
+// stopping at 4 columns. The broadcast is a single digit, the other numbers
+// may have several.
+var variantRegex = regexp.MustCompile(`^W[RC](\d+)(\d)([LCR])(\d+)([LCR])(\d+)$`)
+
+// factorizations maps the factorization letters of the T/V column to their
+// values in HPL.dat.
+var factorizations = map[byte]int{'L': 0, 'C': 1, 'R': 2}
+
+// SelectVariant narrows the swept lists to the values of the T/V column of a
+// result. The knobs are returned unchanged when none is swept.
+func (k DATKnobs) SelectVariant(variant string) (DATKnobs, error) {
+	if len(k.PFacts) <= 1 && len(k.NBMins) <= 1 && len(k.NDivs) <= 1 &&
+		len(k.RFacts) <= 1 && len(k.BCasts) <= 1 && len(k.Depths) <= 1 {
+		return k, nil
+	}
+
+	match := variantRegex.FindStringSubmatch(strings.TrimSpace(variant))
+	if match == nil {
+		return k, fmt.Errorf("unknown variant %q, cannot select the swept knobs", variant)
+	}
+
+	digit := func(s string) []int {
+		v, _ := strconv.Atoi(s)
+		return []int{v}
+	}
+	narrow := func(values []int, selected []int) []int {
+		if len(values) > 1 {
+			return selected
+		}
+		return values
+	}
+	k.Depths = narrow(k.Depths, digit(match[1]))
+	k.BCasts = narrow(k.BCasts, digit(match[2]))
+	k.RFacts = narrow(k.RFacts, []int{factorizations[match[3][0]]})
+	k.NDivs = narrow(k.NDivs, digit(match[4]))
+	k.PFacts = narrow(k.PFacts, []int{factorizations[match[5][0]]})
+	k.NBMins = narrow(k.NBMins, digit(match[6]))
+	return k, nil
+}
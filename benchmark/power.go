@@ -0,0 +1,48 @@
+package benchmark
+
+import (
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// PowerDir is the directory of the workspace in which the power samplers
+// write, in a subdirectory per job.
+const PowerDir = "power"
+
+// DefaultPowerInterval is the default interval between power samples.
+const DefaultPowerInterval = time.Second
+
+// PowerSampling configures the power samplers launched on each node alongside
+// the benchmark. nvidia-smi samples the power of the GPUs, and optionally
+// IPMI the power of the node and RAPL the energy of the CPUs.
+type PowerSampling struct {
+	Enabled bool
+	// Interval between samples, DefaultPowerInterval when 0
+	Interval time.Duration
+	IPMI     bool
+	RAPL     bool
+}
+
+// SamplingInterval returns the interval between samples.
+func (p PowerSampling) SamplingInterval() time.Duration {
+	if p.Interval <= 0 {
+		return DefaultPowerInterval
+	}
+	return p.Interval
+}
+
+// IntervalMs returns the interval in milliseconds, as expected by nvidia-smi.
+func (p PowerSampling) IntervalMs() int64 {
+	return max(1, p.SamplingInterval().Milliseconds())
+}
+
+// IntervalSeconds returns the interval in seconds, as expected by sleep.
+func (p PowerSampling) IntervalSeconds() string {
+	return strconv.FormatFloat(p.SamplingInterval().Seconds(), 'f', -1, 64)
+}
+
+// PowerSamplesDir returns the directory of the power samples of a job.
+func PowerSamplesDir(workspace string, jobID int) string {
+	return filepath.Join(workspace, PowerDir, strconv.Itoa(jobID))
+}
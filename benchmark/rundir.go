@@ -0,0 +1,92 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/squarefactory/benchmark-api/utils"
+)
+
+const (
+	DatFileName      = "hpl.dat"
+	SbatchFileName   = "benchmark.sbatch"
+	JobOutputName    = "job-%j.log"
+	MetadataFileName = "metadata.json"
+)
+
+// RunDir is the isolated working directory of a benchmark run. It holds the
+// generated DAT and sbatch files, the raw output of each job, the CSV results
+// and a metadata file.
+type RunDir struct {
+	ID   string
+	Path string
+}
+
+// RunMetadata describes a benchmark run, and is stored in its RunDir.
+type RunMetadata struct {
+	ID            string           `json:"id"`
+	StartTime     time.Time        `json:"startTime"`
+	EndTime       *time.Time       `json:"endTime,omitempty"`
+	Node          int              `json:"node"`
+	ContainerPath string           `json:"containerPath"`
+	GPUModel      string           `json:"gpuModel,omitempty"`
+	FirstSet      []*scheduler.Job `json:"firstSet,omitempty"`
+	SecondSet     []*scheduler.Job `json:"secondSet,omitempty"`
+	OptimalParams *DATParams       `json:"optimalParams,omitempty"`
+}
+
+// NewRunDir creates a new run directory named <timestamp>-<id> under base.
+func NewRunDir(base string) (*RunDir, error) {
+	id := fmt.Sprintf(
+		"%s-%s",
+		time.Now().Format("20060102-150405"),
+		utils.GenerateRandomString(6),
+	)
+
+	// The job runs on other nodes, it needs an absolute path
+	path, err := filepath.Abs(filepath.Join(base, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		log.Printf("Failed to create run directory: %s", err)
+		return nil, err
+	}
+
+	return &RunDir{
+		ID:   id,
+		Path: path,
+	}, nil
+}
+
+// File returns the path of a file in the run directory.
+func (d *RunDir) File(name string) string {
+	return filepath.Join(d.Path, name)
+}
+
+// SubDir creates a directory in the run directory and returns its path.
+func (d *RunDir) SubDir(name string) (string, error) {
+	path := d.File(name)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		log.Printf("Failed to create directory %s: %s", path, err)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// WriteMetadata writes the metadata file of the run.
+func (d *RunDir) WriteMetadata(meta *RunMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(d.File(MetadataFileName), data, 0o644)
+}
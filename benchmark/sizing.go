@@ -0,0 +1,90 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+)
+
+// SizingModel selects the memory against which the problem size N is sized.
+type SizingModel string
+
+const (
+	// SizingHost sizes N against the host memory, for CPU HPL.
+	SizingHost SizingModel = "host"
+	// SizingDeviceFP64 sizes N against the GPU memory with a double precision
+	// matrix, for GPU HPL.
+	SizingDeviceFP64 SizingModel = "device-fp64"
+	// SizingDeviceFP32 sizes N against the GPU memory with a single precision
+	// matrix, for HPL-AI.
+	SizingDeviceFP32 SizingModel = "device-fp32"
+)
+
+// SizingModels lists the valid sizing models.
+var SizingModels = []SizingModel{SizingHost, SizingDeviceFP64, SizingDeviceFP32}
+
+// ParseSizingModel parses a sizing model name. An empty name is SizingHost.
+func ParseSizingModel(name string) (SizingModel, error) {
+	if name == "" {
+		return SizingHost, nil
+	}
+	for _, model := range SizingModels {
+		if SizingModel(name) == model {
+			return model, nil
+		}
+	}
+	return "", fmt.Errorf("unknown sizing model: %s", name)
+}
+
+// device reports whether the model sizes N against the GPU memory.
+func (m SizingModel) device() bool {
+	return m == SizingDeviceFP64 || m == SizingDeviceFP32
+}
+
+// elementSize returns the size in bytes of a matrix element.
+func (m SizingModel) elementSize() int {
+	if m == SizingDeviceFP32 {
+		return 4
+	}
+	return 8
+}
+
+// calculateDeviceProblemSize sizes N against the total GPU memory of the
+// nodes. The Ns are rounded to the NB of each candidate by the tuner, see
+// RoundProblemSize.
+func (b *Benchmark) calculateDeviceProblemSize(ctx context.Context) error {
+	gpuMem, err := b.SlurmClient.FindGPUMemory(ctx)
+	if err != nil {
+		log.Printf("failed to find gpu memory: %s", err)
+		return err
+	}
+	gpus, err := b.SlurmClient.FindGPUPerNode(ctx)
+	if err != nil {
+		log.Printf("failed to calculate gpus per node: %s", err)
+		return err
+	}
+
+	elements := float64(gpuMem) * (1 << 20) * float64(gpus*b.Sbatch.Node) /
+		float64(b.Sizing.elementSize())
+
+	fractions := b.Space.memoryFractions()
+	b.Dat.NProblemSize = len(fractions)
+	for _, values := range fractions {
+		problemSize := int(math.Sqrt(elements * values))
+
+		b.Dat.ProblemSize += strconv.Itoa(problemSize) + " "
+	}
+
+	return nil
+}
+
+// RoundProblemSize rounds N down to a multiple of NB, so that the last
+// block of the matrix is not ragged. N smaller than NB is left as is.
+func RoundProblemSize(n int, nb int) int {
+	if nb <= 0 || n < nb {
+		return n
+	}
+	return n - n%nb
+}
@@ -0,0 +1,140 @@
+package benchmark
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultBlockSizes are the NBs swept by the first set.
+var DefaultBlockSizes = []int{64, 128, 224, 256, 384, 512, 640, 768, 896, 1024}
+
+// Grid is a P x Q process grid.
+type Grid struct {
+	P int `json:"p"`
+	Q int `json:"q"`
+}
+
+// SearchSpace holds the parameters swept by the first set. Empty fields fall
+// back to the defaults.
+type SearchSpace struct {
+	// MemoryFractions of the memory used by the matrix, from which the Ns are
+	// computed
+	MemoryFractions []float64
+	// ProblemSizes are explicit Ns, used instead of MemoryFractions
+	ProblemSizes []int
+	// BlockSizes are the NBs
+	BlockSizes []int
+	// Grids are the process grids, computed from the number of GPUs when
+	// empty
+	Grids []Grid
+	// GridSweep enumerates the process grids when Grids is empty, unless
+	// disabled
+	GridSweep GridSweep
+	// Knobs are the other parameters of the DAT, HPL sweeps their lists
+	Knobs DATKnobs
+}
+
+// GridSweep selects the factorizations of the number of GPUs swept as process
+// grids. All of them are swept by default.
+type GridSweep struct {
+	// Disabled keeps the most square factorization instead of sweeping all of
+	// them
+	Disabled bool `json:"disabled,omitempty"`
+	// PLessOrEqualQ keeps the grids with P <= Q
+	PLessOrEqualQ bool `json:"pLessOrEqualQ,omitempty"`
+	// MaxAspectRatio keeps the grids with max(P, Q) / min(P, Q) up to it,
+	// unbounded when 0
+	MaxAspectRatio float64 `json:"maxAspectRatio,omitempty"`
+}
+
+func aspectRatio(grid Grid) float64 {
+	return float64(max(grid.P, grid.Q)) / float64(min(grid.P, grid.Q))
+}
+
+// Grids returns the P x Q factorizations of processes kept by the sweep, the
+// most square first.
+func (s *GridSweep) Grids(processes int) []Grid {
+	var grids []Grid
+	for p := 1; p <= processes; p++ {
+		if processes%p != 0 {
+			continue
+		}
+		grid := Grid{P: p, Q: processes / p}
+		if s.PLessOrEqualQ && grid.P > grid.Q {
+			continue
+		}
+		if s.MaxAspectRatio > 0 && aspectRatio(grid) > s.MaxAspectRatio {
+			continue
+		}
+		grids = append(grids, grid)
+	}
+
+	slices.SortStableFunc(grids, func(a, b Grid) int {
+		return cmp.Compare(aspectRatio(a), aspectRatio(b))
+	})
+	return grids
+}
+
+func (s *SearchSpace) memoryFractions() []float64 {
+	if len(s.MemoryFractions) == 0 {
+		return benchmarkMemoryUsePercentage
+	}
+	return s.MemoryFractions
+}
+
+func (s *SearchSpace) blockSizes() []int {
+	if len(s.BlockSizes) == 0 {
+		return DefaultBlockSizes
+	}
+	return s.BlockSizes
+}
+
+// SetSweep sets the Ns, NBs and process grids of the DAT.
+func (d *DATParams) SetSweep(problemSizes, blockSizes []int, grids []Grid) {
+	d.NProblemSize = len(problemSizes)
+	d.ProblemSize = joinInts(problemSizes)
+	d.NBlockSize = len(blockSizes)
+	d.BlockSize = joinInts(blockSizes)
+	d.Grids = grids
+	d.P = grids[0].P
+	d.Q = grids[0].Q
+}
+
+// Sweep returns the Ns, NBs and process grids of the DAT.
+func (d *DATParams) Sweep() (problemSizes, blockSizes []int, grids []Grid, err error) {
+	if problemSizes, err = splitInts(d.ProblemSize); err != nil {
+		return nil, nil, nil, err
+	}
+	if blockSizes, err = splitInts(d.BlockSize); err != nil {
+		return nil, nil, nil, err
+	}
+	grids = d.Grids
+	if len(grids) == 0 {
+		grids = []Grid{{P: d.P, Q: d.Q}}
+	}
+	return problemSizes, blockSizes, grids, nil
+}
+
+// joinInts formats integers as a space-separated list.
+func joinInts(values []int) string {
+	s := make([]string, 0, len(values))
+	for _, v := range values {
+		s = append(s, strconv.Itoa(v))
+	}
+	return strings.Join(s, " ")
+}
+
+// splitInts parses a space-separated list of integers.
+func splitInts(s string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Fields(s) {
+		v, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
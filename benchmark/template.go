@@ -10,3 +10,46 @@ var MultiNodeTmpl string
 
 //go:embed templates/singlenode.tmpl
 var SingleNodeTmpl string
+
+//go:embed templates/pbs_multinode.tmpl
+var PBSMultiNodeTmpl string
+
+//go:embed templates/pbs_singlenode.tmpl
+var PBSSingleNodeTmpl string
+
+//go:embed templates/k8s_multinode.tmpl
+var KubernetesMultiNodeTmpl string
+
+//go:embed templates/k8s_singlenode.tmpl
+var KubernetesSingleNodeTmpl string
+
+//go:embed templates/local.tmpl
+var LocalTmpl string
+
+// JobTemplates is the set of job script templates of a scheduler.
+type JobTemplates struct {
+	SingleNode string
+	MultiNode  string
+}
+
+var SlurmTemplates = JobTemplates{
+	SingleNode: SingleNodeTmpl,
+	MultiNode:  MultiNodeTmpl,
+}
+
+var PBSTemplates = JobTemplates{
+	SingleNode: PBSSingleNodeTmpl,
+	MultiNode:  PBSMultiNodeTmpl,
+}
+
+var KubernetesTemplates = JobTemplates{
+	SingleNode: KubernetesSingleNodeTmpl,
+	MultiNode:  KubernetesMultiNodeTmpl,
+}
+
+// LocalTemplates run on a single host, multi node benchmarks are not
+// supported.
+var LocalTemplates = JobTemplates{
+	SingleNode: LocalTmpl,
+	MultiNode:  LocalTmpl,
+}
@@ -0,0 +1,215 @@
+package compare
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/squarefactory/benchmark-api/history"
+	"github.com/squarefactory/benchmark-api/stats"
+	"github.com/urfave/cli/v2"
+)
+
+// regressionExitCode is the exit code on regression, errors exiting with 1.
+const regressionExitCode = 2
+
+var flags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "history.dir",
+		Value: history.DefaultDir,
+		Usage: "Directory of the run history.",
+		EnvVars: []string{
+			"HISTORY_DIR",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "baseline",
+		Usage: "Name of the baseline to compare the run with, the node set of the run by default.",
+	},
+	&cli.Float64Flag{
+		Name:  "threshold",
+		Value: 100 * history.DefaultThreshold,
+		Usage: "Drop of the median Gflops, in percent, considered a regression.",
+		EnvVars: []string{
+			"REGRESSION_THRESHOLD",
+		},
+		Action: func(ctx *cli.Context, v float64) error {
+			if v < 0 || v >= 100 {
+				return fmt.Errorf("invalid threshold %g, must be in [0, 100)", v)
+			}
+			return nil
+		},
+	},
+	&cli.Float64Flag{
+		Name:  "alpha",
+		Value: history.DefaultAlpha,
+		Usage: "Significance level of the Mann-Whitney U test.",
+		EnvVars: []string{
+			"REGRESSION_ALPHA",
+		},
+		Action: func(ctx *cli.Context, v float64) error {
+			if v <= 0 || v >= 1 {
+				return fmt.Errorf("invalid alpha %g, must be in (0, 1)", v)
+			}
+			return nil
+		},
+	},
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "Print the comparison as JSON.",
+	},
+}
+
+var Command = &cli.Command{
+	Name:  "compare",
+	Usage: "Compare the second set of a run with a baseline, and exit with 2 on regression.",
+	Description: "With two run IDs, the second run is compared with the first one. With a single run ID, it is\n" +
+		"compared with its baseline, tagged with `history baseline`.",
+	ArgsUsage: "[<baseline_run_id>] <run_id>",
+	Flags:     flags,
+	Action: func(cCtx *cli.Context) error {
+		if cCtx.NArg() < 1 || cCtx.NArg() > 2 {
+			return errors.New("expected one or two run IDs")
+		}
+		dir := cCtx.String("history.dir")
+		if _, err := os.Stat(dir); err != nil {
+			log.Printf("Failed to open the history: %s", err)
+			return err
+		}
+		store := &history.Store{Dir: dir}
+
+		current, err := store.Load(cCtx.Args().Get(cCtx.NArg() - 1))
+		if err != nil {
+			return err
+		}
+		var baseline *history.Record
+		if cCtx.NArg() == 2 {
+			baseline, err = store.Load(cCtx.Args().Get(0))
+		} else {
+			name := cCtx.String("baseline")
+			if name == "" {
+				name = history.NodeSet(current)
+			}
+			baseline, err = store.Baseline(name)
+		}
+		if err != nil {
+			return err
+		}
+
+		threshold := cCtx.Float64("threshold") / 100
+		alpha := cCtx.Float64("alpha")
+		c, err := history.Compare(baseline, current, threshold, alpha)
+		if err != nil {
+			return err
+		}
+
+		if cCtx.Bool("json") {
+			encoder := json.NewEncoder(cCtx.App.Writer)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(struct {
+				Baseline string `json:"baselineId"`
+				Current  string `json:"currentId"`
+				*history.Comparison
+			}{
+				Baseline:   baseline.ID,
+				Current:    current.ID,
+				Comparison: c,
+			}); err != nil {
+				return err
+			}
+		} else {
+			writeComparison(cCtx.App.Writer, baseline, current, c, threshold, alpha)
+		}
+
+		if c.Regression {
+			return cli.Exit(
+				fmt.Sprintf("regression: the median dropped by %.1f%% since %s", -100*c.Delta, baseline.ID),
+				regressionExitCode,
+			)
+		}
+		return nil
+	},
+}
+
+func writeComparison(
+	w io.Writer,
+	baseline *history.Record,
+	current *history.Record,
+	c *history.Comparison,
+	threshold float64,
+	alpha float64,
+) {
+	for _, run := range []struct {
+		name    string
+		record  *history.Record
+		summary stats.Summary
+	}{
+		{"baseline", baseline, c.Baseline},
+		{"current", current, c.Current},
+	} {
+		params := ""
+		if p := run.record.OptimalParams; p != nil {
+			params = fmt.Sprintf(", N=%s NB=%s P=%d Q=%d", p.ProblemSize, p.BlockSize, p.P, p.Q)
+		}
+		fmt.Fprintf(
+			w,
+			"%-8s  %s: %d runs, median %.4g, mean %.4g, stddev %.4g Gflops%s\n",
+			run.name,
+			run.record.ID,
+			run.summary.Count,
+			run.summary.Median,
+			run.summary.Mean,
+			run.summary.StdDev,
+			params,
+		)
+	}
+	exact := "normal approximation"
+	if c.Test.Exact {
+		exact = "exact"
+	}
+	fmt.Fprintf(
+		w,
+		"delta     %+.2f%% of the median, Mann-Whitney U=%g, p=%.4g (%s)\n",
+		100*c.Delta,
+		c.Test.U,
+		c.Test.P,
+		exact,
+	)
+	verdict := "no regression"
+	if c.Regression {
+		verdict = "REGRESSION"
+	}
+	fmt.Fprintf(w, "verdict   %s (threshold %g%%, alpha %g)\n", verdict, 100*threshold, alpha)
+
+	if baseline.Version != current.Version {
+		fmt.Fprintf(w, "note      the tool version changed from %s to %s\n", baseline.Version, current.Version)
+	}
+	if baseline.ContainerDigest != current.ContainerDigest {
+		fmt.Fprintf(
+			w,
+			"note      the container image changed from %s (%s) to %s (%s)\n",
+			baseline.ContainerImage,
+			orDash(baseline.ContainerDigest),
+			current.ContainerImage,
+			orDash(current.ContainerDigest),
+		)
+	}
+	if baseline.Resources.GPUModel != current.Resources.GPUModel {
+		fmt.Fprintf(
+			w,
+			"note      the GPU model changed from %s to %s\n",
+			baseline.Resources.GPUModel,
+			current.Resources.GPUModel,
+		)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,321 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/history"
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/urfave/cli/v2"
+)
+
+var flags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "history.dir",
+		Value: history.DefaultDir,
+		Usage: "Directory of the run history.",
+		EnvVars: []string{
+			"HISTORY_DIR",
+		},
+	},
+}
+
+var Command = &cli.Command{
+	Name:  "history",
+	Usage: "Browse the history of the runs.",
+	Flags: flags,
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Usage:  "List the recorded runs.",
+			Action: list,
+		},
+		{
+			Name:      "show",
+			Usage:     "Show a recorded run.",
+			ArgsUsage: "<run_id>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the whole record as JSON.",
+				},
+			},
+			Action: show,
+		},
+		{
+			Name:      "baseline",
+			Usage:     "Tag a run as the baseline of its node set, or list the baselines when no ID is given.",
+			ArgsUsage: "[run_id]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "name",
+					Usage: "Name of the baseline, the node set of the run (node count and selection) by default.",
+				},
+			},
+			Action: baseline,
+		},
+		{
+			Name:      "export",
+			Usage:     "Export the records of the runs, all of them when no ID is given.",
+			ArgsUsage: "[run_id...]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "format",
+					Value: string(resultparser.FormatJSON),
+					Usage: fmt.Sprintf(
+						"Format of the export, one of: %s, %s (the records), %s (their results).",
+						resultparser.FormatJSON,
+						resultparser.FormatNDJSON,
+						resultparser.FormatCSV,
+					),
+					Action: func(ctx *cli.Context, s string) error {
+						_, err := resultparser.ParseFormat(s)
+						return err
+					},
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Usage:   "File to which the export is written, standard output by default.",
+					Aliases: []string{"o"},
+				},
+			},
+			Action: export,
+		},
+	},
+}
+
+func openStore(cCtx *cli.Context) (*history.Store, error) {
+	dir := cCtx.String("history.dir")
+	if _, err := os.Stat(dir); err != nil {
+		log.Printf("Failed to open the history: %s", err)
+		return nil, err
+	}
+	return &history.Store{Dir: dir}, nil
+}
+
+func list(cCtx *cli.Context) error {
+	store, err := openStore(cCtx)
+	if err != nil {
+		return err
+	}
+	records, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cCtx.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTART\tSTATE\tNODES\tGPU\tN\tNB\tP\tQ\tMEDIAN GFLOPS\tVERSION")
+	for _, r := range records {
+		n, nb, p, q, median := "-", "-", "-", "-", "-"
+		if r.OptimalParams != nil {
+			n, nb = r.OptimalParams.ProblemSize, r.OptimalParams.BlockSize
+			p, q = fmt.Sprint(r.OptimalParams.P), fmt.Sprint(r.OptimalParams.Q)
+		}
+		if len(r.Statistics) > 0 {
+			median = fmt.Sprintf("%.4g", r.Statistics[0].Median)
+		}
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.ID,
+			r.StartTime.Local().Format(time.DateTime),
+			r.State,
+			r.Node,
+			orDash(r.Resources.GPUModel),
+			n,
+			nb,
+			p,
+			q,
+			median,
+			orDash(r.Version),
+		)
+	}
+	return w.Flush()
+}
+
+func show(cCtx *cli.Context) error {
+	if cCtx.NArg() < 1 {
+		return errors.New("not enough arguments")
+	}
+	store, err := openStore(cCtx)
+	if err != nil {
+		return err
+	}
+	r, err := store.Load(cCtx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		encoder := json.NewEncoder(cCtx.App.Writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	}
+	writeRecord(cCtx.App.Writer, r)
+	return nil
+}
+
+// writeRecord writes the main fields of a record.
+func writeRecord(out io.Writer, r *history.Record) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", r.ID)
+	fmt.Fprintf(w, "State:\t%s\n", r.State)
+	if r.Error != "" {
+		fmt.Fprintf(w, "Error:\t%s\n", r.Error)
+	}
+	fmt.Fprintf(w, "Start:\t%s\n", r.StartTime.Local().Format(time.DateTime))
+	if r.EndTime != nil {
+		fmt.Fprintf(
+			w,
+			"End:\t%s (%s)\n",
+			r.EndTime.Local().Format(time.DateTime),
+			r.EndTime.Sub(r.StartTime).Round(time.Second),
+		)
+	}
+	fmt.Fprintf(w, "Version:\t%s\n", orDash(r.Version))
+	fmt.Fprintf(w, "Directory:\t%s\n", r.Path)
+	fmt.Fprintf(w, "Scheduler:\t%s\n", r.Scheduler)
+	fmt.Fprintf(w, "Nodes:\t%d\n", r.Node)
+	for _, selection := range []struct{ name, value string }{
+		{"Partition", r.Selection.Partition},
+		{"Node list", r.Selection.NodeList},
+		{"Exclude", r.Selection.Exclude},
+		{"Constraint", r.Selection.Constraint},
+		{"Reservation", r.Selection.Reservation},
+	} {
+		if selection.value != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", selection.name, selection.value)
+		}
+	}
+	fmt.Fprintf(w, "Container:\t%s\n", r.ContainerImage)
+	fmt.Fprintf(w, "Digest:\t%s\n", orDash(r.ContainerDigest))
+	fmt.Fprintf(w, "GPU model:\t%s\n", orDash(r.Resources.GPUModel))
+	fmt.Fprintf(
+		w,
+		"Per node:\t%d GPUs, %d tasks, %d CPUs per task\n",
+		r.Resources.GPUsPerNode,
+		r.Resources.TasksPerNode,
+		r.Resources.CPUsPerTask,
+	)
+	for _, node := range r.Resources.Nodes {
+		fmt.Fprintf(w, "Node %s:\t%d MB, %d GPUs, %d CPUs\n", node.Name, node.Memory, node.GPUs, node.CPUs)
+	}
+	if p := r.OptimalParams; p != nil {
+		fmt.Fprintf(w, "Parameters:\tN=%s NB=%s P=%d Q=%d\n", p.ProblemSize, p.BlockSize, p.P, p.Q)
+	}
+	fmt.Fprintf(w, "Results:\t%d\n", len(r.Results))
+	for _, s := range r.Statistics {
+		fmt.Fprintf(
+			w,
+			"N=%d NB=%d P=%d Q=%d %s %s:\t%d runs, mean %.4g, median %.4g, stddev %.4g, min %.4g, max %.4g Gflops\n",
+			s.ProblemSize,
+			s.NB,
+			s.P,
+			s.Q,
+			s.Kind,
+			s.Variant,
+			s.Count,
+			s.Mean,
+			s.Median,
+			s.StdDev,
+			s.Min,
+			s.Max,
+		)
+	}
+	w.Flush()
+}
+
+func baseline(cCtx *cli.Context) error {
+	store, err := openStore(cCtx)
+	if err != nil {
+		return err
+	}
+
+	if cCtx.NArg() == 0 {
+		baselines, err := store.Baselines()
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(baselines))
+		for name := range baselines {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		w := tabwriter.NewWriter(cCtx.App.Writer, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tRUN")
+		for _, name := range names {
+			fmt.Fprintf(w, "%s\t%s\n", name, baselines[name])
+		}
+		return w.Flush()
+	}
+
+	r, err := store.Load(cCtx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	name := cCtx.String("name")
+	if name == "" {
+		name = history.NodeSet(r)
+	}
+	if err := store.SetBaseline(name, r.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(cCtx.App.Writer, "run %s is the baseline of %s\n", r.ID, name)
+	return nil
+}
+
+func export(cCtx *cli.Context) error {
+	format, err := resultparser.ParseFormat(cCtx.String("format"))
+	if err != nil {
+		return err
+	}
+	store, err := openStore(cCtx)
+	if err != nil {
+		return err
+	}
+
+	var records []*history.Record
+	if cCtx.NArg() == 0 {
+		records, err = store.List()
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range cCtx.Args().Slice() {
+		r, err := store.Load(id)
+		if err != nil {
+			return err
+		}
+		records = append(records, r)
+	}
+
+	path := cCtx.String("output")
+	if path == "" {
+		return history.Export(cCtx.App.Writer, format, records)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := history.Export(file, format, records); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
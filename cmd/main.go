@@ -4,7 +4,11 @@ import (
 	"log"
 	"os"
 
+	"github.com/squarefactory/benchmark-api/cmd/compare"
+	"github.com/squarefactory/benchmark-api/cmd/history"
 	"github.com/squarefactory/benchmark-api/cmd/run"
+	"github.com/squarefactory/benchmark-api/cmd/screen"
+	"github.com/squarefactory/benchmark-api/cmd/serve"
 	"github.com/urfave/cli/v2"
 )
 
@@ -19,6 +23,10 @@ var app = &cli.App{
 	Flags:   flags,
 	Commands: []*cli.Command{
 		run.Command,
+		serve.Command,
+		history.Command,
+		compare.Command,
+		screen.Command,
 	},
 	Suggest: true,
 }
@@ -3,28 +3,55 @@ package run
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/config"
 	"github.com/squarefactory/benchmark-api/executor"
+	"github.com/squarefactory/benchmark-api/history"
+	"github.com/squarefactory/benchmark-api/peak"
 	"github.com/squarefactory/benchmark-api/resultparser"
 	"github.com/squarefactory/benchmark-api/scheduler"
-	"github.com/squarefactory/benchmark-api/try"
+	"github.com/squarefactory/benchmark-api/stats"
+	"github.com/squarefactory/benchmark-api/tuner"
 	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
-	user                 = "root"
-	firstSetResults      = "first_set.csv"
-	secondSetResults     = "second_set.csv"
-	benchmarkInSecondSet = 20
+	user             = "root"
+	firstSetResults  = "first_set"
+	secondSetResults = "second_set"
+	jobRetries       = 3
 )
 
-var flags = []cli.Flag{
+const (
+	schedulerSlurm      = "slurm"
+	schedulerSlurmREST  = "slurmrest"
+	schedulerPBS        = "pbs"
+	schedulerKubernetes = "kubernetes"
+	schedulerLocal      = "local"
+)
+
+// Flags are the flags of the run command, also used by the serve command.
+var Flags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "config",
+		Usage: "YAML or JSON run configuration, defining the search space, repetitions and timeouts.",
+		EnvVars: []string{
+			"CONFIG",
+		},
+	},
 	&cli.StringFlag{
 		Name:  "container.path",
 		Value: "/etc/hpl-benchmark/hpc-benchmarks:hpl.sqsh",
@@ -33,17 +60,222 @@ var flags = []cli.Flag{
 		},
 		Aliases: []string{"c"},
 		Action: func(ctx *cli.Context, s string) error {
-			info, err := os.Stat(s)
-			if err != nil {
-				return err
-			}
-			perms := info.Mode().Perm()
-			if perms&0o077 != 0 {
-				log.Fatal(
-					"incorrect permissions for container .sqsh, must be user-only",
-				)
-			}
-			return nil
+			return validateContainerPath(s, ctx.String("scheduler"), ctx.String("container.runtime"))
+		},
+	},
+	&cli.StringFlag{
+		Name:  "container.runtime",
+		Value: "enroot",
+		Usage: "Container runtime used by the local scheduler, enroot or apptainer.",
+		EnvVars: []string{
+			"CONTAINER_RUNTIME",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "scheduler",
+		Value: schedulerSlurm,
+		Usage: fmt.Sprintf(
+			"Scheduler backend, one of: %s, %s, %s, %s, %s.",
+			schedulerSlurm,
+			schedulerSlurmREST,
+			schedulerPBS,
+			schedulerKubernetes,
+			schedulerLocal,
+		),
+		EnvVars: []string{
+			"SCHEDULER",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "slurmrest.url",
+		Usage: "URL of slurmrestd, used by the slurmrest scheduler.",
+		EnvVars: []string{
+			"SLURMRESTD_URL",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "slurmrest.user",
+		Value: user,
+		Usage: "User name sent to slurmrestd, used by the slurmrest scheduler.",
+		EnvVars: []string{
+			"SLURM_USER_NAME",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "slurmrest.token",
+		Usage: "JWT sent to slurmrestd, used by the slurmrest scheduler.",
+		EnvVars: []string{
+			"SLURM_JWT",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "kubernetes.kubeconfig",
+		Usage: "Path to the kubeconfig, used by the kubernetes scheduler. Defaults to the in-cluster configuration.",
+		EnvVars: []string{
+			"KUBECONFIG",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "kubernetes.namespace",
+		Value: "default",
+		Usage: "Namespace of the benchmark jobs, used by the kubernetes scheduler.",
+		EnvVars: []string{
+			"KUBERNETES_NAMESPACE",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "partition",
+		Usage: "Partition of the benchmarked nodes.",
+		EnvVars: []string{
+			"PARTITION",
+		},
+		Aliases: []string{"p"},
+	},
+	&cli.StringFlag{
+		Name:  "nodelist",
+		Usage: "Host list of the benchmarked nodes, e.g. gpu[01-04].",
+		EnvVars: []string{
+			"NODELIST",
+		},
+		Aliases: []string{"w"},
+	},
+	&cli.StringFlag{
+		Name:  "exclude",
+		Usage: "Host list of nodes to leave out of the benchmark.",
+		EnvVars: []string{
+			"EXCLUDE",
+		},
+		Aliases: []string{"x"},
+	},
+	&cli.StringFlag{
+		Name:  "constraint",
+		Usage: "Features required on the benchmarked nodes, e.g. a100&ib.",
+		EnvVars: []string{
+			"CONSTRAINT",
+		},
+		Aliases: []string{"C"},
+	},
+	&cli.StringFlag{
+		Name:  "reservation",
+		Usage: "Reservation in which to run the benchmark.",
+		EnvVars: []string{
+			"RESERVATION",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "account",
+		Usage: "Account charged for the benchmark jobs.",
+		EnvVars: []string{
+			"ACCOUNT",
+		},
+		Aliases: []string{"A"},
+	},
+	&cli.StringFlag{
+		Name:  "sizing",
+		Value: string(benchmark.SizingHost),
+		Usage: fmt.Sprintf(
+			"Memory against which the problem size is sized, one of: %s (CPU HPL), %s (GPU HPL), %s (HPL-AI).",
+			benchmark.SizingHost,
+			benchmark.SizingDeviceFP64,
+			benchmark.SizingDeviceFP32,
+		),
+		EnvVars: []string{
+			"SIZING",
+		},
+		Action: func(ctx *cli.Context, s string) error {
+			_, err := benchmark.ParseSizingModel(s)
+			return err
+		},
+	},
+	&cli.BoolFlag{
+		Name:  "refuse-heterogeneous",
+		Usage: "Fail instead of warning when the selected nodes differ in memory, GPU or CPU count.",
+		EnvVars: []string{
+			"REFUSE_HETEROGENEOUS",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "results.format",
+		Value: string(resultparser.FormatCSV),
+		Usage: fmt.Sprintf(
+			"Format of the exported results, one of: %s, %s, %s.",
+			resultparser.FormatCSV,
+			resultparser.FormatJSON,
+			resultparser.FormatNDJSON,
+		),
+		EnvVars: []string{
+			"RESULTS_FORMAT",
+		},
+		Action: func(ctx *cli.Context, s string) error {
+			_, err := resultparser.ParseFormat(s)
+			return err
+		},
+	},
+	&cli.BoolFlag{
+		Name:  "power",
+		Usage: "Sample the power of the GPUs with nvidia-smi on each node during the jobs, and report the energy of each result. Slurm schedulers only.",
+		EnvVars: []string{
+			"POWER",
+		},
+	},
+	&cli.DurationFlag{
+		Name:  "power.interval",
+		Value: benchmark.DefaultPowerInterval,
+		Usage: "Interval between power samples.",
+		EnvVars: []string{
+			"POWER_INTERVAL",
+		},
+	},
+	&cli.BoolFlag{
+		Name:  "power.ipmi",
+		Usage: "Also sample the power of the nodes with ipmitool, which then replaces the power of the GPUs and CPUs.",
+		EnvVars: []string{
+			"POWER_IPMI",
+		},
+	},
+	&cli.BoolFlag{
+		Name:  "power.rapl",
+		Usage: "Also sample the energy of the CPUs from their RAPL counters.",
+		EnvVars: []string{
+			"POWER_RAPL",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "metrics.textfile",
+		Usage: "Metrics file to which the results are written, e.g. in the directory of the textfile collector of node_exporter.",
+		EnvVars: []string{
+			"METRICS_TEXTFILE",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "metrics.pushgateway",
+		Usage: "URL of a Pushgateway to which the results are pushed.",
+		EnvVars: []string{
+			"PUSHGATEWAY_URL",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "metrics.job",
+		Value: "hpl_benchmark",
+		Usage: "Job name under which the results are pushed to the Pushgateway.",
+		EnvVars: []string{
+			"METRICS_JOB",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "runs.dir",
+		Value: "runs",
+		Usage: "Directory in which each run gets its own working directory.",
+		EnvVars: []string{
+			"RUNS_DIR",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "history.dir",
+		Value: history.DefaultDir,
+		Usage: "Directory of the run history, read by the history command. Empty to disable the history.",
+		EnvVars: []string{
+			"HISTORY_DIR",
 		},
 	},
 }
@@ -51,7 +283,7 @@ var flags = []cli.Flag{
 var Command = &cli.Command{
 	Name:      "run",
 	Usage:     "Run an HPL-AI benchmark.",
-	Flags:     flags,
+	Flags:     Flags,
 	ArgsUsage: "<node_number>",
 	Action: func(cCtx *cli.Context) error {
 
@@ -67,204 +299,909 @@ var Command = &cli.Command{
 			return err
 		}
 
-		containerPath := os.Getenv("CONTAINER_PATH")
-		workspace := filepath.Dir(containerPath)
-		firstSet := benchmark.NewBenchmark(
-			benchmark.DATParams{},
-			benchmark.SBATCHParams{
-				Node:          node,
-				ContainerPath: containerPath,
-				Workspace:     workspace,
-			},
-			scheduler.NewSlurm(&executor.Shell{}, user),
-		)
-
-		log.Printf("running first set, with general parameters")
-		if err := RunFirstSet(firstSet, ctx); err != nil {
-			log.Printf("failed to run first set of benchmark: %s", err)
+		opts, err := NewOptions(cCtx)
+		if err != nil {
+			return err
+		}
+		opts.Node = node
+		if path := cCtx.String("config"); path != "" {
+			opts.Config, err = config.Load(path)
+			if err != nil {
+				log.Printf("failed to load configuration: %s", err)
+				return err
+			}
+		}
+		if err := opts.Validate(); err != nil {
 			return err
 		}
 
-		log.Printf("first set finished running, processing results")
+		slurmClient, templates, err := NewScheduler(cCtx, opts.NodeSelection())
+		if err != nil {
+			log.Printf("failed to create scheduler: %s", err)
+			return err
+		}
 
-		optimalParams, err := ProcessFirstSet()
+		runDir, err := benchmark.NewRunDir(cCtx.String("runs.dir"))
 		if err != nil {
-			log.Printf("failed to process first set: %s", err)
+			log.Printf("failed to create run directory: %s", err)
 			return err
 		}
 
-		optimalSet := benchmark.NewBenchmark(
-			benchmark.DATParams{
-				NProblemSize: optimalParams.NProblemSize,
-				ProblemSize:  optimalParams.ProblemSize,
-				NBlockSize:   optimalParams.NBlockSize,
-				BlockSize:    optimalParams.BlockSize,
-				P:            optimalParams.P,
-				Q:            optimalParams.Q,
-			},
-			benchmark.SBATCHParams{
-				Node:          node,
-				ContainerPath: containerPath,
-				Workspace:     workspace,
+		if err := Execute(ctx, slurmClient, templates, runDir, opts); err != nil {
+			return err
+		}
+		log.Printf("results are available in %s", runDir.Path)
+		return nil
+	},
+}
+
+// Options are the parameters of a run, set by the flags of the run command or
+// by the requests to the API of the serve command.
+type Options struct {
+	// Scheduler is the name of the scheduler backend
+	Scheduler string
+	// Node is the number of nodes of the benchmark
+	Node int
+	// Config is the run configuration, the default one when nil
+	Config *config.Config
+	// Sbatch are the parameters of the jobs, completed with the node count
+	// and the workspace of each set
+	Sbatch              benchmark.SBATCHParams
+	Sizing              benchmark.SizingModel
+	RefuseHeterogeneous bool
+	Format              resultparser.Format
+	Metrics             MetricsOptions
+	// Observer is notified of the progress of the run, when not nil
+	Observer Observer
+	// History records the run, when not nil
+	History *history.Store
+	// Version of the tool, recorded in the history
+	Version string
+}
+
+// MetricsOptions are the destinations of the metrics export, which is
+// disabled when both are empty.
+type MetricsOptions struct {
+	Textfile    string
+	Pushgateway string
+	Job         string
+	// Grouping are the labels of the Pushgateway group, with the job
+	Grouping map[string]string
+}
+
+// Observer is notified of the progress of a run.
+type Observer interface {
+	// SetStarted is called when the first or second set starts
+	SetStarted(set string)
+	// JobCompleted is called with the results of each completed job
+	JobCompleted(job *scheduler.Job, results []resultparser.Result)
+}
+
+// NewOptions returns the options set by the flags, without the node count.
+func NewOptions(cCtx *cli.Context) (Options, error) {
+	sizing, err := benchmark.ParseSizingModel(cCtx.String("sizing"))
+	if err != nil {
+		return Options{}, err
+	}
+	format, err := resultparser.ParseFormat(cCtx.String("results.format"))
+	if err != nil {
+		return Options{}, err
+	}
+
+	var store *history.Store
+	if dir := cCtx.String("history.dir"); dir != "" {
+		store, err = history.NewStore(dir)
+		if err != nil {
+			return Options{}, err
+		}
+	}
+
+	selection := nodeSelection(cCtx)
+	return Options{
+		Scheduler: cCtx.String("scheduler"),
+		Sbatch: benchmark.SBATCHParams{
+			ContainerPath:    os.Getenv("CONTAINER_PATH"),
+			ContainerRuntime: cCtx.String("container.runtime"),
+			Partition:        selection.Partition,
+			NodeList:         selection.NodeList,
+			Exclude:          selection.Exclude,
+			Constraint:       selection.Constraint,
+			Reservation:      selection.Reservation,
+			Account:          cCtx.String("account"),
+			Power: benchmark.PowerSampling{
+				Enabled:  cCtx.Bool("power"),
+				Interval: cCtx.Duration("power.interval"),
+				IPMI:     cCtx.Bool("power.ipmi"),
+				RAPL:     cCtx.Bool("power.rapl"),
 			},
-			scheduler.NewSlurm(&executor.Shell{}, user),
-		)
+		},
+		Sizing:              sizing,
+		RefuseHeterogeneous: cCtx.Bool("refuse-heterogeneous"),
+		Format:              format,
+		Metrics: MetricsOptions{
+			Textfile:    cCtx.String("metrics.textfile"),
+			Pushgateway: cCtx.String("metrics.pushgateway"),
+			Job:         cCtx.String("metrics.job"),
+		},
+		History: store,
+		Version: cCtx.App.Version,
+	}, nil
+}
 
-		log.Printf("running second set, with optimal parameters")
-		if err := RunSecondSet(optimalSet, ctx); err != nil {
-			log.Printf("failed to run second set of benchmark: %s", err)
-			return err
+// Validate checks that the scheduler supports the options.
+func (o *Options) Validate() error {
+	if o.Node < 1 {
+		return fmt.Errorf("invalid node count %d, must be at least 1", o.Node)
+	}
+	if o.Scheduler == schedulerLocal && o.Node != 1 {
+		return errors.New("the local scheduler only runs single node benchmarks")
+	}
+	if o.Sbatch.Power.Enabled &&
+		o.Scheduler != schedulerSlurm &&
+		o.Scheduler != schedulerSlurmREST {
+		return errors.New("power sampling is only supported by the Slurm schedulers")
+	}
+	if o.Config != nil && o.Config.ContainerPath != "" {
+		if err := validateContainerPath(
+			o.Config.ContainerPath,
+			o.Scheduler,
+			o.Sbatch.ContainerRuntime,
+		); err != nil {
+			return fmt.Errorf("containerPath: %w", err)
 		}
+	}
+	if o.NodeSelection() != (scheduler.NodeSelection{}) &&
+		o.Scheduler != schedulerSlurm &&
+		o.Scheduler != schedulerSlurmREST {
+		return fmt.Errorf(
+			"the %s scheduler does not support --partition, --nodelist, --exclude, --constraint nor --reservation",
+			o.Scheduler,
+		)
+	}
+	return nil
+}
 
+// validateContainerPath checks that the container image exists, is readable
+// by its owner only, and is in the format of the container runtime: a .sif
+// for apptainer, a .sqsh for enroot and pyxis.
+func validateContainerPath(path string, schedulerName string, runtime string) error {
+	// Kubernetes pulls the container image from a registry
+	if schedulerName == schedulerKubernetes {
 		return nil
-	},
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("incorrect permissions for container %s, must be user-only", path)
+	}
+
+	ext := ".sqsh"
+	if schedulerName == schedulerLocal && runtime == "apptainer" {
+		ext = ".sif"
+	}
+	if filepath.Ext(path) != ext {
+		return fmt.Errorf("container %s is not a %s image", path, ext)
+	}
+	return nil
 }
 
-func RunFirstSet(b *benchmark.Benchmark, ctx context.Context) error {
+// NodeSelection returns the nodes selected by the options.
+func (o *Options) NodeSelection() scheduler.NodeSelection {
+	return scheduler.NodeSelection{
+		Partition:   o.Sbatch.Partition,
+		NodeList:    o.Sbatch.NodeList,
+		Exclude:     o.Sbatch.Exclude,
+		Constraint:  o.Sbatch.Constraint,
+		Reservation: o.Sbatch.Reservation,
+	}
+}
 
-	if err := b.CalculateBenchmarkParams(ctx); err != nil {
-		log.Printf("failed to calculate first set parameters")
+// sbatchParams returns the parameters of the jobs of a set.
+func (o *Options) sbatchParams(containerPath string, workspace string) benchmark.SBATCHParams {
+	params := o.Sbatch
+	params.Node = o.Node
+	params.ContainerPath = containerPath
+	params.Workspace = workspace
+	return params
+}
+
+// Execute runs the first set of the benchmark in the run directory, then the
+// second set with the best parameters of the first one. The run is recorded
+// in the history.
+func Execute(
+	ctx context.Context,
+	slurmClient benchmark.SlurmScheduler,
+	templates benchmark.JobTemplates,
+	runDir *benchmark.RunDir,
+	opts Options,
+) error {
+	if opts.Config == nil {
+		opts.Config = config.Default()
+	}
+	record := &history.Record{
+		ID:        runDir.ID,
+		Version:   opts.Version,
+		State:     history.StateRunning,
+		StartTime: time.Now(),
+		Path:      runDir.Path,
+		Scheduler: opts.Scheduler,
+		Node:      opts.Node,
+		Selection: opts.NodeSelection(),
+		Config:    opts.Config,
+	}
+	saveRecord(opts.History, record)
+
+	err := execute(ctx, slurmClient, templates, runDir, opts, record)
+	record.Finish(err, ctx.Err() != nil)
+	saveRecord(opts.History, record)
+	return err
+}
+
+// saveRecord saves the record in the history, if any. Failures are logged
+// only, as the run directory holds the results anyway.
+func saveRecord(store *history.Store, record *history.Record) {
+	if store == nil {
+		return
+	}
+	if err := store.Save(record); err != nil {
+		log.Printf("failed to record run %s in the history: %s", record.ID, err)
+	}
+}
+
+func execute(
+	ctx context.Context,
+	slurmClient benchmark.SlurmScheduler,
+	templates benchmark.JobTemplates,
+	runDir *benchmark.RunDir,
+	opts Options,
+	record *history.Record,
+) error {
+	cfg := opts.Config
+	containerPath := opts.Sbatch.ContainerPath
+	if cfg.ContainerPath != "" {
+		containerPath = cfg.ContainerPath
+	}
+	log.Printf("working in %s", runDir.Path)
+
+	record.ContainerImage = containerPath
+	if opts.History != nil {
+		digest, err := history.ContainerDigest(containerPath)
+		if err != nil {
+			log.Printf("failed to find the digest of %s: %s", containerPath, err)
+		}
+		record.ContainerDigest = digest
+	}
+
+	meta := &benchmark.RunMetadata{
+		ID:            runDir.ID,
+		StartTime:     time.Now(),
+		Node:          opts.Node,
+		ContainerPath: containerPath,
+	}
+	if err := runDir.WriteMetadata(meta); err != nil {
+		log.Printf("failed to write run metadata: %s", err)
 		return err
 	}
 
-	files, err := b.GenerateFiles(ctx)
+	firstSetWorkspace, err := runDir.SubDir("first_set")
 	if err != nil {
-		log.Printf("Failed to generate benchmark files: %s", err)
 		return err
 	}
+	firstSet := benchmark.NewBenchmark(
+		benchmark.DATParams{},
+		opts.sbatchParams(containerPath, firstSetWorkspace),
+		slurmClient,
+	)
+	firstSet.Templates = templates
+	firstSet.Space = cfg.SearchSpace()
+	firstSet.RefuseHeterogeneous = opts.RefuseHeterogeneous
+	firstSet.Sizing = opts.Sizing
 
-	output, err := os.Create(scheduler.JobOutput)
+	budget := cfg.Budget()
+	gpuModel, gpuPeak := findGPUPeak(ctx, slurmClient, cfg)
+	meta.GPUModel = gpuModel
+	firstResults := newResultSet(runDir, firstSetResults, resultparser.SetFirst, opts)
+	firstResults.gpuModel, firstResults.gpuPeak = gpuModel, gpuPeak
+	secondResults := newResultSet(runDir, secondSetResults, resultparser.SetSecond, opts)
+	secondResults.gpuModel, secondResults.gpuPeak = gpuModel, gpuPeak
+	record.Resources.GPUModel = gpuModel
+	defer func() {
+		record.Results = slices.Concat(firstResults.results, secondResults.results)
+		record.Statistics = secondResults.summaries
+	}()
+	log.Printf("running first set, with general parameters")
+	if opts.Observer != nil {
+		opts.Observer.SetStarted(firstResults.set)
+	}
+	optimalParams, jobs, err := RunFirstSet(
+		firstSet,
+		ctx,
+		cfg,
+		budget,
+		firstResults,
+	)
+	meta.FirstSet = jobs
+	record.Resources.Nodes = firstSet.Nodes
+	record.Resources.GPUsPerNode = firstSet.Sbatch.GpusPerNode
+	record.Resources.TasksPerNode = firstSet.Sbatch.NtasksPerNode
+	record.Resources.CPUsPerTask = firstSet.Sbatch.CpusPerTasks
+	record.Resources.GPUAffinity = firstSet.Sbatch.GpuAffinity
+	record.Resources.CPUAffinity = firstSet.Sbatch.CpuAffinity
+	exportMetrics(ctx, opts.Metrics, firstResults.results)
 	if err != nil {
-		log.Printf("failed to create output file: %s", err)
+		log.Printf("failed to run first set of benchmark: %s", err)
+		return err
+	}
+	meta.OptimalParams = &optimalParams
+	record.OptimalParams = &optimalParams
+	record.Results = firstResults.results
+	saveRecord(opts.History, record)
+	if err := runDir.WriteMetadata(meta); err != nil {
+		log.Printf("failed to write run metadata: %s", err)
 		return err
 	}
-	defer output.Close()
 
-	if err := b.Run(ctx, &files); err != nil {
-		log.Printf("Failed to run benchmark: %s", err)
+	secondSetWorkspace, err := runDir.SubDir("second_set")
+	if err != nil {
 		return err
 	}
+	optimalSet := benchmark.NewBenchmark(
+		benchmark.DATParams{
+			NProblemSize: optimalParams.NProblemSize,
+			ProblemSize:  optimalParams.ProblemSize,
+			NBlockSize:   optimalParams.NBlockSize,
+			BlockSize:    optimalParams.BlockSize,
+			P:            optimalParams.P,
+			Q:            optimalParams.Q,
+			Knobs:        optimalParams.Knobs,
+		},
+		opts.sbatchParams(containerPath, secondSetWorkspace),
+		slurmClient,
+	)
+	optimalSet.Templates = templates
 
-	_, err = try.Do(func() (int, error) {
-		_, err := b.SlurmClient.FindRunningJobByName(
-			ctx,
-			&scheduler.FindRunningJobByNameRequest{
-				Name: benchmark.JobName,
-				User: benchmark.User,
-			},
-		)
-		if err == nil {
-			log.Print("benchmark is still running, unable to process results")
-			return 0, errors.New("benchmark is still running")
+	log.Printf("running second set, with optimal parameters")
+	if opts.Observer != nil {
+		opts.Observer.SetStarted(secondResults.set)
+	}
+	jobs, err = RunSecondSet(
+		optimalSet,
+		ctx,
+		cfg,
+		budget,
+		secondResults,
+	)
+	meta.SecondSet = jobs
+	exportMetrics(ctx, opts.Metrics, slices.Concat(firstResults.results, secondResults.results))
+	now := time.Now()
+	meta.EndTime = &now
+	if err := runDir.WriteMetadata(meta); err != nil {
+		log.Printf("failed to write run metadata: %s", err)
+	}
+	if err != nil {
+		log.Printf("failed to run second set of benchmark: %s", err)
+		return err
+	}
+	return nil
+}
+
+// findGPUPeak returns the model of the GPUs and its peak, nil when the peak
+// of the model is unknown. The efficiency of the results is only reported
+// when the peak is known.
+func findGPUPeak(
+	ctx context.Context,
+	client benchmark.SlurmScheduler,
+	cfg *config.Config,
+) (string, *peak.Peak) {
+	model := cfg.GPUModel
+	if model == "" {
+		var err error
+		model, err = client.FindGPUModel(ctx)
+		if err != nil {
+			log.Printf("failed to find the GPU model, efficiency will not be reported: %s", err)
+			return "", nil
 		}
+	}
 
-		return 0, nil
-	}, 60, 5*time.Minute)
+	p, ok := cfg.PeakTable().Lookup(model)
+	if !ok {
+		log.Printf("no peak known for %s, efficiency will not be reported, see peaks in the configuration", model)
+		return model, nil
+	}
+	log.Printf(
+		"GPU model %s, peak per GPU: %g FP64, %g FP64 tensor, %g FP16 tensor TFLOPS",
+		model,
+		p.TFLOPS(peak.PrecisionFP64),
+		p.TFLOPS(peak.PrecisionFP64Tensor),
+		p.TFLOPS(peak.PrecisionFP16Tensor),
+	)
+	return model, &p
+}
 
-	if err != nil {
-		log.Printf("Benchmark is still running, unable to process results")
-		return err
+// nodeSelection returns the nodes selected by the command line flags.
+func nodeSelection(cCtx *cli.Context) scheduler.NodeSelection {
+	return scheduler.NodeSelection{
+		Partition:   cCtx.String("partition"),
+		NodeList:    cCtx.String("nodelist"),
+		Exclude:     cCtx.String("exclude"),
+		Constraint:  cCtx.String("constraint"),
+		Reservation: cCtx.String("reservation"),
 	}
+}
 
-	return nil
+// NewScheduler creates the scheduler backend selected by the --scheduler flag,
+// along with its job templates. The Slurm schedulers discover the resources of
+// the selected nodes only.
+func NewScheduler(
+	cCtx *cli.Context,
+	selection scheduler.NodeSelection,
+) (benchmark.SlurmScheduler, benchmark.JobTemplates, error) {
+	switch cCtx.String("scheduler") {
+	case schedulerSlurm:
+		return scheduler.NewSlurm(
+			&executor.Shell{},
+			user,
+			selection,
+			cCtx.String("account"),
+		), benchmark.SlurmTemplates, nil
+	case schedulerSlurmREST:
+		if cCtx.String("slurmrest.url") == "" {
+			return nil, benchmark.JobTemplates{}, errors.New(
+				"--slurmrest.url is required by the slurmrest scheduler",
+			)
+		}
+		return scheduler.NewSlurmREST(
+			&http.Client{Timeout: time.Minute},
+			cCtx.String("slurmrest.url"),
+			cCtx.String("slurmrest.user"),
+			cCtx.String("slurmrest.token"),
+			selection,
+		), benchmark.SlurmTemplates, nil
+	case schedulerPBS:
+		return scheduler.NewPBS(&executor.Shell{}, user), benchmark.PBSTemplates, nil
+	case schedulerKubernetes:
+		config, err := clientcmd.BuildConfigFromFlags("", cCtx.String("kubernetes.kubeconfig"))
+		if err != nil {
+			return nil, benchmark.JobTemplates{}, err
+		}
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, benchmark.JobTemplates{}, err
+		}
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, benchmark.JobTemplates{}, err
+		}
+		return scheduler.NewKubernetes(
+			clientset,
+			dynamicClient,
+			cCtx.String("kubernetes.namespace"),
+		), benchmark.KubernetesTemplates, nil
+	case schedulerLocal:
+		return scheduler.NewLocal(&executor.Shell{}, user), benchmark.LocalTemplates, nil
+	default:
+		return nil, benchmark.JobTemplates{}, fmt.Errorf(
+			"unknown scheduler: %s",
+			cCtx.String("scheduler"),
+		)
+	}
 }
 
-func ProcessFirstSet() (benchmark.DATParams, error) {
+// WaitTries returns the number of polls, delay apart, lasting timeout.
+func WaitTries(timeout config.Duration, delay time.Duration) int {
+	tries := int((time.Duration(timeout) + delay - 1) / delay)
+	return max(1, tries)
+}
+
+// RunFirstSet tunes the parameters of the benchmark through the stages of the
+// configured strategy, and returns the best ones along with the jobs.
+func RunFirstSet(
+	b *benchmark.Benchmark,
+	ctx context.Context,
+	cfg *config.Config,
+	budget *tuner.Budget,
+	results *resultSet,
+) (benchmark.DATParams, []*scheduler.Job, error) {
 
-	if err := resultparser.WriteHeaderToCsv(firstSetResults, resultparser.CsvHeader); err != nil {
-		log.Printf("Failed to write header to csv: %s", err)
-		return benchmark.DATParams{}, err
+	if err := b.CalculateBenchmarkParams(ctx); err != nil {
+		log.Printf("failed to calculate first set parameters")
+		return benchmark.DATParams{}, nil, err
 	}
 
-	if err := resultparser.WriteResultsToCSV(scheduler.JobOutput, firstSetResults); err != nil {
-		log.Printf("Failed to process results: %s", err)
-		return benchmark.DATParams{}, err
+	problemSizes, blockSizes, grids, err := b.Dat.Sweep()
+	if err != nil {
+		log.Printf("failed to read first set parameters: %s", err)
+		return benchmark.DATParams{}, nil, err
 	}
 
-	optimalRow, err := resultparser.FindMaxGflopsRow(firstSetResults)
+	strategy, err := cfg.Strategy()
 	if err != nil {
-		log.Printf("Failed to find row containing max gflops score: %s", err)
-		return benchmark.DATParams{}, err
+		return benchmark.DATParams{}, nil, err
 	}
 
-	p, err := strconv.Atoi(optimalRow[2])
+	delay := 5 * time.Minute
+	evaluator := &jobEvaluator{
+		benchmark: b,
+		results:   results,
+		tries:     WaitTries(cfg.Timeouts.FirstSet, delay),
+		delay:     delay,
+	}
+	t := &tuner.Tuner{
+		Strategy:  strategy,
+		Evaluator: evaluator,
+		Budget:    budget,
+		Criterion: cfg.Criterion(),
+		Repeats:   cfg.Repeats(),
+	}
+	best, _, err := t.Run(ctx, tuner.Space{
+		ProblemSizes: problemSizes,
+		BlockSizes:   blockSizes,
+		Grids:        grids,
+	})
 	if err != nil {
-		log.Printf("failed to convert %s as integer: %s", optimalRow[2], err)
-		return benchmark.DATParams{}, err
+		log.Printf("Failed to tune the benchmark: %s", err)
+		return benchmark.DATParams{}, evaluator.jobs, err
+	}
+	log.Printf(
+		"best parameters by %s: N=%d NB=%d P=%d Q=%d %s, %s",
+		t.Criterion,
+		best.N,
+		best.NB,
+		best.P,
+		best.Q,
+		best.Variant,
+		formatSummary(best.Summary),
+	)
+
+	if err := results.writeSummary(); err != nil {
+		return benchmark.DATParams{}, evaluator.jobs, err
 	}
 
-	q, err := strconv.Atoi(optimalRow[3])
+	knobs, err := b.Dat.Knobs.SelectVariant(best.Variant)
 	if err != nil {
-		log.Printf("failed to convert %s as integer: %s", optimalRow[2], err)
-		return benchmark.DATParams{}, err
+		log.Printf("Failed to select the knobs of the best variant: %s", err)
+		return benchmark.DATParams{}, evaluator.jobs, err
 	}
 
 	return benchmark.DATParams{
 		NProblemSize: 1,
-		ProblemSize:  optimalRow[0],
+		ProblemSize:  strconv.Itoa(best.N),
 		NBlockSize:   1,
-		BlockSize:    optimalRow[1],
-		P:            p,
-		Q:            q,
-	}, nil
+		BlockSize:    strconv.Itoa(best.NB),
+		P:            best.P,
+		Q:            best.Q,
+		Knobs:        knobs,
+	}, evaluator.jobs, nil
+}
+
+// jobEvaluator evaluates the spaces of the tuner in first set jobs.
+type jobEvaluator struct {
+	benchmark *benchmark.Benchmark
+	results   *resultSet
+	tries     int
+	delay     time.Duration
+	jobs      []*scheduler.Job
+}
+
+func (e *jobEvaluator) Evaluate(
+	ctx context.Context,
+	stage int,
+	space tuner.Space,
+) ([]tuner.Result, error) {
+	e.benchmark.Dat.SetSweep(space.ProblemSizes, space.BlockSizes, space.Grids)
+
+	files, err := e.benchmark.GenerateFiles(ctx)
+	if err != nil {
+		log.Printf("Failed to generate benchmark files: %s", err)
+		return nil, err
+	}
+
+	job, err := RunJob(ctx, e.benchmark, &files, e.tries, e.delay)
+	if err != nil {
+		return nil, err
+	}
+	e.jobs = append(e.jobs, job)
+
+	output, err := e.results.add(e.benchmark, job)
+	if err != nil {
+		return nil, err
+	}
+	return tunerResults(output.Runs), nil
 }
 
-func RunSecondSet(b *benchmark.Benchmark, ctx context.Context) error {
+// tunerResults converts the runs to tuner results. Runs which failed the
+// residual check are left out, so that they are never selected.
+func tunerResults(runs []resultparser.Run) []tuner.Result {
+	results := make([]tuner.Result, 0, len(runs))
+	for _, run := range runs {
+		if run.Failed() {
+			log.Printf(
+				"N=%d NB=%d P=%d Q=%d failed the residual check (%g), ignoring it",
+				run.N,
+				run.NB,
+				run.P,
+				run.Q,
+				run.Residual,
+			)
+			continue
+		}
+		results = append(results, tuner.Result{
+			Candidate: tuner.Candidate{N: run.N, NB: run.NB, P: run.P, Q: run.Q, Variant: run.Variant},
+			Gflops:    run.Gflops,
+		})
+	}
+	return results
+}
+
+// RunJob submits the benchmark and waits for it to complete. Jobs which did
+// not finish as COMPLETED are resubmitted when the failure is unrelated to the
+// benchmark (preemption, node failure...), and reported otherwise.
+func RunJob(
+	ctx context.Context,
+	b *benchmark.Benchmark,
+	files *benchmark.BenchmarkFile,
+	tries int,
+	delay time.Duration,
+) (*scheduler.Job, error) {
+	for attempt := 1; ; attempt++ {
+		job, err := b.Run(ctx, files)
+		if err != nil {
+			log.Printf("Failed to run benchmark: %s", err)
+			return nil, err
+		}
+
+		if err := b.Wait(ctx, job, tries, delay); err != nil {
+			log.Printf("Benchmark is still running, unable to process results: %s", err)
+			// The job is cancelled along with the run
+			if err := b.Cancel(context.WithoutCancel(ctx), job); err != nil {
+				log.Printf("Failed to cancel job %d: %s", job.ID, err)
+			}
+			return nil, err
+		}
+
+		state, err := b.State(ctx, job)
+		if err != nil {
+			log.Printf("Failed to find final state of job %d: %s", job.ID, err)
+			// The job may still be running, it is not left behind
+			if err := b.Cancel(context.WithoutCancel(ctx), job); err != nil {
+				log.Printf("Failed to cancel job %d: %s", job.ID, err)
+			}
+			return nil, err
+		}
+		if err := b.CollectOutput(ctx, job); err != nil {
+			log.Printf("Failed to collect output: %s", err)
+			return nil, err
+		}
+
+		if state.Completed() {
+			return job, nil
+		}
+
+		err = fmt.Errorf("job %d did not complete: %s", job.ID, state)
+		if !state.Retriable() || attempt >= jobRetries {
+			log.Printf("Benchmark failed: %s", err)
+			return nil, err
+		}
+		log.Printf("%s, retrying (%d/%d)", err, attempt, jobRetries)
+	}
+}
+
+func RunSecondSet(
+	b *benchmark.Benchmark,
+	ctx context.Context,
+	cfg *config.Config,
+	budget *tuner.Budget,
+	results *resultSet,
+) ([]*scheduler.Job, error) {
 
 	if err := b.CalculateSBATCHParams(ctx); err != nil {
 		log.Printf("failed to calculate sbatch params for optimal set: %s", err)
-		return err
+		return nil, err
 	}
 
 	files, err := b.GenerateFiles(ctx)
 	if err != nil {
 		log.Printf("Failed to generate benchmark files: %s", err)
-		return err
+		return nil, err
 	}
 
-	output, err := os.Create(scheduler.JobOutput)
+	delay := 2 * time.Minute
+	var jobs []*scheduler.Job
+	for i := 0; i < cfg.Repetitions; i++ {
+		if budget.Exhausted() {
+			log.Printf("budget exhausted after %d of %d repetitions", i, cfg.Repetitions)
+			break
+		}
+		job, err := RunJob(ctx, b, &files, WaitTries(cfg.Timeouts.SecondSet, delay), delay)
+		if err != nil {
+			return jobs, err
+		}
+		budget.Spend(1)
+		jobs = append(jobs, job)
+
+		if _, err := results.add(b, job); err != nil {
+			return jobs, err
+		}
+	}
+
+	if err := results.writeSummary(); err != nil {
+		return jobs, err
+	}
+	return jobs, nil
+}
+
+// resultSet collects the results of the jobs of a set, and exports them after
+// each job.
+type resultSet struct {
+	runID   string
+	set     string
+	format  resultparser.Format
+	path    string
+	summary string
+	// gpuModel and gpuPeak are the model of the GPUs and its peak, nil when
+	// unknown
+	gpuModel  string
+	gpuPeak   *peak.Peak
+	observer  Observer
+	results   []resultparser.Result
+	summaries []resultparser.ConfigSummary
+}
+
+func newResultSet(
+	runDir *benchmark.RunDir,
+	name string,
+	set string,
+	opts Options,
+) *resultSet {
+	return &resultSet{
+		runID:    runDir.ID,
+		set:      set,
+		format:   opts.Format,
+		path:     runDir.File(name + opts.Format.Extension()),
+		summary:  runDir.File(name + "_summary.csv"),
+		observer: opts.Observer,
+	}
+}
+
+// add parses the output of a job of the benchmark, and exports the results.
+func (s *resultSet) add(
+	b *benchmark.Benchmark,
+	job *scheduler.Job,
+) (*resultparser.Output, error) {
+	output, err := resultparser.ParseFile(job.OutputFile)
 	if err != nil {
-		log.Printf("failed to create output file: %s", err)
-		return err
+		log.Printf("Failed to process results: %s", err)
+		return nil, err
+	}
+	for _, message := range output.Errors {
+		log.Printf("job %d reported: %s", job.ID, message)
 	}
-	defer output.Close()
 
-	if err := resultparser.WriteHeaderToCsv(secondSetResults, resultparser.CsvHeader); err != nil {
-		log.Printf("Failed to write header to csv: %s", err)
-		return err
+	results := output.Results(resultparser.Metadata{
+		RunID:          s.runID,
+		Set:            s.set,
+		JobID:          job.ID,
+		Nodes:          b.Sbatch.Node,
+		GPUs:           b.Sbatch.Node * b.Sbatch.GpusPerNode,
+		ContainerImage: b.Sbatch.ContainerPath,
+		SubmitTime:     job.SubmitTime,
+		ParseTime:      time.Now(),
+		GPUModel:       s.gpuModel,
+		GPUPeak:        s.gpuPeak,
+	})
+	if b.Sbatch.Power.Enabled {
+		measureEnergy(b, job, results)
+	}
+	s.results = append(s.results, results...)
+	if s.observer != nil {
+		s.observer.JobCompleted(job, results)
+	}
+	if err := resultparser.WriteResults(s.path, s.format, s.results); err != nil {
+		return nil, err
 	}
+	return output, nil
+}
 
-	for i := 0; i < benchmarkInSecondSet; i++ {
-		if err := b.Run(ctx, &files); err != nil {
-			log.Printf("Failed to run benchmark: %s", err)
-			return err
+// measureEnergy sets the energy of the results of a job from its power
+// samples. Missing samples are logged only.
+func measureEnergy(b *benchmark.Benchmark, job *scheduler.Job, results []resultparser.Result) {
+	trace, err := resultparser.ReadPowerDir(benchmark.PowerSamplesDir(b.Sbatch.Workspace, job.ID))
+	if err != nil {
+		log.Printf("failed to read the power samples of job %d: %s", job.ID, err)
+		return
+	}
+	if b.Sbatch.Power.RAPL {
+		if hosts := trace.Unsampled(resultparser.PowerSourceRAPL); len(hosts) > 0 {
+			log.Printf(
+				"warning: no RAPL sample of job %d on %s, the energy counters may be readable by root only",
+				job.ID,
+				strings.Join(hosts, ","),
+			)
+		}
+	}
+	resultparser.MeasureEnergy(results, trace, b.Sbatch.Power.SamplingInterval())
+	for _, result := range results {
+		if result.Energy == nil {
+			continue
 		}
+		log.Printf(
+			"N=%d NB=%d P=%d Q=%d: %.4g Gflops, %.4g W, %.4g J, %.4g Gflops/W",
+			result.N,
+			result.NB,
+			result.P,
+			result.Q,
+			result.Gflops,
+			result.Energy.Watts,
+			result.Energy.Joules,
+			result.Energy.GflopsPerWatt,
+		)
+	}
+}
 
-		_, err = try.Do(func() (int, error) {
-			_, err := b.SlurmClient.FindRunningJobByName(
-				ctx,
-				&scheduler.FindRunningJobByNameRequest{
-					Name: benchmark.JobName,
-					User: benchmark.User,
-				},
+// writeSummary writes the statistics of each configuration, and logs them.
+func (s *resultSet) writeSummary() error {
+	summaries := resultparser.SummarizeResults(s.results)
+	s.summaries = summaries
+	for _, summary := range summaries {
+		efficiency := ""
+		if summary.Rpeak > 0 {
+			efficiency = fmt.Sprintf(
+				", Rpeak %.4g Gflops, Rmax/Rpeak %.1f%%",
+				summary.Rpeak,
+				100*summary.Efficiency,
 			)
-			if err == nil {
-				log.Print("benchmark is still running, unable to process results")
-				return 0, errors.New("benchmark is still running")
-			}
+		}
+		log.Printf(
+			"N=%d NB=%d P=%d Q=%d %s %s: %s%s",
+			summary.ProblemSize,
+			summary.NB,
+			summary.P,
+			summary.Q,
+			summary.Kind,
+			summary.Variant,
+			formatSummary(summary.Summary),
+			efficiency,
+		)
+	}
 
-			return 0, nil
-		}, 10, 2*time.Minute)
+	return resultparser.WriteSummaryToCsv(summaries, s.summary)
+}
 
-		if err != nil {
-			log.Printf("Benchmark is still running, unable to process results")
-			return err
+// exportMetrics writes the results to the metrics textfile and pushes them to
+// the Pushgateway, when configured. Failures are logged only, as the results
+// are exported in the run directory anyway.
+func exportMetrics(ctx context.Context, opts MetricsOptions, results []resultparser.Result) {
+	if path := opts.Textfile; path != "" {
+		if err := resultparser.WriteMetricsFile(path, results); err != nil {
+			log.Printf("failed to write metrics to %s: %s", path, err)
 		}
-
-		if err := resultparser.AppendResultsToCsv(scheduler.JobOutput, secondSetResults); err != nil {
-			log.Printf("Failed to process results: %s", err)
-			return err
+	}
+	if gateway := opts.Pushgateway; gateway != "" {
+		if err := resultparser.PushMetrics(
+			ctx,
+			&http.Client{Timeout: time.Minute},
+			gateway,
+			opts.Job,
+			opts.Grouping,
+			results,
+		); err != nil {
+			log.Printf("failed to push metrics to %s: %s", gateway, err)
 		}
 	}
-	return nil
+}
+
+func formatSummary(s stats.Summary) string {
+	return fmt.Sprintf(
+		"%d runs, mean %.4g, median %.4g, stddev %.4g, min %.4g, max %.4g, 95%% CI [%.4g, %.4g] Gflops",
+		s.Count,
+		s.Mean,
+		s.Median,
+		s.StdDev,
+		s.Min,
+		s.Max,
+		s.CILow,
+		s.CIHigh,
+	)
 }
@@ -0,0 +1,436 @@
+package screen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/cmd/run"
+	"github.com/squarefactory/benchmark-api/config"
+	"github.com/squarefactory/benchmark-api/executor"
+	"github.com/squarefactory/benchmark-api/history"
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/squarefactory/benchmark-api/screen"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	user           = "root"
+	schedulerSlurm = "slurm"
+	reportFile     = "screen.csv"
+	nodesDir       = "nodes"
+	// outlierExitCode is the exit code when outliers are found, errors
+	// exiting with 1
+	outlierExitCode = 2
+)
+
+var flags = slices.Concat(run.Flags, []cli.Flag{
+	&cli.StringFlag{
+		Name:  "run",
+		Usage: "ID of the single node run of the history whose optimal parameters are used, the latest one of the partition by default.",
+	},
+	&cli.Float64Flag{
+		Name:  "threshold",
+		Value: 100 * screen.DefaultThreshold,
+		Usage: "Drop below the median Gflops of the partition, in percent, from which a node is an outlier.",
+		EnvVars: []string{
+			"SCREEN_THRESHOLD",
+		},
+		Action: func(ctx *cli.Context, v float64) error {
+			if v < 0 || v >= 100 {
+				return fmt.Errorf("invalid threshold %g, must be in [0, 100)", v)
+			}
+			return nil
+		},
+	},
+	&cli.BoolFlag{
+		Name:  "drain",
+		Usage: "Drain the outliers with scontrol update.",
+	},
+	&cli.StringFlag{
+		Name:  "drain.reason",
+		Value: "HPL screening",
+		Usage: "Reason of the drain, followed by the verdict of the node.",
+	},
+})
+
+var Command = &cli.Command{
+	Name:  "screen",
+	Usage: "Benchmark each node of a partition individually, and exit with 2 when some are outliers.",
+	Description: "A single node job is submitted on each schedulable node of the selection, all of them at once, with\n" +
+		"the optimal parameters of a single node run of the history. Nodes whose Gflops are below the median\n" +
+		"of the partition by more than the threshold, or whose job failed because of the node (node failure,\n" +
+		"failed residual check), are reported and optionally drained. Nodes whose job timed out or was cancelled\n" +
+		"are reported as not screened.",
+	Flags: flags,
+	Action: func(cCtx *cli.Context) error {
+		ctx := cCtx.Context
+
+		opts, err := run.NewOptions(cCtx)
+		if err != nil {
+			return err
+		}
+		opts.Node = 1
+		if opts.Scheduler != schedulerSlurm {
+			return fmt.Errorf("the screen command only supports the %s scheduler", schedulerSlurm)
+		}
+		if opts.History == nil {
+			return errors.New("the screen command reads the optimal parameters from the history, --history.dir is required")
+		}
+		if err := opts.Validate(); err != nil {
+			return err
+		}
+
+		record, err := tunedRecord(opts.History, cCtx.String("run"), opts.Sbatch.Partition)
+		if err != nil {
+			return err
+		}
+		log.Printf(
+			"screening with the parameters of run %s: N=%s NB=%s P=%d Q=%d",
+			record.ID,
+			record.OptimalParams.ProblemSize,
+			record.OptimalParams.BlockSize,
+			record.OptimalParams.P,
+			record.OptimalParams.Q,
+		)
+
+		slurm := scheduler.NewSlurm(&executor.Shell{}, user, opts.NodeSelection(), opts.Sbatch.Account)
+		nodes, err := schedulableNodes(ctx, slurm)
+		if err != nil {
+			return err
+		}
+
+		runDir, err := benchmark.NewRunDir(cCtx.String("runs.dir"))
+		if err != nil {
+			log.Printf("failed to create run directory: %s", err)
+			return err
+		}
+		log.Printf("working in %s", runDir.Path)
+
+		opts.Sbatch.ContainerPath = containerPath(cCtx, record)
+		log.Printf("screening with the container %s", opts.Sbatch.ContainerPath)
+
+		results, err := screenNodes(ctx, slurm, runDir, opts, record, nodes)
+		if err != nil {
+			return err
+		}
+
+		threshold := cCtx.Float64("threshold") / 100
+		report := screen.Evaluate(results, threshold)
+		if err := writeReport(runDir.File(reportFile), &report); err != nil {
+			return err
+		}
+		writeNodes(cCtx.App.Writer, &report)
+		log.Printf("report is available in %s", runDir.File(reportFile))
+
+		if notScreened := report.NotScreened(); len(notScreened) > 0 {
+			log.Printf(
+				"warning: %d of %d nodes were not screened, their jobs did not run to completion",
+				len(notScreened),
+				len(report.Nodes),
+			)
+		}
+		outliers := report.Outliers()
+		if len(outliers) == 0 {
+			return nil
+		}
+		if cCtx.Bool("drain") {
+			for _, node := range outliers {
+				reason := fmt.Sprintf("%s %s: %s", cCtx.String("drain.reason"), runDir.ID, verdict(node))
+				if err := slurm.DrainNode(context.WithoutCancel(ctx), node.Node, reason); err != nil {
+					return err
+				}
+				log.Printf("drained %s: %s", node.Node, reason)
+			}
+		}
+		return cli.Exit(
+			fmt.Sprintf("%d of %d nodes are outliers", len(outliers), len(report.Nodes)),
+			outlierExitCode,
+		)
+	},
+}
+
+// tunedRecord returns the record of the run whose optimal parameters are used,
+// the latest single node run of the partition with optimal parameters when id
+// is empty.
+func tunedRecord(store *history.Store, id string, partition string) (*history.Record, error) {
+	if id != "" {
+		record, err := store.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		if record.Node != 1 || record.OptimalParams == nil {
+			return nil, fmt.Errorf("run %s is not a single node run with optimal parameters", id)
+		}
+		return record, nil
+	}
+
+	records, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Node == 1 &&
+			record.OptimalParams != nil &&
+			record.State == history.StateCompleted &&
+			(partition == "" || record.Selection.Partition == partition) {
+			return record, nil
+		}
+	}
+	return nil, fmt.Errorf(
+		"no completed single node run in %s, tune the parameters with `run 1` first",
+		store.Dir,
+	)
+}
+
+// containerPath returns the container of the screening: the one set by the
+// flag or the environment, else the one of the tuned run, so that the nodes are
+// screened against the image the parameters were tuned with.
+func containerPath(cCtx *cli.Context, record *history.Record) string {
+	if cCtx.IsSet("container.path") {
+		return cCtx.String("container.path")
+	}
+	if record.ContainerImage != "" {
+		return record.ContainerImage
+	}
+	return cCtx.String("container.path")
+}
+
+// schedulableNodes returns the selected nodes on which jobs can start.
+func schedulableNodes(ctx context.Context, slurm *scheduler.Slurm) ([]string, error) {
+	nodes, err := slurm.FindNodes(ctx)
+	if err != nil {
+		log.Printf("failed to find nodes: %s", err)
+		return nil, err
+	}
+
+	var names []string
+	for _, node := range nodes {
+		if !node.Schedulable() {
+			log.Printf("skipping node %s, it is %s", node.Name, node.State)
+			continue
+		}
+		names = append(names, node.Name)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no schedulable node matches the selection")
+	}
+	return names, nil
+}
+
+// screenNodes runs the benchmark on each node concurrently, and waits for all
+// of them. The failures of the benchmark of a node are reported in its result.
+func screenNodes(
+	ctx context.Context,
+	slurm *scheduler.Slurm,
+	runDir *benchmark.RunDir,
+	opts run.Options,
+	record *history.Record,
+	nodes []string,
+) ([]screen.NodeResult, error) {
+	cfg := record.Config
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	params := *record.OptimalParams
+	dat := benchmark.DATParams{
+		NProblemSize: params.NProblemSize,
+		ProblemSize:  params.ProblemSize,
+		NBlockSize:   params.NBlockSize,
+		BlockSize:    params.BlockSize,
+		P:            params.P,
+		Q:            params.Q,
+		Knobs:        params.Knobs,
+	}
+
+	// The jobs share the resources of the selected nodes
+	sbatch := opts.Sbatch
+	sbatch.Node = 1
+	prototype := benchmark.NewBenchmark(dat, sbatch, slurm)
+	if err := prototype.CalculateSBATCHParams(ctx); err != nil {
+		log.Printf("failed to calculate sbatch params: %s", err)
+		return nil, err
+	}
+
+	delay := 2 * time.Minute
+	tries := run.WaitTries(cfg.Timeouts.SecondSet, delay)
+	results := make([]screen.NodeResult, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		workspace, err := runDir.SubDir(filepath.Join(nodesDir, node))
+		if err != nil {
+			return nil, err
+		}
+		sbatch := prototype.Sbatch
+		sbatch.Workspace = workspace
+		sbatch.NodeList = node
+		sbatch.Exclude = ""
+		b := benchmark.NewBenchmark(dat, sbatch, slurm)
+		b.Templates = benchmark.SlurmTemplates
+
+		files, err := b.GenerateFiles(ctx)
+		if err != nil {
+			log.Printf("Failed to generate benchmark files: %s", err)
+			return nil, err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = screenNode(ctx, b, &files, node, tries, delay)
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// screenNode runs the benchmark on a node, and returns its best Gflops which
+// passed the residual check. Unlike the jobs of a run, the failed jobs are not
+// retried, so that the failures of the node are reported.
+func screenNode(
+	ctx context.Context,
+	b *benchmark.Benchmark,
+	files *benchmark.BenchmarkFile,
+	node string,
+	tries int,
+	delay time.Duration,
+) screen.NodeResult {
+	result := screen.NodeResult{Node: node}
+	job, err := b.Run(ctx, files)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.JobID = job.ID
+
+	if err := b.Wait(ctx, job, tries, delay); err != nil {
+		// The job may still be pending on a busy partition
+		log.Printf("Benchmark of %s did not finish in time: %s", node, err)
+		if err := b.Cancel(context.WithoutCancel(ctx), job); err != nil {
+			log.Printf("Failed to cancel job %d: %s", job.ID, err)
+		}
+		result.Error = err.Error()
+		return result
+	}
+
+	state, err := b.State(ctx, job)
+	if err != nil {
+		log.Printf("Failed to find final state of job %d: %s", job.ID, err)
+		// The job may still be running, it is not left behind
+		if err := b.Cancel(context.WithoutCancel(ctx), job); err != nil {
+			log.Printf("Failed to cancel job %d: %s", job.ID, err)
+		}
+		result.Error = err.Error()
+		return result
+	}
+	if err := b.CollectOutput(ctx, job); err != nil {
+		log.Printf("Failed to collect output: %s", err)
+		result.Error = err.Error()
+		return result
+	}
+	if !state.Completed() {
+		log.Printf("Benchmark of %s did not complete: %s", node, state)
+		result.Error = fmt.Sprintf("job %d did not complete: %s", job.ID, state.State)
+		result.NodeFault = state.NodeFailed()
+		return result
+	}
+
+	output, err := resultparser.ParseFile(job.OutputFile)
+	if err != nil {
+		log.Printf("Failed to process results of %s: %s", node, err)
+		result.Error = err.Error()
+		return result
+	}
+	for _, message := range output.Errors {
+		log.Printf("job %d on %s reported: %s", job.ID, node, message)
+	}
+	failed := 0
+	for _, r := range output.Runs {
+		if r.Failed() {
+			failed++
+			continue
+		}
+		result.Gflops = max(result.Gflops, r.Gflops)
+	}
+	switch {
+	case result.Gflops > 0:
+		log.Printf("node %s: %.4g Gflops", node, result.Gflops)
+	case failed > 0:
+		// Wrong results point at the hardware of the node
+		result.Error = "no run passed the residual check"
+		result.NodeFault = true
+	default:
+		result.Error = "no result in the output of the job"
+	}
+	return result
+}
+
+func writeReport(path string, report *screen.Report) error {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Printf("failed to write the report: %s", err)
+		return err
+	}
+	if err := report.WriteCSV(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeNodes writes the verdict of each node and the statistics of the
+// partition.
+func writeNodes(out io.Writer, report *screen.Report) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tJOB\tGFLOPS\tDELTA\tSTATUS")
+	for _, node := range report.Nodes {
+		job, gflops, delta := "-", "-", "-"
+		if node.JobID > 0 {
+			job = fmt.Sprint(node.JobID)
+		}
+		if node.Status == screen.StatusOK || node.Status == screen.StatusSlow {
+			gflops = fmt.Sprintf("%.4g", node.Gflops)
+			delta = fmt.Sprintf("%+.1f%%", 100*node.Delta)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", node.Node, job, gflops, delta, verdict(node))
+	}
+	w.Flush()
+	fmt.Fprintf(
+		out,
+		"%d nodes, median %.4g, mean %.4g, stddev %.4g, min %.4g, max %.4g Gflops, threshold %g%%\n",
+		report.Summary.Count,
+		report.Summary.Median,
+		report.Summary.Mean,
+		report.Summary.StdDev,
+		report.Summary.Min,
+		report.Summary.Max,
+		100*report.Threshold,
+	)
+}
+
+// verdict describes the status of a node.
+func verdict(node screen.NodeReport) string {
+	switch node.Status {
+	case screen.StatusSlow:
+		return fmt.Sprintf("slow, %.1f%% below the median", -100*node.Delta)
+	case screen.StatusFailed, screen.StatusNotScreened:
+		return fmt.Sprintf("%s, %s", node.Status, node.Error)
+	default:
+		return string(node.Status)
+	}
+}
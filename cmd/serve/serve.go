@@ -0,0 +1,124 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/cmd/run"
+	"github.com/squarefactory/benchmark-api/server"
+	"github.com/urfave/cli/v2"
+)
+
+var flags = slices.Concat(run.Flags, []cli.Flag{
+	&cli.StringFlag{
+		Name:  "listen",
+		Value: "127.0.0.1:8080",
+		Usage: "Address on which the API listens, the loopback interface by default.",
+		EnvVars: []string{
+			"LISTEN_ADDRESS",
+		},
+	},
+	&cli.StringFlag{
+		Name:  "token",
+		Usage: "Bearer token required by every request.",
+		EnvVars: []string{
+			"API_TOKEN",
+		},
+	},
+	&cli.BoolFlag{
+		Name:  "allow-container-override",
+		Usage: "Let the configurations of the requests set the container of their run.",
+		EnvVars: []string{
+			"ALLOW_CONTAINER_OVERRIDE",
+		},
+	},
+})
+
+var Command = &cli.Command{
+	Name:  "serve",
+	Usage: "Serve an HTTP API to submit, follow and cancel benchmark runs.",
+	Description: "The flags of the run command apply to every run, the node count and partition are set by each\n" +
+		"request, and the configuration of a request overlays the --config one.",
+	Flags: flags,
+	Action: func(cCtx *cli.Context) error {
+		opts, err := run.NewOptions(cCtx)
+		if err != nil {
+			return err
+		}
+		if cCtx.String("token") == "" {
+			return errors.New("--token is required, every request must be authorized")
+		}
+		if opts.Metrics.Textfile != "" {
+			return errors.New(
+				"--metrics.textfile is not supported by serve, concurrent runs would overwrite it, use --metrics.pushgateway",
+			)
+		}
+
+		// The runs, and their jobs, are cancelled on shutdown
+		ctx, stop := signal.NotifyContext(cCtx.Context, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		runner := func(
+			ctx context.Context,
+			runDir *benchmark.RunDir,
+			submission server.Submission,
+			progress *server.Progress,
+		) error {
+			runOpts := opts
+			runOpts.Node = submission.Nodes
+			if submission.Partition != "" {
+				runOpts.Sbatch.Partition = submission.Partition
+			}
+			runOpts.Config = submission.Config
+			runOpts.Observer = progress
+			// Each run replaces its own group of metrics
+			runOpts.Metrics.Grouping = map[string]string{"run_id": runDir.ID}
+			if err := runOpts.Validate(); err != nil {
+				return err
+			}
+
+			slurmClient, templates, err := run.NewScheduler(cCtx, runOpts.NodeSelection())
+			if err != nil {
+				log.Printf("failed to create scheduler: %s", err)
+				return err
+			}
+			return run.Execute(ctx, slurmClient, templates, runDir, runOpts)
+		}
+
+		s := server.New(ctx, cCtx.String("runs.dir"), runner, server.Options{
+			Token:                  cCtx.String("token"),
+			AllowContainerOverride: cCtx.Bool("allow-container-override"),
+			Config:                 opts.Config,
+		})
+		httpServer := &http.Server{
+			Addr:              cCtx.String("listen"),
+			Handler:           s.Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			<-ctx.Done()
+			log.Printf("shutting down, cancelling the runs")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := httpServer.Shutdown(shutdownCtx); err != nil {
+				log.Printf("failed to shut down: %s", err)
+			}
+		}()
+
+		log.Printf("listening on %s", httpServer.Addr)
+		err = httpServer.ListenAndServe()
+		s.Wait()
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	},
+}
@@ -0,0 +1,382 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/peak"
+	"github.com/squarefactory/benchmark-api/stats"
+	"github.com/squarefactory/benchmark-api/tuner"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultRepetitions      = 20
+	DefaultFirstSetTimeout  = 5 * time.Hour
+	DefaultSecondSetTimeout = 20 * time.Minute
+)
+
+// Duration is a time.Duration read from a string such as 30m, or from a
+// number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type Timeouts struct {
+	// FirstSet is the maximum duration of the first set job
+	FirstSet Duration `json:"firstSet,omitempty"`
+	// SecondSet is the maximum duration of each second set job
+	SecondSet Duration `json:"secondSet,omitempty"`
+}
+
+// Budget limits the jobs of a run, tuning and second set included. Zero
+// limits are unbounded.
+type Budget struct {
+	// Jobs is the maximum number of jobs
+	Jobs int `json:"jobs,omitempty"`
+	// Duration is the maximum wall-clock time, after which no job is started
+	Duration Duration `json:"duration,omitempty"`
+}
+
+// Tuning configures the stages of the first set.
+type Tuning struct {
+	// Strategy is one of tuner.Strategies, grid by default
+	Strategy string `json:"strategy,omitempty"`
+	// TopK is the number of best candidates refined after the first stage of
+	// the grid and random strategies, no refinement when 0
+	TopK int `json:"topK,omitempty"`
+	// Eta is the reduction factor of successive halving
+	Eta int `json:"eta,omitempty"`
+	// Samples is the number of candidates drawn by the random strategy
+	Samples int `json:"samples,omitempty"`
+	// Seed of the random strategy
+	Seed int64 `json:"seed,omitempty"`
+	// Criterion selects the best configuration, one of stats.Criteria,
+	// median by default
+	Criterion string `json:"criterion,omitempty"`
+	// Repeats is the number of results of the best candidates required by
+	// the criteria other than max, tuner.DefaultRepeats by default. 1
+	// disables the repeats.
+	Repeats int `json:"repeats,omitempty"`
+	// Budget of the run
+	Budget Budget `json:"budget,omitempty"`
+}
+
+// Config is the run configuration. Omitted fields fall back to the defaults.
+type Config struct {
+	// MemoryFractions of the memory used by the matrix, from which the Ns of
+	// the first set are computed
+	MemoryFractions []float64 `json:"memoryFractions,omitempty"`
+	// ProblemSizes are explicit Ns, exclusive with MemoryFractions
+	ProblemSizes []int `json:"problemSizes,omitempty"`
+	// BlockSizes are the NBs of the first set
+	BlockSizes []int `json:"blockSizes,omitempty"`
+	// Grids are the P x Q process grids of the first set
+	Grids []benchmark.Grid `json:"grids,omitempty"`
+	// GridSweep enumerates the process grids of the first set unless disabled,
+	// exclusive with Grids
+	GridSweep benchmark.GridSweep `json:"gridSweep,omitempty"`
+	// HPL are the other parameters of HPL.dat, its lists are swept by the
+	// first set
+	HPL benchmark.DATKnobs `json:"hpl,omitempty"`
+	// Tuning configures the stages of the first set
+	Tuning Tuning `json:"tuning,omitempty"`
+	// Repetitions is the number of jobs of the second set
+	Repetitions int `json:"repetitions,omitempty"`
+	// Timeouts of the jobs
+	Timeouts Timeouts `json:"timeouts,omitempty"`
+	// ContainerPath overrides the CONTAINER_PATH environment variable
+	ContainerPath string `json:"containerPath,omitempty"`
+	// GPUModel overrides the model of the GPUs found on the nodes, e.g. when
+	// it cannot be queried through the scheduler
+	GPUModel string `json:"gpuModel,omitempty"`
+	// Peaks replace or complete the default peak of the GPU models
+	Peaks peak.Table `json:"peaks,omitempty"`
+}
+
+// Default returns the configuration used when no file is given.
+func Default() *Config {
+	c := &Config{}
+	c.setDefaults()
+	return c
+}
+
+// Load reads a YAML or JSON configuration file, validates it and fills the
+// omitted fields with the defaults.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse reads a YAML or JSON configuration, validates it and fills the
+// omitted fields with the defaults.
+func Parse(data []byte) (*Config, error) {
+	// JSON is valid YAML
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	c.setDefaults()
+	return c, nil
+}
+
+// exclusiveFields are the pairs of fields which cannot be set together.
+var exclusiveFields = [][2]string{
+	{"memoryFractions", "problemSizes"},
+	{"grids", "gridSweep"},
+}
+
+// Overlay reads a YAML or JSON configuration over base: the fields it sets
+// replace those of base, the objects being merged field by field, and setting
+// one of two exclusive fields drops the other from base. The result is
+// validated and its omitted fields filled with the defaults.
+func Overlay(base *Config, data []byte) (*Config, error) {
+	baseData, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	var merged map[string]interface{}
+	if err := json.Unmarshal(baseData, &merged); err != nil {
+		return nil, err
+	}
+
+	overlayData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	var overlay map[string]interface{}
+	if err := json.Unmarshal(overlayData, &overlay); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+
+	for _, fields := range exclusiveFields {
+		if _, ok := overlay[fields[0]]; ok {
+			delete(merged, fields[1])
+		}
+		if _, ok := overlay[fields[1]]; ok {
+			delete(merged, fields[0])
+		}
+	}
+	mergeObjects(merged, overlay)
+
+	data, err = json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// mergeObjects sets the fields of overlay in dst, merging the objects found
+// in both.
+func mergeObjects(dst map[string]interface{}, overlay map[string]interface{}) {
+	for key, value := range overlay {
+		object, ok := value.(map[string]interface{})
+		dstObject, dstOk := dst[key].(map[string]interface{})
+		if ok && dstOk {
+			mergeObjects(dstObject, object)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+func (c *Config) setDefaults() {
+	if c.Repetitions == 0 {
+		c.Repetitions = DefaultRepetitions
+	}
+	if c.Timeouts.FirstSet == 0 {
+		c.Timeouts.FirstSet = Duration(DefaultFirstSetTimeout)
+	}
+	if c.Timeouts.SecondSet == 0 {
+		c.Timeouts.SecondSet = Duration(DefaultSecondSetTimeout)
+	}
+}
+
+// Validate checks the configuration, reporting all the invalid fields.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.MemoryFractions) > 0 && len(c.ProblemSizes) > 0 {
+		errs = append(errs, errors.New("memoryFractions and problemSizes are exclusive"))
+	}
+	for i, fraction := range c.MemoryFractions {
+		if fraction <= 0 || fraction > 1 {
+			errs = append(errs, fmt.Errorf("memoryFractions[%d]: %g is not in (0, 1]", i, fraction))
+		}
+	}
+	for i, n := range c.ProblemSizes {
+		if n <= 0 {
+			errs = append(errs, fmt.Errorf("problemSizes[%d]: %d must be positive", i, n))
+		}
+	}
+	for i, nb := range c.BlockSizes {
+		if nb <= 0 {
+			errs = append(errs, fmt.Errorf("blockSizes[%d]: %d must be positive", i, nb))
+		}
+	}
+	for i, grid := range c.Grids {
+		if grid.P <= 0 || grid.Q <= 0 {
+			errs = append(errs, fmt.Errorf("grids[%d]: %dx%d must be positive", i, grid.P, grid.Q))
+			continue
+		}
+		first := c.Grids[0]
+		if grid.P*grid.Q != first.P*first.Q {
+			errs = append(errs, fmt.Errorf(
+				"grids[%d]: %dx%d does not have the %d processes of %dx%d",
+				i,
+				grid.P,
+				grid.Q,
+				first.P*first.Q,
+				first.P,
+				first.Q,
+			))
+		}
+	}
+	if c.GridSweep != (benchmark.GridSweep{}) && len(c.Grids) > 0 {
+		errs = append(errs, errors.New("grids and gridSweep are exclusive"))
+	}
+	if c.GridSweep.MaxAspectRatio != 0 && c.GridSweep.MaxAspectRatio < 1 {
+		errs = append(errs, fmt.Errorf("gridSweep.maxAspectRatio: %g must be at least 1", c.GridSweep.MaxAspectRatio))
+	}
+	if err, ok := c.HPL.Validate().(interface{ Unwrap() []error }); ok {
+		for _, err := range err.Unwrap() {
+			errs = append(errs, fmt.Errorf("hpl.%w", err))
+		}
+	}
+	if _, err := tuner.NewStrategy(c.Tuning.Strategy, tuner.Options{}); err != nil {
+		errs = append(errs, fmt.Errorf("tuning.strategy: %w", err))
+	}
+	if criterion, err := stats.ParseCriterion(c.Tuning.Criterion); err != nil {
+		errs = append(errs, fmt.Errorf("tuning.criterion: %w", err))
+	} else if criterion == stats.CriterionCILow && c.Tuning.Repeats == 1 {
+		errs = append(errs, errors.New(
+			"tuning.criterion: ci-low needs repeats, the confidence interval of a single result is undefined",
+		))
+	}
+	if c.Tuning.TopK < 0 {
+		errs = append(errs, fmt.Errorf("tuning.topK: %d must be positive", c.Tuning.TopK))
+	}
+	if c.Tuning.Eta != 0 && c.Tuning.Eta < 2 {
+		errs = append(errs, fmt.Errorf("tuning.eta: %d must be at least 2", c.Tuning.Eta))
+	}
+	if c.Tuning.Repeats < 0 {
+		errs = append(errs, fmt.Errorf("tuning.repeats: %d must be positive", c.Tuning.Repeats))
+	}
+	if c.Tuning.Samples < 0 {
+		errs = append(errs, fmt.Errorf("tuning.samples: %d must be positive", c.Tuning.Samples))
+	}
+	if c.Tuning.Budget.Jobs < 0 {
+		errs = append(errs, fmt.Errorf("tuning.budget.jobs: %d must be positive", c.Tuning.Budget.Jobs))
+	}
+	if c.Tuning.Budget.Duration < 0 {
+		errs = append(errs, fmt.Errorf(
+			"tuning.budget.duration: %s must be positive",
+			time.Duration(c.Tuning.Budget.Duration),
+		))
+	}
+	if err, ok := c.Peaks.Validate().(interface{ Unwrap() []error }); ok {
+		for _, err := range err.Unwrap() {
+			errs = append(errs, fmt.Errorf("peaks%w", err))
+		}
+	}
+	if c.Repetitions < 0 {
+		errs = append(errs, fmt.Errorf("repetitions: %d must be positive", c.Repetitions))
+	}
+	if c.Timeouts.FirstSet < 0 {
+		errs = append(errs, fmt.Errorf("timeouts.firstSet: %s must be positive", time.Duration(c.Timeouts.FirstSet)))
+	}
+	if c.Timeouts.SecondSet < 0 {
+		errs = append(errs, fmt.Errorf("timeouts.secondSet: %s must be positive", time.Duration(c.Timeouts.SecondSet)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// SearchSpace returns the search space of the first set.
+func (c *Config) SearchSpace() benchmark.SearchSpace {
+	return benchmark.SearchSpace{
+		MemoryFractions: c.MemoryFractions,
+		ProblemSizes:    c.ProblemSizes,
+		BlockSizes:      c.BlockSizes,
+		Grids:           c.Grids,
+		GridSweep:       c.GridSweep,
+		Knobs:           c.HPL,
+	}
+}
+
+// Strategy returns the tuning strategy.
+func (c *Config) Strategy() (tuner.Strategy, error) {
+	return tuner.NewStrategy(c.Tuning.Strategy, tuner.Options{
+		TopK:    c.Tuning.TopK,
+		Eta:     c.Tuning.Eta,
+		Samples: c.Tuning.Samples,
+		Seed:    c.Tuning.Seed,
+	})
+}
+
+// Repeats returns the number of results of the best candidates required by a
+// statistical criterion.
+func (c *Config) Repeats() int {
+	if c.Tuning.Repeats == 0 {
+		return tuner.DefaultRepeats
+	}
+	return c.Tuning.Repeats
+}
+
+// Criterion returns the criterion selecting the best configuration.
+func (c *Config) Criterion() stats.Criterion {
+	// Validated by Load
+	criterion, err := stats.ParseCriterion(c.Tuning.Criterion)
+	if err != nil {
+		return stats.DefaultCriterion
+	}
+	return criterion
+}
+
+// Budget returns a new budget of the run.
+func (c *Config) Budget() *tuner.Budget {
+	return tuner.NewBudget(c.Tuning.Budget.Jobs, time.Duration(c.Tuning.Budget.Duration))
+}
+
+// PeakTable returns the peak of the GPU models, defaults included.
+func (c *Config) PeakTable() peak.Table {
+	return peak.Default.Merge(c.Peaks)
+}
@@ -0,0 +1,303 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/config"
+	"github.com/squarefactory/benchmark-api/peak"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		expected *config.Config
+		wantErr  string
+	}{
+		{
+			name: "YAML",
+			file: "run.yaml",
+			content: `memoryFractions: [0.8, 0.85]
+blockSizes: [256, 512]
+grids:
+  - {p: 2, q: 4}
+  - {p: 4, q: 2}
+repetitions: 5
+timeouts:
+  firstSet: 2h
+  secondSet: 600
+containerPath: /opt/hpl.sqsh
+`,
+			expected: &config.Config{
+				MemoryFractions: []float64{0.8, 0.85},
+				BlockSizes:      []int{256, 512},
+				Grids:           []benchmark.Grid{{P: 2, Q: 4}, {P: 4, Q: 2}},
+				Repetitions:     5,
+				Timeouts: config.Timeouts{
+					FirstSet:  config.Duration(2 * time.Hour),
+					SecondSet: config.Duration(10 * time.Minute),
+				},
+				ContainerPath: "/opt/hpl.sqsh",
+			},
+		},
+		{
+			name:    "JSON with defaults",
+			file:    "run.json",
+			content: `{"problemSizes": [100000, 120000]}`,
+			expected: &config.Config{
+				ProblemSizes: []int{100000, 120000},
+				Repetitions:  config.DefaultRepetitions,
+				Timeouts: config.Timeouts{
+					FirstSet:  config.Duration(config.DefaultFirstSetTimeout),
+					SecondSet: config.Duration(config.DefaultSecondSetTimeout),
+				},
+			},
+		},
+		{
+			name: "HPL knobs",
+			file: "run.yaml",
+			content: `hpl:
+  pfacts: [0, 1, 2]
+  bcasts: [1, 3]
+  swap: 0
+  threshold: -16
+`,
+			expected: &config.Config{
+				HPL: benchmark.DATKnobs{
+					PFacts:    []int{0, 1, 2},
+					BCasts:    []int{1, 3},
+					Swap:      ptr(0),
+					Threshold: ptr(-16.0),
+				},
+				Repetitions: config.DefaultRepetitions,
+				Timeouts: config.Timeouts{
+					FirstSet:  config.Duration(config.DefaultFirstSetTimeout),
+					SecondSet: config.Duration(config.DefaultSecondSetTimeout),
+				},
+			},
+		},
+		{
+			name: "Tuning",
+			file: "run.yaml",
+			content: `tuning:
+  strategy: successive-halving
+  eta: 3
+  budget: {jobs: 12, duration: 8h}
+`,
+			expected: &config.Config{
+				Tuning: config.Tuning{
+					Strategy: "successive-halving",
+					Eta:      3,
+					Budget: config.Budget{
+						Jobs:     12,
+						Duration: config.Duration(8 * time.Hour),
+					},
+				},
+				Repetitions: config.DefaultRepetitions,
+				Timeouts: config.Timeouts{
+					FirstSet:  config.Duration(config.DefaultFirstSetTimeout),
+					SecondSet: config.Duration(config.DefaultSecondSetTimeout),
+				},
+			},
+		},
+		{
+			name: "Peaks",
+			file: "run.yaml",
+			content: `gpuModel: NVIDIA L40S
+peaks:
+  NVIDIA L40S: {fp64: 1.4, fp16Tensor: 362}
+`,
+			expected: &config.Config{
+				GPUModel: "NVIDIA L40S",
+				Peaks: peak.Table{
+					"NVIDIA L40S": {FP64: 1.4, FP16Tensor: 362},
+				},
+				Repetitions: config.DefaultRepetitions,
+				Timeouts: config.Timeouts{
+					FirstSet:  config.Duration(config.DefaultFirstSetTimeout),
+					SecondSet: config.Duration(config.DefaultSecondSetTimeout),
+				},
+			},
+		},
+		{
+			name:    "Invalid peak",
+			file:    "run.yaml",
+			content: "peaks:\n  NVIDIA A30: {fp16Tensor: 165}\n",
+			wantErr: "peaks[NVIDIA A30].fp64: 0 must be positive",
+		},
+		{
+			name:    "Unknown strategy",
+			file:    "run.yaml",
+			content: "tuning: {strategy: bayesian}\n",
+			wantErr: `tuning.strategy: unknown tuning strategy "bayesian"`,
+		},
+		{
+			name:    "Unknown criterion",
+			file:    "run.yaml",
+			content: "tuning: {criterion: best}\n",
+			wantErr: `tuning.criterion: unknown criterion "best"`,
+		},
+		{
+			name:    "CI low without repeats",
+			file:    "run.yaml",
+			content: "tuning: {criterion: ci-low, repeats: 1}\n",
+			wantErr: "tuning.criterion: ci-low needs repeats",
+		},
+		{
+			name:    "Negative repeats",
+			file:    "run.yaml",
+			content: "tuning: {repeats: -1}\n",
+			wantErr: "tuning.repeats: -1 must be positive",
+		},
+		{
+			name:    "Exclusive grids",
+			file:    "run.yaml",
+			content: "grids: [{p: 2, q: 4}]\ngridSweep: {pLessOrEqualQ: true}\n",
+			wantErr: "grids and gridSweep are exclusive",
+		},
+		{
+			name:    "Invalid aspect ratio",
+			file:    "run.yaml",
+			content: "gridSweep: {maxAspectRatio: 0.5}\n",
+			wantErr: "gridSweep.maxAspectRatio: 0.5 must be at least 1",
+		},
+		{
+			name:    "Invalid HPL knob",
+			file:    "run.yaml",
+			content: "hpl:\n  bcasts: [1, 6]\n",
+			wantErr: "hpl.bcasts[1]: 6 is not in [0, 5]",
+		},
+		{
+			name:    "Unknown field",
+			file:    "run.yaml",
+			content: "blockSize: [256]\n",
+			wantErr: `unknown field "blockSize"`,
+		},
+		{
+			name:    "Exclusive Ns",
+			file:    "run.yaml",
+			content: "memoryFractions: [0.8]\nproblemSizes: [100000]\n",
+			wantErr: "memoryFractions and problemSizes are exclusive",
+		},
+		{
+			name:    "Invalid fraction",
+			file:    "run.yaml",
+			content: "memoryFractions: [0.8, 80]\n",
+			wantErr: "memoryFractions[1]: 80 is not in (0, 1]",
+		},
+		{
+			name:    "Grids with different sizes",
+			file:    "run.yaml",
+			content: "grids: [{p: 2, q: 4}, {p: 2, q: 2}]\n",
+			wantErr: "grids[1]: 2x2 does not have the 8 processes of 2x4",
+		},
+		{
+			name:    "Invalid timeout",
+			file:    "run.yaml",
+			content: "timeouts:\n  firstSet: 2 hours\n",
+			wantErr: "failed to parse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0644))
+
+			c, err := config.Load(path)
+
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, c)
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	c := config.Default()
+
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, config.DefaultRepetitions, c.Repetitions)
+	assert.Equal(t, benchmark.SearchSpace{}, c.SearchSpace())
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+const overlayBase = `memoryFractions: [0.8]
+grids:
+  - {p: 2, q: 4}
+repetitions: 5
+tuning:
+  strategy: successive-halving
+  budget: {jobs: 10}
+`
+
+func TestOverlay(t *testing.T) {
+	base, err := config.Parse([]byte(overlayBase))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		overlay  string
+		expected func(c *config.Config)
+		wantErr  string
+	}{
+		{
+			name:     "Empty",
+			overlay:  "{}",
+			expected: func(c *config.Config) {},
+		},
+		{
+			name: "Overlay",
+			overlay: `{
+				"problemSizes": [10000],
+				"gridSweep": {"pLessOrEqualQ": true},
+				"tuning": {"topK": 2}
+			}`,
+			expected: func(c *config.Config) {
+				c.MemoryFractions = nil
+				c.ProblemSizes = []int{10000}
+				c.Grids = nil
+				c.GridSweep = benchmark.GridSweep{PLessOrEqualQ: true}
+				c.Tuning.TopK = 2
+			},
+		},
+		{
+			name:    "Unknown field",
+			overlay: `{"repetition": 3}`,
+			wantErr: "failed to parse",
+		},
+		{
+			name:    "Invalid",
+			overlay: `{"repetitions": -1}`,
+			wantErr: "repetitions: -1 must be positive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := config.Overlay(base, []byte(tt.overlay))
+
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			expected, err := config.Parse([]byte(overlayBase))
+			require.NoError(t, err)
+			tt.expected(expected)
+			assert.Equal(t, expected, c)
+			assert.Equal(t, []float64{0.8}, base.MemoryFractions)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/squarefactory/benchmark-api/stats"
+)
+
+// baselinesFile maps the names of the baselines to their run.
+const baselinesFile = "baselines.json"
+
+// DefaultThreshold is the default drop of the median Gflops considered a
+// regression.
+const DefaultThreshold = 0.05
+
+// DefaultAlpha is the default significance level of the regressions.
+const DefaultAlpha = 0.05
+
+// NodeSet returns the default name of the baseline of a run, which describes
+// its nodes: their count and selection.
+func NodeSet(r *Record) string {
+	fields := []string{"nodes=" + strconv.Itoa(r.Node)}
+	for _, field := range []struct{ name, value string }{
+		{"partition", r.Selection.Partition},
+		{"nodelist", r.Selection.NodeList},
+		{"exclude", r.Selection.Exclude},
+		{"constraint", r.Selection.Constraint},
+		{"reservation", r.Selection.Reservation},
+	} {
+		if field.value != "" {
+			fields = append(fields, field.name+"="+field.value)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// Baselines returns the IDs of the baseline runs, by name.
+func (s *Store) Baselines() (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, baselinesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	baselines := map[string]string{}
+	if err := json.Unmarshal(data, &baselines); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", baselinesFile, err)
+	}
+	return baselines, nil
+}
+
+// SetBaseline tags the run as the baseline of the name, replacing the
+// previous one.
+func (s *Store) SetBaseline(name string, id string) error {
+	if name == "" {
+		return errors.New("the name of the baseline is empty")
+	}
+	if _, err := s.Load(id); err != nil {
+		return err
+	}
+
+	baselines, err := s.Baselines()
+	if err != nil {
+		return err
+	}
+	baselines[name] = id
+	return s.writeJSON(baselinesFile, baselines)
+}
+
+// Baseline reads the record of the baseline of the name.
+func (s *Store) Baseline(name string) (*Record, error) {
+	baselines, err := s.Baselines()
+	if err != nil {
+		return nil, err
+	}
+	id, ok := baselines[name]
+	if !ok {
+		return nil, fmt.Errorf("no baseline for %s", name)
+	}
+	return s.Load(id)
+}
+
+// Comparison compares the second set of a run with the one of a baseline.
+type Comparison struct {
+	Baseline stats.Summary `json:"baseline"`
+	Current  stats.Summary `json:"current"`
+	// Delta is the relative change of the median Gflops
+	Delta float64           `json:"delta"`
+	Test  stats.MannWhitney `json:"test"`
+	// Regression is set when the median dropped by more than the threshold,
+	// with a significant difference
+	Regression bool `json:"regression"`
+}
+
+// Compare compares the Gflops of the second set of the run with the ones of
+// the baseline. A drop of the median by more than threshold, e.g. 0.05 for
+// 5%, is a regression when the Mann–Whitney U test finds the difference
+// significant at the level alpha.
+func Compare(baseline, current *Record, threshold, alpha float64) (*Comparison, error) {
+	baselineGflops, err := secondSetGflops(baseline)
+	if err != nil {
+		return nil, err
+	}
+	currentGflops, err := secondSetGflops(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := Comparable(baseline, current); err != nil {
+		return nil, err
+	}
+
+	test, err := stats.MannWhitneyU(currentGflops, baselineGflops)
+	if err != nil {
+		return nil, err
+	}
+	c := &Comparison{
+		Baseline: stats.Summarize(baselineGflops),
+		Current:  stats.Summarize(currentGflops),
+		Test:     test,
+	}
+	c.Delta = (c.Current.Median - c.Baseline.Median) / c.Baseline.Median
+	c.Regression = c.Delta <= -threshold && test.P < alpha
+	return c, nil
+}
+
+// Comparable returns an error when the second sets of the runs cannot be
+// compared: they ran on different node counts, with different parameters, or
+// different benchmarks.
+func Comparable(baseline, current *Record) error {
+	if baseline.Node != current.Node {
+		return fmt.Errorf(
+			"runs %s and %s are not comparable, they ran on %d and %d nodes",
+			baseline.ID,
+			current.ID,
+			baseline.Node,
+			current.Node,
+		)
+	}
+	if b, c := optimalParams(baseline), optimalParams(current); b != c {
+		return fmt.Errorf(
+			"runs %s and %s are not comparable, their second sets ran with %s and %s",
+			baseline.ID,
+			current.ID,
+			b,
+			c,
+		)
+	}
+	if b, c := secondSetKinds(baseline), secondSetKinds(current); !slices.Equal(b, c) {
+		return fmt.Errorf(
+			"runs %s and %s are not comparable, their second sets ran %v and %v",
+			baseline.ID,
+			current.ID,
+			b,
+			c,
+		)
+	}
+	return nil
+}
+
+// optimalParams formats the N, NB and process grid chosen by the first set.
+func optimalParams(r *Record) string {
+	p := r.OptimalParams
+	if p == nil {
+		return "no parameters"
+	}
+	return fmt.Sprintf("N=%s NB=%s P=%d Q=%d", p.ProblemSize, p.BlockSize, p.P, p.Q)
+}
+
+// secondSetKinds returns the sorted kinds of benchmark of the second set.
+func secondSetKinds(r *Record) []resultparser.Kind {
+	var kinds []resultparser.Kind
+	for _, result := range r.Results {
+		if result.Set == resultparser.SetSecond && !slices.Contains(kinds, result.Kind) {
+			kinds = append(kinds, result.Kind)
+		}
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+// secondSetGflops returns the Gflops of the results of the second set which
+// passed the residual check.
+func secondSetGflops(r *Record) ([]float64, error) {
+	var gflops []float64
+	for _, result := range r.Results {
+		if result.Set == resultparser.SetSecond && !result.Failed() && result.Gflops > 0 {
+			gflops = append(gflops, result.Gflops)
+		}
+	}
+	if len(gflops) == 0 {
+		return nil, fmt.Errorf("run %s has no result in its second set", r.ID)
+	}
+	return gflops, nil
+}
@@ -0,0 +1,165 @@
+package history_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/history"
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// secondSet returns a record whose second set reached the Gflops.
+func secondSet(id string, gflops ...float64) *history.Record {
+	r := newRecord(id, time.Now())
+	// First set results are not compared
+	r.Results = append(r.Results, resultparser.Result{
+		Run:      resultparser.Run{Gflops: 1},
+		Metadata: resultparser.Metadata{Set: resultparser.SetFirst},
+	})
+	for _, g := range gflops {
+		r.Results = append(r.Results, resultparser.Result{
+			Run:      resultparser.Run{Kind: resultparser.KindHPL, Gflops: g},
+			Metadata: resultparser.Metadata{Set: resultparser.SetSecond},
+		})
+	}
+	return r
+}
+
+func TestCompare(t *testing.T) {
+	baseline := secondSet("baseline", 100, 101, 99, 102, 100, 98, 101, 100)
+	tests := []struct {
+		name       string
+		current    *history.Record
+		threshold  float64
+		delta      float64
+		regression bool
+	}{
+		{
+			name:      "Same performance",
+			current:   secondSet("current", 101, 99, 100, 100, 102, 98, 100, 101),
+			threshold: 0.05,
+			delta:     0,
+		},
+		{
+			name:       "Regression",
+			current:    secondSet("current", 90, 91, 89, 92, 90, 88, 91, 90),
+			threshold:  0.05,
+			delta:      -0.1,
+			regression: true,
+		},
+		{
+			name:      "Drop below the threshold",
+			current:   secondSet("current", 90, 91, 89, 92, 90, 88, 91, 90),
+			threshold: 0.15,
+			delta:     -0.1,
+		},
+		{
+			name:      "Improvement",
+			current:   secondSet("current", 110, 111, 109, 112, 110, 108, 111, 110),
+			threshold: 0.05,
+			delta:     0.1,
+		},
+		{
+			name:      "Too few results to be significant",
+			current:   secondSet("current", 80),
+			threshold: 0.05,
+			delta:     -0.2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := history.Compare(baseline, tt.current, tt.threshold, history.DefaultAlpha)
+
+			require.NoError(t, err)
+			assert.InDelta(t, tt.delta, c.Delta, 1e-9)
+			assert.Equal(t, tt.regression, c.Regression)
+			assert.Equal(t, 100.0, c.Baseline.Median)
+		})
+	}
+
+	_, err := history.Compare(baseline, secondSet("current"), history.DefaultThreshold, history.DefaultAlpha)
+	assert.ErrorContains(t, err, "run current has no result in its second set")
+}
+
+func TestCompareIncomparable(t *testing.T) {
+	baseline := secondSet("baseline", 100, 101, 99)
+	baseline.OptimalParams = &benchmark.DATParams{ProblemSize: "95000", BlockSize: "512", P: 2, Q: 4}
+	tests := []struct {
+		name    string
+		modify  func(r *history.Record)
+		wantErr string
+	}{
+		{
+			name:    "Node count",
+			modify:  func(r *history.Record) { r.Node = 4 },
+			wantErr: "they ran on 2 and 4 nodes",
+		},
+		{
+			name: "Parameters",
+			modify: func(r *history.Record) {
+				r.OptimalParams = &benchmark.DATParams{ProblemSize: "95000", BlockSize: "1024", P: 2, Q: 4}
+			},
+			wantErr: "ran with N=95000 NB=512 P=2 Q=4 and N=95000 NB=1024 P=2 Q=4",
+		},
+		{
+			name: "Kind",
+			modify: func(r *history.Record) {
+				for i := range r.Results {
+					r.Results[i].Kind = resultparser.KindHPLAI
+				}
+			},
+			wantErr: "ran [HPL] and [HPL-AI]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := secondSet("current", 100, 101, 99)
+			current.OptimalParams = &benchmark.DATParams{ProblemSize: "95000", BlockSize: "512", P: 2, Q: 4}
+			tt.modify(current)
+
+			_, err := history.Compare(baseline, current, history.DefaultThreshold, history.DefaultAlpha)
+
+			assert.ErrorContains(t, err, "runs baseline and current are not comparable")
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestBaselines(t *testing.T) {
+	store, err := history.NewStore(filepath.Join(t.TempDir(), "history"))
+	require.NoError(t, err)
+	r := newRecord("20240108-100000-aaaaaa", time.Now())
+	require.NoError(t, store.Save(r))
+
+	_, err = store.Baseline(history.NodeSet(r))
+	assert.ErrorContains(t, err, "no baseline for nodes=2,partition=gpu")
+	assert.Error(t, store.SetBaseline("a100", "unknown"))
+
+	require.NoError(t, store.SetBaseline(history.NodeSet(r), r.ID))
+	baseline, err := store.Baseline("nodes=2,partition=gpu")
+	require.NoError(t, err)
+	assert.Equal(t, r.ID, baseline.ID)
+
+	// The baselines are not records
+	records, err := store.List()
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+}
+
+func TestNodeSet(t *testing.T) {
+	r := &history.Record{
+		Node: 4,
+		Selection: scheduler.NodeSelection{
+			Partition:  "gpu",
+			Exclude:    "gpu[03-04]",
+			Constraint: "a100&ib",
+		},
+	}
+
+	assert.Equal(t, "nodes=4,partition=gpu,exclude=gpu[03-04],constraint=a100&ib", history.NodeSet(r))
+}
@@ -0,0 +1,238 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/config"
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/squarefactory/benchmark-api/scheduler"
+)
+
+// DefaultDir is the default directory of the store.
+const DefaultDir = "history"
+
+// State is the state of a recorded run.
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+	StateCanceled  State = "canceled"
+)
+
+// Record is the history of a run.
+type Record struct {
+	ID string `json:"id"`
+	// Version of the tool which ran the benchmark
+	Version   string     `json:"version"`
+	State     State      `json:"state"`
+	Error     string     `json:"error,omitempty"`
+	StartTime time.Time  `json:"startTime"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+	// Path of the run directory
+	Path      string                  `json:"path"`
+	Scheduler string                  `json:"scheduler"`
+	Node      int                     `json:"node"`
+	Selection scheduler.NodeSelection `json:"selection"`
+	Config    *config.Config          `json:"config"`
+	// ContainerImage is the path or reference of the container, and
+	// ContainerDigest its digest, empty when unknown
+	ContainerImage  string    `json:"containerImage"`
+	ContainerDigest string    `json:"containerDigest,omitempty"`
+	Resources       Resources `json:"resources"`
+	// OptimalParams are the parameters chosen by the first set
+	OptimalParams *benchmark.DATParams  `json:"optimalParams,omitempty"`
+	Results       []resultparser.Result `json:"results"`
+	// Statistics are the summaries of the second set
+	Statistics []resultparser.ConfigSummary `json:"statistics,omitempty"`
+}
+
+// Resources are the resources discovered on the nodes of a run.
+type Resources struct {
+	Nodes        []scheduler.NodeResources `json:"nodes,omitempty"`
+	GPUModel     string                    `json:"gpuModel,omitempty"`
+	GPUsPerNode  int                       `json:"gpusPerNode,omitempty"`
+	TasksPerNode int                       `json:"tasksPerNode,omitempty"`
+	CPUsPerTask  int                       `json:"cpusPerTask,omitempty"`
+	GPUAffinity  string                    `json:"gpuAffinity,omitempty"`
+	CPUAffinity  string                    `json:"cpuAffinity,omitempty"`
+}
+
+// Finish records the end of the run, failed with err unless nil.
+func (r *Record) Finish(err error, canceled bool) {
+	now := time.Now()
+	r.EndTime = &now
+	switch {
+	case canceled:
+		r.State = StateCanceled
+	case err != nil:
+		r.State = StateFailed
+	default:
+		r.State = StateCompleted
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// Store keeps a JSON file per run in a directory. As each run only writes its
+// own file, concurrent runs do not need to be coordinated.
+type Store struct {
+	Dir string
+}
+
+// NewStore creates the directory of the store if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("Failed to create history directory: %s", err)
+		return nil, err
+	}
+	return &Store{Dir: dir}, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// Save writes the record, replacing its previous version.
+func (s *Store) Save(r *Record) error {
+	return s.writeJSON(r.ID+".json", r)
+}
+
+// writeJSON replaces a file of the store atomically, so that it is never read
+// half written.
+func (s *Store) writeJSON(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, "."+name+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.Dir, name))
+}
+
+// Load reads the record of a run.
+func (s *Store) Load(id string) (*Record, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid run ID %q", id)
+	}
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("run %s not found in %s", id, s.Dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Record{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("failed to parse the record of run %s: %w", id, err)
+	}
+	return r, nil
+}
+
+// List reads the records of all the runs, by start time.
+func (s *Store) List() ([]*Record, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() ||
+			strings.HasPrefix(name, ".") ||
+			filepath.Ext(name) != ".json" ||
+			name == baselinesFile {
+			continue
+		}
+		r, err := s.Load(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	slices.SortStableFunc(records, func(a, b *Record) int {
+		return a.StartTime.Compare(b.StartTime)
+	})
+	return records, nil
+}
+
+// Export writes the records in the format. JSON writes an array of records and
+// NDJSON one record per line, while CSV writes the results of all the records.
+func Export(w io.Writer, format resultparser.Format, records []*Record) error {
+	switch format {
+	case resultparser.FormatCSV:
+		var results []resultparser.Result
+		for _, r := range records {
+			results = append(results, r.Results...)
+		}
+		return resultparser.Export(w, format, results)
+	case resultparser.FormatJSON:
+		if records == nil {
+			records = []*Record{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case resultparser.FormatNDJSON:
+		encoder := json.NewEncoder(w)
+		for _, r := range records {
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown results format %q", format)
+	}
+}
+
+// ContainerDigest returns the digest of a container: the SHA-256 of a local
+// image file, such as a .sqsh, or the digest of an image reference pinned
+// with @sha256:. It is empty when the image is neither.
+func ContainerDigest(image string) (string, error) {
+	if _, digest, ok := strings.Cut(image, "@sha256:"); ok {
+		return "sha256:" + digest, nil
+	}
+
+	file, err := os.Open(image)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,164 @@
+package history_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/config"
+	"github.com/squarefactory/benchmark-api/history"
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRecord(id string, start time.Time, gflops ...float64) *history.Record {
+	r := &history.Record{
+		ID:        id,
+		Version:   "v1.2.0",
+		State:     history.StateRunning,
+		StartTime: start,
+		Scheduler: "slurm",
+		Node:      2,
+		Selection: scheduler.NodeSelection{Partition: "gpu"},
+		Config:    config.Default(),
+		Resources: history.Resources{
+			Nodes: []scheduler.NodeResources{
+				{Name: "gpu01", Memory: 1031000, GPUs: 8, CPUs: 128},
+				{Name: "gpu02", Memory: 1031000, GPUs: 8, CPUs: 128},
+			},
+			GPUModel:    "NVIDIA A100-SXM4-80GB",
+			GPUsPerNode: 8,
+		},
+	}
+	for _, g := range gflops {
+		r.Results = append(r.Results, resultparser.Result{
+			Run:      resultparser.Run{N: 1000, NB: 256, P: 4, Q: 4, Gflops: g},
+			Metadata: resultparser.Metadata{RunID: id},
+		})
+	}
+	return r
+}
+
+func TestStore(t *testing.T) {
+	store, err := history.NewStore(filepath.Join(t.TempDir(), "history"))
+	require.NoError(t, err)
+
+	later := newRecord("20240108-120000-bbbbbb", time.Date(2024, time.January, 8, 12, 0, 0, 0, time.UTC), 2e4)
+	earlier := newRecord("20240108-100000-aaaaaa", time.Date(2024, time.January, 8, 10, 0, 0, 0, time.UTC), 1e4)
+	require.NoError(t, store.Save(later))
+	require.NoError(t, store.Save(earlier))
+
+	// Saving again replaces the record
+	earlier.OptimalParams = &benchmark.DATParams{ProblemSize: "1000", BlockSize: "256", P: 4, Q: 4}
+	earlier.Finish(nil, false)
+	require.NoError(t, store.Save(earlier))
+
+	loaded, err := store.Load(earlier.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StateCompleted, loaded.State)
+	assert.Equal(t, earlier.OptimalParams, loaded.OptimalParams)
+	assert.Equal(t, earlier.Resources, loaded.Resources)
+	assert.Equal(t, earlier.Config, loaded.Config)
+	assert.Equal(t, earlier.Selection, loaded.Selection)
+
+	records, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, earlier.ID, records[0].ID)
+	assert.Equal(t, later.ID, records[1].ID)
+
+	_, err = store.Load("unknown")
+	assert.ErrorContains(t, err, "run unknown not found")
+	_, err = store.Load("../history")
+	assert.Error(t, err)
+}
+
+func TestFinish(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		canceled bool
+		expected history.State
+	}{
+		{name: "Completed", expected: history.StateCompleted},
+		{name: "Failed", err: errors.New("job 42 did not complete"), expected: history.StateFailed},
+		{name: "Canceled", err: errors.New("context canceled"), canceled: true, expected: history.StateCanceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRecord("20240108-100000-aaaaaa", time.Now())
+
+			r.Finish(tt.err, tt.canceled)
+
+			assert.Equal(t, tt.expected, r.State)
+			assert.NotNil(t, r.EndTime)
+			if tt.err != nil {
+				assert.Equal(t, tt.err.Error(), r.Error)
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	records := []*history.Record{
+		newRecord("20240108-100000-aaaaaa", time.Date(2024, time.January, 8, 10, 0, 0, 0, time.UTC), 1e4, 1.1e4),
+		newRecord("20240108-120000-bbbbbb", time.Date(2024, time.January, 8, 12, 0, 0, 0, time.UTC), 2e4),
+	}
+
+	var csv bytes.Buffer
+	require.NoError(t, history.Export(&csv, resultparser.FormatCSV, records))
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[3], "20240108-120000-bbbbbb")
+
+	var ndjson bytes.Buffer
+	require.NoError(t, history.Export(&ndjson, resultparser.FormatNDJSON, records))
+	lines = strings.Split(strings.TrimSpace(ndjson.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], `{"id":"20240108-100000-aaaaaa"`), lines[0])
+
+	var json bytes.Buffer
+	require.NoError(t, history.Export(&json, resultparser.FormatJSON, nil))
+	assert.Equal(t, "[]\n", json.String())
+}
+
+func TestContainerDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hpl.sqsh")
+	require.NoError(t, os.WriteFile(path, []byte("hpl"), 0o600))
+
+	tests := []struct {
+		name     string
+		image    string
+		expected string
+	}{
+		{
+			name:     "Image file",
+			image:    path,
+			expected: "sha256:74fb67c85974249d0b24cadcd54029cc314d8195d7018b1b47e01d9b1a03c011",
+		},
+		{
+			name:     "Pinned reference",
+			image:    "nvcr.io/nvidia/hpc-benchmarks@sha256:0123abcd",
+			expected: "sha256:0123abcd",
+		},
+		{
+			name:  "Tagged reference",
+			image: "nvcr.io/nvidia/hpc-benchmarks:23.5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest, err := history.ContainerDigest(tt.image)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, digest)
+		})
+	}
+}
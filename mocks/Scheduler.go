@@ -22,6 +22,10 @@ func (_m *Scheduler) Submit(ctx context.Context, req *scheduler.SubmitRequest) (
 		return rf(ctx, req), nil
 	}
 
+	if rf, ok := args.Get(0).(string); ok {
+		return rf, args.Error(1)
+	}
+
 	if rf, ok := args.Get(1).(error); ok {
 		return "", rf
 	}
@@ -67,17 +71,17 @@ func (_m *Scheduler) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
-func (_m *Scheduler) FindRunningJobByName(
+func (_m *Scheduler) FindRunningJobByID(
 	ctx context.Context,
-	req *scheduler.FindRunningJobByNameRequest,
+	req *scheduler.FindRunningJobByIDRequest,
 ) (int, error) {
 	args := _m.Called(ctx, req)
 
-	if rf, ok := args.Get(0).(func(context.Context, *scheduler.FindRunningJobByNameRequest) (int, error)); ok {
+	if rf, ok := args.Get(0).(func(context.Context, *scheduler.FindRunningJobByIDRequest) (int, error)); ok {
 		return rf(ctx, req)
 	}
 
-	if rf, ok := args.Get(0).(func(context.Context, *scheduler.FindRunningJobByNameRequest) int); ok {
+	if rf, ok := args.Get(0).(func(context.Context, *scheduler.FindRunningJobByIDRequest) int); ok {
 		return rf(ctx, req), nil
 	}
 
@@ -142,6 +146,30 @@ func (_m *Scheduler) FindCPUPerNode(ctx context.Context) (int, error) {
 	return 0, nil
 }
 
+func (_m *Scheduler) FindGPUMemory(ctx context.Context) (int, error) {
+	args := _m.Called(ctx)
+
+	if rf, ok := args.Get(0).(func(context.Context) (int, error)); ok {
+		return rf(ctx)
+	}
+
+	if rf, ok := args.Get(0).(int); ok {
+		return rf, args.Error(1)
+	}
+
+	return 0, args.Error(1)
+}
+
+func (_m *Scheduler) FindGPUModel(ctx context.Context) (string, error) {
+	args := _m.Called(ctx)
+
+	if rf, ok := args.Get(0).(string); ok {
+		return rf, args.Error(1)
+	}
+
+	return "", args.Error(1)
+}
+
 func (_m *Scheduler) FindCPUAffinity(ctx context.Context) (string, error) {
 	args := _m.Called(ctx)
 
@@ -153,7 +181,7 @@ func (_m *Scheduler) FindCPUAffinity(ctx context.Context) (string, error) {
 }
 
 func (_m *Scheduler) FindJobOutputFile(ctx context.Context, jobID int) (string, error) {
-	args := _m.Called(ctx)
+	args := _m.Called(ctx, jobID)
 
 	if rf, ok := args.Get(0).(string); ok {
 		return rf, args.Error(1)
@@ -162,6 +190,34 @@ func (_m *Scheduler) FindJobOutputFile(ctx context.Context, jobID int) (string,
 	return "", args.Error(1)
 }
 
+func (_m *Scheduler) FindJobState(ctx context.Context, jobID int) (*scheduler.JobState, error) {
+	args := _m.Called(ctx, jobID)
+
+	if rf, ok := args.Get(0).(func(context.Context, int) (*scheduler.JobState, error)); ok {
+		return rf(ctx, jobID)
+	}
+
+	if rf, ok := args.Get(0).(*scheduler.JobState); ok {
+		return rf, args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+func (_m *Scheduler) FindNodeResources(ctx context.Context) ([]scheduler.NodeResources, error) {
+	args := _m.Called(ctx)
+
+	if rf, ok := args.Get(0).(func(context.Context) ([]scheduler.NodeResources, error)); ok {
+		return rf(ctx)
+	}
+
+	if rf, ok := args.Get(0).([]scheduler.NodeResources); ok {
+		return rf, args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
 type mockConstructorTestingTNewScheduler interface {
 	mock.TestingT
 	Cleanup(func())
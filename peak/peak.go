@@ -0,0 +1,149 @@
+package peak
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Peak is the theoretical peak of a GPU in TFLOPS, without sparsity.
+type Peak struct {
+	FP64 float64 `json:"fp64"`
+	// FP64Tensor is the peak of the FP64 tensor cores, the FP64 peak when 0
+	FP64Tensor float64 `json:"fp64Tensor,omitempty"`
+	// FP16Tensor is the peak of the FP16 and BF16 tensor cores
+	FP16Tensor float64 `json:"fp16Tensor,omitempty"`
+}
+
+// Precision is the arithmetic in which a benchmark reaches its peak.
+type Precision string
+
+const (
+	PrecisionFP64       Precision = "fp64"
+	PrecisionFP64Tensor Precision = "fp64-tensor"
+	PrecisionFP16Tensor Precision = "fp16-tensor"
+)
+
+// TFLOPS returns the peak in the precision, 0 when unknown.
+func (p Peak) TFLOPS(precision Precision) float64 {
+	switch precision {
+	case PrecisionFP64:
+		return p.FP64
+	case PrecisionFP64Tensor:
+		if p.FP64Tensor == 0 {
+			return p.FP64
+		}
+		return p.FP64Tensor
+	case PrecisionFP16Tensor:
+		return p.FP16Tensor
+	default:
+		return 0
+	}
+}
+
+// Rpeak returns the peak of gpus GPUs in the precision, in Gflops like the
+// results of HPL.
+func (p Peak) Rpeak(precision Precision, gpus int) float64 {
+	return p.TFLOPS(precision) * 1000 * float64(gpus)
+}
+
+// Validate checks that the peaks are positive.
+func (p Peak) Validate() error {
+	var errs []error
+	if p.FP64 <= 0 {
+		errs = append(errs, fmt.Errorf("fp64: %g must be positive", p.FP64))
+	}
+	if p.FP64Tensor < 0 {
+		errs = append(errs, fmt.Errorf("fp64Tensor: %g must not be negative", p.FP64Tensor))
+	}
+	if p.FP16Tensor < 0 {
+		errs = append(errs, fmt.Errorf("fp16Tensor: %g must not be negative", p.FP16Tensor))
+	}
+	return errors.Join(errs...)
+}
+
+// Table maps the GPU model names reported by
+// `nvidia-smi --query-gpu=name` to their peak.
+type Table map[string]Peak
+
+// Default is the peak of the usual datacenter GPUs, from the datasheets of
+// NVIDIA.
+var Default = Table{
+	"Tesla V100-SXM2-16GB":  {FP64: 7.8, FP16Tensor: 125},
+	"Tesla V100-SXM2-32GB":  {FP64: 7.8, FP16Tensor: 125},
+	"Tesla V100-PCIE-16GB":  {FP64: 7, FP16Tensor: 112},
+	"Tesla V100-PCIE-32GB":  {FP64: 7, FP16Tensor: 112},
+	"NVIDIA A100-SXM4-40GB": {FP64: 9.7, FP64Tensor: 19.5, FP16Tensor: 312},
+	"NVIDIA A100-SXM4-80GB": {FP64: 9.7, FP64Tensor: 19.5, FP16Tensor: 312},
+	"NVIDIA A100-PCIE-40GB": {FP64: 9.7, FP64Tensor: 19.5, FP16Tensor: 312},
+	"NVIDIA A100 80GB PCIe": {FP64: 9.7, FP64Tensor: 19.5, FP16Tensor: 312},
+	"NVIDIA A30":            {FP64: 5.2, FP64Tensor: 10.3, FP16Tensor: 165},
+	"NVIDIA H100 80GB HBM3": {FP64: 34, FP64Tensor: 67, FP16Tensor: 989},
+	"NVIDIA H100 PCIe":      {FP64: 26, FP64Tensor: 51, FP16Tensor: 756},
+	"NVIDIA H100 NVL":       {FP64: 30, FP64Tensor: 60, FP16Tensor: 835},
+	"NVIDIA H200":           {FP64: 34, FP64Tensor: 67, FP16Tensor: 989},
+	"NVIDIA GH200 480GB":    {FP64: 34, FP64Tensor: 67, FP16Tensor: 989},
+}
+
+// Merge returns the peaks of the table, replaced or completed by the
+// overrides.
+func (t Table) Merge(overrides Table) Table {
+	merged := make(Table, len(t)+len(overrides))
+	for name, p := range t {
+		merged[name] = p
+	}
+	for name, p := range overrides {
+		// An override replaces the entry of the same model, whatever its
+		// spelling
+		for other := range merged {
+			if normalize(other) == normalize(name) {
+				delete(merged, other)
+			}
+		}
+		merged[name] = p
+	}
+	return merged
+}
+
+// Lookup returns the peak of the GPU model. Names are compared regardless of
+// case, spaces and dashes, so that the labels of the GPU feature discovery,
+// e.g. NVIDIA-A100-SXM4-80GB, match too.
+func (t Table) Lookup(name string) (Peak, bool) {
+	if p, ok := t[name]; ok {
+		return p, true
+	}
+	for other, p := range t {
+		if normalize(other) == normalize(name) {
+			return p, true
+		}
+	}
+	return Peak{}, false
+}
+
+// Validate checks the peak of each model, in order of name.
+func (t Table) Validate() error {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var errs []error
+	for _, name := range names {
+		err, ok := t[name].Validate().(interface{ Unwrap() []error })
+		if !ok {
+			continue
+		}
+		for _, err := range err.Unwrap() {
+			errs = append(errs, fmt.Errorf("[%s].%w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_'
+	}), "-"))
+}
@@ -0,0 +1,91 @@
+package peak_test
+
+import (
+	"testing"
+
+	"github.com/squarefactory/benchmark-api/peak"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		expected peak.Peak
+		found    bool
+	}{
+		{
+			name:     "nvidia-smi name",
+			model:    "NVIDIA A100-SXM4-80GB",
+			expected: peak.Peak{FP64: 9.7, FP64Tensor: 19.5, FP16Tensor: 312},
+			found:    true,
+		},
+		{
+			name:     "GPU feature discovery label",
+			model:    "NVIDIA-H100-80GB-HBM3",
+			expected: peak.Peak{FP64: 34, FP64Tensor: 67, FP16Tensor: 989},
+			found:    true,
+		},
+		{
+			name:  "Unknown model",
+			model: "NVIDIA GeForce RTX 4090",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := peak.Default.Lookup(tt.model)
+
+			assert.Equal(t, tt.found, ok)
+			assert.Equal(t, tt.expected, p)
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	table := peak.Table{
+		"NVIDIA A100-SXM4-80GB": {FP64: 9.7, FP64Tensor: 19.5, FP16Tensor: 312},
+		"NVIDIA A30":            {FP64: 5.2, FP64Tensor: 10.3, FP16Tensor: 165},
+	}
+
+	merged := table.Merge(peak.Table{
+		"nvidia a100 sxm4 80gb": {FP64: 9},
+		"NVIDIA L40S":           {FP64: 1.4, FP16Tensor: 362},
+	})
+
+	assert.Equal(t, peak.Table{
+		"nvidia a100 sxm4 80gb": {FP64: 9},
+		"NVIDIA A30":            {FP64: 5.2, FP64Tensor: 10.3, FP16Tensor: 165},
+		"NVIDIA L40S":           {FP64: 1.4, FP16Tensor: 362},
+	}, merged)
+	// The table is left untouched
+	assert.Len(t, table, 2)
+	p, ok := merged.Lookup("NVIDIA A100-SXM4-80GB")
+	assert.True(t, ok)
+	assert.Equal(t, peak.Peak{FP64: 9}, p)
+}
+
+func TestRpeak(t *testing.T) {
+	p := peak.Peak{FP64: 9.7, FP64Tensor: 19.5, FP16Tensor: 312}
+
+	assert.InDelta(t, 4*9700.0, p.Rpeak(peak.PrecisionFP64, 4), 1e-9)
+	assert.InDelta(t, 8*19500.0, p.Rpeak(peak.PrecisionFP64Tensor, 8), 1e-9)
+	assert.InDelta(t, 4*312000.0, p.Rpeak(peak.PrecisionFP16Tensor, 4), 1e-9)
+	// Without FP64 tensor cores, the FP64 peak is used
+	assert.InDelta(t, 7800.0, peak.Peak{FP64: 7.8}.Rpeak(peak.PrecisionFP64Tensor, 1), 1e-9)
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, peak.Default.Validate())
+
+	err := peak.Table{
+		"NVIDIA A30": {FP64: 0, FP16Tensor: -1},
+	}.Validate()
+
+	require.Error(t, err)
+	assert.Equal(
+		t,
+		"[NVIDIA A30].fp64: 0 must be positive\n[NVIDIA A30].fp16Tensor: -1 must not be negative",
+		err.Error(),
+	)
+}
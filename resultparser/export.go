@@ -0,0 +1,164 @@
+package resultparser
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is the file format of the exported results.
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatJSON   Format = "json"
+	FormatNDJSON Format = "ndjson"
+)
+
+// Formats are the available formats.
+var Formats = []Format{FormatCSV, FormatJSON, FormatNDJSON}
+
+// ParseFormat returns the format with the given name, CSV when empty.
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return FormatCSV, nil
+	}
+	f := Format(name)
+	if !slices.Contains(Formats, f) {
+		names := make([]string, 0, len(Formats))
+		for _, f := range Formats {
+			names = append(names, string(f))
+		}
+		return "", fmt.Errorf(
+			"unknown results format %q, must be one of: %s",
+			name,
+			strings.Join(names, ", "),
+		)
+	}
+	return f, nil
+}
+
+// Extension returns the file extension of the format, with its dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// MetadataCsvHeader are the columns of the metadata, after CsvHeader.
+var MetadataCsvHeader = []string{
+	"RunID",
+	"Set",
+	"JobID",
+	"Nodes",
+	"GPUs",
+	"ContainerImage",
+	"SubmitTime",
+	"ParseTime",
+	"StartTime",
+	"EndTime",
+	"GPUModel",
+	"Rpeak",
+	"Efficiency",
+	"Watts",
+	"Joules",
+	"GflopsPerWatt",
+}
+
+// Export writes the results in the format. CSV keeps the columns of CsvHeader
+// first, followed by MetadataCsvHeader. JSON writes an array, and NDJSON one
+// object per line.
+func Export(w io.Writer, format Format, results []Result) error {
+	switch format {
+	case FormatCSV:
+		return exportCSV(w, results)
+	case FormatJSON:
+		if results == nil {
+			results = []Result{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	case FormatNDJSON:
+		encoder := json.NewEncoder(w)
+		for _, result := range results {
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown results format %q", format)
+	}
+}
+
+func exportCSV(w io.Writer, results []Result) error {
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	formatFloat := func(v float64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append(slices.Clone(CsvHeader), MetadataCsvHeader...)); err != nil {
+		return err
+	}
+	for _, result := range results {
+		energy := result.Energy
+		if energy == nil {
+			energy = &Energy{}
+		}
+		record := append(
+			result.Run.Record(),
+			result.RunID,
+			result.Set,
+			strconv.Itoa(result.JobID),
+			strconv.Itoa(result.Nodes),
+			strconv.Itoa(result.GPUs),
+			result.ContainerImage,
+			formatTime(result.SubmitTime),
+			formatTime(result.ParseTime),
+			formatTime(result.StartTime),
+			formatTime(result.EndTime),
+			result.GPUModel,
+			formatFloat(result.Rpeak),
+			formatFloat(result.Efficiency),
+			formatFloat(energy.Watts),
+			formatFloat(energy.Joules),
+			formatFloat(energy.GflopsPerWatt),
+		)
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteResults writes the results to a file in the format, replacing it.
+func WriteResults(path string, format Format, results []Result) error {
+	output, err := os.Create(path)
+	if err != nil {
+		log.Printf("Failed to create output file: %s", err)
+		return err
+	}
+	defer output.Close()
+
+	if err := Export(output, format, results); err != nil {
+		log.Printf("Failed to write results: %s", err)
+		return err
+	}
+	return output.Close()
+}
@@ -0,0 +1,174 @@
+package resultparser_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/peak"
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportedResults(t *testing.T) []resultparser.Result {
+	output, err := resultparser.ParseFile("testdata/hpl.log")
+	require.NoError(t, err)
+
+	return output.Results(resultparser.Metadata{
+		RunID:          "20240108-100000-abcdef",
+		Set:            "first",
+		JobID:          123,
+		Nodes:          1,
+		GPUs:           4,
+		ContainerImage: "/etc/hpl-benchmark/hpc-benchmarks:hpl.sqsh",
+		SubmitTime:     time.Date(2024, time.January, 8, 9, 59, 0, 0, time.UTC),
+		ParseTime:      time.Date(2024, time.January, 8, 10, 2, 0, 0, time.UTC),
+	})
+}
+
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := resultparser.Export(&buf, resultparser.FormatCSV, exportedResults(t))
+
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(
+		t,
+		"ProblemSize,NB,P,Q,Time,Gflops,Refine,Iter,Gflops_wrefinement,Variant,Residual,Status,Kind,"+
+			"RunID,Set,JobID,Nodes,GPUs,ContainerImage,SubmitTime,ParseTime,StartTime,EndTime,"+
+			"GPUModel,Rpeak,Efficiency,Watts,Joules,GflopsPerWatt",
+		lines[0],
+	)
+	assert.Equal(
+		t,
+		"10000,192,2,2,12.34,54.05,,,,WR11C2R4,0.00339962076,PASSED,HPL,"+
+			"20240108-100000-abcdef,first,123,1,4,/etc/hpl-benchmark/hpc-benchmarks:hpl.sqsh,"+
+			"2024-01-08T09:59:00Z,2024-01-08T10:02:00Z,2024-01-08T10:00:00Z,2024-01-08T10:00:12Z,,,,,,",
+		lines[1],
+	)
+}
+
+func TestResultsEfficiency(t *testing.T) {
+	output, err := resultparser.ParseFile("testdata/hpl_ai.log")
+	require.NoError(t, err)
+	require.NotEmpty(t, output.Runs)
+
+	results := output.Results(resultparser.Metadata{
+		Nodes:    1,
+		GPUs:     4,
+		GPUModel: "NVIDIA A100-SXM4-80GB",
+		GPUPeak:  &peak.Peak{FP64: 9.7, FP64Tensor: 19.5, FP16Tensor: 312},
+	})
+
+	for _, result := range results {
+		// HPL-AI peaks on the FP16 tensor cores
+		assert.InDelta(t, 4*312000.0, result.Rpeak, 1e-9)
+		assert.InDelta(t, result.Gflops/result.Rpeak, result.Efficiency, 1e-12)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, resultparser.Export(&buf, resultparser.FormatCSV, results[:1]))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.True(t, strings.HasSuffix(lines[1], ",NVIDIA A100-SXM4-80GB,1.248e+06,"+
+		strconv.FormatFloat(results[0].Efficiency, 'g', -1, 64)+",,,"), lines[1])
+
+	summaries := resultparser.SummarizeResults(results)
+	require.NotEmpty(t, summaries)
+	assert.InDelta(t, 4*312000.0, summaries[0].Rpeak, 1e-9)
+	assert.InDelta(t, summaries[0].Max/summaries[0].Rpeak, summaries[0].Efficiency, 1e-12)
+}
+
+func TestResultsUnknownPeak(t *testing.T) {
+	results := exportedResults(t)
+
+	for _, result := range results {
+		assert.Zero(t, result.Rpeak)
+		assert.Zero(t, result.Efficiency)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	tests := []struct {
+		format resultparser.Format
+		decode func(data []byte) ([]resultparser.Result, error)
+	}{
+		{
+			format: resultparser.FormatJSON,
+			decode: func(data []byte) ([]resultparser.Result, error) {
+				var results []resultparser.Result
+				err := json.Unmarshal(data, &results)
+				return results, err
+			},
+		},
+		{
+			format: resultparser.FormatNDJSON,
+			decode: func(data []byte) ([]resultparser.Result, error) {
+				var results []resultparser.Result
+				for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+					var result resultparser.Result
+					if err := json.Unmarshal([]byte(line), &result); err != nil {
+						return nil, err
+					}
+					results = append(results, result)
+				}
+				return results, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "first_set"+tt.format.Extension())
+			expected := exportedResults(t)
+
+			err := resultparser.WriteResults(path, tt.format, expected)
+			require.NoError(t, err)
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Contains(t, string(data), `"jobId":`)
+			assert.Contains(t, string(data), `"status":`)
+			results, err := tt.decode(data)
+			require.NoError(t, err)
+			assert.Equal(t, expected, results)
+		})
+	}
+}
+
+func TestExportEmptyJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := resultparser.Export(&buf, resultparser.FormatJSON, nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, "[]\n", buf.String())
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected resultparser.Format
+		isError  bool
+	}{
+		{name: "", expected: resultparser.FormatCSV},
+		{name: "ndjson", expected: resultparser.FormatNDJSON},
+		{name: "parquet", isError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := resultparser.ParseFormat(tt.name)
+
+			if (err != nil) != tt.isError {
+				t.Fatalf("ParseFormat() error = %v, isError %v", err, tt.isError)
+			}
+			assert.Equal(t, tt.expected, format)
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package resultparser
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the benchmark which printed a result.
+type Kind string
+
+const (
+	KindHPL    Kind = "HPL"
+	KindHPLAI  Kind = "HPL-AI"
+	KindHPLMxP Kind = "HPL-MxP"
+)
+
+// Status is the verdict of the residual check of a run.
+type Status string
+
+const (
+	StatusPassed Status = "PASSED"
+	StatusFailed Status = "FAILED"
+	// StatusUnchecked is the status of runs without residual check
+	StatusUnchecked Status = ""
+)
+
+// Run is a result line of the benchmark, with its residual check.
+type Run struct {
+	Kind Kind `json:"kind"`
+	// Variant is the T/V column, e.g. WR01C2R4
+	Variant string  `json:"variant"`
+	N       int     `json:"n"`
+	NB      int     `json:"nb"`
+	P       int     `json:"p"`
+	Q       int     `json:"q"`
+	Time    float64 `json:"time"`
+	Gflops  float64 `json:"gflops"`
+	// Refine, Iter and GflopsWithRefinement are reported by HPL-AI and
+	// HPL-MxP only
+	Refine               float64 `json:"refine,omitempty"`
+	Iter                 int     `json:"iter,omitempty"`
+	GflopsWithRefinement float64 `json:"gflopsWithRefinement,omitempty"`
+	// Residual is the scaled residual of the check
+	Residual  float64   `json:"residual,omitempty"`
+	Status    Status    `json:"status,omitempty"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+// Failed reports whether the residual check failed. A failed run must not be
+// selected.
+func (r *Run) Failed() bool {
+	return r.Status == StatusFailed
+}
+
+// Output is the parsed output of a benchmark job.
+type Output struct {
+	Runs []Run `json:"runs"`
+	// Errors are the error messages of HPL, CUDA or MPI
+	Errors []string `json:"errors,omitempty"`
+}
+
+const number = `[-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?`
+
+var (
+	// resultRegex matches the result lines, once the per GPU values in
+	// parentheses are removed:
+	//   WR11C2R4 N NB P Q Time Gflops
+	//   HPL_AI WRC01L2R2 N NB P Q Time Gflops Refine Iter Gflops_wRefinement
+	resultRegex = regexp.MustCompile(
+		`^(?:(HPL[_-]AI|HPL[_-]MxP)\s+)?(W[RC]\S*)\s+(\d+)\s+(\d+)\s+(\d+)\s+(\d+)\s+(` + number + `)\s+(` + number + `)` +
+			`(?:\s+(` + number + `)\s+(\d+)\s+(` + number + `))?$`,
+	)
+	parenthesesRegex = regexp.MustCompile(`\([^)]*\)`)
+	residualRegex    = regexp.MustCompile(
+		`^\|\|Ax-b\|\|.*=\s*(` + number + `)\s*\.*\s*(PASSED|FAILED)`,
+	)
+	timeRegex  = regexp.MustCompile(`\b(start|end) time\s+(.+)$`)
+	errorRegex = regexp.MustCompile(`(?i)\berror\b|abort|segmentation fault`)
+)
+
+// Parse reads the output of a benchmark job.
+func Parse(r io.Reader) (*Output, error) {
+	output := &Output{}
+	// last is the run to which residuals and times apply
+	var last *Run
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		cleaned := strings.Join(strings.Fields(parenthesesRegex.ReplaceAllString(line, "")), " ")
+		if match := resultRegex.FindStringSubmatch(cleaned); match != nil {
+			run, err := parseRun(match)
+			if err != nil {
+				log.Printf("Failed to parse result line %q: %s", line, err)
+				output.Errors = append(output.Errors, line)
+				continue
+			}
+			output.Runs = append(output.Runs, run)
+			last = &output.Runs[len(output.Runs)-1]
+			continue
+		}
+
+		if match := residualRegex.FindStringSubmatch(line); match != nil {
+			if last == nil || last.Status != StatusUnchecked {
+				continue
+			}
+			last.Residual, _ = strconv.ParseFloat(match[1], 64)
+			last.Status = Status(match[2])
+			continue
+		}
+
+		if match := timeRegex.FindStringSubmatch(line); match != nil {
+			if last == nil {
+				continue
+			}
+			t, err := time.Parse(time.ANSIC, strings.Join(strings.Fields(match[2]), " "))
+			if err != nil {
+				continue
+			}
+			if match[1] == "start" {
+				last.StartTime = t
+			} else {
+				last.EndTime = t
+			}
+			continue
+		}
+
+		if errorRegex.MatchString(line) {
+			output.Errors = append(output.Errors, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// ParseFile reads the output file of a benchmark job.
+func ParseFile(path string) (*Output, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to read input file: %s", err)
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+func parseRun(match []string) (Run, error) {
+	run := Run{
+		Kind:    KindHPL,
+		Variant: match[2],
+	}
+	switch strings.ReplaceAll(match[1], "_", "-") {
+	case "HPL-AI":
+		run.Kind = KindHPLAI
+	case "HPL-MxP":
+		run.Kind = KindHPLMxP
+	}
+
+	var err error
+	ints := []*int{&run.N, &run.NB, &run.P, &run.Q}
+	for i, v := range ints {
+		if *v, err = strconv.Atoi(match[3+i]); err != nil {
+			return Run{}, err
+		}
+	}
+	if run.Time, err = strconv.ParseFloat(match[7], 64); err != nil {
+		return Run{}, err
+	}
+	if run.Gflops, err = strconv.ParseFloat(match[8], 64); err != nil {
+		return Run{}, err
+	}
+
+	if match[9] == "" {
+		return run, nil
+	}
+	if run.Refine, err = strconv.ParseFloat(match[9], 64); err != nil {
+		return Run{}, err
+	}
+	if run.Iter, err = strconv.Atoi(match[10]); err != nil {
+		return Run{}, err
+	}
+	if run.GflopsWithRefinement, err = strconv.ParseFloat(match[11], 64); err != nil {
+		return Run{}, err
+	}
+	return run, nil
+}
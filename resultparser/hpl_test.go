@@ -0,0 +1,151 @@
+package resultparser_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		expected *resultparser.Output
+	}{
+		{
+			name: "HPL",
+			file: "testdata/hpl.log",
+			expected: &resultparser.Output{
+				Runs: []resultparser.Run{
+					{
+						Kind:      resultparser.KindHPL,
+						Variant:   "WR11C2R4",
+						N:         10000,
+						NB:        192,
+						P:         2,
+						Q:         2,
+						Time:      12.34,
+						Gflops:    54.05,
+						Residual:  3.39962076e-03,
+						Status:    resultparser.StatusPassed,
+						StartTime: time.Date(2024, time.January, 8, 10, 0, 0, 0, time.UTC),
+						EndTime:   time.Date(2024, time.January, 8, 10, 0, 12, 0, time.UTC),
+					},
+					{
+						Kind:      resultparser.KindHPL,
+						Variant:   "WR11C2R4",
+						N:         20000,
+						NB:        192,
+						P:         2,
+						Q:         2,
+						Time:      80.10,
+						Gflops:    66.59,
+						Residual:  214.506711,
+						Status:    resultparser.StatusFailed,
+						StartTime: time.Date(2024, time.January, 8, 10, 0, 13, 0, time.UTC),
+						EndTime:   time.Date(2024, time.January, 8, 10, 1, 33, 0, time.UTC),
+					},
+				},
+			},
+		},
+		{
+			name: "HPL-AI",
+			file: "testdata/hpl_ai.log",
+			expected: &resultparser.Output{
+				Runs: []resultparser.Run{
+					{
+						Kind:                 resultparser.KindHPLAI,
+						Variant:              "WRC01L2R2",
+						N:                    95000,
+						NB:                   384,
+						P:                    2,
+						Q:                    2,
+						Time:                 14.75,
+						Gflops:               3.876e+04,
+						Refine:               5.77248,
+						Iter:                 2,
+						GflopsWithRefinement: 2.785e+04,
+						Residual:             0.0028706,
+						Status:               resultparser.StatusPassed,
+					},
+					{
+						Kind:                 resultparser.KindHPLAI,
+						Variant:              "WRC01L2R2",
+						N:                    95000,
+						NB:                   512,
+						P:                    2,
+						Q:                    2,
+						Time:                 14.93,
+						Gflops:               3.828e+04,
+						Refine:               5.76942,
+						Iter:                 2,
+						GflopsWithRefinement: 2.761e+04,
+						Residual:             27.1,
+						Status:               resultparser.StatusFailed,
+					},
+				},
+				Errors: []string{
+					"CUDA error at hpl_ai.cu:212 code=2(cudaErrorMemoryAllocation)",
+					"MPI_ABORT was invoked on rank 1 in communicator MPI_COMM_WORLD",
+				},
+			},
+		},
+		{
+			name: "HPL-MxP",
+			file: "testdata/hpl_mxp.log",
+			expected: &resultparser.Output{
+				Runs: []resultparser.Run{
+					{
+						Kind:                 resultparser.KindHPLMxP,
+						Variant:              "WR03L8R2",
+						N:                    120000,
+						NB:                   1024,
+						P:                    1,
+						Q:                    4,
+						Time:                 20.10,
+						Gflops:               5.731e+04,
+						Refine:               4.21,
+						Iter:                 3,
+						GflopsWithRefinement: 4.790e+04,
+						Residual:             0.00133,
+						Status:               resultparser.StatusPassed,
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := resultparser.ParseFile(tt.file)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, output)
+		})
+	}
+}
+
+func TestParseShortLines(t *testing.T) {
+	output, err := resultparser.Parse(strings.NewReader("HPL_AI\nHPL_AI WRC01 1 1\nWR11C2R4\n"))
+
+	require.NoError(t, err)
+	assert.Empty(t, output.Runs)
+}
+
+func TestRecords(t *testing.T) {
+	output, err := resultparser.ParseFile("testdata/hpl.log")
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]string{
+		{"10000", "192", "2", "2", "12.34", "54.05", "", "", "", "WR11C2R4", "0.00339962076", "PASSED", "HPL"},
+		{"20000", "192", "2", "2", "80.1", "66.59", "", "", "", "WR11C2R4", "214.506711", "FAILED", "HPL"},
+	}, output.Records())
+
+	// The failed run has the most Gflops, but is never selected
+	summaries := resultparser.SummarizeRecords(output.Records())
+	require.Len(t, summaries, 1)
+	assert.Equal(t, 10000, summaries[0].ProblemSize)
+}
@@ -0,0 +1,276 @@
+package resultparser
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MetricsContentType is the content type of the metrics pushed to a
+// Pushgateway, which reads the text format of Prometheus.
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type metric struct {
+	name string
+	help string
+	// value of the metric for a result, ok is false when not reported
+	value func(r *Result) (v float64, ok bool)
+}
+
+var metrics = []metric{
+	{
+		name: "hpl_gflops",
+		help: "Rate of execution for solving the linear system.",
+		value: func(r *Result) (float64, bool) {
+			return r.Gflops, true
+		},
+	},
+	{
+		name: "hpl_gflops_with_refinement",
+		help: "Rate of execution including the iterative refinement of HPL-AI and HPL-MxP.",
+		value: func(r *Result) (float64, bool) {
+			return r.GflopsWithRefinement, r.Kind != KindHPL
+		},
+	},
+	{
+		name: "hpl_time_seconds",
+		help: "Time to solve the linear system.",
+		value: func(r *Result) (float64, bool) {
+			return r.Time, true
+		},
+	},
+	{
+		name: "hpl_rpeak_gflops",
+		help: "Theoretical peak of the GPUs of the job in the precision of the benchmark.",
+		value: func(r *Result) (float64, bool) {
+			return r.Rpeak, r.Rpeak > 0
+		},
+	},
+	{
+		name: "hpl_efficiency_ratio",
+		help: "Rate of execution over the theoretical peak, Rmax/Rpeak.",
+		value: func(r *Result) (float64, bool) {
+			return r.Efficiency, r.Rpeak > 0
+		},
+	},
+	{
+		name: "hpl_power_watts",
+		help: "Average power of the nodes during the run.",
+		value: func(r *Result) (float64, bool) {
+			if r.Energy == nil {
+				return 0, false
+			}
+			return r.Energy.Watts, true
+		},
+	},
+	{
+		name: "hpl_energy_joules",
+		help: "Energy drawn by the nodes during the run.",
+		value: func(r *Result) (float64, bool) {
+			if r.Energy == nil {
+				return 0, false
+			}
+			return r.Energy.Joules, true
+		},
+	},
+	{
+		name: "hpl_gflops_per_watt",
+		help: "Energy efficiency of the run.",
+		value: func(r *Result) (float64, bool) {
+			if r.Energy == nil {
+				return 0, false
+			}
+			return r.Energy.GflopsPerWatt, true
+		},
+	},
+	{
+		name: "hpl_residual_check_passed",
+		help: "Whether the residual check passed, 1 for PASSED, 0 for FAILED.",
+		value: func(r *Result) (float64, bool) {
+			if r.Status == StatusUnchecked {
+				return 0, false
+			}
+			if r.Failed() {
+				return 0, true
+			}
+			return 1, true
+		},
+	},
+}
+
+// escapeLabelValue escapes a label value of the text format.
+var escapeLabelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+
+// labels returns the labels of a result. The run and job IDs are left out, as
+// they would create new series on every run. The results sharing their
+// parameters, e.g. those of the second set, are told apart by their
+// repetition.
+func labels(r *Result, repetition int) string {
+	pairs := [][2]string{
+		{"set", r.Set},
+		{"kind", string(r.Kind)},
+		{"variant", r.Variant},
+		{"n", strconv.Itoa(r.N)},
+		{"nb", strconv.Itoa(r.NB)},
+		{"p", strconv.Itoa(r.P)},
+		{"q", strconv.Itoa(r.Q)},
+		{"nodes", strconv.Itoa(r.Nodes)},
+		{"gpus", strconv.Itoa(r.GPUs)},
+		{"repetition", strconv.Itoa(repetition)},
+	}
+	return formatLabels(pairs)
+}
+
+func formatLabels(pairs [][2]string) string {
+	s := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		s = append(s, fmt.Sprintf(`%s="%s"`, pair[0], escapeLabelValue(pair[1])))
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+// WriteMetrics writes the results as gauges in the text format of Prometheus,
+// which the textfile collector of node_exporter and Pushgateway read. The
+// parameters of each run are labels, and the run IDs are the labels of the
+// hpl_run_info gauge.
+func WriteMetrics(w io.Writer, results []Result) error {
+	// Labels of each result, with its repetition among the results sharing
+	// its parameters
+	resultLabels := make([]string, len(results))
+	repetitions := map[string]int{}
+	var runIDs []string
+	for i := range results {
+		key := labels(&results[i], 0)
+		resultLabels[i] = labels(&results[i], repetitions[key])
+		repetitions[key]++
+		if !slices.Contains(runIDs, results[i].RunID) {
+			runIDs = append(runIDs, results[i].RunID)
+		}
+	}
+
+	var buf bytes.Buffer
+	if len(runIDs) > 0 {
+		buf.WriteString("# HELP hpl_run_info Run of the benchmark which produced the results.\n")
+		buf.WriteString("# TYPE hpl_run_info gauge\n")
+		for _, runID := range runIDs {
+			fmt.Fprintf(&buf, "hpl_run_info%s 1\n", formatLabels([][2]string{{"run_id", runID}}))
+		}
+	}
+
+	for _, m := range metrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", m.name)
+		for i := range results {
+			v, ok := m.value(&results[i])
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(
+				&buf,
+				"%s%s %s\n",
+				m.name,
+				resultLabels[i],
+				strconv.FormatFloat(v, 'g', -1, 64),
+			)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteMetricsFile writes the metrics to a file of the textfile collector.
+// The file is replaced atomically, so that the collector never reads a
+// partial file.
+func WriteMetricsFile(path string, results []Result) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		log.Printf("Failed to create metrics file: %s", err)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := WriteMetrics(tmp, results); err != nil {
+		tmp.Close()
+		log.Printf("Failed to write metrics: %s", err)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PushMetrics replaces the metrics of the group of the job on a Pushgateway.
+// The group is identified by the job and the grouping labels, if any.
+func PushMetrics(
+	ctx context.Context,
+	client *http.Client,
+	gateway string,
+	job string,
+	grouping map[string]string,
+	results []Result,
+) error {
+	var body bytes.Buffer
+	if err := WriteMetrics(&body, results); err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(gateway, "/") + "/metrics" + groupingPath("job", job)
+	names := make([]string, 0, len(grouping))
+	for name := range grouping {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		endpoint += groupingPath(name, grouping[name])
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", MetricsContentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Failed to push metrics: %s", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf(
+			"pushgateway returned %s: %s",
+			resp.Status,
+			strings.TrimSpace(string(message)),
+		)
+	}
+	return nil
+}
+
+// groupingPath returns the path of a label of the grouping key. Values with a
+// slash are encoded in base64 as the Pushgateway requires, and empty values
+// as "=", since their encoding is empty.
+func groupingPath(name string, value string) string {
+	if value == "" {
+		return "/" + name + "@base64/="
+	}
+	if strings.Contains(value, "/") {
+		return "/" + name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return "/" + name + "/" + url.PathEscape(value)
+}
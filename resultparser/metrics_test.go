@@ -0,0 +1,213 @@
+package resultparser_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var metricsResults = []resultparser.Result{
+	{
+		Run: resultparser.Run{
+			Kind:     resultparser.KindHPL,
+			Variant:  "WR01C2R4",
+			N:        90000,
+			NB:       512,
+			P:        2,
+			Q:        2,
+			Time:     12.5,
+			Gflops:   38880,
+			Residual: 0.0021,
+			Status:   resultparser.StatusPassed,
+		},
+		Metadata: resultparser.Metadata{
+			RunID: "20240108-100000-abcdef",
+			Set:   "second",
+			JobID: 123,
+			Nodes: 1,
+			GPUs:  4,
+		},
+		Rpeak:      78000,
+		Efficiency: 0.4984615384615385,
+		Energy: &resultparser.Energy{
+			GPUWatts:      1200,
+			Watts:         1500,
+			Joules:        18750,
+			GflopsPerWatt: 25.92,
+		},
+	},
+	{
+		Run: resultparser.Run{
+			Kind:                 resultparser.KindHPLAI,
+			Variant:              "WRC01L2R2",
+			N:                    90000,
+			NB:                   1024,
+			P:                    2,
+			Q:                    2,
+			Time:                 3.25,
+			Gflops:               149538,
+			Refine:               0.5,
+			Iter:                 3,
+			GflopsWithRefinement: 129600,
+			Status:               resultparser.StatusFailed,
+		},
+		Metadata: resultparser.Metadata{
+			RunID: `run "a"`,
+			Set:   "first",
+			JobID: 124,
+			Nodes: 1,
+			GPUs:  4,
+		},
+	},
+}
+
+const expectedMetrics = `# HELP hpl_run_info Run of the benchmark which produced the results.
+# TYPE hpl_run_info gauge
+hpl_run_info{run_id="20240108-100000-abcdef"} 1
+hpl_run_info{run_id="run \"a\""} 1
+# HELP hpl_gflops Rate of execution for solving the linear system.
+# TYPE hpl_gflops gauge
+hpl_gflops{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="0"} 38880
+hpl_gflops{set="first",kind="HPL-AI",variant="WRC01L2R2",n="90000",nb="1024",p="2",q="2",nodes="1",gpus="4",repetition="0"} 149538
+# HELP hpl_gflops_with_refinement Rate of execution including the iterative refinement of HPL-AI and HPL-MxP.
+# TYPE hpl_gflops_with_refinement gauge
+hpl_gflops_with_refinement{set="first",kind="HPL-AI",variant="WRC01L2R2",n="90000",nb="1024",p="2",q="2",nodes="1",gpus="4",repetition="0"} 129600
+# HELP hpl_time_seconds Time to solve the linear system.
+# TYPE hpl_time_seconds gauge
+hpl_time_seconds{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="0"} 12.5
+hpl_time_seconds{set="first",kind="HPL-AI",variant="WRC01L2R2",n="90000",nb="1024",p="2",q="2",nodes="1",gpus="4",repetition="0"} 3.25
+# HELP hpl_rpeak_gflops Theoretical peak of the GPUs of the job in the precision of the benchmark.
+# TYPE hpl_rpeak_gflops gauge
+hpl_rpeak_gflops{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="0"} 78000
+# HELP hpl_efficiency_ratio Rate of execution over the theoretical peak, Rmax/Rpeak.
+# TYPE hpl_efficiency_ratio gauge
+hpl_efficiency_ratio{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="0"} 0.4984615384615385
+# HELP hpl_power_watts Average power of the nodes during the run.
+# TYPE hpl_power_watts gauge
+hpl_power_watts{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="0"} 1500
+# HELP hpl_energy_joules Energy drawn by the nodes during the run.
+# TYPE hpl_energy_joules gauge
+hpl_energy_joules{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="0"} 18750
+# HELP hpl_gflops_per_watt Energy efficiency of the run.
+# TYPE hpl_gflops_per_watt gauge
+hpl_gflops_per_watt{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="0"} 25.92
+# HELP hpl_residual_check_passed Whether the residual check passed, 1 for PASSED, 0 for FAILED.
+# TYPE hpl_residual_check_passed gauge
+hpl_residual_check_passed{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="0"} 1
+hpl_residual_check_passed{set="first",kind="HPL-AI",variant="WRC01L2R2",n="90000",nb="1024",p="2",q="2",nodes="1",gpus="4",repetition="0"} 0
+`
+
+func TestWriteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := resultparser.WriteMetrics(&buf, metricsResults)
+
+	require.NoError(t, err)
+	assert.Equal(t, expectedMetrics, buf.String())
+}
+
+func TestWriteMetricsEmpty(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := resultparser.WriteMetrics(&buf, nil)
+
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "hpl_gflops{")
+	assert.NotContains(t, buf.String(), "hpl_run_info")
+}
+
+func TestWriteMetricsRepetitions(t *testing.T) {
+	var buf bytes.Buffer
+	results := []resultparser.Result{metricsResults[0], metricsResults[0]}
+	results[1].JobID = 125
+
+	err := resultparser.WriteMetrics(&buf, results)
+
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), `hpl_gflops{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="0"} 38880`)
+	assert.Contains(t, buf.String(), `hpl_gflops{set="second",kind="HPL",variant="WR01C2R4",n="90000",nb="512",p="2",q="2",nodes="1",gpus="4",repetition="1"} 38880`)
+	assert.Equal(t, 1, strings.Count(buf.String(), "hpl_run_info{"))
+}
+
+func TestWriteMetricsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hpl.prom")
+	require.NoError(t, os.WriteFile(path, []byte("stale"), 0o644))
+
+	err := resultparser.WriteMetricsFile(path, metricsResults)
+
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expectedMetrics, string(content))
+	// The temporary file is renamed
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestPushMetrics(t *testing.T) {
+	var (
+		method      string
+		path        string
+		contentType string
+		body        []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.EscapedPath()
+		contentType = r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	err := resultparser.PushMetrics(
+		context.Background(),
+		server.Client(),
+		server.URL+"/",
+		"hpl benchmark",
+		map[string]string{"run_id": "20240108-100000-abcdef", "partition": "gpu/a100", "reservation": ""},
+		metricsResults,
+	)
+
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(
+		t,
+		"/metrics/job/hpl%20benchmark/partition@base64/Z3B1L2ExMDA/reservation@base64/=/run_id/20240108-100000-abcdef",
+		path,
+	)
+	assert.Equal(t, resultparser.MetricsContentType, contentType)
+	assert.Equal(t, expectedMetrics, string(body))
+}
+
+func TestPushMetricsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid metric", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := resultparser.PushMetrics(
+		context.Background(),
+		server.Client(),
+		server.URL,
+		"hpl_benchmark",
+		nil,
+		metricsResults,
+	)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400")
+	assert.Contains(t, err.Error(), "invalid metric")
+}
@@ -0,0 +1,351 @@
+package resultparser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PowerSource is the sampler of a power series.
+type PowerSource string
+
+const (
+	// PowerSourceGPU is the power of a GPU, sampled by nvidia-smi
+	PowerSourceGPU PowerSource = "gpu"
+	// PowerSourceIPMI is the power of a node, sampled by ipmitool
+	PowerSourceIPMI PowerSource = "ipmi"
+	// PowerSourceRAPL is the power of the CPUs of a node, derived from the
+	// RAPL energy counters
+	PowerSourceRAPL PowerSource = "rapl"
+)
+
+// powerTimeLayout is the layout of the timestamps of nvidia-smi, also used by
+// the other samplers. Like the start and end times of HPL, it is in the local
+// time of the node.
+const powerTimeLayout = "2006/01/02 15:04:05.999999999"
+
+// PowerSample is the power of a component at a given time.
+type PowerSample struct {
+	Time  time.Time
+	Watts float64
+}
+
+// PowerSeries are the samples of a component of a node.
+type PowerSeries struct {
+	Source PowerSource
+	Host   string
+	// Device is the index of the GPU, empty for the other sources
+	Device  string
+	Samples []PowerSample
+}
+
+// PowerTrace are the power series of the nodes of a job.
+type PowerTrace []PowerSeries
+
+// ParseGPUPower reads the output of
+// `nvidia-smi --query-gpu=timestamp,index,power.draw --format=csv`, with or
+// without header and units, into a series per GPU. Samples which are not
+// available, e.g. [N/A], are skipped.
+func ParseGPUPower(host string, r io.Reader) ([]PowerSeries, error) {
+	var series []PowerSeries
+	index := map[string]int{}
+
+	err := scanPowerLines(r, func(line string) {
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "timestamp") {
+			return
+		}
+		t, err := time.Parse(powerTimeLayout, strings.TrimSpace(fields[0]))
+		if err != nil {
+			return
+		}
+		watts, err := strconv.ParseFloat(
+			strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(fields[2]), "W")),
+			64,
+		)
+		if err != nil {
+			return
+		}
+
+		device := strings.TrimSpace(fields[1])
+		i, ok := index[device]
+		if !ok {
+			series = append(series, PowerSeries{Source: PowerSourceGPU, Host: host, Device: device})
+			i = len(series) - 1
+			index[device] = i
+		}
+		series[i].Samples = append(series[i].Samples, PowerSample{Time: t, Watts: watts})
+	})
+	return series, err
+}
+
+// ParseIPMIPower reads the samples of the node power, one
+// `<timestamp> <watts>` per line.
+func ParseIPMIPower(host string, r io.Reader) (PowerSeries, error) {
+	series := PowerSeries{Source: PowerSourceIPMI, Host: host}
+	err := scanTimedValues(r, func(t time.Time, watts float64) {
+		series.Samples = append(series.Samples, PowerSample{Time: t, Watts: watts})
+	})
+	return series, err
+}
+
+// ParseRAPLEnergy reads the samples of the energy counters of the CPUs, one
+// `<timestamp> <microjoules>` per line, and derives the power between each
+// pair of samples. The intervals over which a counter wrapped are skipped.
+func ParseRAPLEnergy(host string, r io.Reader) (PowerSeries, error) {
+	series := PowerSeries{Source: PowerSourceRAPL, Host: host}
+	var (
+		last       time.Time
+		lastJoules float64
+	)
+	err := scanTimedValues(r, func(t time.Time, microjoules float64) {
+		joules := microjoules / 1e6
+		if !last.IsZero() && t.After(last) && joules >= lastJoules {
+			series.Samples = append(series.Samples, PowerSample{
+				Time:  t,
+				Watts: (joules - lastJoules) / t.Sub(last).Seconds(),
+			})
+		}
+		last, lastJoules = t, joules
+	})
+	return series, err
+}
+
+// ReadPowerDir reads the files written by the power samplers of a job, named
+// <host>.gpu.csv, <host>.ipmi.txt and <host>.rapl.txt.
+func ReadPowerDir(dir string) (PowerTrace, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Failed to read power samples: %s", err)
+		return nil, err
+	}
+
+	var trace PowerTrace
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		base := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		source := PowerSource(strings.TrimPrefix(filepath.Ext(base), "."))
+		host := strings.TrimSuffix(base, filepath.Ext(base))
+
+		series, err := readPowerFile(filepath.Join(dir, entry.Name()), host, source)
+		if err != nil {
+			log.Printf("Failed to parse power samples %s: %s", entry.Name(), err)
+			return nil, err
+		}
+		trace = append(trace, series...)
+	}
+	return trace, nil
+}
+
+func readPowerFile(path string, host string, source PowerSource) ([]PowerSeries, error) {
+	var parse func(r io.Reader) ([]PowerSeries, error)
+	switch source {
+	case PowerSourceGPU:
+		parse = func(r io.Reader) ([]PowerSeries, error) {
+			return ParseGPUPower(host, r)
+		}
+	case PowerSourceIPMI:
+		parse = func(r io.Reader) ([]PowerSeries, error) {
+			series, err := ParseIPMIPower(host, r)
+			return []PowerSeries{series}, err
+		}
+	case PowerSourceRAPL:
+		parse = func(r io.Reader) ([]PowerSeries, error) {
+			series, err := ParseRAPLEnergy(host, r)
+			return []PowerSeries{series}, err
+		}
+	default:
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parse(file)
+}
+
+func scanPowerLines(r io.Reader, fn func(line string)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// scanTimedValues reads `<date> <time> <value>` lines. Lines without a value,
+// such as the last line of a killed sampler, are skipped.
+func scanTimedValues(r io.Reader, fn func(t time.Time, v float64)) error {
+	return scanPowerLines(r, func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return
+		}
+		t, err := time.Parse(powerTimeLayout, fields[0]+" "+fields[1])
+		if err != nil {
+			return
+		}
+		v, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return
+		}
+		fn(t, v)
+	})
+}
+
+// Energy is the power drawn by the nodes of a job during the core phase of a
+// run, between the start and end times of HPL.
+type Energy struct {
+	// GPUWatts, CPUWatts and NodeWatts are the average power of the GPUs
+	// (nvidia-smi), CPUs (RAPL) and nodes (IPMI)
+	GPUWatts  float64 `json:"gpuWatts,omitempty"`
+	CPUWatts  float64 `json:"cpuWatts,omitempty"`
+	NodeWatts float64 `json:"nodeWatts,omitempty"`
+	// Watts is the average power of the nodes: IPMI when measured, the GPUs
+	// and CPUs otherwise
+	Watts         float64 `json:"watts"`
+	Joules        float64 `json:"joules"`
+	GflopsPerWatt float64 `json:"gflopsPerWatt"`
+}
+
+// Measure aligns the samples with the core phase of the run, and returns the
+// energy it drew. The end time of HPL is truncated to the second, so the phase
+// lasts until the next second. A series without sample in the phase, when it
+// is shorter than the sampling interval, holds its last sample if it was taken
+// at most one interval before the phase. Older samples are left out, as their
+// sampler may have stopped long before.
+func (t PowerTrace) Measure(run *Run, interval time.Duration) (*Energy, error) {
+	if run.StartTime.IsZero() || run.EndTime.IsZero() {
+		return nil, errors.New("the run has no start and end times")
+	}
+	start, end := run.StartTime, run.EndTime.Add(time.Second)
+
+	energy := &Energy{}
+	hosts := map[string]*Energy{}
+	for _, series := range t {
+		watts, ok := series.average(start, end, interval)
+		if !ok {
+			continue
+		}
+		host, ok := hosts[series.Host]
+		if !ok {
+			host = &Energy{}
+			hosts[series.Host] = host
+		}
+		switch series.Source {
+		case PowerSourceGPU:
+			host.GPUWatts += watts
+		case PowerSourceRAPL:
+			host.CPUWatts += watts
+		case PowerSourceIPMI:
+			host.NodeWatts += watts
+		}
+	}
+	for _, host := range hosts {
+		energy.GPUWatts += host.GPUWatts
+		energy.CPUWatts += host.CPUWatts
+		energy.NodeWatts += host.NodeWatts
+		if host.NodeWatts > 0 {
+			energy.Watts += host.NodeWatts
+		} else {
+			energy.Watts += host.GPUWatts + host.CPUWatts
+		}
+	}
+	if energy.Watts <= 0 {
+		return nil, fmt.Errorf(
+			"no power sample between %s and %s",
+			start.Format(time.DateTime),
+			end.Format(time.DateTime),
+		)
+	}
+
+	duration := run.Time
+	if duration <= 0 {
+		duration = end.Sub(start).Seconds()
+	}
+	energy.Joules = energy.Watts * duration
+	energy.GflopsPerWatt = run.Gflops / energy.Watts
+	return energy, nil
+}
+
+// average returns the average power of the samples in [start, end), or the
+// last sample taken at most interval before start when there is none.
+func (s *PowerSeries) average(start, end time.Time, interval time.Duration) (float64, bool) {
+	var (
+		sum   float64
+		count int
+		last  *PowerSample
+	)
+	for i := range s.Samples {
+		sample := &s.Samples[i]
+		if !sample.Time.Before(end) {
+			continue
+		}
+		if sample.Time.Before(start) {
+			if last == nil || sample.Time.After(last.Time) {
+				last = sample
+			}
+			continue
+		}
+		sum += sample.Watts
+		count++
+	}
+	if count == 0 {
+		if last == nil || last.Time.Before(start.Add(-interval)) {
+			return 0, false
+		}
+		return last.Watts, true
+	}
+	return sum / float64(count), true
+}
+
+// Unsampled returns the hosts of the trace without any sample of the source,
+// e.g. those on which the RAPL energy counters are readable by root only.
+func (t PowerTrace) Unsampled(source PowerSource) []string {
+	var hosts []string
+	sampled := map[string]bool{}
+	for _, series := range t {
+		if !slices.Contains(hosts, series.Host) {
+			hosts = append(hosts, series.Host)
+		}
+		if series.Source == source && len(series.Samples) > 0 {
+			sampled[series.Host] = true
+		}
+	}
+	return slices.DeleteFunc(hosts, func(host string) bool { return sampled[host] })
+}
+
+// MeasureEnergy sets the energy of the results from the power trace of their
+// job, sampled every interval. Results which cannot be aligned with the trace
+// are left without energy.
+func MeasureEnergy(results []Result, trace PowerTrace, interval time.Duration) {
+	for i := range results {
+		energy, err := trace.Measure(&results[i].Run, interval)
+		if err != nil {
+			log.Printf(
+				"Failed to measure the energy of N=%d NB=%d P=%d Q=%d: %s",
+				results[i].N,
+				results[i].NB,
+				results[i].P,
+				results[i].Q,
+				err,
+			)
+			continue
+		}
+		results[i].Energy = energy
+	}
+}
@@ -0,0 +1,171 @@
+package resultparser_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGPUPower(t *testing.T) {
+	out := `timestamp, index, power.draw [W]
+2024/01/08 10:00:00.500, 0, 290.00 W
+2024/01/08 10:00:00.500, 1, [N/A]
+2024/01/08 10:00:01.500, 0, 310.00 W
+2024/01/08 10:00:01.500, 1, 305.50 W
+2024/01/08 10:00:02.5`
+
+	series, err := resultparser.ParseGPUPower("gpu01", strings.NewReader(out))
+
+	require.NoError(t, err)
+	assert.Equal(t, []resultparser.PowerSeries{
+		{
+			Source: resultparser.PowerSourceGPU,
+			Host:   "gpu01",
+			Device: "0",
+			Samples: []resultparser.PowerSample{
+				{Time: time.Date(2024, time.January, 8, 10, 0, 0, 5e8, time.UTC), Watts: 290},
+				{Time: time.Date(2024, time.January, 8, 10, 0, 1, 5e8, time.UTC), Watts: 310},
+			},
+		},
+		{
+			Source: resultparser.PowerSourceGPU,
+			Host:   "gpu01",
+			Device: "1",
+			Samples: []resultparser.PowerSample{
+				{Time: time.Date(2024, time.January, 8, 10, 0, 1, 5e8, time.UTC), Watts: 305.5},
+			},
+		},
+	}, series)
+}
+
+func TestParseRAPLEnergy(t *testing.T) {
+	out := `2024/01/08 10:00:00.000000000 1000000
+2024/01/08 10:00:02.000000000 401000000
+2024/01/08 10:00:03.000000000 1000
+2024/01/08 10:00:04.000000000 150001000
+`
+
+	series, err := resultparser.ParseRAPLEnergy("gpu01", strings.NewReader(out))
+
+	require.NoError(t, err)
+	// The interval over which the counter wrapped is skipped
+	assert.Equal(t, []resultparser.PowerSample{
+		{Time: time.Date(2024, time.January, 8, 10, 0, 2, 0, time.UTC), Watts: 200},
+		{Time: time.Date(2024, time.January, 8, 10, 0, 4, 0, time.UTC), Watts: 150},
+	}, series.Samples)
+}
+
+func TestMeasureEnergy(t *testing.T) {
+	output, err := resultparser.ParseFile("testdata/hpl.log")
+	require.NoError(t, err)
+	trace, err := resultparser.ReadPowerDir("testdata/power")
+	require.NoError(t, err)
+	// 2 GPUs, IPMI and RAPL on gpu01, 2 GPUs and RAPL on gpu02
+	require.Len(t, trace, 7)
+
+	results := output.Results(resultparser.Metadata{Nodes: 2, GPUs: 4})
+	resultparser.MeasureEnergy(results, trace, time.Second)
+
+	tests := []struct {
+		gpu, cpu, node, watts float64
+	}{
+		// gpu01 is measured by IPMI, gpu02 by its GPUs and CPUs
+		{gpu: 1200, cpu: 400, node: 900, watts: 900 + 600 + 200},
+		{gpu: 1400, cpu: 400, node: 1000, watts: 1000 + 700 + 200},
+	}
+	require.Len(t, results, len(tests))
+	for i, tt := range tests {
+		energy := results[i].Energy
+		require.NotNil(t, energy)
+		assert.InDelta(t, tt.gpu, energy.GPUWatts, 1e-9)
+		assert.InDelta(t, tt.cpu, energy.CPUWatts, 1e-6)
+		assert.InDelta(t, tt.node, energy.NodeWatts, 1e-9)
+		assert.InDelta(t, tt.watts, energy.Watts, 1e-6)
+		assert.InDelta(t, tt.watts*results[i].Time, energy.Joules, 1e-6)
+		assert.InDelta(t, results[i].Gflops/tt.watts, energy.GflopsPerWatt, 1e-9)
+	}
+}
+
+func TestMeasureShortRun(t *testing.T) {
+	trace := resultparser.PowerTrace{
+		{
+			Source: resultparser.PowerSourceGPU,
+			Host:   "gpu01",
+			Device: "0",
+			Samples: []resultparser.PowerSample{
+				{Time: time.Date(2024, time.January, 8, 9, 59, 50, 0, time.UTC), Watts: 60},
+				{Time: time.Date(2024, time.January, 8, 10, 0, 0, 0, time.UTC), Watts: 250},
+				{Time: time.Date(2024, time.January, 8, 10, 0, 10, 0, time.UTC), Watts: 60},
+			},
+		},
+	}
+	run := &resultparser.Run{
+		Gflops:    500,
+		Time:      2,
+		StartTime: time.Date(2024, time.January, 8, 10, 0, 2, 0, time.UTC),
+		EndTime:   time.Date(2024, time.January, 8, 10, 0, 4, 0, time.UTC),
+	}
+
+	energy, err := trace.Measure(run, 10*time.Second)
+
+	// The last sample before the end of the run is held
+	require.NoError(t, err)
+	assert.Equal(t, &resultparser.Energy{
+		GPUWatts:      250,
+		Watts:         250,
+		Joules:        500,
+		GflopsPerWatt: 2,
+	}, energy)
+}
+
+func TestMeasureWithoutSamples(t *testing.T) {
+	run := &resultparser.Run{
+		StartTime: time.Date(2024, time.January, 8, 10, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2024, time.January, 8, 10, 0, 12, 0, time.UTC),
+	}
+
+	_, err := resultparser.PowerTrace{}.Measure(run, time.Second)
+	assert.Error(t, err)
+
+	_, err = resultparser.PowerTrace{}.Measure(&resultparser.Run{}, time.Second)
+	assert.Error(t, err)
+}
+
+func TestMeasureStaleSample(t *testing.T) {
+	trace := resultparser.PowerTrace{
+		{
+			Source: resultparser.PowerSourceGPU,
+			Host:   "gpu01",
+			Device: "0",
+			Samples: []resultparser.PowerSample{
+				{Time: time.Date(2024, time.January, 8, 9, 55, 0, 0, time.UTC), Watts: 250},
+			},
+		},
+	}
+	run := &resultparser.Run{
+		Gflops:    500,
+		Time:      2,
+		StartTime: time.Date(2024, time.January, 8, 10, 0, 2, 0, time.UTC),
+		EndTime:   time.Date(2024, time.January, 8, 10, 0, 4, 0, time.UTC),
+	}
+
+	// The sampler stopped minutes before the run
+	_, err := trace.Measure(run, time.Second)
+
+	assert.Error(t, err)
+}
+
+func TestUnsampled(t *testing.T) {
+	trace := resultparser.PowerTrace{
+		{Source: resultparser.PowerSourceGPU, Host: "gpu01", Device: "0", Samples: []resultparser.PowerSample{{Watts: 250}}},
+		{Source: resultparser.PowerSourceRAPL, Host: "gpu01", Samples: []resultparser.PowerSample{{Watts: 200}}},
+		{Source: resultparser.PowerSourceGPU, Host: "gpu02", Device: "0", Samples: []resultparser.PowerSample{{Watts: 250}}},
+		{Source: resultparser.PowerSourceRAPL, Host: "gpu03"},
+	}
+
+	assert.Equal(t, []string{"gpu02", "gpu03"}, trace.Unsampled(resultparser.PowerSourceRAPL))
+}
@@ -0,0 +1,123 @@
+package resultparser
+
+import (
+	"time"
+
+	"github.com/squarefactory/benchmark-api/peak"
+	"github.com/squarefactory/benchmark-api/stats"
+)
+
+const (
+	// SetFirst is the set which tunes the parameters
+	SetFirst = "first"
+	// SetSecond is the set which repeats the best parameters
+	SetSecond = "second"
+)
+
+// Metadata describes the job which produced a result.
+type Metadata struct {
+	// RunID is the ID of the run directory
+	RunID string `json:"runId"`
+	// Set is the set of the job, first or second
+	Set   string `json:"set"`
+	JobID int    `json:"jobId"`
+	Nodes int    `json:"nodes"`
+	// GPUs is the total number of GPUs of the job
+	GPUs           int       `json:"gpus"`
+	ContainerImage string    `json:"containerImage"`
+	SubmitTime     time.Time `json:"submitTime"`
+	// ParseTime is the time at which the output was parsed, once the job was
+	// over
+	ParseTime time.Time `json:"parseTime"`
+	// GPUModel is the model of the GPUs, as reported by nvidia-smi
+	GPUModel string `json:"gpuModel,omitempty"`
+	// GPUPeak is the peak of a GPU, nil when the model is unknown
+	GPUPeak *peak.Peak `json:"gpuPeak,omitempty"`
+}
+
+// Result is a run of the benchmark, along with the metadata of its job.
+type Result struct {
+	Run
+	Metadata
+	// Rpeak is the peak of the GPUs of the job in Gflops, in the precision
+	// of the benchmark, 0 when unknown
+	Rpeak float64 `json:"rpeak,omitempty"`
+	// Efficiency is Rmax/Rpeak, the Gflops of the run over Rpeak
+	Efficiency float64 `json:"efficiency,omitempty"`
+	// Energy is the energy drawn during the run, nil when the power was not
+	// sampled
+	Energy *Energy `json:"energy,omitempty"`
+}
+
+// Precision returns the precision in which the benchmark reaches its peak:
+// FP64 tensor cores for HPL, FP16 tensor cores for HPL-AI and HPL-MxP.
+func (k Kind) Precision() peak.Precision {
+	if k == KindHPL {
+		return peak.PrecisionFP64Tensor
+	}
+	return peak.PrecisionFP16Tensor
+}
+
+// Results returns the runs of the output with the metadata of their job, and
+// their efficiency when the peak of the GPUs is known.
+func (o *Output) Results(meta Metadata) []Result {
+	results := make([]Result, 0, len(o.Runs))
+	for _, run := range o.Runs {
+		result := Result{Run: run, Metadata: meta}
+		if meta.GPUPeak != nil {
+			result.Rpeak = meta.GPUPeak.Rpeak(run.Kind.Precision(), meta.GPUs)
+		}
+		if result.Rpeak > 0 {
+			result.Efficiency = run.Gflops / result.Rpeak
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// SummarizeResults groups the results by configuration, in order of
+// appearance. Failed runs are skipped. The efficiency of a configuration is
+// its best Gflops, Rmax, over its Rpeak.
+func SummarizeResults(results []Result) []ConfigSummary {
+	type key struct {
+		n, nb, p, q int
+		kind        Kind
+		variant     string
+	}
+	var order []key
+	gflops := map[key][]float64{}
+	rpeaks := map[key]float64{}
+	for _, result := range results {
+		run := result.Run
+		if run.Failed() {
+			continue
+		}
+		k := key{n: run.N, nb: run.NB, p: run.P, q: run.Q, kind: run.Kind, variant: run.Variant}
+		if _, ok := gflops[k]; !ok {
+			order = append(order, k)
+		}
+		gflops[k] = append(gflops[k], run.Gflops)
+		if rpeaks[k] == 0 {
+			rpeaks[k] = result.Rpeak
+		}
+	}
+
+	summaries := make([]ConfigSummary, 0, len(order))
+	for _, k := range order {
+		s := ConfigSummary{
+			ProblemSize: k.n,
+			NB:          k.nb,
+			P:           k.p,
+			Q:           k.q,
+			Kind:        k.kind,
+			Variant:     k.variant,
+			Summary:     stats.Summarize(gflops[k]),
+			Rpeak:       rpeaks[k],
+		}
+		if s.Rpeak > 0 {
+			s.Efficiency = s.Max / s.Rpeak
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
@@ -19,8 +19,21 @@ var CsvHeader = []string{
 	"Refine",
 	"Iter",
 	"Gflops_wrefinement",
+	"Variant",
+	"Residual",
+	"Status",
+	"Kind",
 }
 
+const (
+	// VariantColumn is the index of the T/V column of HPL in the CSV records
+	VariantColumn = 9
+	// StatusColumn is the index of the residual check verdict
+	StatusColumn = 11
+	// KindColumn is the index of the kind of benchmark, HPL, HPL-AI or HPL-MxP
+	KindColumn = 12
+)
+
 func WriteResultsToCSV(resultFile, csvFile string) error {
 
 	// Read the input file contents
@@ -105,48 +118,73 @@ func WriteDataAsCsvRecord(file *os.File, lines []string) error {
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
-	// Process each line and extract the required values
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "HPL_AI") {
-			// Split the line into fields
-			fields := strings.Fields(line)
-
-			// Extract the required values
-			problemsize := fields[2]
-			nb := fields[3]
-			p := fields[4]
-			q := fields[5]
-			time := fields[6]
-			gflops := fields[7]
-			refine := fields[8]
-			iter := fields[9]
-			gflops_wrefinement := fields[10]
-
-			// Write the extracted values to the CSV file
-			record := []string{
-				problemsize,
-				nb,
-				p,
-				q,
-				time,
-				gflops,
-				refine,
-				iter,
-				gflops_wrefinement,
-			}
-
-			err := writer.Write(record)
-			if err != nil {
-				log.Printf("Failed to write CSV record: %s", err)
-				return err
-			}
+	for _, record := range ParseRecords(lines) {
+		err := writer.Write(record)
+		if err != nil {
+			log.Printf("Failed to write CSV record: %s", err)
+			return err
 		}
 	}
 	return nil
 
 }
 
+// ParseRecords returns the CSV records of the result lines.
+func ParseRecords(lines []string) [][]string {
+	// Reading from memory does not fail
+	output, _ := Parse(strings.NewReader(strings.Join(lines, "\n")))
+	return output.Records()
+}
+
+// Records returns the CSV records of the runs, in the columns of CsvHeader.
+func (o *Output) Records() [][]string {
+	records := make([][]string, 0, len(o.Runs))
+	for _, run := range o.Runs {
+		records = append(records, run.Record())
+	}
+	return records
+}
+
+// Record returns the CSV record of the run, in the columns of CsvHeader. The
+// refinement columns are empty for HPL, as is the residual of unchecked runs.
+func (r *Run) Record() []string {
+	formatFloat := func(v float64) string {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	var refine, iter, gflopsWithRefinement string
+	if r.Kind != KindHPL {
+		refine = formatFloat(r.Refine)
+		iter = strconv.Itoa(r.Iter)
+		gflopsWithRefinement = formatFloat(r.GflopsWithRefinement)
+	}
+	var residual string
+	if r.Status != StatusUnchecked {
+		residual = formatFloat(r.Residual)
+	}
+
+	return []string{
+		strconv.Itoa(r.N),
+		strconv.Itoa(r.NB),
+		strconv.Itoa(r.P),
+		strconv.Itoa(r.Q),
+		formatFloat(r.Time),
+		formatFloat(r.Gflops),
+		refine,
+		iter,
+		gflopsWithRefinement,
+		r.Variant,
+		residual,
+		string(r.Status),
+		string(r.Kind),
+	}
+}
+
+// failedRecord reports whether the residual check of a record failed.
+func failedRecord(row []string) bool {
+	return len(row) > StatusColumn && Status(row[StatusColumn]) == StatusFailed
+}
+
 func FindMaxGflopsRow(csvFile string) ([]string, error) {
 	file, err := os.Open(csvFile)
 	if err != nil {
@@ -166,6 +204,10 @@ func FindMaxGflopsRow(csvFile string) ([]string, error) {
 	var maxGflopsRow []string
 
 	for _, row := range records {
+		// Failed runs are never selected
+		if failedRecord(row) {
+			continue
+		}
 		gflops, err := strconv.ParseFloat(row[5], 64) // Gflops is in the 6th column (index 5)
 		if err != nil {
 			fmt.Println("Error converting Gflops to float:", err)
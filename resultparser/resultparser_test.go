@@ -2,17 +2,19 @@ package resultparser_test
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/squarefactory/benchmark-api/stats"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestWriteResultsToCSV(t *testing.T) {
 
-	tempInputFile := "/tmp/benchmark.log"
-	defer os.Remove(tempInputFile)
+	dir := t.TempDir()
+	tempInputFile := filepath.Join(dir, "benchmark.log")
 
 	cleanData := `HPL_AI WRC01 1 1 1 1 0.001 10.0 1 1 9.5
 	HPL_AI WRC01 2 2 2 2 0.002 20.0 1 1 19.0`
@@ -28,15 +30,15 @@ func TestWriteResultsToCSV(t *testing.T) {
 	}{
 		{
 			name:       "Positive test",
-			resultFile: "/tmp/benchmark.log",
-			csvFile:    "benchmark.log",
+			resultFile: tempInputFile,
+			csvFile:    filepath.Join(dir, "benchmark.csv"),
 			wantErr:    false,
 		},
 
 		{
 			name:       "File does not exist",
-			resultFile: "/tmp/non_existing_file.txt",
-			csvFile:    "benchmark.log",
+			resultFile: filepath.Join(dir, "non_existing_file.txt"),
+			csvFile:    filepath.Join(dir, "benchmark.csv"),
 			wantErr:    true,
 		},
 	}
@@ -50,8 +52,7 @@ func TestWriteResultsToCSV(t *testing.T) {
 }
 
 func TestFindMaxGflopsRow(t *testing.T) {
-	tempInputFile := "/tmp/benchmark.log"
-	defer os.Remove(tempInputFile)
+	tempInputFile := filepath.Join(t.TempDir(), "benchmark.log")
 
 	cleanData := `ProblemSize,NB,P,Q,Time,Gflops,Refine,Iter,Gflops_wrefinement
 95000,64,2,2,29.67,1.927e+04,5.71402,2,1.616e+04
@@ -89,3 +90,124 @@ func TestFindMaxGflopsRow(t *testing.T) {
 		})
 	}
 }
+
+func TestSummarize(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "second_set.csv")
+
+	// 384 has the single highest Gflops, 512 the highest median
+	cleanData := `ProblemSize,NB,P,Q,Time,Gflops,Refine,Iter,Gflops_wrefinement,Variant
+95000,384,2,2,14.75,3.876e+04,5.77248,2,2.785e+04,WRC01
+95000,512,2,2,14.93,3.828e+04,5.76942,2,2.761e+04,WRC01
+95000,384,2,2,16.10,3.551e+04,5.77248,2,2.601e+04,WRC01
+95000,512,2,2,14.95,3.824e+04,5.76942,2,2.760e+04,WRC01
+95000,384,2,2,16.40,3.486e+04,5.77248,2,2.550e+04,WRC01
+95000,512,2,2,14.90,3.832e+04,5.76942,2,2.762e+04,WRC01`
+
+	err := os.WriteFile(csvFile, []byte(cleanData), 0644)
+	require.NoError(t, err)
+
+	summaries, err := resultparser.Summarize(csvFile)
+	require.NoError(t, err)
+	require.Len(t, summaries, 2)
+	assert.Equal(t, 384, summaries[0].NB)
+	assert.Equal(t, "WRC01", summaries[0].Variant)
+	assert.Equal(t, 3, summaries[0].Count)
+	assert.Equal(t, 3.876e+04, summaries[0].Max)
+	assert.Equal(t, 3.551e+04, summaries[0].Median)
+	assert.Equal(t, 512, summaries[1].NB)
+	assert.InDelta(t, 3.828e+04, summaries[1].Mean, 1e-6)
+
+	tests := []struct {
+		criterion stats.Criterion
+		expected  int
+	}{
+		{criterion: stats.CriterionMax, expected: 384},
+		{criterion: stats.CriterionMedian, expected: 512},
+		{criterion: stats.CriterionCILow, expected: 512},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.criterion), func(t *testing.T) {
+			best, err := resultparser.SelectBest(summaries, tt.criterion)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, best.NB)
+		})
+	}
+
+	summaryFile := filepath.Join(t.TempDir(), "second_set_summary.csv")
+	require.NoError(t, resultparser.WriteSummaryToCsv(summaries, summaryFile))
+	summary, err := os.ReadFile(summaryFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(summary), "ProblemSize,NB,P,Q,Kind,Variant,Count,Mean,Median,StdDev,Min,Max,CILow,CIHigh,Rpeak,Efficiency\n95000,384,2,2,,WRC01,3,")
+}
+
+func TestSummarizeRecordsVariants(t *testing.T) {
+	records := [][]string{
+		{"95000", "384", "2", "2", "16.10", "3.876e+04", "", "", "", "WR01C2R4"},
+		{"95000", "384", "2", "2", "16.10", "3.551e+04", "", "", "", "WR11C2R4"},
+		{"95000", "384", "2", "2", "16.10", "3.486e+04", "", "", "", "WR01C2R4"},
+	}
+
+	summaries := resultparser.SummarizeRecords(records)
+
+	require.Len(t, summaries, 2)
+	assert.Equal(t, "WR01C2R4", summaries[0].Variant)
+	assert.Equal(t, 2, summaries[0].Count)
+	assert.Equal(t, "WR11C2R4", summaries[1].Variant)
+	assert.Equal(t, 1, summaries[1].Count)
+}
+
+func TestSummarizeRecordsKinds(t *testing.T) {
+	records := [][]string{
+		{"95000", "384", "2", "2", "16.10", "3.876e+04", "", "", "", "WR01C2R4", "0.0028", "PASSED", "HPL"},
+		{"95000", "384", "2", "2", "16.10", "2.5e+05", "5.77", "2", "2.7e+05", "WR01C2R4", "0.0028", "PASSED", "HPL-AI"},
+	}
+
+	summaries := resultparser.SummarizeRecords(records)
+
+	require.Len(t, summaries, 2)
+	assert.Equal(t, resultparser.KindHPL, summaries[0].Kind)
+	assert.Equal(t, resultparser.KindHPLAI, summaries[1].Kind)
+	assert.Equal(t, 2.5e+05, summaries[1].Max)
+}
+
+func TestSummarizeResultsKinds(t *testing.T) {
+	run := resultparser.Run{N: 95000, NB: 384, P: 2, Q: 2, Variant: "WR01C2R4"}
+	hpl, hplAI := run, run
+	hpl.Kind, hpl.Gflops = resultparser.KindHPL, 3.876e+04
+	hplAI.Kind, hplAI.Gflops = resultparser.KindHPLAI, 2.5e+05
+	results := []resultparser.Result{{Run: hpl}, {Run: hplAI, Rpeak: 1.248e+06}, {Run: hpl}}
+
+	summaries := resultparser.SummarizeResults(results)
+
+	require.Len(t, summaries, 2)
+	assert.Equal(t, 95000, summaries[0].ProblemSize)
+	assert.Equal(t, 384, summaries[0].NB)
+	assert.Equal(t, resultparser.KindHPL, summaries[0].Kind)
+	assert.Equal(t, 2, summaries[0].Count)
+	assert.Zero(t, summaries[0].Rpeak)
+	assert.Equal(t, resultparser.KindHPLAI, summaries[1].Kind)
+	assert.Equal(t, 1, summaries[1].Count)
+	assert.InDelta(t, 2.5e+05/1.248e+06, summaries[1].Efficiency, 1e-12)
+}
+
+func TestSelectBestEmpty(t *testing.T) {
+	_, err := resultparser.SelectBest(nil, stats.CriterionMedian)
+
+	assert.Error(t, err)
+}
+
+func TestFindMaxGflopsRowSkipsFailed(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "first_set.csv")
+
+	cleanData := `ProblemSize,NB,P,Q,Time,Gflops,Refine,Iter,Gflops_wrefinement,Variant,Residual,Status
+95000,384,2,2,14.75,3.876e+04,5.77248,2,2.785e+04,WRC01,0.0028706,PASSED
+95000,512,2,2,14.93,3.928e+04,5.76942,2,2.761e+04,WRC01,27.1,FAILED`
+
+	err := os.WriteFile(csvFile, []byte(cleanData), 0644)
+	require.NoError(t, err)
+
+	got, err := resultparser.FindMaxGflopsRow(csvFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, "384", got[1])
+}
@@ -0,0 +1,176 @@
+package resultparser
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/squarefactory/benchmark-api/stats"
+)
+
+var SummaryHeader = []string{
+	"ProblemSize",
+	"NB",
+	"P",
+	"Q",
+	"Kind",
+	"Variant",
+	"Count",
+	"Mean",
+	"Median",
+	"StdDev",
+	"Min",
+	"Max",
+	"CILow",
+	"CIHigh",
+	"Rpeak",
+	"Efficiency",
+}
+
+// ConfigSummary is the summary of the Gflops of a configuration, identified by
+// its problem size, block size, process grid, kind and variant.
+type ConfigSummary struct {
+	ProblemSize int `json:"problemSize"`
+	NB          int `json:"nb"`
+	P           int `json:"p"`
+	Q           int `json:"q"`
+	// Kind of benchmark, empty in records without it
+	Kind Kind `json:"kind,omitempty"`
+	// Variant is the T/V column of HPL, empty in records without it
+	Variant string `json:"variant,omitempty"`
+	stats.Summary
+	// Rpeak is the peak of the GPUs in Gflops, 0 when unknown
+	Rpeak float64 `json:"rpeak,omitempty"`
+	// Efficiency is the max Gflops, Rmax, over Rpeak
+	Efficiency float64 `json:"efficiency,omitempty"`
+}
+
+// SummarizeRecords groups the CSV records by configuration, as
+// SummarizeResults. Records without a configuration or Gflops, such as
+// headers, are skipped.
+func SummarizeRecords(records [][]string) []ConfigSummary {
+	results := make([]Result, 0, len(records))
+	for _, row := range records {
+		run, err := parseRecord(row)
+		if err != nil {
+			continue
+		}
+		results = append(results, Result{Run: *run})
+	}
+	return SummarizeResults(results)
+}
+
+// parseRecord reads the configuration, kind, Gflops and status of a CSV record,
+// in the columns of CsvHeader.
+func parseRecord(row []string) (*Run, error) {
+	if len(row) < 6 {
+		return nil, fmt.Errorf("record of %d columns", len(row))
+	}
+
+	var run Run
+	var err error
+	for i, v := range []*int{&run.N, &run.NB, &run.P, &run.Q} {
+		if *v, err = strconv.Atoi(row[i]); err != nil {
+			return nil, err
+		}
+	}
+	if run.Gflops, err = strconv.ParseFloat(row[5], 64); err != nil {
+		return nil, err
+	}
+	if len(row) > VariantColumn {
+		run.Variant = row[VariantColumn]
+	}
+	if len(row) > StatusColumn {
+		run.Status = Status(row[StatusColumn])
+	}
+	if len(row) > KindColumn {
+		run.Kind = Kind(row[KindColumn])
+	}
+	return &run, nil
+}
+
+// Summarize groups the rows of a CSV results file by configuration.
+func Summarize(csvFile string) ([]ConfigSummary, error) {
+	file, err := os.Open(csvFile)
+	if err != nil {
+		log.Printf("Failed to open CSV file: %s", err)
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		log.Printf("Failed to read CSV records: %s", err)
+		return nil, err
+	}
+
+	return SummarizeRecords(records), nil
+}
+
+// SelectBest returns the best configuration by the criterion.
+func SelectBest(summaries []ConfigSummary, criterion stats.Criterion) (ConfigSummary, error) {
+	if len(summaries) == 0 {
+		return ConfigSummary{}, errors.New("no result to select a configuration from")
+	}
+
+	// Ties of the criterion, e.g. configurations which ran once by the lower
+	// bound of the confidence interval, are broken by the median, then the
+	// first configuration wins
+	best := summaries[0]
+	for _, s := range summaries[1:] {
+		score, bestScore := criterion.Score(s.Summary), criterion.Score(best.Summary)
+		if score > bestScore || score == bestScore && s.Median > best.Median {
+			best = s
+		}
+	}
+	return best, nil
+}
+
+// WriteSummaryToCsv writes the summaries to a CSV file.
+func WriteSummaryToCsv(summaries []ConfigSummary, csvFile string) error {
+	output, err := os.Create(csvFile)
+	if err != nil {
+		log.Printf("Failed to create output file: %s", err)
+		return err
+	}
+	defer output.Close()
+
+	writer := csv.NewWriter(output)
+	defer writer.Flush()
+
+	if err := writer.Write(SummaryHeader); err != nil {
+		log.Printf("Failed to write CSV header: %s", err)
+		return err
+	}
+	for _, s := range summaries {
+		record := []string{
+			strconv.Itoa(s.ProblemSize),
+			strconv.Itoa(s.NB),
+			strconv.Itoa(s.P),
+			strconv.Itoa(s.Q),
+			string(s.Kind),
+			s.Variant,
+			strconv.Itoa(s.Count),
+		}
+		for _, v := range []float64{s.Mean, s.Median, s.StdDev, s.Min, s.Max, float64(s.CILow), float64(s.CIHigh)} {
+			record = append(record, strconv.FormatFloat(v, 'g', 6, 64))
+		}
+		if s.Rpeak > 0 {
+			record = append(
+				record,
+				strconv.FormatFloat(s.Rpeak, 'g', 6, 64),
+				strconv.FormatFloat(s.Efficiency, 'g', 6, 64),
+			)
+		} else {
+			record = append(record, "", "")
+		}
+		if err := writer.Write(record); err != nil {
+			log.Printf("Failed to write CSV record: %s", err)
+			return err
+		}
+	}
+	return nil
+}
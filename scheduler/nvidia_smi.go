@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NvidiaSMIGPUMemoryCommand lists the memory of each GPU in MiB.
+const NvidiaSMIGPUMemoryCommand = "nvidia-smi --query-gpu=memory.total --format=csv,noheader,nounits"
+
+// parseNvidiaSMIGPUMemory returns the smallest GPU memory in MB listed by
+// NvidiaSMIGPUMemoryCommand.
+func parseNvidiaSMIGPUMemory(out string) (int, error) {
+	var smallest int
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// Older drivers ignore nounits
+		mem, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(line, "MiB")))
+		if err != nil {
+			return 0, fmt.Errorf("invalid GPU memory %q: %w", line, err)
+		}
+		if smallest == 0 || mem < smallest {
+			smallest = mem
+		}
+	}
+
+	if smallest == 0 {
+		return 0, errors.New("no GPU found")
+	}
+	return smallest, nil
+}
+
+// NvidiaSMIGPUNameCommand lists the model name of each GPU.
+const NvidiaSMIGPUNameCommand = "nvidia-smi --query-gpu=name --format=csv,noheader"
+
+// parseNvidiaSMIGPUName returns the model name of the GPUs listed by
+// NvidiaSMIGPUNameCommand, which must all be of the same model.
+func parseNvidiaSMIGPUName(out string) (string, error) {
+	var name string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if name != "" && line != name {
+			return "", fmt.Errorf("GPU models differ: %s, %s", name, line)
+		}
+		name = line
+	}
+
+	if name == "" {
+		return "", errors.New("no GPU found")
+	}
+	return name, nil
+}
+
+// NvidiaSMITopologyCommand prints the topology matrix of the GPUs, from which
+// NvidiaSMITopologyFilter keeps the CPU affinity of each GPU, e.g. "0 0-31".
+const (
+	NvidiaSMITopologyCommand = "nvidia-smi topo -m"
+	NvidiaSMITopologyFilter  = " | grep -E '^GPU[0-9]+' | awk '{print $1, $7}' | sed 's/GPU//'"
+)
@@ -0,0 +1,596 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// KubernetesJobIDLabel identifies the resources of a benchmark job.
+	KubernetesJobIDLabel = "benchmark-api/job-id"
+	// KubernetesRoleLabel identifies the pod whose logs are the job output.
+	KubernetesRoleLabel = "benchmark-api/role"
+	// KubernetesOutputAnnotation holds the path of the job output.
+	KubernetesOutputAnnotation = "benchmark-api/output"
+
+	KubernetesRoleLauncher = "launcher"
+
+	// KubernetesGPUMemoryLabel is set by the GPU feature discovery to the
+	// memory of the GPUs in MiB.
+	KubernetesGPUMemoryLabel = "nvidia.com/gpu.memory"
+	// KubernetesGPUProductLabel is set by the GPU feature discovery to the
+	// model of the GPUs, with dashes instead of spaces.
+	KubernetesGPUProductLabel = "nvidia.com/gpu.product"
+
+	// KubernetesTTLAfterFinished is the time after which finished workloads,
+	// along with their pods and ConfigMap, are deleted, unless the manifest
+	// sets its own. It leaves time to collect the logs of the launcher.
+	KubernetesTTLAfterFinished = 24 * time.Hour
+
+	kubernetesGPUResource = corev1.ResourceName("nvidia.com/gpu")
+
+	// kubernetesSubmitTries bounds the draws of a job ID not used by another
+	// workload
+	kubernetesSubmitTries = 3
+)
+
+var (
+	kubernetesJobGVR = schema.GroupVersionResource{
+		Group:    "batch",
+		Version:  "v1",
+		Resource: "jobs",
+	}
+	kubernetesMPIJobGVR = schema.GroupVersionResource{
+		Group:    "kubeflow.org",
+		Version:  "v2beta1",
+		Resource: "mpijobs",
+	}
+)
+
+// errKubernetesWorkloadNotFound is returned when no workload has the job ID.
+var errKubernetesWorkloadNotFound = errors.New("workload not found")
+
+// Kubernetes runs the benchmark as a Job (single node) or as an MPIJob of the
+// Kubeflow MPI operator (multi node).
+//
+// The job body is a manifest in which %j is replaced by the job ID, drawn at
+// random. The files of the SubmitRequest are stored in a ConfigMap named after
+// the workload and owned by it, and both are deleted by the TTL controller
+// once the workload is finished. The logs of the launcher pod are written to
+// the job output by CollectJobOutput.
+type Kubernetes struct {
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+	namespace string
+}
+
+func NewKubernetes(
+	clientset kubernetes.Interface,
+	dynamic dynamic.Interface,
+	namespace string,
+) *Kubernetes {
+	return &Kubernetes{
+		clientset: clientset,
+		dynamic:   dynamic,
+		namespace: namespace,
+	}
+}
+
+// kubernetesTTLPath is the path of the TTL after finished in the spec of a
+// workload.
+func kubernetesTTLPath(obj *unstructured.Unstructured) []string {
+	if obj.GetKind() == "MPIJob" {
+		return []string{"spec", "runPolicy", "ttlSecondsAfterFinished"}
+	}
+	return []string{"spec", "ttlSecondsAfterFinished"}
+}
+
+func kubernetesGVR(obj *unstructured.Unstructured) (schema.GroupVersionResource, error) {
+	switch obj.GetKind() {
+	case "Job":
+		return kubernetesJobGVR, nil
+	case "MPIJob":
+		return kubernetesMPIJobGVR, nil
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("unsupported kind: %s", obj.GetKind())
+}
+
+func kubernetesJobSelector(jobID int) string {
+	return fmt.Sprintf("%s=%d", KubernetesJobIDLabel, jobID)
+}
+
+// findWorkload returns the Job or MPIJob of a benchmark job.
+func (k *Kubernetes) findWorkload(ctx context.Context, jobID int) (*unstructured.Unstructured, error) {
+	for _, gvr := range []schema.GroupVersionResource{kubernetesJobGVR, kubernetesMPIJobGVR} {
+		list, err := k.dynamic.Resource(gvr).Namespace(k.namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: kubernetesJobSelector(jobID),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(list.Items) > 0 {
+			return &list.Items[0], nil
+		}
+	}
+	return nil, fmt.Errorf("job %d: %w", jobID, errKubernetesWorkloadNotFound)
+}
+
+// CancelJob deletes the workload, its pods and its ConfigMap.
+func (k *Kubernetes) CancelJob(ctx context.Context, req *CancelRequest) error {
+	obj, err := k.findWorkload(ctx, req.JobID)
+	if err != nil {
+		log.Printf("cancel failed: %s", err)
+		return err
+	}
+
+	gvr, err := kubernetesGVR(obj)
+	if err != nil {
+		return err
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	if err := k.dynamic.Resource(gvr).Namespace(k.namespace).Delete(
+		ctx,
+		obj.GetName(),
+		metav1.DeleteOptions{PropagationPolicy: &propagation},
+	); err != nil {
+		log.Printf("cancel failed: %s", err)
+		return err
+	}
+
+	if err := k.clientset.CoreV1().ConfigMaps(k.namespace).Delete(
+		ctx,
+		obj.GetName(),
+		metav1.DeleteOptions{},
+	); err != nil {
+		log.Printf("failed to delete configmap %s: %s", obj.GetName(), err)
+	}
+	return nil
+}
+
+// Submit creates the workload described by the job body, and the ConfigMap
+// holding the job files. Job IDs are drawn again when a workload or ConfigMap
+// of the same name already exists, e.g. one of another invocation.
+func (k *Kubernetes) Submit(ctx context.Context, req *SubmitRequest) (string, error) {
+	for try := 1; ; try++ {
+		jobID := strconv.FormatInt(1+rand.Int63n(999_999_999), 10)
+		err := k.submit(ctx, req, jobID)
+		if apierrors.IsAlreadyExists(err) && try < kubernetesSubmitTries {
+			log.Printf("job ID %s is already used, drawing another one: %s", jobID, err)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return jobID, nil
+	}
+}
+
+func (k *Kubernetes) submit(ctx context.Context, req *SubmitRequest, jobID string) error {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(
+		[]byte(strings.ReplaceAll(req.Body, "%j", jobID)),
+		&obj.Object,
+	); err != nil {
+		log.Printf("submit failed, invalid manifest: %s", err)
+		return err
+	}
+
+	gvr, err := kubernetesGVR(obj)
+	if err != nil {
+		log.Printf("submit failed: %s", err)
+		return err
+	}
+
+	output := req.Output
+	if output == "" {
+		output = JobOutput
+	}
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[KubernetesJobIDLabel] = jobID
+	obj.SetLabels(labels)
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[KubernetesOutputAnnotation] = strings.ReplaceAll(output, "%j", jobID)
+	obj.SetAnnotations(annotations)
+	ttlPath := kubernetesTTLPath(obj)
+	if _, found, _ := unstructured.NestedFieldNoCopy(obj.Object, ttlPath...); !found {
+		if err := unstructured.SetNestedField(
+			obj.Object,
+			int64(KubernetesTTLAfterFinished/time.Second),
+			ttlPath...,
+		); err != nil {
+			log.Printf("submit failed, cannot set the TTL: %s", err)
+			return err
+		}
+	}
+
+	created, err := k.dynamic.Resource(gvr).Namespace(k.namespace).Create(
+		ctx,
+		obj,
+		metav1.CreateOptions{},
+	)
+	if err != nil {
+		log.Printf("submit failed: %s", err)
+		return err
+	}
+
+	// The pods wait for the ConfigMap to be mounted, which is deleted along
+	// with the workload
+	if _, err := k.clientset.CoreV1().ConfigMaps(k.namespace).Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   obj.GetName(),
+			Labels: map[string]string{KubernetesJobIDLabel: jobID},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: created.GetAPIVersion(),
+				Kind:       created.GetKind(),
+				Name:       created.GetName(),
+				UID:        created.GetUID(),
+			}},
+		},
+		Data: req.Files,
+	}, metav1.CreateOptions{}); err != nil {
+		log.Printf("submit failed, cannot create configmap: %s", err)
+		propagation := metav1.DeletePropagationBackground
+		if err := k.dynamic.Resource(gvr).Namespace(k.namespace).Delete(
+			context.WithoutCancel(ctx),
+			obj.GetName(),
+			metav1.DeleteOptions{PropagationPolicy: &propagation},
+		); err != nil {
+			log.Printf("failed to delete workload %s: %s", obj.GetName(), err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// HealthCheck queries the version of the API server.
+func (k *Kubernetes) HealthCheck(ctx context.Context) error {
+	_, err := k.clientset.Discovery().ServerVersion()
+	if err != nil {
+		log.Printf("healthcheck failed: %s", err)
+	}
+	return err
+}
+
+// kubernetesCondition returns the finished condition of a Job or MPIJob.
+func kubernetesCondition(obj *unstructured.Unstructured) (condition string, reason string) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		c, ok := c.(map[string]interface{})
+		if !ok || c["status"] != string(corev1.ConditionTrue) {
+			continue
+		}
+		switch c["type"] {
+		case string(batchv1.JobComplete), "Succeeded":
+			return JobStateCompleted, fmt.Sprint(c["reason"])
+		case string(batchv1.JobFailed):
+			return JobStateFailed, fmt.Sprint(c["reason"])
+		}
+	}
+	return "", ""
+}
+
+// FindRunningJobByID returns the job ID if the workload is not finished.
+func (k *Kubernetes) FindRunningJobByID(
+	ctx context.Context,
+	req *FindRunningJobByIDRequest,
+) (int, error) {
+	obj, err := k.findWorkload(ctx, req.JobID)
+	if errors.Is(err, errKubernetesWorkloadNotFound) {
+		return 0, ErrJobNotRunning
+	}
+	if err != nil {
+		log.Printf("FindRunningJobByID failed: %s", err)
+		return 0, err
+	}
+
+	if condition, _ := kubernetesCondition(obj); condition != "" {
+		return 0, ErrJobNotRunning
+	}
+	return req.JobID, nil
+}
+
+// FindNodeResources returns the allocatable resources of the schedulable nodes
+// with GPUs, or of all of them if none has GPUs, sorted by name.
+func (k *Kubernetes) FindNodeResources(ctx context.Context) ([]NodeResources, error) {
+	nodes, err := k.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("FindNodeResources failed: %s", err)
+		return nil, err
+	}
+
+	if len(nodes.Items) == 0 {
+		return nil, errors.New("no nodes found")
+	}
+
+	resources := make([]NodeResources, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		if !kubernetesSchedulable(&node) {
+			continue
+		}
+		mem := node.Status.Allocatable[corev1.ResourceMemory]
+		gpu := node.Status.Allocatable[kubernetesGPUResource]
+		cpu := node.Status.Allocatable[corev1.ResourceCPU]
+		resources = append(resources, NodeResources{
+			Name:   node.Name,
+			Memory: int(mem.Value() / (1 << 20)),
+			GPUs:   int(gpu.Value()),
+			CPUs:   int(cpu.Value()),
+		})
+	}
+
+	if len(resources) == 0 {
+		return nil, errors.New("no schedulable node found")
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Name < resources[j].Name
+	})
+	return gpuNodes(resources), nil
+}
+
+// kubernetesSchedulable returns whether the benchmark pods can land on the
+// node: it is not cordoned, and has no taint repelling them, as they tolerate
+// none. The not-ready and unreachable nodes are tainted by the node lifecycle
+// controller.
+func kubernetesSchedulable(node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			return false
+		}
+	}
+	return true
+}
+
+// FindMemPerNode returns the smallest allocatable memory of the nodes in MB.
+func (k *Kubernetes) FindMemPerNode(ctx context.Context) (int, error) {
+	nodes, err := k.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("FindMemPerNode failed: %s", err)
+		return 0, err
+	}
+
+	return MinNodeResources(nodes).Memory, nil
+}
+
+func (k *Kubernetes) FindGPUPerNode(ctx context.Context) (int, error) {
+	nodes, err := k.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("FindGPUPerNode failed: %s", err)
+		return 0, err
+	}
+
+	return MinNodeResources(nodes).GPUs, nil
+}
+
+func (k *Kubernetes) FindCPUPerNode(ctx context.Context) (int, error) {
+	nodes, err := k.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("FindCPUPerNode failed : %s", err)
+		return 0, err
+	}
+
+	return MinNodeResources(nodes).CPUs, nil
+}
+
+// FindGPUMemory returns the smallest GPU memory in MB among the GPU nodes,
+// from the labels of the NVIDIA GPU feature discovery.
+func (k *Kubernetes) FindGPUMemory(ctx context.Context) (int, error) {
+	nodes, err := k.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("FindGPUMemory failed: %s", err)
+		return 0, err
+	}
+
+	var smallest int
+	for _, node := range nodes.Items {
+		label, ok := node.Labels[KubernetesGPUMemoryLabel]
+		if !ok {
+			continue
+		}
+		mem, err := strconv.Atoi(label)
+		if err != nil {
+			log.Printf("Invalid GPU memory label %s of node %s: %s", label, node.Name, err)
+			return 0, err
+		}
+		if smallest == 0 || mem < smallest {
+			smallest = mem
+		}
+	}
+
+	if smallest == 0 {
+		return 0, fmt.Errorf("no node labeled with %s", KubernetesGPUMemoryLabel)
+	}
+	return smallest, nil
+}
+
+// FindGPUModel returns the model of the GPUs from the labels of the NVIDIA
+// GPU feature discovery. The dashes which replace the spaces of the model name
+// are kept, as they cannot be told apart from the dashes of the name.
+func (k *Kubernetes) FindGPUModel(ctx context.Context) (string, error) {
+	nodes, err := k.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("FindGPUModel failed: %s", err)
+		return "", err
+	}
+
+	var model string
+	for _, node := range nodes.Items {
+		label, ok := node.Labels[KubernetesGPUProductLabel]
+		if !ok {
+			continue
+		}
+		if model != "" && label != model {
+			return "", fmt.Errorf("GPU models differ: %s, %s", model, label)
+		}
+		model = label
+	}
+
+	if model == "" {
+		return "", fmt.Errorf("no node labeled with %s", KubernetesGPUProductLabel)
+	}
+	return model, nil
+}
+
+// FindCPUAffinity cannot be queried from the API server. An empty affinity
+// lets hpl.sh pick one.
+func (k *Kubernetes) FindCPUAffinity(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (k *Kubernetes) FindJobOutputFile(ctx context.Context, jobID int) (string, error) {
+	obj, err := k.findWorkload(ctx, jobID)
+	if err != nil {
+		log.Printf("FindJobOutputFile failed : %s", err)
+		return "", err
+	}
+
+	return obj.GetAnnotations()[KubernetesOutputAnnotation], nil
+}
+
+// FindJobState computes the final state of a job from its workload and its
+// pods.
+func (k *Kubernetes) FindJobState(ctx context.Context, jobID int) (*JobState, error) {
+	obj, err := k.findWorkload(ctx, jobID)
+	if err != nil {
+		log.Printf("FindJobState failed : %s", err)
+		return nil, err
+	}
+
+	condition, reason := kubernetesCondition(obj)
+	if condition == "" {
+		return nil, fmt.Errorf("job %d is not finished", jobID)
+	}
+
+	pods, err := k.clientset.CoreV1().Pods(k.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: kubernetesJobSelector(jobID),
+	})
+	if err != nil {
+		log.Printf("FindJobState failed : %s", err)
+		return nil, err
+	}
+
+	state := &JobState{
+		State:    condition,
+		ExitCode: "0",
+	}
+	if reason == batchv1.JobReasonDeadlineExceeded {
+		state.State = JobStateTimeout
+	}
+
+	var nodes []string
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != "" {
+			nodes = append(nodes, pod.Spec.NodeName)
+		}
+		if pod.Status.Reason == "Evicted" || pod.Status.Reason == "Preempting" {
+			state.State = JobStatePreempted
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			terminated := status.State.Terminated
+			if terminated == nil || terminated.ExitCode == 0 {
+				continue
+			}
+			state.ExitCode = strconv.Itoa(int(terminated.ExitCode))
+			if terminated.Reason == "OOMKilled" {
+				state.State = JobStateOutOfMemory
+			}
+		}
+	}
+	sort.Strings(nodes)
+	state.NodeList = strings.Join(nodes, ",")
+
+	start, _, _ := unstructured.NestedString(obj.Object, "status", "startTime")
+	end, _, _ := unstructured.NestedString(obj.Object, "status", "completionTime")
+	if start != "" && end != "" {
+		startTime, startErr := time.Parse(time.RFC3339, start)
+		endTime, endErr := time.Parse(time.RFC3339, end)
+		if startErr == nil && endErr == nil {
+			state.Elapsed = endTime.Sub(startTime)
+		}
+	}
+
+	return state, nil
+}
+
+// CollectJobOutput writes the logs of the launcher pod of a finished job to
+// the job output.
+func (k *Kubernetes) CollectJobOutput(ctx context.Context, jobID int) error {
+	obj, err := k.findWorkload(ctx, jobID)
+	if err != nil {
+		log.Printf("CollectJobOutput failed : %s", err)
+		return err
+	}
+
+	pods, err := k.clientset.CoreV1().Pods(k.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf(
+			"%s,%s=%s",
+			kubernetesJobSelector(jobID),
+			KubernetesRoleLabel,
+			KubernetesRoleLauncher,
+		),
+	})
+	if err != nil {
+		log.Printf("CollectJobOutput failed : %s", err)
+		return err
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("launcher pod of job %d not found", jobID)
+	}
+
+	if err := k.collectLogs(ctx, &pods.Items[0], obj.GetAnnotations()[KubernetesOutputAnnotation]); err != nil {
+		log.Printf("failed to collect logs of job %d: %s", jobID, err)
+		return err
+	}
+	return nil
+}
+
+// collectLogs writes the logs of a pod to a file.
+func (k *Kubernetes) collectLogs(ctx context.Context, pod *corev1.Pod, path string) error {
+	stream, err := k.clientset.CoreV1().Pods(k.namespace).GetLogs(
+		pod.Name,
+		&corev1.PodLogOptions{},
+	).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, stream)
+	return err
+}
@@ -0,0 +1,460 @@
+//go:build unit
+
+package scheduler_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/stretchr/testify/suite"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	namespace = "benchmark"
+
+	jobManifest = `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: hpl-benchmark-%j
+spec:
+  template:
+    metadata:
+      labels:
+        benchmark-api/job-id: "%j"
+        benchmark-api/role: launcher
+    spec:
+      restartPolicy: Never
+      containers:
+        - name: benchmark
+          image: nvcr.io/nvidia/hpc-benchmarks:23.5
+`
+
+	mpiJobManifest = `apiVersion: kubeflow.org/v2beta1
+kind: MPIJob
+metadata:
+  name: hpl-benchmark-%j
+spec:
+  slotsPerWorker: 4
+  mpiReplicaSpecs:
+    Launcher:
+      replicas: 1
+    Worker:
+      replicas: 2
+`
+)
+
+var (
+	jobGVR = schema.GroupVersionResource{
+		Group:    "batch",
+		Version:  "v1",
+		Resource: "jobs",
+	}
+	mpiJobGVR = schema.GroupVersionResource{
+		Group:    "kubeflow.org",
+		Version:  "v2beta1",
+		Resource: "mpijobs",
+	}
+)
+
+type KubernetesTestSuite struct {
+	suite.Suite
+	clientset *fake.Clientset
+	dynamic   *dynamicfake.FakeDynamicClient
+	impl      *scheduler.Kubernetes
+}
+
+func (suite *KubernetesTestSuite) BeforeTest(suiteName, testName string) {
+	suite.clientset = fake.NewSimpleClientset(
+		kubernetesNode("cpu01", "64", "256Gi", ""),
+		kubernetesNode("gpu02", "128", "1Ti", "8"),
+		kubernetesNode("gpu01", "128", "512Gi", "4"),
+	)
+	suite.dynamic = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			jobGVR:    "JobList",
+			mpiJobGVR: "MPIJobList",
+		},
+	)
+	suite.impl = scheduler.NewKubernetes(suite.clientset, suite.dynamic, namespace)
+}
+
+func kubernetesNode(name, cpu, mem, gpu string) *corev1.Node {
+	allocatable := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse(mem),
+	}
+	if gpu != "" {
+		allocatable["nvidia.com/gpu"] = resource.MustParse(gpu)
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Allocatable: allocatable},
+	}
+}
+
+// finish marks a workload as finished and creates its pods.
+func (suite *KubernetesTestSuite) finish(
+	gvr schema.GroupVersionResource,
+	jobID int,
+	condition string,
+	reason string,
+	pods ...*corev1.Pod,
+) {
+	ctx := context.Background()
+	name := "hpl-benchmark-" + strconv.Itoa(jobID)
+	obj, err := suite.dynamic.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(unstructured.SetNestedField(obj.Object, map[string]interface{}{
+		"startTime":      "2023-07-01T10:00:00Z",
+		"completionTime": "2023-07-01T10:30:00Z",
+		"conditions": []interface{}{
+			map[string]interface{}{
+				"type":   condition,
+				"status": "True",
+				"reason": reason,
+			},
+		},
+	}, "status"))
+	_, err = suite.dynamic.Resource(gvr).Namespace(namespace).Update(ctx, obj, metav1.UpdateOptions{})
+	suite.Require().NoError(err)
+
+	for _, pod := range pods {
+		pod.Namespace = namespace
+		pod.Labels[scheduler.KubernetesJobIDLabel] = strconv.Itoa(jobID)
+		_, err := suite.clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+		suite.Require().NoError(err)
+	}
+}
+
+func (suite *KubernetesTestSuite) TestSubmit() {
+	// Arrange
+	ctx := context.Background()
+	req := &scheduler.SubmitRequest{
+		Name:   "HPL-Benchmark",
+		User:   user,
+		Body:   jobManifest,
+		Output: "/runs/1/job-%j.log",
+		Files:  map[string]string{"hpl.dat": "dat"},
+	}
+
+	// Act
+	out, err := suite.impl.Submit(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+	jobID, err := strconv.Atoi(out)
+	suite.Require().NoError(err)
+
+	job, err := suite.dynamic.Resource(jobGVR).Namespace(namespace).Get(
+		ctx,
+		"hpl-benchmark-"+out,
+		metav1.GetOptions{},
+	)
+	suite.Require().NoError(err)
+	suite.Equal(out, job.GetLabels()[scheduler.KubernetesJobIDLabel])
+	ttl, _, _ := unstructured.NestedInt64(job.Object, "spec", "ttlSecondsAfterFinished")
+	suite.Equal(int64(scheduler.KubernetesTTLAfterFinished/time.Second), ttl)
+
+	cm, err := suite.clientset.CoreV1().ConfigMaps(namespace).Get(
+		ctx,
+		"hpl-benchmark-"+out,
+		metav1.GetOptions{},
+	)
+	suite.Require().NoError(err)
+	suite.Equal(req.Files, cm.Data)
+	suite.Require().Len(cm.OwnerReferences, 1)
+	suite.Equal("Job", cm.OwnerReferences[0].Kind)
+	suite.Equal("hpl-benchmark-"+out, cm.OwnerReferences[0].Name)
+
+	output, err := suite.impl.FindJobOutputFile(ctx, jobID)
+	suite.NoError(err)
+	suite.Equal("/runs/1/job-"+out+".log", output)
+
+	running, err := suite.impl.FindRunningJobByID(ctx, &scheduler.FindRunningJobByIDRequest{
+		JobID: jobID,
+		User:  user,
+	})
+	suite.NoError(err)
+	suite.Equal(jobID, running)
+}
+
+func (suite *KubernetesTestSuite) TestSubmitMPIJobTTL() {
+	// Arrange
+	ctx := context.Background()
+	manifest := mpiJobManifest + "  runPolicy:\n    ttlSecondsAfterFinished: 600\n"
+
+	// Act
+	out, err := suite.impl.Submit(ctx, &scheduler.SubmitRequest{Body: manifest})
+
+	// Assert
+	suite.Require().NoError(err)
+	job, err := suite.dynamic.Resource(mpiJobGVR).Namespace(namespace).Get(
+		ctx,
+		"hpl-benchmark-"+out,
+		metav1.GetOptions{},
+	)
+	suite.Require().NoError(err)
+	// Numbers of YAML manifests are decoded as floats
+	ttl, _, _ := unstructured.NestedFieldNoCopy(job.Object, "spec", "runPolicy", "ttlSecondsAfterFinished")
+	suite.EqualValues(600, ttl)
+}
+
+func (suite *KubernetesTestSuite) TestSubmitDistinctIDs() {
+	// Arrange
+	ctx := context.Background()
+	ids := map[string]bool{}
+
+	for range 20 {
+		// Act
+		out, err := suite.impl.Submit(ctx, &scheduler.SubmitRequest{Body: jobManifest})
+
+		// Assert
+		suite.Require().NoError(err)
+		suite.False(ids[out], out)
+		ids[out] = true
+	}
+
+	// Another invocation draws its own IDs
+	other := scheduler.NewKubernetes(suite.clientset, suite.dynamic, namespace)
+	out, err := other.Submit(ctx, &scheduler.SubmitRequest{Body: jobManifest})
+	suite.Require().NoError(err)
+	suite.False(ids[out], out)
+}
+
+func (suite *KubernetesTestSuite) TestSubmitUnsupportedKind() {
+	// Act
+	_, err := suite.impl.Submit(context.Background(), &scheduler.SubmitRequest{
+		Body: "apiVersion: v1\nkind: Pod\nmetadata:\n  name: test\n",
+	})
+
+	// Assert
+	suite.Error(err)
+}
+
+func (suite *KubernetesTestSuite) TestFindJobStateCompleted() {
+	// Arrange
+	ctx := context.Background()
+	output := filepath.Join(suite.T().TempDir(), "job-%j.log")
+	out, err := suite.impl.Submit(ctx, &scheduler.SubmitRequest{
+		Body:   jobManifest,
+		Output: output,
+	})
+	suite.Require().NoError(err)
+	jobID, _ := strconv.Atoi(out)
+	suite.finish(jobGVR, jobID, "Complete", "", &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "hpl-benchmark-" + out + "-abcde",
+			Labels: map[string]string{
+				scheduler.KubernetesRoleLabel: scheduler.KubernetesRoleLauncher,
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: "gpu01"},
+	})
+
+	// Act
+	_, runningErr := suite.impl.FindRunningJobByID(ctx, &scheduler.FindRunningJobByIDRequest{
+		JobID: jobID,
+		User:  user,
+	})
+	state, err := suite.impl.FindJobState(ctx, jobID)
+	logsPath := filepath.Join(filepath.Dir(output), "job-"+out+".log")
+	_, statErr := os.Stat(logsPath)
+	collectErr := suite.impl.CollectJobOutput(ctx, jobID)
+
+	// Assert
+	suite.Error(runningErr)
+	suite.NoError(err)
+	suite.Equal(&scheduler.JobState{
+		State:    scheduler.JobStateCompleted,
+		ExitCode: "0",
+		Elapsed:  30 * time.Minute,
+		NodeList: "gpu01",
+	}, state)
+	// The logs are only collected on request
+	suite.ErrorIs(statErr, os.ErrNotExist)
+	suite.NoError(collectErr)
+	logs, err := os.ReadFile(logsPath)
+	suite.NoError(err)
+	suite.Equal("fake logs", string(logs))
+}
+
+func (suite *KubernetesTestSuite) TestFindJobStateOutOfMemory() {
+	// Arrange
+	ctx := context.Background()
+	out, err := suite.impl.Submit(ctx, &scheduler.SubmitRequest{
+		Body:   mpiJobManifest,
+		Output: filepath.Join(suite.T().TempDir(), "job-%j.log"),
+	})
+	suite.Require().NoError(err)
+	jobID, _ := strconv.Atoi(out)
+	suite.finish(mpiJobGVR, jobID, "Failed", "MPIJobFailed",
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "hpl-benchmark-" + out + "-launcher",
+				Labels: map[string]string{
+					scheduler.KubernetesRoleLabel: scheduler.KubernetesRoleLauncher,
+				},
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+					},
+				}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "hpl-benchmark-" + out + "-worker-0",
+				Labels: map[string]string{scheduler.KubernetesRoleLabel: "worker"},
+			},
+			Spec: corev1.PodSpec{NodeName: "gpu01"},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{
+							ExitCode: 137,
+							Reason:   "OOMKilled",
+						},
+					},
+				}},
+			},
+		},
+	)
+
+	// Act
+	state, err := suite.impl.FindJobState(ctx, jobID)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(scheduler.JobStateOutOfMemory, state.State)
+	suite.Equal("gpu01", state.NodeList)
+}
+
+func (suite *KubernetesTestSuite) TestCancel() {
+	// Arrange
+	ctx := context.Background()
+	out, err := suite.impl.Submit(ctx, &scheduler.SubmitRequest{Body: jobManifest})
+	suite.Require().NoError(err)
+	jobID, _ := strconv.Atoi(out)
+
+	// Act
+	err = suite.impl.CancelJob(ctx, &scheduler.CancelRequest{JobID: jobID, User: user})
+
+	// Assert
+	suite.NoError(err)
+	_, err = suite.impl.FindJobOutputFile(ctx, jobID)
+	suite.Error(err)
+	_, err = suite.clientset.CoreV1().ConfigMaps(namespace).Get(
+		ctx,
+		"hpl-benchmark-"+out,
+		metav1.GetOptions{},
+	)
+	suite.Error(err)
+}
+
+func (suite *KubernetesTestSuite) TestNodeInventory() {
+	// Arrange
+	ctx := context.Background()
+
+	// Act
+	mem, memErr := suite.impl.FindMemPerNode(ctx)
+	gpu, gpuErr := suite.impl.FindGPUPerNode(ctx)
+	cpu, cpuErr := suite.impl.FindCPUPerNode(ctx)
+
+	// Assert
+	suite.NoError(memErr)
+	suite.NoError(gpuErr)
+	suite.NoError(cpuErr)
+	suite.Equal(524288, mem)
+	suite.Equal(4, gpu)
+	suite.Equal(128, cpu)
+}
+
+func (suite *KubernetesTestSuite) TestNodeInventoryUnschedulable() {
+	// Arrange
+	ctx := context.Background()
+	cordoned := kubernetesNode("gpu03", "32", "128Gi", "2")
+	cordoned.Spec.Unschedulable = true
+	tainted := kubernetesNode("gpu04", "32", "128Gi", "2")
+	tainted.Spec.Taints = []corev1.Taint{
+		{Key: "node.kubernetes.io/not-ready", Effect: corev1.TaintEffectNoSchedule},
+	}
+	for _, node := range []*corev1.Node{cordoned, tainted} {
+		_, err := suite.clientset.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+		suite.Require().NoError(err)
+	}
+
+	// Act
+	mem, memErr := suite.impl.FindMemPerNode(ctx)
+	gpu, gpuErr := suite.impl.FindGPUPerNode(ctx)
+
+	// Assert
+	suite.NoError(memErr)
+	suite.NoError(gpuErr)
+	suite.Equal(524288, mem)
+	suite.Equal(4, gpu)
+}
+
+func (suite *KubernetesTestSuite) TestFindGPUMemory() {
+	// Arrange
+	ctx := context.Background()
+	for name, mem := range map[string]string{"gpu01": "81920", "gpu02": "40960"} {
+		node, err := suite.clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		suite.Require().NoError(err)
+		node.Labels = map[string]string{scheduler.KubernetesGPUMemoryLabel: mem}
+		_, err = suite.clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		suite.Require().NoError(err)
+	}
+
+	// Act
+	mem, err := suite.impl.FindGPUMemory(ctx)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(40960, mem)
+}
+
+func (suite *KubernetesTestSuite) TestFindGPUMemoryWithoutLabels() {
+	// Act
+	_, err := suite.impl.FindGPUMemory(context.Background())
+
+	// Assert
+	suite.Error(err)
+}
+
+func (suite *KubernetesTestSuite) TestFindGPUModel() {
+	// Arrange
+	ctx := context.Background()
+	node, err := suite.clientset.CoreV1().Nodes().Get(ctx, "gpu01", metav1.GetOptions{})
+	suite.Require().NoError(err)
+	node.Labels = map[string]string{scheduler.KubernetesGPUProductLabel: "NVIDIA-H100-80GB-HBM3"}
+	_, err = suite.clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	suite.Require().NoError(err)
+
+	// Act
+	model, err := suite.impl.FindGPUModel(ctx)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("NVIDIA-H100-80GB-HBM3", model)
+}
+
+func TestKubernetesTestSuite(t *testing.T) {
+	suite.Run(t, &KubernetesTestSuite{})
+}
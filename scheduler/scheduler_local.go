@@ -0,0 +1,340 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/utils"
+)
+
+// Local runs the benchmark directly on the current host, without scheduler.
+// Each job is a process group started in the background, writing its exit
+// code to a file when it finishes.
+type Local struct {
+	executor  Executor
+	adminUser string
+
+	mu     sync.Mutex
+	nextID int
+	jobs   map[int]*localJob
+}
+
+type localJob struct {
+	pid        int
+	output     string
+	exitFile   string
+	submitTime time.Time
+}
+
+func NewLocal(
+	executor Executor,
+	adminUser string,
+) *Local {
+	return &Local{
+		executor:  executor,
+		adminUser: adminUser,
+		// Job IDs must not collide with the files of previous invocations
+		nextID: int(time.Now().Unix() % 1_000_000_000),
+		jobs:   map[int]*localJob{},
+	}
+}
+
+func (s *Local) findJob(jobID int) (*localJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return nil, fmt.Errorf("job %d not found", jobID)
+	}
+	return job, nil
+}
+
+// CancelJob kills the process group of the job.
+func (s *Local) CancelJob(ctx context.Context, req *CancelRequest) error {
+	job, err := s.findJob(req.JobID)
+	if err != nil {
+		log.Printf("cancel failed: %s", err)
+		return err
+	}
+
+	cmd := fmt.Sprintf("kill -- -%d", job.pid)
+	_, err = s.executor.ExecAs(ctx, req.User, cmd)
+	if err != nil {
+		log.Printf("cancel failed: %s", err)
+	}
+	return err
+}
+
+// Submit writes the job script next to its output and starts it in the
+// background.
+func (s *Local) Submit(ctx context.Context, req *SubmitRequest) (string, error) {
+	s.mu.Lock()
+	s.nextID++
+	jobID := s.nextID
+	s.mu.Unlock()
+
+	output := req.Output
+	if output == "" {
+		output = JobOutput
+	}
+	output = strings.ReplaceAll(output, "%j", strconv.Itoa(jobID))
+	dir := filepath.Dir(output)
+	script := filepath.Join(dir, fmt.Sprintf("job-%d.sh", jobID))
+	exitFile := filepath.Join(dir, fmt.Sprintf("job-%d.exitcode", jobID))
+	eof := utils.GenerateRandomString(10)
+
+	cmd := fmt.Sprintf(`cat > %s << '%s'
+%s
+%s
+setsid sh -c 'sh %s; echo $? $(date +%%s) > %s' > %s 2>&1 < /dev/null &
+echo $!`,
+		script,
+		eof,
+		req.Body,
+		eof,
+		script,
+		exitFile,
+		output,
+	)
+	submitTime := time.Now()
+	out, err := s.executor.ExecAs(ctx, req.User, cmd)
+	if err != nil {
+		log.Printf("submit failed: %s", err)
+		return strings.TrimSpace(out), err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		log.Printf("Failed to parse PID: %s", err)
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.jobs[jobID] = &localJob{
+		pid:        pid,
+		output:     output,
+		exitFile:   exitFile,
+		submitTime: submitTime,
+	}
+	s.mu.Unlock()
+
+	return strconv.Itoa(jobID), nil
+}
+
+// HealthCheck checks that mpirun is available
+func (s *Local) HealthCheck(ctx context.Context) error {
+	_, err := s.executor.ExecAs(ctx, s.adminUser, "command -v mpirun")
+	if err != nil {
+		log.Printf("healthcheck failed: %s", err)
+	}
+	return err
+}
+
+// FindRunningJobByID returns the job ID while its process group is alive and
+// it has not written its exit code.
+func (s *Local) FindRunningJobByID(
+	ctx context.Context,
+	req *FindRunningJobByIDRequest,
+) (int, error) {
+	job, err := s.findJob(req.JobID)
+	if err != nil {
+		log.Printf("FindRunningJobByID failed: %s", err)
+		return 0, err
+	}
+
+	cmd := fmt.Sprintf("test ! -e %s && kill -0 -- -%d", job.exitFile, job.pid)
+	if _, err := s.executor.ExecAs(ctx, req.User, cmd); err != nil {
+		return 0, ErrJobNotRunning
+	}
+
+	return req.JobID, nil
+}
+
+// FindMemPerNode returns the memory of the host in MB.
+func (s *Local) FindMemPerNode(ctx context.Context) (int, error) {
+	out, err := s.executor.ExecAs(ctx, s.adminUser, "grep MemTotal /proc/meminfo")
+	if err != nil {
+		log.Printf("FindMemPerNode failed: %s", err)
+		return 0, err
+	}
+
+	// MemTotal:       527958908 kB
+	fields := strings.Fields(out)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected meminfo: %s", out)
+	}
+	mem, err := strconv.Atoi(fields[1])
+	if err != nil {
+		log.Printf("failed to convert %s to integer: %s", fields[1], err)
+		return 0, err
+	}
+
+	return mem / 1024, nil
+}
+
+func (s *Local) FindGPUPerNode(ctx context.Context) (int, error) {
+	cmd := "nvidia-smi --query-gpu=index --format=csv,noheader"
+	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+	if err != nil {
+		log.Printf("FindGPUPerNode failed: %s", err)
+		return 0, err
+	}
+
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return 0, nil
+	}
+	return len(strings.Split(out, "\n")), nil
+}
+
+func (s *Local) FindCPUPerNode(ctx context.Context) (int, error) {
+	out, err := s.executor.ExecAs(ctx, s.adminUser, "nproc")
+	if err != nil {
+		log.Printf("FindCPUPerNode failed : %s", err)
+		return 0, err
+	}
+
+	cpu, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		log.Printf("Failed to convert %s to integer: %s", out, err)
+		return 0, err
+	}
+
+	return cpu, nil
+}
+
+// FindNodeResources returns the resources of the host.
+func (s *Local) FindNodeResources(ctx context.Context) ([]NodeResources, error) {
+	mem, err := s.FindMemPerNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gpu, err := s.FindGPUPerNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cpu, err := s.FindCPUPerNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	return []NodeResources{{
+		Name:   hostname,
+		Memory: mem,
+		GPUs:   gpu,
+		CPUs:   cpu,
+	}}, nil
+}
+
+// FindGPUMemory returns the smallest GPU memory in MB using nvidia-smi.
+func (s *Local) FindGPUMemory(ctx context.Context) (int, error) {
+	out, err := s.executor.ExecAs(ctx, s.adminUser, NvidiaSMIGPUMemoryCommand)
+	if err != nil {
+		log.Printf("FindGPUMemory failed : %s", err)
+		return 0, err
+	}
+
+	mem, err := parseNvidiaSMIGPUMemory(out)
+	if err != nil {
+		log.Printf("Failed to parse GPU memory: %s", err)
+		return 0, err
+	}
+
+	return mem, nil
+}
+
+// FindGPUModel returns the model name of the GPUs using nvidia-smi.
+func (s *Local) FindGPUModel(ctx context.Context) (string, error) {
+	out, err := s.executor.ExecAs(ctx, s.adminUser, NvidiaSMIGPUNameCommand)
+	if err != nil {
+		log.Printf("FindGPUModel failed : %s", err)
+		return "", err
+	}
+
+	name, err := parseNvidiaSMIGPUName(out)
+	if err != nil {
+		log.Printf("Failed to parse GPU model: %s", err)
+		return "", err
+	}
+
+	return name, nil
+}
+
+func (s *Local) FindCPUAffinity(ctx context.Context) (string, error) {
+	cmd := NvidiaSMITopologyCommand + NvidiaSMITopologyFilter
+	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+	if err != nil {
+		log.Printf("FindCPUAffinity failed : %s", err)
+		return "", err
+	}
+
+	return out, nil
+}
+
+func (s *Local) FindJobOutputFile(ctx context.Context, jobID int) (string, error) {
+	job, err := s.findJob(jobID)
+	if err != nil {
+		log.Printf("FindJobOutputFile failed : %s", err)
+		return "", err
+	}
+
+	return job.output, nil
+}
+
+// FindJobState reads the exit code written by the job when it finished.
+func (s *Local) FindJobState(ctx context.Context, jobID int) (*JobState, error) {
+	job, err := s.findJob(jobID)
+	if err != nil {
+		log.Printf("FindJobState failed : %s", err)
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	state := &JobState{
+		NodeList: hostname,
+	}
+
+	// A job killed with its process group does not write its exit code
+	out, err := s.executor.ExecAs(ctx, s.adminUser, "cat "+job.exitFile)
+	if err != nil {
+		state.State = JobStateCancelled
+		return state, nil
+	}
+
+	// <exit code> <end time>
+	fields := strings.Fields(out)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("unexpected exit file content: %s", out)
+	}
+	exitCode, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	endTime, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	state.ExitCode = fields[0]
+	state.Elapsed = max(0, time.Unix(endTime, 0).Sub(job.submitTime).Round(time.Second))
+	switch {
+	case exitCode == 0:
+		state.State = JobStateCompleted
+	case exitCode > 128:
+		// Killed by signal exitCode-128
+		state.State = JobStateCancelled
+	default:
+		state.State = JobStateFailed
+	}
+
+	return state, nil
+}
@@ -0,0 +1,231 @@
+//go:build unit
+
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/mocks"
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type LocalTestSuite struct {
+	suite.Suite
+	executor *mocks.Executor
+	impl     *scheduler.Local
+}
+
+func (suite *LocalTestSuite) BeforeTest(suiteName, testName string) {
+	suite.executor = mocks.NewExecutor(suite.T())
+	suite.impl = scheduler.NewLocal(
+		suite.executor,
+		admin,
+	)
+}
+
+// submit starts a fake job with the PID 4242.
+func (suite *LocalTestSuite) submit() int {
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		user,
+		mock.MatchedBy(func(cmd string) bool {
+			return strings.Contains(cmd, "setsid")
+		}),
+	).Return("4242\n", nil).Once()
+
+	out, err := suite.impl.Submit(context.Background(), &scheduler.SubmitRequest{
+		Name:   "name",
+		User:   user,
+		Body:   "#!/bin/sh\n\nmpirun sleep infinity\n",
+		Output: "/runs/1/job-%j.log",
+	})
+	suite.Require().NoError(err)
+
+	jobID, err := strconv.Atoi(out)
+	suite.Require().NoError(err)
+	return jobID
+}
+
+func (suite *LocalTestSuite) TestSubmit() {
+	// Arrange
+	body := "#!/bin/sh\n\nmpirun sleep infinity\n"
+	var cmd string
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		user,
+		mock.Anything,
+	).Run(func(args mock.Arguments) {
+		cmd = args.String(2)
+	}).Return("4242\n", nil)
+	ctx := context.Background()
+
+	// Act
+	out, err := suite.impl.Submit(ctx, &scheduler.SubmitRequest{
+		Name:   "name",
+		User:   user,
+		Body:   body,
+		Output: "/runs/1/job-%j.log",
+	})
+
+	// Assert
+	suite.NoError(err)
+	jobID, err := strconv.Atoi(out)
+	suite.Require().NoError(err)
+	suite.Contains(cmd, body)
+	suite.Contains(cmd, fmt.Sprintf("> /runs/1/job-%d.log 2>&1", jobID))
+	suite.Contains(cmd, fmt.Sprintf("/runs/1/job-%d.exitcode", jobID))
+
+	output, err := suite.impl.FindJobOutputFile(ctx, jobID)
+	suite.NoError(err)
+	suite.Equal(fmt.Sprintf("/runs/1/job-%d.log", jobID), output)
+}
+
+func (suite *LocalTestSuite) TestCancel() {
+	// Arrange
+	jobID := suite.submit()
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		user,
+		"kill -- -4242",
+	).Return("", nil)
+
+	// Act
+	err := suite.impl.CancelJob(context.Background(), &scheduler.CancelRequest{
+		JobID: jobID,
+		User:  user,
+	})
+
+	// Assert
+	suite.NoError(err)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *LocalTestSuite) TestFindRunningJobByID() {
+	// Arrange
+	jobID := suite.submit()
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		user,
+		mock.MatchedBy(func(cmd string) bool {
+			return strings.Contains(cmd, "kill -0 -- -4242")
+		}),
+	).Return("", nil).Once()
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		user,
+		mock.MatchedBy(func(cmd string) bool {
+			return strings.Contains(cmd, "kill -0 -- -4242")
+		}),
+	).Return("", errors.New("exit status 1")).Once()
+	ctx := context.Background()
+	req := &scheduler.FindRunningJobByIDRequest{JobID: jobID, User: user}
+
+	// Act
+	running, runningErr := suite.impl.FindRunningJobByID(ctx, req)
+	_, finishedErr := suite.impl.FindRunningJobByID(ctx, req)
+
+	// Assert
+	suite.NoError(runningErr)
+	suite.Equal(jobID, running)
+	suite.Error(finishedErr)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *LocalTestSuite) TestFindJobState() {
+	tests := []struct {
+		name     string
+		out      string
+		err      error
+		expected string
+	}{
+		{
+			name:     "Completed",
+			out:      fmt.Sprintf("0 %d\n", time.Now().Unix()),
+			expected: scheduler.JobStateCompleted,
+		},
+		{
+			name:     "Failed",
+			out:      fmt.Sprintf("1 %d\n", time.Now().Unix()),
+			expected: scheduler.JobStateFailed,
+		},
+		{
+			name:     "Killed",
+			err:      errors.New("cat: No such file or directory"),
+			expected: scheduler.JobStateCancelled,
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			suite.BeforeTest("", "")
+			jobID := suite.submit()
+			suite.executor.On(
+				"ExecAs",
+				mock.Anything,
+				admin,
+				fmt.Sprintf("cat /runs/1/job-%d.exitcode", jobID),
+			).Return(tt.out, tt.err)
+
+			// Act
+			state, err := suite.impl.FindJobState(context.Background(), jobID)
+
+			// Assert
+			suite.NoError(err)
+			suite.Equal(tt.expected, state.State)
+		})
+	}
+}
+
+func (suite *LocalTestSuite) TestNodeInventory() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"grep MemTotal /proc/meminfo",
+	).Return("MemTotal:       527958908 kB\n", nil)
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"nvidia-smi --query-gpu=index --format=csv,noheader",
+	).Return("0\n1\n2\n3\n", nil)
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"nproc",
+	).Return("64\n", nil)
+	ctx := context.Background()
+
+	// Act
+	mem, memErr := suite.impl.FindMemPerNode(ctx)
+	gpu, gpuErr := suite.impl.FindGPUPerNode(ctx)
+	cpu, cpuErr := suite.impl.FindCPUPerNode(ctx)
+
+	// Assert
+	suite.NoError(memErr)
+	suite.NoError(gpuErr)
+	suite.NoError(cpuErr)
+	suite.Equal(515584, mem)
+	suite.Equal(4, gpu)
+	suite.Equal(64, cpu)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func TestLocalTestSuite(t *testing.T) {
+	suite.Run(t, &LocalTestSuite{})
+}
@@ -0,0 +1,472 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/squarefactory/benchmark-api/utils"
+)
+
+// PBS talks to a PBS Pro / OpenPBS server using the qsub, qstat, qdel and
+// pbsnodes commands.
+type PBS struct {
+	executor  Executor
+	adminUser string
+}
+
+func NewPBS(
+	executor Executor,
+	adminUser string,
+) *PBS {
+	return &PBS{
+		executor:  executor,
+		adminUser: adminUser,
+	}
+}
+
+type pbsQstatOutput struct {
+	Jobs map[string]pbsJob `json:"Jobs"`
+}
+
+type pbsJob struct {
+	JobName       string            `json:"Job_Name"`
+	JobState      string            `json:"job_state"`
+	OutputPath    string            `json:"Output_Path"`
+	ExitStatus    *int              `json:"Exit_status"`
+	ExecHost      string            `json:"exec_host"`
+	ResourcesUsed map[string]string `json:"resources_used"`
+}
+
+type pbsNodesOutput struct {
+	Nodes map[string]pbsNode `json:"nodes"`
+}
+
+type pbsNode struct {
+	// State is a comma separated list, e.g. free or down,offline
+	State              string `json:"state"`
+	ResourcesAvailable struct {
+		Mem   string `json:"mem"`
+		NCPUs int    `json:"ncpus"`
+		NGPUs int    `json:"ngpus"`
+	} `json:"resources_available"`
+}
+
+// unavailablePBSStates are the node states on which no job can start.
+var unavailablePBSStates = []string{
+	"down",
+	"offline",
+	"maintenance",
+	"stale",
+	"state-unknown",
+	"unresolvable",
+}
+
+// schedulable returns whether jobs can start on the node.
+func (n *pbsNode) schedulable() bool {
+	for _, state := range strings.Split(n.State, ",") {
+		if slices.Contains(unavailablePBSStates, strings.TrimSpace(state)) {
+			return false
+		}
+	}
+	return true
+}
+
+// pbsJobID strips the server name from a PBS job identifier, e.g. 123.server.
+func pbsJobID(id string) string {
+	return strings.SplitN(strings.TrimSpace(id), ".", 2)[0]
+}
+
+// CancelJob kills a job using qdel command.
+func (s *PBS) CancelJob(ctx context.Context, req *CancelRequest) error {
+	cmd := fmt.Sprintf("qdel %d", req.JobID)
+	_, err := s.executor.ExecAs(ctx, req.User, cmd)
+	if err != nil {
+		log.Printf("cancel failed: %s", err)
+	}
+	return err
+}
+
+// Submit a job script to the PBS server using the qsub command.
+func (s *PBS) Submit(ctx context.Context, req *SubmitRequest) (string, error) {
+	eof := utils.GenerateRandomString(10)
+
+	// PBS does not expand %j, give it the directory so it names the file
+	// itself.
+	output := req.Output
+	if output == "" {
+		output = JobOutput
+	}
+	if strings.Contains(output, "%j") {
+		output = filepath.Dir(output) + "/"
+	}
+
+	cmd := fmt.Sprintf(`qsub \
+  -N %s \
+  -o %s \
+  -j oe << '%s'
+%s
+%s`,
+		req.Name,
+		output,
+		eof,
+		req.Body,
+		eof,
+	)
+	out, err := s.executor.ExecAs(ctx, req.User, cmd)
+	if err != nil {
+		log.Printf("submit failed: %s", err)
+		return strings.TrimSpace(out), err
+	}
+
+	return pbsJobID(out), nil
+}
+
+// HealthCheck runs qstat to check if the server is running
+func (s *PBS) HealthCheck(ctx context.Context) error {
+	_, err := s.executor.ExecAs(ctx, s.adminUser, "qstat -B")
+	if err != nil {
+		log.Printf("healthcheck failed: %s", err)
+	}
+	return err
+}
+
+// errPBSUnknownJob is returned when qstat does not know the job.
+var errPBSUnknownJob = errors.New("unknown job")
+
+// findJob runs qstat on a job, including finished jobs if history is set.
+func (s *PBS) findJob(
+	ctx context.Context,
+	user string,
+	jobID int,
+	history bool,
+) (*pbsJob, error) {
+	flags := "-f -F json"
+	if history {
+		flags = "-x " + flags
+	}
+	cmd := fmt.Sprintf("qstat %s %d", flags, jobID)
+	out, err := s.executor.ExecAs(ctx, user, cmd)
+	if err != nil && strings.Contains(out, "Unknown Job Id") {
+		return nil, fmt.Errorf("job %d: %w", jobID, errPBSUnknownJob)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var qstat pbsQstatOutput
+	if err := json.Unmarshal([]byte(out), &qstat); err != nil {
+		return nil, err
+	}
+
+	for id, job := range qstat.Jobs {
+		if pbsJobID(id) == strconv.Itoa(jobID) {
+			return &job, nil
+		}
+	}
+	return nil, fmt.Errorf("job %d: %w", jobID, errPBSUnknownJob)
+}
+
+// FindRunningJobByID find a queued or running job using qstat.
+func (s *PBS) FindRunningJobByID(
+	ctx context.Context,
+	req *FindRunningJobByIDRequest,
+) (int, error) {
+	job, err := s.findJob(ctx, req.User, req.JobID, false)
+	if errors.Is(err, errPBSUnknownJob) {
+		return 0, ErrJobNotRunning
+	}
+	if err != nil {
+		log.Printf("FindRunningJobByID failed: %s", err)
+		return 0, err
+	}
+
+	if job.JobState == "F" {
+		return 0, ErrJobNotRunning
+	}
+	return req.JobID, nil
+}
+
+// FindNodeResources returns the resources of the schedulable nodes with GPUs,
+// or of all of them if none has GPUs, sorted by name. Down and offline nodes
+// are left out.
+func (s *PBS) FindNodeResources(ctx context.Context) ([]NodeResources, error) {
+	out, err := s.executor.ExecAs(ctx, s.adminUser, "pbsnodes -a -F json")
+	if err != nil {
+		log.Printf("FindNodeResources failed: %s", err)
+		return nil, err
+	}
+
+	var nodes pbsNodesOutput
+	if err := json.Unmarshal([]byte(out), &nodes); err != nil {
+		return nil, err
+	}
+
+	if len(nodes.Nodes) == 0 {
+		return nil, errors.New("no nodes found")
+	}
+
+	resources := make([]NodeResources, 0, len(nodes.Nodes))
+	for name, node := range nodes.Nodes {
+		if !node.schedulable() {
+			continue
+		}
+		mem, err := parsePBSSize(node.ResourcesAvailable.Mem)
+		if err != nil {
+			log.Printf("failed to parse memory %s: %s", node.ResourcesAvailable.Mem, err)
+			return nil, err
+		}
+		resources = append(resources, NodeResources{
+			Name:   name,
+			Memory: int(mem / (1 << 20)),
+			GPUs:   node.ResourcesAvailable.NGPUs,
+			CPUs:   node.ResourcesAvailable.NCPUs,
+		})
+	}
+
+	if len(resources) == 0 {
+		return nil, errors.New("no schedulable node found")
+	}
+
+	// Map iteration order is random
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Name < resources[j].Name
+	})
+	return gpuNodes(resources), nil
+}
+
+// FindMemPerNode returns the smallest memory of the nodes in MB.
+func (s *PBS) FindMemPerNode(ctx context.Context) (int, error) {
+	nodes, err := s.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("FindMemPerNode failed: %s", err)
+		return 0, err
+	}
+
+	return MinNodeResources(nodes).Memory, nil
+}
+
+func (s *PBS) FindGPUPerNode(ctx context.Context) (int, error) {
+	nodes, err := s.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("FindGPUPerNode failed: %s", err)
+		return 0, err
+	}
+
+	return MinNodeResources(nodes).GPUs, nil
+}
+
+func (s *PBS) FindCPUPerNode(ctx context.Context) (int, error) {
+	nodes, err := s.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("FindCPUPerNode failed : %s", err)
+		return 0, err
+	}
+
+	return MinNodeResources(nodes).CPUs, nil
+}
+
+// onNode returns cmd run by a blocking job on the first GPU node, with all its
+// GPUs, as the submit host may have other GPUs, or none. The job output is
+// delivered to a temporary file of the submit host, then printed.
+func (s *PBS) onNode(ctx context.Context, cmd string) (string, error) {
+	nodes, err := s.FindNodeResources(ctx)
+	if err != nil {
+		return "", err
+	}
+	node := nodes[0]
+
+	resources := "select=1:host=" + node.Name
+	if node.GPUs > 0 {
+		resources += fmt.Sprintf(":ngpus=%d", node.GPUs)
+	}
+	log.Printf("querying the GPUs of %s", node.Name)
+	return fmt.Sprintf(
+		`out=$(mktemp) && trap 'rm -f "$out"' EXIT && `+
+			`qsub -W block=true -N %s -j oe -o "$out" -l %s -l walltime=00:05:00 -- /bin/sh -c '%s' > /dev/null && `+
+			`cat "$out"`,
+		JobName,
+		resources,
+		cmd,
+	), nil
+}
+
+// FindGPUMemory returns the smallest GPU memory in MB of a GPU node using
+// nvidia-smi.
+func (s *PBS) FindGPUMemory(ctx context.Context) (int, error) {
+	cmd, err := s.onNode(ctx, NvidiaSMIGPUMemoryCommand)
+	if err != nil {
+		log.Printf("FindGPUMemory failed : %s", err)
+		return 0, err
+	}
+	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+	if err != nil {
+		log.Printf("FindGPUMemory failed : %s", err)
+		return 0, err
+	}
+
+	mem, err := parseNvidiaSMIGPUMemory(out)
+	if err != nil {
+		log.Printf("Failed to parse GPU memory: %s", err)
+		return 0, err
+	}
+
+	return mem, nil
+}
+
+// FindGPUModel returns the model name of the GPUs of a GPU node using
+// nvidia-smi.
+func (s *PBS) FindGPUModel(ctx context.Context) (string, error) {
+	cmd, err := s.onNode(ctx, NvidiaSMIGPUNameCommand)
+	if err != nil {
+		log.Printf("FindGPUModel failed : %s", err)
+		return "", err
+	}
+	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+	if err != nil {
+		log.Printf("FindGPUModel failed : %s", err)
+		return "", err
+	}
+
+	name, err := parseNvidiaSMIGPUName(out)
+	if err != nil {
+		log.Printf("Failed to parse GPU model: %s", err)
+		return "", err
+	}
+
+	return name, nil
+}
+
+// FindCPUAffinity returns the CPU affinity of each GPU of a GPU node using
+// nvidia-smi.
+func (s *PBS) FindCPUAffinity(ctx context.Context) (string, error) {
+	cmd, err := s.onNode(ctx, NvidiaSMITopologyCommand)
+	if err != nil {
+		log.Printf("FindCPUAffinity failed : %s", err)
+		return "", err
+	}
+	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd+NvidiaSMITopologyFilter)
+	if err != nil {
+		log.Printf("FindCPUAffinity failed : %s", err)
+		return "", err
+	}
+
+	return out, nil
+}
+
+func (s *PBS) FindJobOutputFile(ctx context.Context, jobID int) (string, error) {
+	job, err := s.findJob(ctx, s.adminUser, jobID, true)
+	if err != nil {
+		log.Printf("FindJobOutputFile failed : %s", err)
+		return "", err
+	}
+
+	// Output_Path is formatted as host:path
+	path := job.OutputPath
+	if _, p, ok := strings.Cut(path, ":"); ok {
+		path = p
+	}
+	if strings.HasSuffix(path, "/") {
+		path = fmt.Sprintf("%s%s.o%d", path, job.JobName, jobID)
+	}
+
+	return path, nil
+}
+
+// FindJobState fetches the final state of a job using qstat -x.
+func (s *PBS) FindJobState(ctx context.Context, jobID int) (*JobState, error) {
+	job, err := s.findJob(ctx, s.adminUser, jobID, true)
+	if err != nil {
+		log.Printf("FindJobState failed : %s", err)
+		return nil, err
+	}
+
+	if job.JobState != "F" {
+		return nil, fmt.Errorf("job %d is not finished", jobID)
+	}
+
+	state := &JobState{
+		State:    JobStateCancelled,
+		NodeList: pbsNodeList(job.ExecHost),
+		MaxRSS:   job.ResourcesUsed["mem"],
+	}
+
+	if walltime, ok := job.ResourcesUsed["walltime"]; ok {
+		elapsed, err := parseSlurmDuration(walltime)
+		if err != nil {
+			log.Printf("Failed to parse walltime %s: %s", walltime, err)
+			return nil, err
+		}
+		state.Elapsed = elapsed
+	}
+
+	// Jobs deleted before running have no exit status
+	if job.ExitStatus == nil {
+		return state, nil
+	}
+
+	exitStatus := *job.ExitStatus
+	state.ExitCode = strconv.Itoa(exitStatus)
+	switch {
+	case exitStatus == 0:
+		state.State = JobStateCompleted
+	case exitStatus >= 256:
+		// Killed by signal exitStatus-256
+		state.State = JobStateCancelled
+	case exitStatus == -3 || exitStatus == -11 || exitStatus == -14 || exitStatus == -20:
+		// JOB_EXEC_RETRY, JOB_EXEC_RERUN, JOB_EXEC_RERUN_ON_SIS_FAIL and
+		// JOB_EXEC_RERUN_MS_FAIL: the job could not run on its nodes
+		state.State = JobStateNodeFail
+	default:
+		state.State = JobStateFailed
+	}
+
+	return state, nil
+}
+
+// pbsNodeList turns an exec_host such as node1/0*64+node2/0*64 into a
+// comma-separated list of nodes.
+func pbsNodeList(execHost string) string {
+	var nodes []string
+	seen := map[string]bool{}
+	for _, chunk := range strings.Split(execHost, "+") {
+		node := strings.SplitN(chunk, "/", 2)[0]
+		if node != "" && !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return strings.Join(nodes, ",")
+}
+
+// parsePBSSize parses sizes such as 527958908kb into bytes.
+func parsePBSSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{
+		"kb": 1 << 10,
+		"mb": 1 << 20,
+		"gb": 1 << 30,
+		"tb": 1 << 40,
+	} {
+		if strings.HasSuffix(s, suffix) {
+			multiplier = m
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+	s = strings.TrimSuffix(s, "b")
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return v * multiplier, nil
+}
@@ -0,0 +1,365 @@
+//go:build unit
+
+package scheduler_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/mocks"
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+const pbsnodes = `{
+    "timestamp":1690000000,
+    "pbs_version":"2022.1.3",
+    "pbs_server":"pbs-server",
+    "nodes":{
+        "gpu01":{
+            "Mom":"gpu01",
+            "state":"free",
+            "resources_available":{
+                "host":"gpu01",
+                "mem":"527958908kb",
+                "ncpus":64,
+                "ngpus":4,
+                "vnode":"gpu01"
+            }
+        },
+        "gpu02":{
+            "Mom":"gpu02",
+            "state":"free",
+            "resources_available":{
+                "host":"gpu02",
+                "mem":"527958908kb",
+                "ncpus":64,
+                "ngpus":4,
+                "vnode":"gpu02"
+            }
+        }
+    }
+}`
+
+type PBSTestSuite struct {
+	suite.Suite
+	executor *mocks.Executor
+	impl     *scheduler.PBS
+}
+
+func (suite *PBSTestSuite) BeforeTest(suiteName, testName string) {
+	suite.executor = mocks.NewExecutor(suite.T())
+	suite.impl = scheduler.NewPBS(
+		suite.executor,
+		admin,
+	)
+}
+
+func (suite *PBSTestSuite) TestCancel() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		user,
+		"qdel 123",
+	).Return("", nil)
+	ctx := context.Background()
+
+	// Act
+	err := suite.impl.CancelJob(ctx, &scheduler.CancelRequest{
+		JobID: 123,
+		User:  user,
+	})
+
+	// Assert
+	suite.NoError(err)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *PBSTestSuite) TestSubmit() {
+	// Arrange
+	req := &scheduler.SubmitRequest{
+		Name: "name",
+		User: user,
+		Body: `#!/bin/sh
+
+mpirun sleep infinity
+`,
+		Output: "/runs/1/job-%j.log",
+	}
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		user,
+		mock.MatchedBy(func(cmd string) bool {
+			return strings.Contains(cmd, "qsub") &&
+				strings.Contains(cmd, "-N name") &&
+				strings.Contains(cmd, "-o /runs/1/ ") &&
+				strings.Contains(cmd, req.Body)
+		}),
+	).Return("123.pbs-server\n", nil)
+	ctx := context.Background()
+
+	// Act
+	jobID, err := suite.impl.Submit(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("123", jobID)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *PBSTestSuite) TestFindRunningJobByID() {
+	tests := []struct {
+		name    string
+		out     string
+		wantErr bool
+	}{
+		{
+			name: "Running",
+			out:  `{"Jobs":{"123.pbs-server":{"Job_Name":"name","job_state":"R"}}}`,
+		},
+		{
+			name: "Queued",
+			out:  `{"Jobs":{"123.pbs-server":{"Job_Name":"name","job_state":"Q"}}}`,
+		},
+		{
+			name:    "Finished",
+			out:     `{"Jobs":{"123.pbs-server":{"Job_Name":"name","job_state":"F"}}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			executor := mocks.NewExecutor(suite.T())
+			executor.On(
+				"ExecAs",
+				mock.Anything,
+				user,
+				"qstat -f -F json 123",
+			).Return(tt.out, nil)
+			impl := scheduler.NewPBS(executor, admin)
+
+			// Act
+			jobID, err := impl.FindRunningJobByID(
+				context.Background(),
+				&scheduler.FindRunningJobByIDRequest{JobID: 123, User: user},
+			)
+
+			// Assert
+			if tt.wantErr {
+				suite.ErrorIs(err, scheduler.ErrJobNotRunning)
+			} else {
+				suite.NoError(err)
+				suite.Equal(123, jobID)
+			}
+		})
+	}
+}
+
+func (suite *PBSTestSuite) TestFindJobOutputFile() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"qstat -x -f -F json 123",
+	).Return(`{"Jobs":{"123.pbs-server":{
+		"Job_Name":"HPL-Benchmark",
+		"job_state":"Q",
+		"Output_Path":"login01:/runs/1/"
+	}}}`, nil)
+	ctx := context.Background()
+
+	// Act
+	out, err := suite.impl.FindJobOutputFile(ctx, 123)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("/runs/1/HPL-Benchmark.o123", out)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *PBSTestSuite) TestFindJobState() {
+	tests := []struct {
+		name     string
+		out      string
+		expected *scheduler.JobState
+	}{
+		{
+			name: "Completed",
+			out: `{"Jobs":{"123.pbs-server":{
+				"job_state":"F",
+				"Exit_status":0,
+				"exec_host":"gpu01/0*64+gpu02/0*64",
+				"resources_used":{"mem":"1048576kb","walltime":"01:02:03"}
+			}}}`,
+			expected: &scheduler.JobState{
+				State:    scheduler.JobStateCompleted,
+				ExitCode: "0",
+				Elapsed:  time.Hour + 2*time.Minute + 3*time.Second,
+				NodeList: "gpu01,gpu02",
+				MaxRSS:   "1048576kb",
+			},
+		},
+		{
+			name: "Killed",
+			out: `{"Jobs":{"123.pbs-server":{
+				"job_state":"F",
+				"Exit_status":271,
+				"exec_host":"gpu01/0*64",
+				"resources_used":{"walltime":"00:10:00"}
+			}}}`,
+			expected: &scheduler.JobState{
+				State:    scheduler.JobStateCancelled,
+				ExitCode: "271",
+				Elapsed:  10 * time.Minute,
+				NodeList: "gpu01",
+			},
+		},
+		{
+			name: "Rerun",
+			out: `{"Jobs":{"123.pbs-server":{
+				"job_state":"F",
+				"Exit_status":-11,
+				"exec_host":"gpu01/0*64"
+			}}}`,
+			expected: &scheduler.JobState{
+				State:    scheduler.JobStateNodeFail,
+				ExitCode: "-11",
+				NodeList: "gpu01",
+			},
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			executor := mocks.NewExecutor(suite.T())
+			executor.On(
+				"ExecAs",
+				mock.Anything,
+				admin,
+				"qstat -x -f -F json 123",
+			).Return(tt.out, nil)
+			impl := scheduler.NewPBS(executor, admin)
+
+			// Act
+			out, err := impl.FindJobState(context.Background(), 123)
+
+			// Assert
+			suite.NoError(err)
+			suite.Equal(tt.expected, out)
+		})
+	}
+}
+
+func (suite *PBSTestSuite) TestNodeInventory() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"pbsnodes -a -F json",
+	).Return(pbsnodes, nil)
+	ctx := context.Background()
+
+	// Act
+	mem, memErr := suite.impl.FindMemPerNode(ctx)
+	gpu, gpuErr := suite.impl.FindGPUPerNode(ctx)
+	cpu, cpuErr := suite.impl.FindCPUPerNode(ctx)
+
+	// Assert
+	suite.NoError(memErr)
+	suite.NoError(gpuErr)
+	suite.NoError(cpuErr)
+	suite.Equal(515584, mem)
+	suite.Equal(4, gpu)
+	suite.Equal(64, cpu)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *PBSTestSuite) TestNodeInventoryUnschedulable() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"pbsnodes -a -F json",
+	).Return(`{"nodes":{
+		"gpu01":{"state":"free","resources_available":{"mem":"527958908kb","ncpus":64,"ngpus":4}},
+		"gpu02":{"state":"down,offline","resources_available":{"mem":"263979454kb","ncpus":32,"ngpus":2}}
+	}}`, nil)
+	ctx := context.Background()
+
+	// Act
+	mem, memErr := suite.impl.FindMemPerNode(ctx)
+	gpu, gpuErr := suite.impl.FindGPUPerNode(ctx)
+
+	// Assert
+	suite.NoError(memErr)
+	suite.NoError(gpuErr)
+	suite.Equal(515584, mem)
+	suite.Equal(4, gpu)
+}
+
+func (suite *PBSTestSuite) TestFindGPUMemory() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"pbsnodes -a -F json",
+	).Return(pbsnodes, nil)
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		mock.MatchedBy(func(cmd string) bool {
+			return strings.Contains(cmd, "qsub -W block=true") &&
+				strings.Contains(cmd, "-l select=1:host=gpu01:ngpus=4") &&
+				strings.Contains(cmd, "'"+scheduler.NvidiaSMIGPUMemoryCommand+"'")
+		}),
+	).Return("81920\n81920\n", nil)
+
+	// Act
+	mem, err := suite.impl.FindGPUMemory(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(81920, mem)
+}
+
+func (suite *PBSTestSuite) TestFindGPUModel() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"pbsnodes -a -F json",
+	).Return(pbsnodes, nil)
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		mock.MatchedBy(func(cmd string) bool {
+			return strings.Contains(cmd, "qsub -W block=true") &&
+				strings.Contains(cmd, "-l select=1:host=gpu01:ngpus=4") &&
+				strings.Contains(cmd, "'"+scheduler.NvidiaSMIGPUNameCommand+"'")
+		}),
+	).Return("NVIDIA A100-SXM4-80GB\nNVIDIA A100-SXM4-80GB\n", nil)
+
+	// Act
+	model, err := suite.impl.FindGPUModel(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("NVIDIA A100-SXM4-80GB", model)
+}
+
+func TestPBSTestSuite(t *testing.T) {
+	suite.Run(t, &PBSTestSuite{})
+}
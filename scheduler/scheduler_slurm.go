@@ -7,6 +7,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/squarefactory/benchmark-api/utils"
 )
@@ -15,27 +16,40 @@ const (
 	User      = "root"
 	JobName   = "HPL-Benchmark"
 	QosName   = "benchmark"
-	JobOutput = "benchmark.log"
+	JobOutput = "benchmark-%j.log"
 )
 
+// slurmInvalidJobID is the error of the controller about a job it no longer
+// knows, once the job is finished and purged.
+const slurmInvalidJobID = "Invalid job id specified"
+
 type Slurm struct {
 	executor  Executor
 	adminUser string
+	// selection restricts the nodes used for resource discovery
+	selection NodeSelection
+	// account charged for the queries run on the nodes, the default one when
+	// empty
+	account string
 }
 
 func NewSlurm(
 	executor Executor,
 	adminUser string,
+	selection NodeSelection,
+	account string,
 ) *Slurm {
 	return &Slurm{
 		executor:  executor,
 		adminUser: adminUser,
+		selection: selection,
+		account:   account,
 	}
 }
 
 // CancelJob kills a job using scancel command.
 func (s *Slurm) CancelJob(ctx context.Context, req *CancelRequest) error {
-	cmd := fmt.Sprintf("scancel --name=%s --me", req.Name)
+	cmd := fmt.Sprintf("scancel %d", req.JobID)
 	_, err := s.executor.ExecAs(ctx, req.User, cmd)
 	if err != nil {
 		log.Printf("cancel failed: %s", err)
@@ -46,6 +60,10 @@ func (s *Slurm) CancelJob(ctx context.Context, req *CancelRequest) error {
 // Submit a sbatch definition script to the SLURM controller using the sbatch command.
 func (s *Slurm) Submit(ctx context.Context, req *SubmitRequest) (string, error) {
 	eof := utils.GenerateRandomString(10)
+	output := req.Output
+	if output == "" {
+		output = JobOutput
+	}
 
 	cmd := fmt.Sprintf(`sbatch \
   --job-name=%s \
@@ -56,7 +74,7 @@ func (s *Slurm) Submit(ctx context.Context, req *SubmitRequest) (string, error)
 %s`,
 		req.Name,
 		QosName,
-		JobOutput,
+		output,
 		eof,
 		req.Body,
 		eof,
@@ -106,68 +124,199 @@ func (s *Slurm) FindRunningJobByName(
 	return jobID, nil
 }
 
-func (s *Slurm) FindMemPerNode(ctx context.Context) (int, error) {
-	cmd := "scontrol show nodes | grep CfgTRES | sed -E 's/.*mem=([0-9]+)[^0-9].*/\\1/'"
-	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+// FindRunningJobByID find a running job using squeue.
+func (s *Slurm) FindRunningJobByID(
+	ctx context.Context,
+	req *FindRunningJobByIDRequest,
+) (int, error) {
+	cmd := fmt.Sprintf("squeue --jobs %d -O JobId:256 --noheader", req.JobID)
+	out, err := s.executor.ExecAs(ctx, req.User, cmd)
+	if err != nil && strings.Contains(out, slurmInvalidJobID) {
+		// The job left the controller memory
+		return 0, ErrJobNotRunning
+	}
 	if err != nil {
-		log.Printf("FindMemPerNode failed: %s", err)
+		log.Printf("FindRunningJobByID failed: %s", err)
 		return 0, err
 	}
 
 	out = strings.TrimSpace(out)
-	lines := strings.Split(out, "\n")
-	if len(lines) > 0 {
-		mem, err := strconv.Atoi(strings.TrimSpace(lines[0]))
-		if err != nil {
-			log.Printf("failed to convert %s to integer: %s", lines[0], err)
-			return 0, err
-		}
+	if out == "" {
+		return 0, ErrJobNotRunning
+	}
 
-		return mem, nil
+	jobID, err := strconv.Atoi(strings.Split(out, "\n")[0])
+	if err != nil {
+		log.Printf("Failed to parse JobId: %s", err)
+		return 0, err
 	}
-	return 0, errors.New("empty string")
+
+	return jobID, nil
 }
 
-func (s *Slurm) FindGPUPerNode(ctx context.Context) (int, error) {
-	cmd := "scontrol show nodes | grep CfgTRES | sed -E 's|.*gres/gpu=([^,]*)|\\1|g'"
-	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+// FindMemPerNode returns the smallest memory in MB among the selected nodes.
+func (s *Slurm) FindMemPerNode(ctx context.Context) (int, error) {
+	nodes, err := s.FindNodeResources(ctx)
 	if err != nil {
-		log.Printf("FindGPUPerNode failed: %s", err)
+		log.Printf("FindMemPerNode failed: %s", err)
 		return 0, err
 	}
 
-	out = strings.TrimSpace(string(out))
-	lines := strings.Split(out, "\n")
-	gpu, err := strconv.Atoi(lines[0])
+	return MinNodeResources(nodes).Memory, nil
+}
+
+func (s *Slurm) FindGPUPerNode(ctx context.Context) (int, error) {
+	nodes, err := s.FindNodeResources(ctx)
 	if err != nil {
-		log.Printf("Failed to convert %s to integer: %s", lines[0], err)
+		log.Printf("FindGPUPerNode failed: %s", err)
 		return 0, err
 	}
 
-	return gpu, nil
+	return MinNodeResources(nodes).GPUs, nil
 }
 
 func (s *Slurm) FindCPUPerNode(ctx context.Context) (int, error) {
-	cmd := "scontrol show nodes | grep CfgTRES= | sed -E 's|.*cpu=([^,]*).*|\\1|g'"
-	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+	nodes, err := s.FindNodeResources(ctx)
 	if err != nil {
 		log.Printf("FindCPUPerNode failed : %s", err)
 		return 0, err
 	}
 
-	out = strings.TrimSpace(string(out))
-	lines := strings.Split(out, "\n")
-	cpu, err := strconv.Atoi(lines[0])
+	return MinNodeResources(nodes).CPUs, nil
+}
+
+// nodeTypes returns a schedulable node of each type of the selection, the
+// nodes with the same gres and features being of the same type. Only the
+// nodes with GPUs are kept, unless none has GPUs.
+func (s *Slurm) nodeTypes(ctx context.Context) ([]SlurmNode, error) {
+	nodes, err := s.FindNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedulable, gpu []SlurmNode
+	for _, node := range nodes {
+		if !node.Schedulable() {
+			continue
+		}
+		schedulable = append(schedulable, node)
+		if node.GPUs() > 0 {
+			gpu = append(gpu, node)
+		}
+	}
+	if len(gpu) > 0 {
+		schedulable = gpu
+	}
+	if len(schedulable) == 0 {
+		return nil, errors.New("no schedulable node in the selection")
+	}
+
+	var types []SlurmNode
+	seen := map[string]bool{}
+	for _, node := range schedulable {
+		key := node.Gres + " " + strings.Join(node.Features, ",")
+		if !seen[key] {
+			seen[key] = true
+			types = append(types, node)
+		}
+	}
+	return types, nil
+}
+
+// onNode returns cmd run by srun on the node, with all its GPUs, as the
+// submit host may have other GPUs, or none.
+func (s *Slurm) onNode(node SlurmNode, cmd string) string {
+	args := []string{
+		"srun",
+		"--job-name=" + JobName,
+		"--qos=" + QosName,
+		"--nodes=1",
+		"--ntasks=1",
+		"--nodelist=" + node.Name,
+		"--time=5",
+	}
+	if gpus := node.GPUs(); gpus > 0 {
+		args = append(args, fmt.Sprintf("--gpus-per-node=%d", gpus))
+	}
+	if s.selection.Partition != "" {
+		args = append(args, "--partition="+s.selection.Partition)
+	}
+	if s.selection.Constraint != "" {
+		args = append(args, "--constraint='"+s.selection.Constraint+"'")
+	}
+	if s.selection.Reservation != "" {
+		args = append(args, "--reservation="+s.selection.Reservation)
+	}
+	if s.account != "" {
+		args = append(args, "--account="+s.account)
+	}
+	log.Printf("querying the GPUs of %s", node.Name)
+	return strings.Join(args, " ") + " " + cmd
+}
+
+// FindGPUMemory returns the smallest GPU memory in MB of the selection using
+// nvidia-smi, on a node of each type.
+func (s *Slurm) FindGPUMemory(ctx context.Context) (int, error) {
+	nodes, err := s.nodeTypes(ctx)
+	if err != nil {
+		log.Printf("FindGPUMemory failed : %s", err)
+		return 0, err
+	}
+
+	smallest := 0
+	for _, node := range nodes {
+		out, err := s.executor.ExecAs(ctx, s.adminUser, s.onNode(node, NvidiaSMIGPUMemoryCommand))
+		if err != nil {
+			log.Printf("FindGPUMemory failed : %s", err)
+			return 0, err
+		}
+
+		mem, err := parseNvidiaSMIGPUMemory(out)
+		if err != nil {
+			log.Printf("Failed to parse GPU memory of %s: %s", node.Name, err)
+			return 0, err
+		}
+		if smallest == 0 || mem < smallest {
+			smallest = mem
+		}
+	}
+
+	return smallest, nil
+}
+
+// FindGPUModel returns the model name of the GPUs of a node of the selection
+// using nvidia-smi.
+func (s *Slurm) FindGPUModel(ctx context.Context) (string, error) {
+	nodes, err := s.nodeTypes(ctx)
+	if err != nil {
+		log.Printf("FindGPUModel failed : %s", err)
+		return "", err
+	}
+	out, err := s.executor.ExecAs(ctx, s.adminUser, s.onNode(nodes[0], NvidiaSMIGPUNameCommand))
+	if err != nil {
+		log.Printf("FindGPUModel failed : %s", err)
+		return "", err
+	}
+
+	name, err := parseNvidiaSMIGPUName(out)
 	if err != nil {
-		log.Printf("Failed to convert %s to integer: %s", lines[0], err)
+		log.Printf("Failed to parse GPU model: %s", err)
+		return "", err
 	}
 
-	return cpu, nil
+	return name, nil
 }
 
+// FindCPUAffinity returns the CPU affinity of each GPU of a node of the
+// selection using nvidia-smi.
 func (s *Slurm) FindCPUAffinity(ctx context.Context) (string, error) {
-	cmd := "nvidia-smi topo -m | grep -E '^GPU[0-9]+' | awk '{print $1, $7}' | sed 's/GPU//'"
-	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+	nodes, err := s.nodeTypes(ctx)
+	if err != nil {
+		log.Printf("FindCPUAffinity failed : %s", err)
+		return "", err
+	}
+	cmd := s.onNode(nodes[0], NvidiaSMITopologyCommand)
+	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd+NvidiaSMITopologyFilter)
 	if err != nil {
 		log.Printf("FindCPUAffinity failed : %s", err)
 		return "", err
@@ -181,9 +330,126 @@ func (s *Slurm) FindJobOutputFile(ctx context.Context, jobID int) (string, error
 	cmd := fmt.Sprintf("scontrol show job %d | sed -n 's/^\\s*StdOut=\\(.*\\)$/\\1/p'", jobID)
 	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
 	if err != nil {
-		log.Printf("FindCPUPerNode failed : %s", err)
+		log.Printf("FindJobOutputFile failed : %s", err)
 		return "", err
 	}
 
-	return out, nil
+	return strings.TrimSpace(out), nil
+}
+
+// FindJobState fetches the final state of a job using sacct.
+func (s *Slurm) FindJobState(ctx context.Context, jobID int) (*JobState, error) {
+	cmd := fmt.Sprintf(
+		"sacct --jobs %d --noheader --parsable2 --format=JobID,State,ExitCode,Elapsed,NodeList,MaxRSS",
+		jobID,
+	)
+	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+	if err != nil {
+		log.Printf("FindJobState failed : %s", err)
+		return nil, err
+	}
+
+	return parseSacct(out, jobID)
+}
+
+// parseSacct parses the parsable output of sacct. The first line describes
+// the job allocation, the following lines describe its steps.
+func parseSacct(out string, jobID int) (*JobState, error) {
+	var state *JobState
+	var maxRSS int64
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) < 6 {
+			continue
+		}
+
+		if fields[0] == strconv.Itoa(jobID) {
+			status := strings.Fields(fields[1])
+			if len(status) == 0 {
+				return nil, fmt.Errorf("job %d has no state", jobID)
+			}
+			elapsed, err := parseSlurmDuration(fields[3])
+			if err != nil {
+				log.Printf("Failed to parse elapsed time %s: %s", fields[3], err)
+				return nil, err
+			}
+			state = &JobState{
+				// States such as "CANCELLED by 0" carry extra information
+				State:    status[0],
+				ExitCode: fields[2],
+				Elapsed:  elapsed,
+				NodeList: fields[4],
+			}
+			continue
+		}
+
+		if rss, err := parseSlurmSize(fields[5]); err == nil && rss > maxRSS {
+			maxRSS = rss
+			if state != nil {
+				state.MaxRSS = fields[5]
+			}
+		}
+	}
+
+	if state == nil {
+		return nil, fmt.Errorf("job %d not found in accounting", jobID)
+	}
+	return state, nil
+}
+
+// parseSlurmDuration parses durations formatted as [D-]HH:MM:SS or MM:SS.mmm.
+func parseSlurmDuration(s string) (time.Duration, error) {
+	var days int
+	if d, rest, ok := strings.Cut(s, "-"); ok {
+		var err error
+		if days, err = strconv.Atoi(d); err != nil {
+			return 0, err
+		}
+		s = rest
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, err
+		}
+		seconds = seconds*60 + v
+	}
+
+	return time.Duration(days)*24*time.Hour +
+		time.Duration(seconds*float64(time.Second)), nil
+}
+
+// parseSlurmSize parses sizes such as 1234K into bytes.
+func parseSlurmSize(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+
+	multiplier := int64(1)
+	switch s[len(s)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	case 'T':
+		multiplier = 1 << 40
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v * float64(multiplier)), nil
 }
@@ -0,0 +1,466 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// slurmFieldRegex matches the keys of scontrol --oneliner output. Values may
+// contain spaces (OS, Reason), but keys are always preceded by a space.
+var slurmFieldRegex = regexp.MustCompile(`(?:^|\s)([A-Za-z][A-Za-z0-9_]*)=`)
+
+// SlurmNode is a node as reported by scontrol show nodes.
+type SlurmNode struct {
+	Name       string
+	Partitions []string
+	State      string
+	CPUs       int
+	// RealMemory in MB
+	RealMemory int
+	CfgTRES    map[string]string
+	AllocTRES  map[string]string
+	Gres       string
+	Features   []string
+}
+
+// unavailableStates are the node states, or state flags, on which no job can
+// start.
+var unavailableStates = []string{
+	"DOWN",
+	"DRAIN",
+	"FAIL",
+	"MAINT",
+	"NOT_RESPONDING",
+	"POWERED_DOWN",
+	"POWERING_DOWN",
+	"FUTURE",
+}
+
+// Schedulable returns whether jobs can start on the node, i.e. it is neither
+// down, drained, failing nor unresponsive.
+func (n *SlurmNode) Schedulable() bool {
+	state := strings.ToUpper(n.State)
+	if state == "" {
+		return true
+	}
+	// Unresponsive nodes are marked with a *
+	if strings.HasSuffix(state, "*") {
+		return false
+	}
+	for _, flag := range strings.Split(state, "+") {
+		for _, unavailable := range unavailableStates {
+			if strings.HasPrefix(flag, unavailable) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// GPUs returns the number of GPUs of the node, from CfgTRES or from Gres.
+func (n *SlurmNode) GPUs() int {
+	if gpu, ok := n.CfgTRES["gres/gpu"]; ok {
+		if v, err := strconv.Atoi(gpu); err == nil {
+			return v
+		}
+	}
+
+	// Typed gres only, e.g. gres/gpu:a100=8
+	var total int
+	for key, value := range n.CfgTRES {
+		if strings.HasPrefix(key, "gres/gpu:") {
+			v, err := strconv.Atoi(value)
+			if err == nil {
+				total += v
+			}
+		}
+	}
+	if total > 0 {
+		return total
+	}
+
+	for _, gres := range splitSlurmList(n.Gres) {
+		if match := gpuGresRegex.FindStringSubmatch(gres); match != nil {
+			v, _ := strconv.Atoi(match[1])
+			total += v
+		}
+	}
+	return total
+}
+
+// Memory returns the configured memory of the node in MB, from CfgTRES or
+// from RealMemory.
+func (n *SlurmNode) Memory() int {
+	mem, ok := n.CfgTRES["mem"]
+	if !ok {
+		return n.RealMemory
+	}
+
+	// Sizes without unit are in MB
+	if v, err := strconv.Atoi(mem); err == nil {
+		return v
+	}
+	v, err := parseSlurmSize(mem)
+	if err != nil {
+		return n.RealMemory
+	}
+	return int(v / (1 << 20))
+}
+
+// splitSlurmList splits a comma-separated list, ignoring commas inside
+// parentheses, e.g. gpu:a100:8(S:0-1),nvme:1.
+func splitSlurmList(s string) []string {
+	if s == "" || s == "(null)" {
+		return nil
+	}
+
+	var items []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, s[start:])
+}
+
+func parseSlurmTRES(s string) map[string]string {
+	tres := map[string]string{}
+	for _, item := range splitSlurmList(s) {
+		if key, value, ok := strings.Cut(item, "="); ok {
+			tres[key] = value
+		}
+	}
+	return tres
+}
+
+// parseSlurmFields parses a line of scontrol --oneliner output.
+func parseSlurmFields(line string) map[string]string {
+	fields := map[string]string{}
+	matches := slurmFieldRegex.FindAllStringSubmatchIndex(line, -1)
+	for i, match := range matches {
+		end := len(line)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		key := line[match[2]:match[3]]
+		fields[key] = strings.TrimSpace(line[match[1]:end])
+	}
+	return fields
+}
+
+// ParseSlurmNodes parses the output of scontrol show nodes --oneliner.
+func ParseSlurmNodes(out string) ([]SlurmNode, error) {
+	var nodes []SlurmNode
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := parseSlurmFields(line)
+		name, ok := fields["NodeName"]
+		if !ok {
+			return nil, fmt.Errorf("no NodeName in line: %s", line)
+		}
+
+		node := SlurmNode{
+			Name:       name,
+			Partitions: splitSlurmList(fields["Partitions"]),
+			State:      fields["State"],
+			CfgTRES:    parseSlurmTRES(fields["CfgTRES"]),
+			AllocTRES:  parseSlurmTRES(fields["AllocTRES"]),
+			Gres:       fields["Gres"],
+			Features:   splitSlurmList(fields["AvailableFeatures"]),
+		}
+		if node.Gres == "(null)" {
+			node.Gres = ""
+		}
+
+		var err error
+		if node.CPUs, err = strconv.Atoi(fields["CPUTot"]); err != nil {
+			return nil, fmt.Errorf("invalid CPUTot of node %s: %w", name, err)
+		}
+		if node.RealMemory, err = strconv.Atoi(fields["RealMemory"]); err != nil {
+			return nil, fmt.Errorf("invalid RealMemory of node %s: %w", name, err)
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// expandSlurmHostList expands a host list such as gpu[01-03,08],cpu01.
+func expandSlurmHostList(s string) ([]string, error) {
+	var hosts []string
+	for _, item := range splitSlurmHostList(s) {
+		expanded, err := expandSlurmHost(item)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, expanded...)
+	}
+	return hosts, nil
+}
+
+// splitSlurmHostList splits a host list, ignoring commas inside brackets.
+func splitSlurmHostList(s string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if start < len(s) {
+		items = append(items, s[start:])
+	}
+	return items
+}
+
+func expandSlurmHost(s string) ([]string, error) {
+	open := strings.IndexByte(s, '[')
+	if open < 0 {
+		return []string{s}, nil
+	}
+	end := strings.IndexByte(s[open:], ']')
+	if end < 0 {
+		return nil, fmt.Errorf("invalid host list: %s", s)
+	}
+	end += open
+
+	// The suffix may contain other ranges, e.g. rack[1-2]-node[01-04]
+	suffixes, err := expandSlurmHost(s[end+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, r := range strings.Split(s[open+1:end], ",") {
+		first, last, isRange := strings.Cut(r, "-")
+		if !isRange {
+			last = first
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host range %s: %w", r, err)
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host range %s: %w", r, err)
+		}
+		for i := from; i <= to; i++ {
+			for _, suffix := range suffixes {
+				// Keep the zero padding of the range, e.g. 01-10
+				hosts = append(hosts, fmt.Sprintf("%s%0*d%s", s[:open], len(first), i, suffix))
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// matchSlurmConstraint reports whether the features satisfy a constraint made
+// of features separated by & (and) or | (or). Counts and brackets are ignored.
+func matchSlurmConstraint(constraint string, features []string) bool {
+	has := make(map[string]bool, len(features))
+	for _, feature := range features {
+		has[feature] = true
+	}
+
+	constraint = strings.NewReplacer("[", "", "]", "", "(", "", ")", "").Replace(constraint)
+	for _, alternative := range strings.Split(constraint, "|") {
+		matched := true
+		for _, feature := range strings.Split(alternative, "&") {
+			feature, _, _ = strings.Cut(strings.TrimSpace(feature), "*")
+			if !has[feature] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeFilter returns a function selecting the nodes of the selection.
+func (s *Slurm) nodeFilter(ctx context.Context) (func(*SlurmNode) bool, error) {
+	var reserved string
+	if s.selection.Reservation != "" {
+		out, err := s.executor.ExecAs(
+			ctx,
+			s.adminUser,
+			fmt.Sprintf("scontrol show reservation %s --oneliner", s.selection.Reservation),
+		)
+		if err != nil {
+			log.Printf("Failed to find reservation %s: %s", s.selection.Reservation, err)
+			return nil, err
+		}
+		reserved = parseSlurmFields(out)["Nodes"]
+	}
+	return selectionFilter(s.selection, reserved)
+}
+
+// selectionFilter returns a function selecting the nodes of the selection,
+// reserved being the host list of the nodes of its reservation.
+func selectionFilter(sel NodeSelection, reserved string) (func(*SlurmNode) bool, error) {
+	var partitions []string
+	if sel.Partition != "" {
+		partitions = strings.Split(sel.Partition, ",")
+	}
+
+	toSet := func(list string) (map[string]bool, error) {
+		hosts, err := expandSlurmHostList(list)
+		if err != nil {
+			return nil, err
+		}
+		set := make(map[string]bool, len(hosts))
+		for _, host := range hosts {
+			set[host] = true
+		}
+		return set, nil
+	}
+
+	included, err := toSet(sel.NodeList)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := toSet(sel.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	reservedNodes, err := toSet(reserved)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(node *SlurmNode) bool {
+		if len(partitions) > 0 && !containsAny(node.Partitions, partitions) {
+			return false
+		}
+		if sel.NodeList != "" && !included[node.Name] {
+			return false
+		}
+		if excluded[node.Name] {
+			return false
+		}
+		if sel.Constraint != "" && !matchSlurmConstraint(sel.Constraint, node.Features) {
+			return false
+		}
+		if sel.Reservation != "" && !reservedNodes[node.Name] {
+			return false
+		}
+		return true
+	}, nil
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, value := range values {
+		for _, candidate := range candidates {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FindNodes lists the nodes of the selection using scontrol.
+func (s *Slurm) FindNodes(ctx context.Context) ([]SlurmNode, error) {
+	out, err := s.executor.ExecAs(ctx, s.adminUser, "scontrol show nodes --oneliner")
+	if err != nil {
+		log.Printf("FindNodes failed: %s", err)
+		return nil, err
+	}
+
+	nodes, err := ParseSlurmNodes(out)
+	if err != nil {
+		log.Printf("Failed to parse nodes: %s", err)
+		return nil, err
+	}
+
+	selected, err := s.nodeFilter(ctx)
+	if err != nil {
+		log.Printf("Invalid node selection: %s", err)
+		return nil, err
+	}
+
+	var filtered []SlurmNode
+	for i := range nodes {
+		if selected(&nodes[i]) {
+			filtered = append(filtered, nodes[i])
+		}
+	}
+
+	return filtered, nil
+}
+
+// FindNodeResources returns the resources of the schedulable nodes of the
+// selection with GPUs, or of all of them if none has GPUs. Down, drained and
+// unresponsive nodes are left out, as no job can land on them.
+func (s *Slurm) FindNodeResources(ctx context.Context) ([]NodeResources, error) {
+	nodes, err := s.FindNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, errors.New("no nodes match the selection")
+	}
+
+	resources := make([]NodeResources, 0, len(nodes))
+	for _, node := range nodes {
+		if !node.Schedulable() {
+			continue
+		}
+		resources = append(resources, NodeResources{
+			Name:   node.Name,
+			Memory: node.Memory(),
+			GPUs:   node.GPUs(),
+			CPUs:   node.CPUs,
+		})
+	}
+
+	if len(resources) == 0 {
+		return nil, errors.New("no schedulable node in the selection")
+	}
+	return gpuNodes(resources), nil
+}
+
+// DrainNode drains a node using scontrol, so that no new job starts on it.
+// The running jobs are left to finish.
+func (s *Slurm) DrainNode(ctx context.Context, node string, reason string) error {
+	cmd := fmt.Sprintf(
+		"scontrol update NodeName=%s State=DRAIN Reason='%s'",
+		node,
+		strings.ReplaceAll(reason, "'", ""),
+	)
+	if _, err := s.executor.ExecAs(ctx, s.adminUser, cmd); err != nil {
+		log.Printf("Failed to drain %s: %s", node, err)
+		return err
+	}
+	return nil
+}
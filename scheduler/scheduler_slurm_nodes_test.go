@@ -0,0 +1,219 @@
+//go:build unit
+
+package scheduler_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSlurmNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		expected []scheduler.SlurmNode
+		gpus     []int
+		mem      []int
+	}{
+		{
+			name: "Typed GPUs",
+			file: "testdata/scontrol_show_nodes_gpu.txt",
+			expected: []scheduler.SlurmNode{
+				{
+					Name:       "gpu01",
+					Partitions: []string{"gpu", "all"},
+					State:      "IDLE",
+					CPUs:       128,
+					RealMemory: 1031000,
+					CfgTRES: map[string]string{
+						"cpu":           "128",
+						"mem":           "1031000M",
+						"billing":       "128",
+						"gres/gpu":      "8",
+						"gres/gpu:a100": "8",
+					},
+					AllocTRES: map[string]string{},
+					Gres:      "gpu:a100:8(S:0-1)",
+					Features:  []string{"a100", "ib"},
+				},
+				{
+					Name:       "gpu02",
+					Partitions: []string{"gpu", "all"},
+					State:      "MIXED",
+					CPUs:       128,
+					RealMemory: 1031000,
+					CfgTRES: map[string]string{
+						"cpu":           "128",
+						"mem":           "1031000M",
+						"billing":       "128",
+						"gres/gpu":      "8",
+						"gres/gpu:a100": "8",
+					},
+					AllocTRES: map[string]string{
+						"cpu":           "64",
+						"mem":           "500G",
+						"gres/gpu":      "4",
+						"gres/gpu:a100": "4",
+					},
+					Gres:     "gpu:a100:8(S:0-1)",
+					Features: []string{"a100", "ib"},
+				},
+			},
+			gpus: []int{8, 8},
+			mem:  []int{1031000, 1031000},
+		},
+		{
+			name: "Nodes without GPU and memory units",
+			file: "testdata/scontrol_show_nodes_mixed.txt",
+			expected: []scheduler.SlurmNode{
+				{
+					Name:       "cpu01",
+					Partitions: []string{"cpu"},
+					State:      "DOWN+NOT_RESPONDING",
+					CPUs:       32,
+					RealMemory: 257000,
+					CfgTRES: map[string]string{
+						"cpu":     "32",
+						"mem":     "257000M",
+						"billing": "32",
+					},
+					AllocTRES: map[string]string{},
+				},
+				{
+					Name:       "gpu03",
+					Partitions: []string{"gpu"},
+					State:      "IDLE",
+					CPUs:       48,
+					RealMemory: 385000,
+					CfgTRES: map[string]string{
+						"cpu":      "48",
+						"mem":      "376G",
+						"billing":  "48",
+						"gres/gpu": "4",
+					},
+					AllocTRES: map[string]string{},
+					Gres:      "gpu:4",
+					Features:  []string{"v100"},
+				},
+			},
+			gpus: []int{0, 4},
+			mem:  []int{257000, 385024},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := os.ReadFile(tt.file)
+			require.NoError(t, err)
+
+			nodes, err := scheduler.ParseSlurmNodes(string(out))
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, nodes)
+			for i, node := range nodes {
+				assert.Equal(t, tt.gpus[i], node.GPUs())
+				assert.Equal(t, tt.mem[i], node.Memory())
+			}
+		})
+	}
+}
+
+func TestSlurmNodeGPUs(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     scheduler.SlurmNode
+		expected int
+	}{
+		{
+			name: "Typed TRES only",
+			node: scheduler.SlurmNode{
+				CfgTRES: map[string]string{"gres/gpu:a100": "8"},
+			},
+			expected: 8,
+		},
+		{
+			name:     "Gres only",
+			node:     scheduler.SlurmNode{Gres: "gpu:a100:4(S:0),gpu:v100:2(S:1),nvme:1"},
+			expected: 6,
+		},
+		{
+			name:     "No GPU",
+			node:     scheduler.SlurmNode{},
+			expected: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.node.GPUs())
+		})
+	}
+}
+
+func TestSlurmNodeSchedulable(t *testing.T) {
+	tests := []struct {
+		state    string
+		expected bool
+	}{
+		{state: "IDLE", expected: true},
+		{state: "MIXED", expected: true},
+		{state: "ALLOCATED+COMPLETING", expected: true},
+		{state: "IDLE+DRAIN", expected: false},
+		{state: "DRAINED", expected: false},
+		{state: "DOWN+NOT_RESPONDING", expected: false},
+		{state: "IDLE*", expected: false},
+		{state: "IDLE+POWERED_DOWN", expected: false},
+		{state: "MAINT+RESERVED", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			node := scheduler.SlurmNode{State: tt.state}
+			assert.Equal(t, tt.expected, node.Schedulable())
+		})
+	}
+}
+
+func TestMinNodeResources(t *testing.T) {
+	tests := []struct {
+		name          string
+		nodes         []scheduler.NodeResources
+		expected      scheduler.NodeResources
+		heterogeneous bool
+	}{
+		{
+			name: "Homogeneous",
+			nodes: []scheduler.NodeResources{
+				{Name: "gpu01", Memory: 1031000, GPUs: 8, CPUs: 128},
+				{Name: "gpu02", Memory: 1031000, GPUs: 8, CPUs: 128},
+			},
+			expected: scheduler.NodeResources{Memory: 1031000, GPUs: 8, CPUs: 128},
+		},
+		{
+			name: "Heterogeneous",
+			nodes: []scheduler.NodeResources{
+				{Name: "gpu01", Memory: 1031000, GPUs: 4, CPUs: 128},
+				{Name: "gpu03", Memory: 385024, GPUs: 8, CPUs: 48},
+			},
+			expected:      scheduler.NodeResources{Memory: 385024, GPUs: 4, CPUs: 48},
+			heterogeneous: true,
+		},
+		{
+			name:     "No nodes",
+			expected: scheduler.NodeResources{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, scheduler.MinNodeResources(tt.nodes))
+			assert.Equal(t, tt.heterogeneous, scheduler.Heterogeneous(tt.nodes))
+		})
+	}
+}
+
+func TestParseSlurmNodesInvalid(t *testing.T) {
+	_, err := scheduler.ParseSlurmNodes("NodeName=gpu01 CPUTot=abc RealMemory=1000\n")
+
+	assert.Error(t, err)
+}
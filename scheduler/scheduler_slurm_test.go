@@ -4,9 +4,12 @@ package scheduler_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/squarefactory/benchmark-api/mocks"
 	"github.com/squarefactory/benchmark-api/scheduler"
@@ -32,15 +35,16 @@ func (suite *ServiceTestSuite) BeforeTest(suiteName, testName string) {
 	suite.impl = scheduler.NewSlurm(
 		suite.executor,
 		admin,
+		scheduler.NodeSelection{},
+		"",
 	)
 }
 
 func (suite *ServiceTestSuite) TestCancel() {
 	// Arrange
-	name := utils.GenerateRandomString(6)
 	req := &scheduler.CancelRequest{
-		Name: name,
-		User: user,
+		JobID: 123,
+		User:  user,
 	}
 	suite.executor.On(
 		"ExecAs",
@@ -48,7 +52,7 @@ func (suite *ServiceTestSuite) TestCancel() {
 		user,
 		mock.MatchedBy(func(cmd string) bool {
 			return strings.Contains(cmd, "scancel") &&
-				strings.Contains(cmd, req.Name)
+				strings.Contains(cmd, "123")
 		}),
 	).Return("ok", nil)
 	ctx := context.Background()
@@ -61,6 +65,23 @@ func (suite *ServiceTestSuite) TestCancel() {
 	suite.executor.AssertExpectations(suite.T())
 }
 
+func (suite *ServiceTestSuite) TestDrainNode() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"scontrol update NodeName=gpu03 State=DRAIN Reason='HPL screening: 12% below the median'",
+	).Return("", nil)
+
+	// Act
+	err := suite.impl.DrainNode(context.Background(), "gpu03", "HPL screening: 12% below the median")
+
+	// Assert
+	suite.NoError(err)
+	suite.executor.AssertExpectations(suite.T())
+}
+
 func (suite *ServiceTestSuite) TestSubmit() {
 	// Arrange
 	name := utils.GenerateRandomString(6)
@@ -140,48 +161,530 @@ func (suite *ServiceTestSuite) TestFindRunningJobByName() {
 	suite.executor.AssertExpectations(suite.T())
 }
 
-func (suite *ServiceTestSuite) TestFindMemPerNode() {
-	mem := 123
-
+func (suite *ServiceTestSuite) TestFindRunningJobByID() {
+	// Arrange
+	jobID := 123
+	req := &scheduler.FindRunningJobByIDRequest{
+		JobID: jobID,
+		User:  user,
+	}
 	suite.executor.On(
 		"ExecAs",
 		mock.Anything,
-		admin,
+		user,
 		mock.MatchedBy(func(cmd string) bool {
-			return strings.Contains(cmd, "scontrol") &&
-				strings.Contains(cmd, "grep CfgTRES")
+			return strings.Contains(cmd, "squeue") &&
+				strings.Contains(cmd, "--jobs 123")
 		}),
-	).Return(fmt.Sprintf("%d\n", mem), nil)
+	).Return(fmt.Sprintf("%d\n", jobID), nil)
 	ctx := context.Background()
 
 	// Act
-	out, err := suite.impl.FindMemPerNode(ctx)
+	out, err := suite.impl.FindRunningJobByID(ctx, req)
 
 	// Assert
 	suite.NoError(err)
-	suite.Equal(mem, out)
+	suite.Equal(jobID, out)
 	suite.executor.AssertExpectations(suite.T())
 }
 
-func (suite *ServiceTestSuite) TestFindGPUPerNode() {
-	gpu := 123
+func (suite *ServiceTestSuite) TestFindRunningJobByIDNotRunning() {
+	tests := []struct {
+		name       string
+		out        string
+		err        error
+		notRunning bool
+	}{
+		{
+			name:       "Finished",
+			out:        "\n",
+			notRunning: true,
+		},
+		{
+			name:       "Purged",
+			out:        "slurm_load_jobs error: Invalid job id specified\n",
+			err:        errors.New("exit status 1"),
+			notRunning: true,
+		},
+		{
+			name: "Controller unreachable",
+			out:  "slurm_load_jobs error: Unable to contact slurm controller (connect failure)\n",
+			err:  errors.New("exit status 1"),
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			executor := mocks.NewExecutor(suite.T())
+			executor.On(
+				"ExecAs",
+				mock.Anything,
+				user,
+				mock.MatchedBy(func(cmd string) bool {
+					return strings.Contains(cmd, "squeue")
+				}),
+			).Return(tt.out, tt.err)
+			impl := scheduler.NewSlurm(executor, admin, scheduler.NodeSelection{}, "")
+
+			// Act
+			_, err := impl.FindRunningJobByID(
+				context.Background(),
+				&scheduler.FindRunningJobByIDRequest{JobID: 123, User: user},
+			)
+
+			// Assert
+			suite.Error(err)
+			suite.Equal(tt.notRunning, errors.Is(err, scheduler.ErrJobNotRunning))
+		})
+	}
+}
+
+func (suite *ServiceTestSuite) TestNodeInventory() {
+	tests := []struct {
+		name string
+		file string
+		mem  int
+		gpu  int
+		cpu  int
+	}{
+		{
+			name: "Typed GPUs",
+			file: "testdata/scontrol_show_nodes_gpu.txt",
+			mem:  1031000,
+			gpu:  8,
+			cpu:  128,
+		},
+		{
+			name: "Skip nodes without GPU",
+			file: "testdata/scontrol_show_nodes_mixed.txt",
+			mem:  385024,
+			gpu:  4,
+			cpu:  48,
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			suite.BeforeTest("", "")
+			out, err := os.ReadFile(tt.file)
+			suite.Require().NoError(err)
+			suite.executor.On(
+				"ExecAs",
+				mock.Anything,
+				admin,
+				"scontrol show nodes --oneliner",
+			).Return(string(out), nil)
+			ctx := context.Background()
+
+			// Act
+			mem, memErr := suite.impl.FindMemPerNode(ctx)
+			gpu, gpuErr := suite.impl.FindGPUPerNode(ctx)
+			cpu, cpuErr := suite.impl.FindCPUPerNode(ctx)
+
+			// Assert
+			suite.NoError(memErr)
+			suite.NoError(gpuErr)
+			suite.NoError(cpuErr)
+			suite.Equal(tt.mem, mem)
+			suite.Equal(tt.gpu, gpu)
+			suite.Equal(tt.cpu, cpu)
+			suite.executor.AssertExpectations(suite.T())
+		})
+	}
+}
+
+func (suite *ServiceTestSuite) TestNodeInventoryNoNodes() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"scontrol show nodes --oneliner",
+	).Return("", nil)
+
+	// Act
+	_, err := suite.impl.FindGPUPerNode(context.Background())
+
+	// Assert
+	suite.Error(err)
+}
 
+func (suite *ServiceTestSuite) TestFindNodeResourcesHeterogeneous() {
+	// Arrange
+	gpu, err := os.ReadFile("testdata/scontrol_show_nodes_gpu.txt")
+	suite.Require().NoError(err)
+	mixed, err := os.ReadFile("testdata/scontrol_show_nodes_mixed.txt")
+	suite.Require().NoError(err)
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"scontrol show nodes --oneliner",
+	).Return(string(gpu)+string(mixed), nil)
+	ctx := context.Background()
+
+	// Act
+	nodes, err := suite.impl.FindNodeResources(ctx)
+	mem, memErr := suite.impl.FindMemPerNode(ctx)
+	gpus, gpuErr := suite.impl.FindGPUPerNode(ctx)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal([]scheduler.NodeResources{
+		{Name: "gpu01", Memory: 1031000, GPUs: 8, CPUs: 128},
+		{Name: "gpu02", Memory: 1031000, GPUs: 8, CPUs: 128},
+		{Name: "gpu03", Memory: 385024, GPUs: 4, CPUs: 48},
+	}, nodes)
+	suite.NoError(memErr)
+	suite.NoError(gpuErr)
+	suite.Equal(385024, mem)
+	suite.Equal(4, gpus)
+}
+
+func (suite *ServiceTestSuite) TestFindNodeResourcesUnschedulable() {
+	// Arrange
+	gpu, err := os.ReadFile("testdata/scontrol_show_nodes_gpu.txt")
+	suite.Require().NoError(err)
+	mixed, err := os.ReadFile("testdata/scontrol_show_nodes_mixed.txt")
+	suite.Require().NoError(err)
+	drained := strings.Replace(string(mixed), "State=IDLE ", "State=IDLE+DRAIN ", 1)
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"scontrol show nodes --oneliner",
+	).Return(string(gpu)+drained, nil)
+	ctx := context.Background()
+
+	// Act
+	nodes, err := suite.impl.FindNodeResources(ctx)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal([]scheduler.NodeResources{
+		{Name: "gpu01", Memory: 1031000, GPUs: 8, CPUs: 128},
+		{Name: "gpu02", Memory: 1031000, GPUs: 8, CPUs: 128},
+	}, nodes)
+}
+
+// mockNodes lists the GPU nodes of the testdata.
+func (suite *ServiceTestSuite) mockNodes() {
+	gpu, err := os.ReadFile("testdata/scontrol_show_nodes_gpu.txt")
+	suite.Require().NoError(err)
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"scontrol show nodes --oneliner",
+	).Return(string(gpu), nil)
+}
+
+func (suite *ServiceTestSuite) TestFindGPUMemory() {
+	tests := []struct {
+		name     string
+		out      string
+		expected int
+		isError  bool
+	}{
+		{
+			name:     "Without units",
+			out:      "81920\n81920\n40960\n81920\n",
+			expected: 40960,
+		},
+		{
+			name:     "With units",
+			out:      "81920 MiB\n81920 MiB\n",
+			expected: 81920,
+		},
+		{
+			name:    "No GPU",
+			out:     "",
+			isError: true,
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			suite.BeforeTest("", "")
+			suite.impl = scheduler.NewSlurm(
+				suite.executor,
+				admin,
+				scheduler.NodeSelection{Partition: "gpu"},
+				"",
+			)
+			suite.mockNodes()
+			suite.executor.On(
+				"ExecAs",
+				mock.Anything,
+				admin,
+				"srun --job-name=HPL-Benchmark --qos=benchmark --nodes=1 --ntasks=1 --nodelist=gpu01 "+
+					"--time=5 --gpus-per-node=8 --partition=gpu "+scheduler.NvidiaSMIGPUMemoryCommand,
+			).Return(tt.out, nil)
+
+			// Act
+			mem, err := suite.impl.FindGPUMemory(context.Background())
+
+			// Assert
+			if tt.isError {
+				suite.Error(err)
+			} else {
+				suite.NoError(err)
+				suite.Equal(tt.expected, mem)
+			}
+		})
+	}
+}
+
+func (suite *ServiceTestSuite) TestFindGPUMemoryNodeTypes() {
+	// Arrange
+	gpu, err := os.ReadFile("testdata/scontrol_show_nodes_gpu.txt")
+	suite.Require().NoError(err)
+	mixed, err := os.ReadFile("testdata/scontrol_show_nodes_mixed.txt")
+	suite.Require().NoError(err)
+	drained := strings.Replace(string(gpu), "State=IDLE ", "State=IDLE+DRAIN ", 1)
+	suite.impl = scheduler.NewSlurm(
+		suite.executor,
+		admin,
+		scheduler.NodeSelection{Partition: "gpu", Constraint: "a100|v100"},
+		"bench",
+	)
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		"scontrol show nodes --oneliner",
+	).Return(drained+string(mixed), nil)
+	for node, out := range map[string]string{
+		"--nodelist=gpu02 --time=5 --gpus-per-node=8": "81920\n",
+		"--nodelist=gpu03 --time=5 --gpus-per-node=4": "32768\n",
+	} {
+		suite.executor.On(
+			"ExecAs",
+			mock.Anything,
+			admin,
+			"srun --job-name=HPL-Benchmark --qos=benchmark --nodes=1 --ntasks=1 "+node+
+				" --partition=gpu --constraint='a100|v100' --account=bench "+scheduler.NvidiaSMIGPUMemoryCommand,
+		).Return(out, nil).Once()
+	}
+
+	// Act
+	mem, err := suite.impl.FindGPUMemory(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(32768, mem)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestFindGPUModel() {
+	tests := []struct {
+		name     string
+		out      string
+		expected string
+		isError  bool
+	}{
+		{
+			name:     "Same model",
+			out:      "NVIDIA A100-SXM4-80GB\nNVIDIA A100-SXM4-80GB\n",
+			expected: "NVIDIA A100-SXM4-80GB",
+		},
+		{
+			name:    "Different models",
+			out:     "NVIDIA A100-SXM4-80GB\nNVIDIA A100-SXM4-40GB\n",
+			isError: true,
+		},
+		{
+			name:    "No GPU",
+			out:     "",
+			isError: true,
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			suite.BeforeTest("", "")
+			suite.impl = scheduler.NewSlurm(
+				suite.executor,
+				admin,
+				scheduler.NodeSelection{Partition: "gpu"},
+				"",
+			)
+			suite.mockNodes()
+			suite.executor.On(
+				"ExecAs",
+				mock.Anything,
+				admin,
+				"srun --job-name=HPL-Benchmark --qos=benchmark --nodes=1 --ntasks=1 --nodelist=gpu01 "+
+					"--time=5 --gpus-per-node=8 --partition=gpu "+scheduler.NvidiaSMIGPUNameCommand,
+			).Return(tt.out, nil)
+
+			// Act
+			name, err := suite.impl.FindGPUModel(context.Background())
+
+			// Assert
+			if tt.isError {
+				suite.Error(err)
+			} else {
+				suite.NoError(err)
+				suite.Equal(tt.expected, name)
+			}
+		})
+	}
+}
+
+func (suite *ServiceTestSuite) TestFindNodesSelection() {
+	gpu, err := os.ReadFile("testdata/scontrol_show_nodes_gpu.txt")
+	suite.Require().NoError(err)
+	mixed, err := os.ReadFile("testdata/scontrol_show_nodes_mixed.txt")
+	suite.Require().NoError(err)
+	nodes := string(gpu) + string(mixed)
+
+	tests := []struct {
+		name        string
+		selection   scheduler.NodeSelection
+		reservation string
+		expected    []string
+	}{
+		{
+			name:     "No selection",
+			expected: []string{"gpu01", "gpu02", "cpu01", "gpu03"},
+		},
+		{
+			name:      "Partition",
+			selection: scheduler.NodeSelection{Partition: "cpu"},
+			expected:  []string{"cpu01"},
+		},
+		{
+			name:      "Node list",
+			selection: scheduler.NodeSelection{NodeList: "gpu[02-03]"},
+			expected:  []string{"gpu02", "gpu03"},
+		},
+		{
+			name: "Exclude",
+			selection: scheduler.NodeSelection{
+				Partition: "gpu",
+				Exclude:   "gpu01,gpu03",
+			},
+			expected: []string{"gpu02"},
+		},
+		{
+			name:      "Constraint",
+			selection: scheduler.NodeSelection{Constraint: "a100&ib|v100"},
+			expected:  []string{"gpu01", "gpu02", "gpu03"},
+		},
+		{
+			name:        "Reservation",
+			selection:   scheduler.NodeSelection{Reservation: "maintenance"},
+			reservation: "ReservationName=maintenance StartTime=2023-07-01T08:00:00 Nodes=gpu[01,03] NodeCnt=2 State=ACTIVE\n",
+			expected:    []string{"gpu01", "gpu03"},
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			suite.executor = mocks.NewExecutor(suite.T())
+			suite.impl = scheduler.NewSlurm(suite.executor, admin, tt.selection, "")
+			suite.executor.On(
+				"ExecAs",
+				mock.Anything,
+				admin,
+				"scontrol show nodes --oneliner",
+			).Return(nodes, nil)
+			if tt.reservation != "" {
+				suite.executor.On(
+					"ExecAs",
+					mock.Anything,
+					admin,
+					"scontrol show reservation maintenance --oneliner",
+				).Return(tt.reservation, nil)
+			}
+
+			// Act
+			selected, err := suite.impl.FindNodes(context.Background())
+
+			// Assert
+			suite.NoError(err)
+			var names []string
+			for _, node := range selected {
+				names = append(names, node.Name)
+			}
+			suite.Equal(tt.expected, names)
+		})
+	}
+}
+
+func (suite *ServiceTestSuite) TestFindJobState() {
+	// Arrange
+	sacct := `123|OUT_OF_MEMORY|0:125|00:12:34|node[1-2]|
+123.batch|OUT_OF_MEMORY|0:125|00:12:34|node1|2048K
+123.extern|COMPLETED|0:0|00:12:35|node[1-2]|0
+123.0|OUT_OF_MEMORY|0:125|00:12:30|node[1-2]|512000M
+`
 	suite.executor.On(
 		"ExecAs",
 		mock.Anything,
 		admin,
 		mock.MatchedBy(func(cmd string) bool {
-			return strings.Contains(cmd, "scontrol") &&
-				strings.Contains(cmd, "gres/gpu=")
+			return strings.Contains(cmd, "sacct") &&
+				strings.Contains(cmd, "--jobs 123")
 		}),
-	).Return(fmt.Sprintf("%d\n", gpu), nil)
+	).Return(sacct, nil)
+	ctx := context.Background()
+
+	// Act
+	out, err := suite.impl.FindJobState(ctx, 123)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(&scheduler.JobState{
+		State:    scheduler.JobStateOutOfMemory,
+		ExitCode: "0:125",
+		Elapsed:  12*time.Minute + 34*time.Second,
+		NodeList: "node[1-2]",
+		MaxRSS:   "512000M",
+	}, out)
+	suite.False(out.Completed())
+	suite.True(out.Finished())
+	suite.False(out.Retriable())
+	suite.False(out.NodeFailed())
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestFindJobStateCancelled() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		mock.Anything,
+	).Return("123|CANCELLED by 0|0:0|1-02:00:00|node1|\n", nil)
 	ctx := context.Background()
 
 	// Act
-	out, err := suite.impl.FindGPUPerNode(ctx)
+	out, err := suite.impl.FindJobState(ctx, 123)
+
 	// Assert
 	suite.NoError(err)
-	suite.Equal(gpu, out)
+	suite.Equal(scheduler.JobStateCancelled, out.State)
+	suite.Equal(26*time.Hour, out.Elapsed)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestFindJobStateUnknownJob() {
+	// Arrange
+	suite.executor.On(
+		"ExecAs",
+		mock.Anything,
+		admin,
+		mock.Anything,
+	).Return("", nil)
+	ctx := context.Background()
+
+	// Act
+	_, err := suite.impl.FindJobState(ctx, 123)
+
+	// Assert
+	suite.Error(err)
 	suite.executor.AssertExpectations(suite.T())
 }
 
@@ -0,0 +1,525 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SlurmRESTAPIVersion = "v0.0.39"
+
+var gpuGresRegex = regexp.MustCompile(`gpu(?::[^:,(]+)?:(\d+)`)
+
+// SlurmREST talks to the SLURM controller through slurmrestd, authenticating
+// with a JWT.
+type SlurmREST struct {
+	client    *http.Client
+	url       string
+	user      string
+	token     string
+	selection NodeSelection
+}
+
+func NewSlurmREST(
+	client *http.Client,
+	url string,
+	user string,
+	token string,
+	selection NodeSelection,
+) *SlurmREST {
+	return &SlurmREST{
+		client:    client,
+		url:       strings.TrimRight(url, "/"),
+		user:      user,
+		token:     token,
+		selection: selection,
+	}
+}
+
+type slurmRESTError struct {
+	Error       string `json:"error"`
+	Description string `json:"description"`
+	ErrorNumber int    `json:"error_number"`
+}
+
+type slurmRESTJobSubmitRequest struct {
+	Script string                 `json:"script"`
+	Job    slurmRESTJobProperties `json:"job"`
+}
+
+type slurmRESTJobProperties struct {
+	Name                    string   `json:"name"`
+	QOS                     string   `json:"qos"`
+	StandardOutput          string   `json:"standard_output"`
+	CurrentWorkingDirectory string   `json:"current_working_directory"`
+	Environment             []string `json:"environment"`
+}
+
+type slurmRESTJobSubmitResponse struct {
+	JobID  int              `json:"job_id"`
+	Errors []slurmRESTError `json:"errors"`
+}
+
+type slurmRESTJobsResponse struct {
+	Jobs   []slurmRESTJob   `json:"jobs"`
+	Errors []slurmRESTError `json:"errors"`
+}
+
+type slurmRESTJob struct {
+	JobID          int            `json:"job_id"`
+	JobState       slurmRESTState `json:"job_state"`
+	StandardOutput string         `json:"standard_output"`
+}
+
+// slurmRESTState is a job state, which is a string in older API versions
+// and a list of flags in newer ones.
+type slurmRESTState string
+
+func (s *slurmRESTState) UnmarshalJSON(data []byte) error {
+	var state string
+	if err := json.Unmarshal(data, &state); err == nil {
+		*s = slurmRESTState(state)
+		return nil
+	}
+
+	var states []string
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+	if len(states) > 0 {
+		*s = slurmRESTState(states[0])
+	}
+	return nil
+}
+
+type slurmRESTDBJobsResponse struct {
+	Jobs   []slurmRESTDBJob `json:"jobs"`
+	Errors []slurmRESTError `json:"errors"`
+}
+
+type slurmRESTDBJob struct {
+	JobID int `json:"job_id"`
+	State struct {
+		Current slurmRESTState `json:"current"`
+	} `json:"state"`
+	ExitCode struct {
+		ReturnCode int `json:"return_code"`
+		Signal     struct {
+			SignalID int `json:"signal_id"`
+		} `json:"signal"`
+	} `json:"exit_code"`
+	Time struct {
+		Elapsed int `json:"elapsed"`
+	} `json:"time"`
+	Nodes string `json:"nodes"`
+	Steps []struct {
+		TRES struct {
+			Requested struct {
+				Max []struct {
+					Type  string `json:"type"`
+					Count int64  `json:"count"`
+				} `json:"max"`
+			} `json:"requested"`
+		} `json:"tres"`
+	} `json:"steps"`
+}
+
+type slurmRESTNodesResponse struct {
+	Nodes  []slurmRESTNode  `json:"nodes"`
+	Errors []slurmRESTError `json:"errors"`
+}
+
+type slurmRESTNode struct {
+	Name       string        `json:"name"`
+	CPUs       int           `json:"cpus"`
+	RealMemory int           `json:"real_memory"`
+	Gres       string        `json:"gres"`
+	TRES       string        `json:"tres"`
+	Partitions slurmRESTList `json:"partitions"`
+	Features   slurmRESTList `json:"features"`
+	// State is the base state in older API versions, along with StateFlags,
+	// and the list of both in newer ones
+	State      slurmRESTList `json:"state"`
+	StateFlags slurmRESTList `json:"state_flags"`
+}
+
+// slurmRESTList is a list, which is a comma separated string in older API
+// versions and an array in newer ones.
+type slurmRESTList []string
+
+func (l *slurmRESTList) UnmarshalJSON(data []byte) error {
+	var list string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = nil
+		if list != "" {
+			*l = strings.Split(list, ",")
+		}
+		return nil
+	}
+
+	var items []string
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
+}
+
+type slurmRESTReservationsResponse struct {
+	Reservations []struct {
+		Name     string `json:"name"`
+		NodeList string `json:"node_list"`
+	} `json:"reservations"`
+	Errors []slurmRESTError `json:"errors"`
+}
+
+// do sends a request to slurmrestd and decodes the JSON response in out.
+func (s *SlurmREST) do(
+	ctx context.Context,
+	method string,
+	path string,
+	body interface{},
+	out interface{},
+) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-SLURM-USER-NAME", s.user)
+	req.Header.Set("X-SLURM-USER-TOKEN", s.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf(
+			"slurmrestd %s %s returned %s: %s",
+			method,
+			path,
+			resp.Status,
+			strings.TrimSpace(string(data)),
+		)
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func joinSlurmRESTErrors(errs []slurmRESTError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, strings.TrimSpace(e.Error+" "+e.Description))
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+func (s *SlurmREST) slurmPath(format string, args ...interface{}) string {
+	return "/slurm/" + SlurmRESTAPIVersion + fmt.Sprintf(format, args...)
+}
+
+func (s *SlurmREST) slurmdbPath(format string, args ...interface{}) string {
+	return "/slurmdb/" + SlurmRESTAPIVersion + fmt.Sprintf(format, args...)
+}
+
+// CancelJob kills a job by sending a DELETE request to slurmrestd.
+func (s *SlurmREST) CancelJob(ctx context.Context, req *CancelRequest) error {
+	if err := s.do(ctx, http.MethodDelete, s.slurmPath("/job/%d", req.JobID), nil, nil); err != nil {
+		log.Printf("cancel failed: %s", err)
+		return err
+	}
+	return nil
+}
+
+// Submit a sbatch definition script to the SLURM controller through slurmrestd.
+func (s *SlurmREST) Submit(ctx context.Context, req *SubmitRequest) (string, error) {
+	output := req.Output
+	if output == "" {
+		output = JobOutput
+	}
+
+	var resp slurmRESTJobSubmitResponse
+	if err := s.do(ctx, http.MethodPost, s.slurmPath("/job/submit"), &slurmRESTJobSubmitRequest{
+		Script: req.Body,
+		Job: slurmRESTJobProperties{
+			Name:                    req.Name,
+			QOS:                     QosName,
+			StandardOutput:          output,
+			CurrentWorkingDirectory: filepath.Dir(output),
+			Environment:             []string{"PATH=/usr/local/bin:/usr/bin:/bin"},
+		},
+	}, &resp); err != nil {
+		log.Printf("submit failed: %s", err)
+		return "", err
+	}
+
+	if err := joinSlurmRESTErrors(resp.Errors); err != nil {
+		log.Printf("submit failed: %s", err)
+		return "", err
+	}
+
+	return strconv.Itoa(resp.JobID), nil
+}
+
+// HealthCheck pings the SLURM controller through slurmrestd.
+func (s *SlurmREST) HealthCheck(ctx context.Context) error {
+	if err := s.do(ctx, http.MethodGet, s.slurmPath("/ping"), nil, nil); err != nil {
+		log.Printf("healthcheck failed: %s", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SlurmREST) findJob(ctx context.Context, jobID int) (*slurmRESTJob, error) {
+	var resp slurmRESTJobsResponse
+	if err := s.do(ctx, http.MethodGet, s.slurmPath("/job/%d", jobID), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	if err := joinSlurmRESTErrors(resp.Errors); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Jobs) == 0 {
+		return nil, fmt.Errorf("job %d not found", jobID)
+	}
+	return &resp.Jobs[0], nil
+}
+
+// FindRunningJobByID returns the job ID if the job is pending or running.
+func (s *SlurmREST) FindRunningJobByID(
+	ctx context.Context,
+	req *FindRunningJobByIDRequest,
+) (int, error) {
+	job, err := s.findJob(ctx, req.JobID)
+	if err != nil && strings.Contains(err.Error(), slurmInvalidJobID) {
+		// The job left the controller memory
+		return 0, ErrJobNotRunning
+	}
+	if err != nil {
+		log.Printf("FindRunningJobByID failed: %s", err)
+		return 0, err
+	}
+
+	switch job.JobState {
+	case "PENDING", "RUNNING", "CONFIGURING", "COMPLETING", "SUSPENDED", "REQUEUED":
+		return job.JobID, nil
+	}
+	return 0, ErrJobNotRunning
+}
+
+// nodeFilter returns a function selecting the nodes of the selection.
+func (s *SlurmREST) nodeFilter(ctx context.Context) (func(*SlurmNode) bool, error) {
+	var reserved string
+	if s.selection.Reservation != "" {
+		var resp slurmRESTReservationsResponse
+		if err := s.do(
+			ctx,
+			http.MethodGet,
+			s.slurmPath("/reservation/%s", s.selection.Reservation),
+			nil,
+			&resp,
+		); err != nil {
+			log.Printf("Failed to find reservation %s: %s", s.selection.Reservation, err)
+			return nil, err
+		}
+		if err := joinSlurmRESTErrors(resp.Errors); err != nil {
+			log.Printf("Failed to find reservation %s: %s", s.selection.Reservation, err)
+			return nil, err
+		}
+		if len(resp.Reservations) == 0 {
+			return nil, fmt.Errorf("reservation %s not found", s.selection.Reservation)
+		}
+		reserved = resp.Reservations[0].NodeList
+	}
+	return selectionFilter(s.selection, reserved)
+}
+
+// FindNodeResources returns the resources of the schedulable nodes of the
+// selection with GPUs, or of all of them if none has GPUs.
+func (s *SlurmREST) FindNodeResources(ctx context.Context) ([]NodeResources, error) {
+	var resp slurmRESTNodesResponse
+	if err := s.do(ctx, http.MethodGet, s.slurmPath("/nodes"), nil, &resp); err != nil {
+		log.Printf("FindNodeResources failed: %s", err)
+		return nil, err
+	}
+
+	if err := joinSlurmRESTErrors(resp.Errors); err != nil {
+		log.Printf("FindNodeResources failed: %s", err)
+		return nil, err
+	}
+
+	selected, err := s.nodeFilter(ctx)
+	if err != nil {
+		log.Printf("Invalid node selection: %s", err)
+		return nil, err
+	}
+
+	resources := make([]NodeResources, 0, len(resp.Nodes))
+	for _, node := range resp.Nodes {
+		slurmNode := SlurmNode{
+			Name:       node.Name,
+			Partitions: node.Partitions,
+			State:      strings.Join(append(node.State, node.StateFlags...), "+"),
+			Features:   node.Features,
+		}
+		if !selected(&slurmNode) || !slurmNode.Schedulable() {
+			continue
+		}
+		var gpu int
+		if match := gpuGresRegex.FindStringSubmatch(node.Gres); match != nil {
+			gpu, _ = strconv.Atoi(match[1])
+		}
+		resources = append(resources, NodeResources{
+			Name:   node.Name,
+			Memory: node.RealMemory,
+			GPUs:   gpu,
+			CPUs:   node.CPUs,
+		})
+	}
+
+	if len(resources) == 0 {
+		return nil, errors.New("no schedulable node in the selection")
+	}
+	return gpuNodes(resources), nil
+}
+
+func (s *SlurmREST) FindMemPerNode(ctx context.Context) (int, error) {
+	nodes, err := s.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("FindMemPerNode failed: %s", err)
+		return 0, err
+	}
+
+	return MinNodeResources(nodes).Memory, nil
+}
+
+func (s *SlurmREST) FindGPUPerNode(ctx context.Context) (int, error) {
+	nodes, err := s.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("FindGPUPerNode failed: %s", err)
+		return 0, err
+	}
+
+	gpu := MinNodeResources(nodes).GPUs
+	if gpu == 0 {
+		return 0, errors.New("no gpu found in the gres of the nodes")
+	}
+	return gpu, nil
+}
+
+func (s *SlurmREST) FindCPUPerNode(ctx context.Context) (int, error) {
+	nodes, err := s.FindNodeResources(ctx)
+	if err != nil {
+		log.Printf("FindCPUPerNode failed : %s", err)
+		return 0, err
+	}
+
+	return MinNodeResources(nodes).CPUs, nil
+}
+
+// FindGPUMemory cannot be queried through slurmrestd.
+func (s *SlurmREST) FindGPUMemory(ctx context.Context) (int, error) {
+	return 0, errors.New("the GPU memory cannot be queried through slurmrestd")
+}
+
+// FindGPUModel cannot be queried through slurmrestd.
+func (s *SlurmREST) FindGPUModel(ctx context.Context) (string, error) {
+	return "", errors.New("the GPU model cannot be queried through slurmrestd")
+}
+
+// FindCPUAffinity cannot be queried through slurmrestd. An empty affinity
+// lets hpl.sh pick one.
+func (s *SlurmREST) FindCPUAffinity(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (s *SlurmREST) FindJobOutputFile(ctx context.Context, jobID int) (string, error) {
+	job, err := s.findJob(ctx, jobID)
+	if err != nil {
+		log.Printf("FindJobOutputFile failed : %s", err)
+		return "", err
+	}
+
+	return strings.ReplaceAll(job.StandardOutput, "%j", strconv.Itoa(jobID)), nil
+}
+
+// FindJobState fetches the final state of a job from the accounting database.
+func (s *SlurmREST) FindJobState(ctx context.Context, jobID int) (*JobState, error) {
+	var resp slurmRESTDBJobsResponse
+	if err := s.do(ctx, http.MethodGet, s.slurmdbPath("/job/%d", jobID), nil, &resp); err != nil {
+		log.Printf("FindJobState failed : %s", err)
+		return nil, err
+	}
+
+	if err := joinSlurmRESTErrors(resp.Errors); err != nil {
+		log.Printf("FindJobState failed : %s", err)
+		return nil, err
+	}
+
+	if len(resp.Jobs) == 0 {
+		return nil, fmt.Errorf("job %d not found in accounting", jobID)
+	}
+	job := resp.Jobs[0]
+
+	var maxRSS int64
+	for _, step := range job.Steps {
+		for _, tres := range step.TRES.Requested.Max {
+			if tres.Type == "mem" && tres.Count > maxRSS {
+				maxRSS = tres.Count
+			}
+		}
+	}
+
+	state := &JobState{
+		State: string(job.State.Current),
+		ExitCode: fmt.Sprintf(
+			"%d:%d",
+			job.ExitCode.ReturnCode,
+			job.ExitCode.Signal.SignalID,
+		),
+		Elapsed:  time.Duration(job.Time.Elapsed) * time.Second,
+		NodeList: job.Nodes,
+	}
+	if maxRSS > 0 {
+		state.MaxRSS = fmt.Sprintf("%dK", maxRSS/1024)
+	}
+
+	return state, nil
+}
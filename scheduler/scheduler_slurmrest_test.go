@@ -0,0 +1,338 @@
+//go:build unit
+
+package scheduler_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/stretchr/testify/suite"
+)
+
+var token = "fakeToken"
+
+type SlurmRESTTestSuite struct {
+	suite.Suite
+	mux    *http.ServeMux
+	server *httptest.Server
+	impl   *scheduler.SlurmREST
+}
+
+func (suite *SlurmRESTTestSuite) BeforeTest(suiteName, testName string) {
+	suite.mux = http.NewServeMux()
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-SLURM-USER-NAME") != admin ||
+			r.Header.Get("X-SLURM-USER-TOKEN") != token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		suite.mux.ServeHTTP(w, r)
+	}))
+	suite.impl = scheduler.NewSlurmREST(
+		suite.server.Client(),
+		suite.server.URL,
+		admin,
+		token,
+		scheduler.NodeSelection{},
+	)
+}
+
+func (suite *SlurmRESTTestSuite) AfterTest(suiteName, testName string) {
+	suite.server.Close()
+}
+
+func (suite *SlurmRESTTestSuite) handle(method, path, body string) {
+	suite.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func (suite *SlurmRESTTestSuite) TestSubmit() {
+	// Arrange
+	var got map[string]interface{}
+	suite.mux.HandleFunc("/slurm/v0.0.39/job/submit", func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal(http.MethodPost, r.Method)
+		suite.NoError(json.NewDecoder(r.Body).Decode(&got))
+		_, _ = io.WriteString(w, `{"job_id": 123, "errors": []}`)
+	})
+	req := &scheduler.SubmitRequest{
+		Name:   "name",
+		User:   user,
+		Body:   "#!/bin/sh\n\nsrun sleep infinity\n",
+		Output: "/runs/1/job-%j.log",
+	}
+
+	// Act
+	jobID, err := suite.impl.Submit(context.Background(), req)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("123", jobID)
+	suite.Equal(req.Body, got["script"])
+	job := got["job"].(map[string]interface{})
+	suite.Equal("name", job["name"])
+	suite.Equal("/runs/1/job-%j.log", job["standard_output"])
+	suite.Equal("/runs/1", job["current_working_directory"])
+}
+
+func (suite *SlurmRESTTestSuite) TestSubmitError() {
+	// Arrange
+	suite.handle(
+		http.MethodPost,
+		"/slurm/v0.0.39/job/submit",
+		`{"errors": [{"error": "Invalid qos specification", "error_number": 2066}]}`,
+	)
+
+	// Act
+	_, err := suite.impl.Submit(context.Background(), &scheduler.SubmitRequest{})
+
+	// Assert
+	suite.ErrorContains(err, "Invalid qos specification")
+}
+
+func (suite *SlurmRESTTestSuite) TestUnauthorized() {
+	// Arrange
+	suite.impl = scheduler.NewSlurmREST(
+		suite.server.Client(),
+		suite.server.URL,
+		admin,
+		"wrong token",
+		scheduler.NodeSelection{},
+	)
+	suite.handle(http.MethodGet, "/slurm/v0.0.39/ping", `{}`)
+
+	// Act
+	err := suite.impl.HealthCheck(context.Background())
+
+	// Assert
+	suite.Error(err)
+}
+
+func (suite *SlurmRESTTestSuite) TestCancel() {
+	// Arrange
+	suite.handle(http.MethodDelete, "/slurm/v0.0.39/job/123", `{}`)
+
+	// Act
+	err := suite.impl.CancelJob(context.Background(), &scheduler.CancelRequest{
+		JobID: 123,
+		User:  user,
+	})
+
+	// Assert
+	suite.NoError(err)
+}
+
+func (suite *SlurmRESTTestSuite) TestHealthCheck() {
+	// Arrange
+	suite.handle(http.MethodGet, "/slurm/v0.0.39/ping", `{"pings": [{"hostname": "ctl", "ping": "UP"}]}`)
+
+	// Act
+	err := suite.impl.HealthCheck(context.Background())
+
+	// Assert
+	suite.NoError(err)
+}
+
+func (suite *SlurmRESTTestSuite) TestFindRunningJobByID() {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{
+			name: "Running",
+			body: `{"jobs": [{"job_id": 123, "job_state": "RUNNING"}]}`,
+		},
+		{
+			name: "Running with state flags",
+			body: `{"jobs": [{"job_id": 123, "job_state": ["PENDING"]}]}`,
+		},
+		{
+			name:    "Completed",
+			body:    `{"jobs": [{"job_id": 123, "job_state": "COMPLETED"}]}`,
+			wantErr: true,
+		},
+	}
+	var body string
+	suite.mux.HandleFunc("/slurm/v0.0.39/job/123", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body)
+	})
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			body = tt.body
+
+			// Act
+			jobID, err := suite.impl.FindRunningJobByID(
+				context.Background(),
+				&scheduler.FindRunningJobByIDRequest{JobID: 123, User: user},
+			)
+
+			// Assert
+			if tt.wantErr {
+				suite.Error(err)
+			} else {
+				suite.NoError(err)
+				suite.Equal(123, jobID)
+			}
+		})
+	}
+}
+
+func (suite *SlurmRESTTestSuite) TestFindJobOutputFile() {
+	// Arrange
+	suite.handle(
+		http.MethodGet,
+		"/slurm/v0.0.39/job/123",
+		`{"jobs": [{"job_id": 123, "job_state": "RUNNING", "standard_output": "/runs/1/job-%j.log"}]}`,
+	)
+
+	// Act
+	out, err := suite.impl.FindJobOutputFile(context.Background(), 123)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("/runs/1/job-123.log", out)
+}
+
+func (suite *SlurmRESTTestSuite) TestFindJobState() {
+	// Arrange
+	suite.handle(http.MethodGet, "/slurmdb/v0.0.39/job/123", `{"jobs": [{
+		"job_id": 123,
+		"state": {"current": "TIMEOUT"},
+		"exit_code": {"return_code": 0, "signal": {"signal_id": 15}},
+		"time": {"elapsed": 3600},
+		"nodes": "node[1-2]",
+		"steps": [
+			{"tres": {"requested": {"max": [{"type": "mem", "count": 2097152}]}}},
+			{"tres": {"requested": {"max": [{"type": "cpu", "count": 1}]}}}
+		]
+	}]}`)
+
+	// Act
+	out, err := suite.impl.FindJobState(context.Background(), 123)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(&scheduler.JobState{
+		State:    scheduler.JobStateTimeout,
+		ExitCode: "0:15",
+		Elapsed:  time.Hour,
+		NodeList: "node[1-2]",
+		MaxRSS:   "2048K",
+	}, out)
+}
+
+func (suite *SlurmRESTTestSuite) TestNodeInventory() {
+	// Arrange
+	suite.handle(http.MethodGet, "/slurm/v0.0.39/nodes", `{"nodes": [{
+		"name": "node1",
+		"cpus": 64,
+		"real_memory": 512000,
+		"gres": "gpu:a100:4(S:0-1)",
+		"tres": "cpu=64,mem=500G,billing=64,gres/gpu=4"
+	}]}`)
+	ctx := context.Background()
+
+	// Act
+	mem, memErr := suite.impl.FindMemPerNode(ctx)
+	gpu, gpuErr := suite.impl.FindGPUPerNode(ctx)
+	cpu, cpuErr := suite.impl.FindCPUPerNode(ctx)
+
+	// Assert
+	suite.NoError(memErr)
+	suite.NoError(gpuErr)
+	suite.NoError(cpuErr)
+	suite.Equal(512000, mem)
+	suite.Equal(4, gpu)
+	suite.Equal(64, cpu)
+}
+
+func (suite *SlurmRESTTestSuite) TestFindNodeResourcesSelection() {
+	// Arrange
+	suite.impl = scheduler.NewSlurmREST(
+		suite.server.Client(),
+		suite.server.URL,
+		admin,
+		token,
+		scheduler.NodeSelection{
+			Partition:   "gpu",
+			Exclude:     "gpu02",
+			Constraint:  "a100",
+			Reservation: "bench",
+		},
+	)
+	suite.handle(http.MethodGet, "/slurm/v0.0.39/nodes", `{"nodes": [
+		{"name": "cpu01", "cpus": 128, "real_memory": 256000, "partitions": ["cpu"], "features": "epyc"},
+		{"name": "gpu01", "cpus": 64, "real_memory": 512000, "gres": "gpu:a100:4", "partitions": ["gpu"], "features": "a100,ib"},
+		{"name": "gpu02", "cpus": 64, "real_memory": 512000, "gres": "gpu:a100:4", "partitions": ["gpu"], "features": "a100,ib"},
+		{"name": "gpu03", "cpus": 64, "real_memory": 512000, "gres": "gpu:v100:4", "partitions": ["gpu"], "features": "v100"},
+		{"name": "gpu04", "cpus": 64, "real_memory": 512000, "gres": "gpu:a100:4", "partitions": ["gpu"], "features": ["a100"]}
+	]}`)
+	suite.handle(http.MethodGet, "/slurm/v0.0.39/reservation/bench", `{"reservations": [{
+		"name": "bench",
+		"node_list": "gpu[01-03]"
+	}]}`)
+
+	// Act
+	nodes, err := suite.impl.FindNodeResources(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal([]scheduler.NodeResources{
+		{Name: "gpu01", Memory: 512000, GPUs: 4, CPUs: 64},
+	}, nodes)
+}
+
+func (suite *SlurmRESTTestSuite) TestFindNodeResourcesNoMatch() {
+	// Arrange
+	suite.impl = scheduler.NewSlurmREST(
+		suite.server.Client(),
+		suite.server.URL,
+		admin,
+		token,
+		scheduler.NodeSelection{Partition: "gpu"},
+	)
+	suite.handle(http.MethodGet, "/slurm/v0.0.39/nodes", `{"nodes": [
+		{"name": "cpu01", "cpus": 128, "real_memory": 256000, "partitions": ["cpu"]}
+	]}`)
+
+	// Act
+	_, err := suite.impl.FindNodeResources(context.Background())
+
+	// Assert
+	suite.ErrorContains(err, "no schedulable node in the selection")
+}
+
+func (suite *SlurmRESTTestSuite) TestFindNodeResourcesUnschedulable() {
+	// Arrange
+	suite.handle(http.MethodGet, "/slurm/v0.0.39/nodes", `{"nodes": [
+		{"name": "gpu01", "cpus": 64, "real_memory": 512000, "gres": "gpu:4", "state": ["IDLE"]},
+		{"name": "gpu02", "cpus": 32, "real_memory": 256000, "gres": "gpu:2", "state": ["IDLE"], "state_flags": ["DRAIN"]},
+		{"name": "gpu03", "cpus": 32, "real_memory": 256000, "gres": "gpu:2", "state": ["DOWN"]}
+	]}`)
+
+	// Act
+	nodes, err := suite.impl.FindNodeResources(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal([]scheduler.NodeResources{
+		{Name: "gpu01", Memory: 512000, GPUs: 4, CPUs: 64},
+	}, nodes)
+}
+
+func TestSlurmRESTTestSuite(t *testing.T) {
+	suite.Run(t, &SlurmRESTTestSuite{})
+}
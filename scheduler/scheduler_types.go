@@ -1,14 +1,23 @@
 package scheduler
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrJobNotRunning is returned by FindRunningJobByID when the job is no longer
+// queued or running. Other errors do not tell whether the job is finished.
+var ErrJobNotRunning = errors.New("no running jobs found")
 
 type Executor interface {
 	ExecAs(ctx context.Context, user string, cmd string) (string, error)
 }
 
 type CancelRequest struct {
-	// Name of the job
-	Name string
+	// JobID of the job
+	JobID int
 	// User is a UNIX User used for impersonation.
 	User string
 }
@@ -20,6 +29,11 @@ type SubmitRequest struct {
 	User string
 	// Body of the job
 	Body string
+	// Output is the path of the job standard output. Defaults to JobOutput.
+	Output string
+	// Files needed by the job, by name. Schedulers sharing a filesystem with
+	// the compute nodes can ignore them.
+	Files map[string]string
 }
 
 type FindRunningJobByNameRequest struct {
@@ -28,3 +42,160 @@ type FindRunningJobByNameRequest struct {
 	// User is a UNIX User used for impersonation. This user should be SLURM admin.
 	User string
 }
+
+type FindRunningJobByIDRequest struct {
+	// JobID of the job
+	JobID int
+	// User is a UNIX User used for impersonation. This user should be SLURM admin.
+	User string
+}
+
+// NodeSelection restricts the nodes used by the benchmark. Empty fields do
+// not restrict anything.
+type NodeSelection struct {
+	// Partition of the nodes
+	Partition string `json:"partition,omitempty"`
+	// NodeList is a host list of the nodes, e.g. gpu[01-04]
+	NodeList string `json:"nodeList,omitempty"`
+	// Exclude is a host list of nodes to leave out
+	Exclude string `json:"exclude,omitempty"`
+	// Constraint on the node features, e.g. a100&ib
+	Constraint string `json:"constraint,omitempty"`
+	// Reservation containing the nodes
+	Reservation string `json:"reservation,omitempty"`
+}
+
+// Job is a handle on a submitted benchmark job.
+type Job struct {
+	// ID is the job ID returned by the scheduler
+	ID int `json:"id"`
+	// Name of the job
+	Name string `json:"name"`
+	// SubmitTime is the time at which the job was submitted
+	SubmitTime time.Time `json:"submitTime"`
+	// OutputFile is the path of the job standard output
+	OutputFile string `json:"outputFile"`
+}
+
+const (
+	JobStateCompleted   = "COMPLETED"
+	JobStateFailed      = "FAILED"
+	JobStateCancelled   = "CANCELLED"
+	JobStateTimeout     = "TIMEOUT"
+	JobStateOutOfMemory = "OUT_OF_MEMORY"
+	JobStatePreempted   = "PREEMPTED"
+	JobStateNodeFail    = "NODE_FAIL"
+	JobStateBootFail    = "BOOT_FAIL"
+	JobStateRequeued    = "REQUEUED"
+)
+
+// JobState is the final accounting information of a job.
+type JobState struct {
+	// State of the job allocation, e.g. COMPLETED, FAILED, TIMEOUT
+	State string
+	// ExitCode of the job, formatted as "exit:signal"
+	ExitCode string
+	// Elapsed time of the job
+	Elapsed time.Duration
+	// NodeList on which the job ran
+	NodeList string
+	// MaxRSS is the highest resident set size among the job steps
+	MaxRSS string
+}
+
+// Completed reports whether the job finished successfully.
+func (s *JobState) Completed() bool {
+	return s.State == JobStateCompleted
+}
+
+func (s *JobState) String() string {
+	return fmt.Sprintf(
+		"%s (exit code %s, elapsed %s, nodes %s, max RSS %s)",
+		s.State,
+		s.ExitCode,
+		s.Elapsed,
+		s.NodeList,
+		s.MaxRSS,
+	)
+}
+
+// Finished reports whether the state is final. Accounting may still report a
+// job which left the queue as pending, running or completing.
+func (s *JobState) Finished() bool {
+	switch s.State {
+	case "PENDING", "RUNNING", "COMPLETING", "CONFIGURING", "SUSPENDED", "STOPPED",
+		"RESIZING", "SIGNALING", "STAGE_OUT", "REQUEUE_HOLD", "REQUEUE_FED":
+		return false
+	}
+	return true
+}
+
+// Retriable reports whether the job failed for reasons unrelated to the
+// benchmark itself, so that running it again may succeed.
+func (s *JobState) Retriable() bool {
+	switch s.State {
+	case JobStatePreempted, JobStateNodeFail, JobStateBootFail, JobStateRequeued:
+		return true
+	}
+	return false
+}
+
+// NodeFailed reports whether the job failed because of its nodes.
+func (s *JobState) NodeFailed() bool {
+	return s.State == JobStateNodeFail || s.State == JobStateBootFail
+}
+
+// NodeResources are the resources of a node which can run the benchmark.
+type NodeResources struct {
+	Name string `json:"name"`
+	// Memory in MB
+	Memory int `json:"memory"`
+	GPUs   int `json:"gpus"`
+	CPUs   int `json:"cpus"`
+}
+
+// gpuNodes returns the nodes with GPUs, or all the nodes if none has.
+func gpuNodes(nodes []NodeResources) []NodeResources {
+	var gpu []NodeResources
+	for _, node := range nodes {
+		if node.GPUs > 0 {
+			gpu = append(gpu, node)
+		}
+	}
+	if len(gpu) == 0 {
+		return nodes
+	}
+	return gpu
+}
+
+// MinNodeResources returns the smallest memory, GPU and CPU counts among the
+// nodes, so that the benchmark fits on any of them.
+func MinNodeResources(nodes []NodeResources) NodeResources {
+	if len(nodes) == 0 {
+		return NodeResources{}
+	}
+
+	smallest := NodeResources{
+		Memory: nodes[0].Memory,
+		GPUs:   nodes[0].GPUs,
+		CPUs:   nodes[0].CPUs,
+	}
+	for _, node := range nodes[1:] {
+		smallest.Memory = min(smallest.Memory, node.Memory)
+		smallest.GPUs = min(smallest.GPUs, node.GPUs)
+		smallest.CPUs = min(smallest.CPUs, node.CPUs)
+	}
+	return smallest
+}
+
+// Heterogeneous reports whether the nodes differ in memory, GPU or CPU count.
+func Heterogeneous(nodes []NodeResources) bool {
+	for _, node := range nodes {
+		if node.Memory != nodes[0].Memory ||
+			node.GPUs != nodes[0].GPUs ||
+			node.CPUs != nodes[0].CPUs {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package screen
+
+import (
+	"encoding/csv"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/squarefactory/benchmark-api/stats"
+)
+
+// DefaultThreshold is the default drop below the median Gflops of the
+// partition from which a node is an outlier.
+const DefaultThreshold = 0.1
+
+// Status is the verdict of the screening of a node.
+type Status string
+
+const (
+	// StatusOK is a node within the threshold of the median
+	StatusOK Status = "ok"
+	// StatusSlow is a node below the median by more than the threshold
+	StatusSlow Status = "slow"
+	// StatusFailed is a node whose benchmark failed because of the node: the
+	// node failed, or the results did not pass the residual check
+	StatusFailed Status = "failed"
+	// StatusNotScreened is a node whose benchmark did not run to completion
+	// for reasons unrelated to the node, e.g. it timed out in the queue or
+	// was cancelled
+	StatusNotScreened Status = "not screened"
+)
+
+// NodeResult is the outcome of the benchmark of a single node.
+type NodeResult struct {
+	Node  string `json:"node"`
+	JobID int    `json:"jobId,omitempty"`
+	// Gflops is the best performance which passed the residual check, 0 when
+	// the benchmark failed
+	Gflops float64 `json:"gflops"`
+	// Error is the reason of the failure of the benchmark, if any
+	Error string `json:"error,omitempty"`
+	// NodeFault is set when the benchmark failed because of the node
+	NodeFault bool `json:"nodeFault,omitempty"`
+}
+
+// NodeReport is the screening of a node.
+type NodeReport struct {
+	NodeResult
+	// Delta is the relative difference with the median of the partition
+	Delta  float64 `json:"delta"`
+	Status Status  `json:"status"`
+}
+
+// Report is the screening of the nodes of a partition.
+type Report struct {
+	// Summary describes the Gflops of the nodes whose benchmark succeeded
+	Summary   stats.Summary `json:"summary"`
+	Threshold float64       `json:"threshold"`
+	Nodes     []NodeReport  `json:"nodes"`
+}
+
+// Evaluate compares the Gflops of each node with the median of the nodes
+// whose benchmark succeeded. Nodes below the median by more than threshold,
+// e.g. 0.1 for 10%, are slow. Failures are only held against the node when
+// they are its fault, the other nodes are not screened. Nodes are sorted by
+// name.
+func Evaluate(results []NodeResult, threshold float64) Report {
+	var gflops []float64
+	for _, result := range results {
+		if result.Error == "" && result.Gflops > 0 {
+			gflops = append(gflops, result.Gflops)
+		}
+	}
+
+	report := Report{
+		Summary:   stats.Summarize(gflops),
+		Threshold: threshold,
+		Nodes:     make([]NodeReport, 0, len(results)),
+	}
+	median := report.Summary.Median
+	for _, result := range results {
+		node := NodeReport{NodeResult: result, Status: StatusOK}
+		switch {
+		case result.NodeFault:
+			node.Status = StatusFailed
+		case result.Error != "" || result.Gflops <= 0:
+			node.Status = StatusNotScreened
+		default:
+			node.Delta = (result.Gflops - median) / median
+			if node.Delta < -threshold {
+				node.Status = StatusSlow
+			}
+		}
+		report.Nodes = append(report.Nodes, node)
+	}
+	slices.SortFunc(report.Nodes, func(a, b NodeReport) int {
+		return strings.Compare(a.Node, b.Node)
+	})
+	return report
+}
+
+// Outliers returns the nodes which are slow or whose benchmark failed because
+// of them.
+func (r *Report) Outliers() []NodeReport {
+	return r.filter(StatusSlow, StatusFailed)
+}
+
+// NotScreened returns the nodes whose benchmark did not run to completion.
+func (r *Report) NotScreened() []NodeReport {
+	return r.filter(StatusNotScreened)
+}
+
+func (r *Report) filter(statuses ...Status) []NodeReport {
+	var nodes []NodeReport
+	for _, node := range r.Nodes {
+		if slices.Contains(statuses, node.Status) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// WriteCSV writes a line per node.
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{
+		"Node",
+		"JobID",
+		"Gflops",
+		"Delta",
+		"Status",
+		"Error",
+	}); err != nil {
+		return err
+	}
+	for _, node := range r.Nodes {
+		jobID := ""
+		if node.JobID > 0 {
+			jobID = strconv.Itoa(node.JobID)
+		}
+		if err := writer.Write([]string{
+			node.Node,
+			jobID,
+			strconv.FormatFloat(node.Gflops, 'f', -1, 64),
+			strconv.FormatFloat(node.Delta, 'f', 4, 64),
+			string(node.Status),
+			node.Error,
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,116 @@
+package screen_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/squarefactory/benchmark-api/screen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluate(t *testing.T) {
+	results := []screen.NodeResult{
+		{Node: "gpu04", JobID: 14, Gflops: 80},
+		{Node: "gpu01", JobID: 11, Gflops: 100},
+		{Node: "gpu03", JobID: 13, Gflops: 95},
+		{Node: "gpu02", JobID: 12, Gflops: 102},
+		{Node: "gpu05", JobID: 15, Error: "job 15 did not complete: NODE_FAIL", NodeFault: true},
+		{Node: "gpu06", JobID: 16, Gflops: 110},
+		{Node: "gpu07", JobID: 17, Error: "job 17 did not finish in time"},
+	}
+	tests := []struct {
+		name      string
+		threshold float64
+		expected  map[string]screen.Status
+	}{
+		{
+			name:      "Default threshold",
+			threshold: screen.DefaultThreshold,
+			expected: map[string]screen.Status{
+				"gpu01": screen.StatusOK,
+				"gpu02": screen.StatusOK,
+				"gpu03": screen.StatusOK,
+				"gpu04": screen.StatusSlow,
+				"gpu05": screen.StatusFailed,
+				"gpu06": screen.StatusOK,
+				"gpu07": screen.StatusNotScreened,
+			},
+		},
+		{
+			name:      "Strict threshold",
+			threshold: 0.02,
+			expected: map[string]screen.Status{
+				"gpu01": screen.StatusOK,
+				"gpu02": screen.StatusOK,
+				"gpu03": screen.StatusSlow,
+				"gpu04": screen.StatusSlow,
+				"gpu05": screen.StatusFailed,
+				"gpu06": screen.StatusOK,
+				"gpu07": screen.StatusNotScreened,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := screen.Evaluate(results, tt.threshold)
+
+			assert.Equal(t, 5, report.Summary.Count)
+			assert.Equal(t, 100.0, report.Summary.Median)
+			require.Len(t, report.Nodes, len(results))
+			for i, node := range report.Nodes {
+				if i > 0 {
+					assert.Less(t, report.Nodes[i-1].Node, node.Node)
+				}
+				assert.Equal(t, tt.expected[node.Node], node.Status, node.Node)
+			}
+			assert.InDelta(t, -0.2, report.Nodes[3].Delta, 1e-9)
+		})
+	}
+}
+
+func TestReportOutliers(t *testing.T) {
+	report := screen.Evaluate([]screen.NodeResult{
+		{Node: "gpu01", Gflops: 100},
+		{Node: "gpu02", Gflops: 50},
+		{Node: "gpu03", Gflops: 101},
+		{Node: "gpu04", Error: "no run passed the residual check", NodeFault: true},
+		{Node: "gpu05", Error: "job 15 did not complete: CANCELLED"},
+		{Node: "gpu06"},
+	}, screen.DefaultThreshold)
+
+	outliers := report.Outliers()
+	notScreened := report.NotScreened()
+
+	require.Len(t, outliers, 2)
+	assert.Equal(t, "gpu02", outliers[0].Node)
+	assert.Equal(t, screen.StatusSlow, outliers[0].Status)
+	assert.Equal(t, "gpu04", outliers[1].Node)
+	assert.Equal(t, screen.StatusFailed, outliers[1].Status)
+	require.Len(t, notScreened, 2)
+	assert.Equal(t, "gpu05", notScreened[0].Node)
+	assert.Equal(t, "gpu06", notScreened[1].Node)
+}
+
+func TestReportWriteCSV(t *testing.T) {
+	report := screen.Evaluate([]screen.NodeResult{
+		{Node: "gpu01", JobID: 11, Gflops: 100},
+		{Node: "gpu02", JobID: 12, Gflops: 80},
+		{Node: "gpu03", Error: "failed to submit"},
+		{Node: "gpu04", JobID: 14, Error: "job 14 did not complete: NODE_FAIL", NodeFault: true},
+	}, screen.DefaultThreshold)
+	var buf bytes.Buffer
+
+	err := report.WriteCSV(&buf)
+
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"Node,JobID,Gflops,Delta,Status,Error\n"+
+			"gpu01,11,100,0.1111,ok,\n"+
+			"gpu02,12,80,-0.1111,slow,\n"+
+			"gpu03,,0,0.0000,not screened,failed to submit\n"+
+			"gpu04,14,0,0.0000,failed,job 14 did not complete: NODE_FAIL\n",
+		buf.String(),
+	)
+}
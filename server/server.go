@@ -0,0 +1,450 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/config"
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/squarefactory/benchmark-api/scheduler"
+)
+
+// State is the state of a run.
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateCanceling State = "canceling"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+	StateCanceled  State = "canceled"
+)
+
+// Done returns whether the run is over.
+func (s State) Done() bool {
+	return s == StateCompleted || s == StateFailed || s == StateCanceled
+}
+
+// Request submits a run.
+type Request struct {
+	// Nodes is the number of nodes of the benchmark
+	Nodes int `json:"nodes"`
+	// Partition overrides the partition of the server
+	Partition string `json:"partition,omitempty"`
+	// Config is the run configuration, as in the file of the run command.
+	// Omitted fields keep their default values.
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// Submission is a validated request.
+type Submission struct {
+	Nodes     int
+	Partition string
+	Config    *config.Config
+}
+
+// Run is the status of a run.
+type Run struct {
+	ID      string  `json:"id"`
+	Path    string  `json:"path"`
+	Request Request `json:"request"`
+	State   State   `json:"state"`
+	// Set is the set being run, first or second
+	Set string `json:"set,omitempty"`
+	// Jobs are the IDs of the completed jobs
+	Jobs []int `json:"jobs"`
+	// Results is the number of results of the completed jobs
+	Results    int        `json:"results"`
+	Error      string     `json:"error,omitempty"`
+	SubmitTime time.Time  `json:"submitTime"`
+	EndTime    *time.Time `json:"endTime,omitempty"`
+}
+
+// Runner runs a benchmark in its run directory, reporting its progress. It
+// stops when the context is cancelled.
+type Runner func(
+	ctx context.Context,
+	runDir *benchmark.RunDir,
+	submission Submission,
+	progress *Progress,
+) error
+
+// Options secure the API.
+type Options struct {
+	// Token authorizes the requests, sent as a bearer token. No request is
+	// authorized when it is empty.
+	Token string
+	// AllowContainerOverride lets the configurations of the requests set the
+	// container of their run, which is then run as the admin user
+	AllowContainerOverride bool
+	// Config is the base configuration of the runs, which the configurations
+	// of the requests overlay. The default configuration when nil.
+	Config *config.Config
+}
+
+// Server runs benchmarks in the background, submitted through a REST API:
+//
+//	POST /runs                 submits a run
+//	GET  /runs                 lists the runs
+//	GET  /runs/{id}            returns the status of a run
+//	GET  /runs/{id}/results    returns the results, ?format=json|ndjson|csv
+//	POST /runs/{id}/cancel     cancels a run and its job
+//
+// Every request must be authorized by the bearer token of the options.
+type Server struct {
+	ctx     context.Context
+	runsDir string
+	runner  Runner
+	opts    Options
+
+	mu   sync.Mutex
+	runs map[string]*run
+	// ids are the IDs of the runs in submission order
+	ids []string
+	wg  sync.WaitGroup
+}
+
+type run struct {
+	status  Run
+	cancel  context.CancelFunc
+	results []resultparser.Result
+}
+
+// New creates a server whose runs are created under runsDir, and cancelled
+// when ctx is.
+func New(ctx context.Context, runsDir string, runner Runner, opts Options) *Server {
+	return &Server{
+		ctx:     ctx,
+		runsDir: runsDir,
+		runner:  runner,
+		opts:    opts,
+		runs:    map[string]*run{},
+	}
+}
+
+// Handler returns the handler of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /runs", s.submit)
+	mux.HandleFunc("GET /runs", s.list)
+	mux.HandleFunc("GET /runs/{id}", s.get)
+	mux.HandleFunc("GET /runs/{id}/results", s.results)
+	mux.HandleFunc("POST /runs/{id}/cancel", s.cancel)
+	return s.authenticate(mux)
+}
+
+// authenticate rejects the requests without the bearer token.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok ||
+			s.opts.Token == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hpl-ai"`)
+			writeError(w, http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Wait waits for the runs to end.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// Submit starts a run in the background and returns its status.
+func (s *Server) Submit(req Request) (Run, error) {
+	submission, err := req.validate(s.opts)
+	if err != nil {
+		return Run{}, err
+	}
+
+	runDir, err := benchmark.NewRunDir(s.runsDir)
+	if err != nil {
+		log.Printf("failed to create run directory: %s", err)
+		return Run{}, err
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	r := &run{
+		status: Run{
+			ID:         runDir.ID,
+			Path:       runDir.Path,
+			Request:    req,
+			State:      StateRunning,
+			Jobs:       []int{},
+			SubmitTime: time.Now(),
+		},
+		cancel: cancel,
+	}
+	s.mu.Lock()
+	s.runs[runDir.ID] = r
+	s.ids = append(s.ids, runDir.ID)
+	status := r.snapshot()
+	s.mu.Unlock()
+
+	log.Printf("run %s submitted on %d nodes", runDir.ID, submission.Nodes)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		err := s.runner(ctx, runDir, submission, &Progress{server: s, id: runDir.ID})
+		s.finish(runDir.ID, ctx, err)
+	}()
+	return status, nil
+}
+
+func (s *Server) finish(id string, ctx context.Context, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.runs[id]
+	now := time.Now()
+	r.status.EndTime = &now
+	switch {
+	case ctx.Err() != nil:
+		r.status.State = StateCanceled
+	case err != nil:
+		r.status.State = StateFailed
+	default:
+		r.status.State = StateCompleted
+	}
+	if err != nil {
+		r.status.Error = err.Error()
+	}
+	r.status.Set = ""
+	log.Printf("run %s %s", id, r.status.State)
+}
+
+// Cancel cancels a run, and returns its status.
+func (s *Server) Cancel(id string) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[id]
+	if !ok {
+		return Run{}, errNotFound
+	}
+	if r.status.State.Done() {
+		return Run{}, fmt.Errorf("run %s is already %s", id, r.status.State)
+	}
+	r.status.State = StateCanceling
+	r.cancel()
+	return r.snapshot(), nil
+}
+
+// Get returns the status of a run.
+func (s *Server) Get(id string) (Run, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[id]
+	if !ok {
+		return Run{}, false
+	}
+	return r.snapshot(), true
+}
+
+// List returns the status of the runs, in submission order.
+func (s *Server) List() []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := make([]Run, 0, len(s.ids))
+	for _, id := range s.ids {
+		runs = append(runs, s.runs[id].snapshot())
+	}
+	return runs
+}
+
+// Results returns the results of the completed jobs of a run.
+func (s *Server) Results(id string) ([]resultparser.Result, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[id]
+	if !ok {
+		return nil, false
+	}
+	return append([]resultparser.Result{}, r.results...), true
+}
+
+// snapshot copies the status, so that it can be read without the lock.
+func (r *run) snapshot() Run {
+	status := r.status
+	status.Jobs = append([]int{}, r.status.Jobs...)
+	return status
+}
+
+// Progress records the progress of a run. It is the observer of the run.
+type Progress struct {
+	server *Server
+	id     string
+}
+
+// SetStarted records the set being run.
+func (p *Progress) SetStarted(set string) {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	p.server.runs[p.id].status.Set = set
+}
+
+// JobCompleted records a completed job and its results.
+func (p *Progress) JobCompleted(job *scheduler.Job, results []resultparser.Result) {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	r := p.server.runs[p.id]
+	r.status.Jobs = append(r.status.Jobs, job.ID)
+	r.status.Results += len(results)
+	r.results = append(r.results, results...)
+}
+
+var (
+	errNotFound     = errors.New("run not found")
+	errUnauthorized = errors.New("missing or invalid bearer token")
+)
+
+// validationError is an invalid request.
+type validationError struct {
+	error
+}
+
+func (e validationError) Unwrap() error {
+	return e.error
+}
+
+func (req Request) validate(opts Options) (Submission, error) {
+	if req.Nodes < 1 {
+		return Submission{}, validationError{
+			fmt.Errorf("invalid node count %d, must be at least 1", req.Nodes),
+		}
+	}
+
+	base := opts.Config
+	if base == nil {
+		base = config.Default()
+	}
+	cfg := base
+	if len(req.Config) > 0 && string(req.Config) != "null" {
+		var err error
+		cfg, err = config.Overlay(base, req.Config)
+		if err != nil {
+			return Submission{}, validationError{err}
+		}
+	}
+	if cfg.ContainerPath != base.ContainerPath && !opts.AllowContainerOverride {
+		return Submission{}, validationError{
+			errors.New("containerPath is not allowed by the server, the container is set by its operator"),
+		}
+	}
+	return Submission{
+		Nodes:     req.Nodes,
+		Partition: req.Partition,
+		Config:    cfg,
+	}, nil
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	status, err := s.Submit(req)
+	if err != nil {
+		var validation validationError
+		if errors.As(err, &validation) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", "/runs/"+status.ID)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.List())
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	status, ok := s.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) results(w http.ResponseWriter, r *http.Request) {
+	format := resultparser.FormatJSON
+	if name := r.URL.Query().Get("format"); name != "" {
+		var err error
+		format, err = resultparser.ParseFormat(name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	results, ok := s.Results(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypes[format])
+	if err := resultparser.Export(w, format, results); err != nil {
+		log.Printf("failed to write results: %s", err)
+	}
+}
+
+func (s *Server) cancel(w http.ResponseWriter, r *http.Request) {
+	status, err := s.Cancel(r.PathValue("id"))
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+var contentTypes = map[resultparser.Format]string{
+	resultparser.FormatCSV:    "text/csv; charset=utf-8",
+	resultparser.FormatJSON:   "application/json",
+	resultparser.FormatNDJSON: "application/x-ndjson",
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{
+		Error: err.Error(),
+	})
+}
@@ -0,0 +1,329 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/config"
+	"github.com/squarefactory/benchmark-api/resultparser"
+	"github.com/squarefactory/benchmark-api/scheduler"
+	"github.com/squarefactory/benchmark-api/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const token = "s3cr3t"
+
+func newServer(t *testing.T, runner server.Runner) *httptest.Server {
+	return newServerWithOptions(t, runner, server.Options{Token: token})
+}
+
+func newServerWithOptions(t *testing.T, runner server.Runner, opts server.Options) *httptest.Server {
+	s := server.New(context.Background(), t.TempDir(), runner, opts)
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.Wait()
+	})
+	return ts
+}
+
+func do(t *testing.T, method string, url string, body string) (*http.Response, []byte) {
+	return doWithToken(t, token, method, url, body)
+}
+
+func doWithToken(
+	t *testing.T,
+	token string,
+	method string,
+	url string,
+	body string,
+) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, data
+}
+
+func decodeRun(t *testing.T, data []byte) server.Run {
+	var run server.Run
+	require.NoError(t, json.Unmarshal(data, &run))
+	return run
+}
+
+// waitDone polls the status of the run until it is over.
+func waitDone(t *testing.T, url string) server.Run {
+	var run server.Run
+	require.Eventually(t, func() bool {
+		_, data := do(t, http.MethodGet, url, "")
+		run = decodeRun(t, data)
+		return run.State.Done()
+	}, 5*time.Second, 10*time.Millisecond)
+	return run
+}
+
+func TestSubmit(t *testing.T) {
+	var submission server.Submission
+	ts := newServer(t, func(
+		ctx context.Context,
+		runDir *benchmark.RunDir,
+		s server.Submission,
+		progress *server.Progress,
+	) error {
+		submission = s
+		progress.SetStarted("first")
+		progress.JobCompleted(&scheduler.Job{ID: 42}, []resultparser.Result{
+			{Run: resultparser.Run{N: 1000, NB: 256, P: 1, Q: 2, Gflops: 1.5e4}},
+			{Run: resultparser.Run{N: 2000, NB: 256, P: 1, Q: 2, Gflops: 2.5e4}},
+		})
+		return nil
+	})
+
+	resp, data := do(
+		t,
+		http.MethodPost,
+		ts.URL+"/runs",
+		`{"nodes": 2, "partition": "gpu", "config": {"blockSizes": [256], "repetitions": 3}}`,
+	)
+
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, string(data))
+	submitted := decodeRun(t, data)
+	assert.Equal(t, "/runs/"+submitted.ID, resp.Header.Get("Location"))
+	assert.Equal(t, server.StateRunning, submitted.State)
+
+	run := waitDone(t, ts.URL+"/runs/"+submitted.ID)
+	assert.Equal(t, server.StateCompleted, run.State)
+	assert.Equal(t, []int{42}, run.Jobs)
+	assert.Equal(t, 2, run.Results)
+	assert.NotNil(t, run.EndTime)
+	assert.Equal(t, 2, submission.Nodes)
+	assert.Equal(t, "gpu", submission.Partition)
+	assert.Equal(t, []int{256}, submission.Config.BlockSizes)
+	assert.Equal(t, 3, submission.Config.Repetitions)
+
+	resp, data = do(t, http.MethodGet, ts.URL+"/runs", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var runs []server.Run
+	require.NoError(t, json.Unmarshal(data, &runs))
+	require.Len(t, runs, 1)
+	assert.Equal(t, submitted.ID, runs[0].ID)
+
+	resp, data = do(t, http.MethodGet, ts.URL+"/runs/"+submitted.ID+"/results", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var results []resultparser.Result
+	require.NoError(t, json.Unmarshal(data, &results))
+	require.Len(t, results, 2)
+	assert.Equal(t, 2.5e4, results[1].Gflops)
+
+	resp, data = do(t, http.MethodGet, ts.URL+"/runs/"+submitted.ID+"/results?format=csv", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[1], "1000,256,1,2,"), lines[1])
+}
+
+func TestSubmitFailed(t *testing.T) {
+	ts := newServer(t, func(
+		context.Context,
+		*benchmark.RunDir,
+		server.Submission,
+		*server.Progress,
+	) error {
+		return errors.New("no node available")
+	})
+
+	_, data := do(t, http.MethodPost, ts.URL+"/runs", `{"nodes": 1}`)
+	run := waitDone(t, ts.URL+"/runs/"+decodeRun(t, data).ID)
+
+	assert.Equal(t, server.StateFailed, run.State)
+	assert.Equal(t, "no node available", run.Error)
+}
+
+func TestSubmitInvalid(t *testing.T) {
+	ts := newServer(t, func(
+		context.Context,
+		*benchmark.RunDir,
+		server.Submission,
+		*server.Progress,
+	) error {
+		t.Error("invalid runs must not be started")
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "Missing node count",
+			body:    `{}`,
+			wantErr: "invalid node count 0",
+		},
+		{
+			name:    "Unknown field",
+			body:    `{"nodes": 1, "node": 2}`,
+			wantErr: "invalid request",
+		},
+		{
+			name:    "Invalid configuration",
+			body:    `{"nodes": 1, "config": {"memoryFractions": [80]}}`,
+			wantErr: "memoryFractions[0]: 80 is not in (0, 1]",
+		},
+		{
+			name:    "Container override",
+			body:    `{"nodes": 1, "config": {"containerPath": "/tmp/evil.sqsh"}}`,
+			wantErr: "containerPath is not allowed by the server",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, data := do(t, http.MethodPost, ts.URL+"/runs", tt.body)
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.Contains(t, string(data), tt.wantErr)
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	started := make(chan struct{})
+	ts := newServer(t, func(
+		ctx context.Context,
+		_ *benchmark.RunDir,
+		_ server.Submission,
+		_ *server.Progress,
+	) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	_, data := do(t, http.MethodPost, ts.URL+"/runs", `{"nodes": 1}`)
+	id := decodeRun(t, data).ID
+	<-started
+
+	resp, data := do(t, http.MethodPost, ts.URL+"/runs/"+id+"/cancel", "")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, string(data))
+	assert.Equal(t, server.StateCanceling, decodeRun(t, data).State)
+
+	run := waitDone(t, ts.URL+"/runs/"+id)
+	assert.Equal(t, server.StateCanceled, run.State)
+
+	resp, _ = do(t, http.MethodPost, ts.URL+"/runs/"+id+"/cancel", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestNotFound(t *testing.T) {
+	ts := newServer(t, nil)
+
+	for _, path := range []string{"/runs/unknown", "/runs/unknown/results"} {
+		resp, _ := do(t, http.MethodGet, ts.URL+path, "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+	resp, _ := do(t, http.MethodPost, ts.URL+"/runs/unknown/cancel", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestContainerOverride(t *testing.T) {
+	submissions := make(chan server.Submission, 1)
+	ts := newServerWithOptions(t, func(
+		_ context.Context,
+		_ *benchmark.RunDir,
+		s server.Submission,
+		_ *server.Progress,
+	) error {
+		submissions <- s
+		return nil
+	}, server.Options{Token: token, AllowContainerOverride: true})
+
+	resp, data := do(
+		t,
+		http.MethodPost,
+		ts.URL+"/runs",
+		`{"nodes": 1, "config": {"containerPath": "/opt/hpl.sqsh"}}`,
+	)
+
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, string(data))
+	assert.Equal(t, "/opt/hpl.sqsh", (<-submissions).Config.ContainerPath)
+}
+
+func TestBaseConfig(t *testing.T) {
+	base, err := config.Parse([]byte(`{"blockSizes": [512], "repetitions": 5, "containerPath": "/opt/hpl.sqsh"}`))
+	require.NoError(t, err)
+	submissions := make(chan server.Submission, 1)
+	ts := newServerWithOptions(t, func(
+		_ context.Context,
+		_ *benchmark.RunDir,
+		s server.Submission,
+		_ *server.Progress,
+	) error {
+		submissions <- s
+		return nil
+	}, server.Options{Token: token, Config: base})
+
+	resp, data := do(t, http.MethodPost, ts.URL+"/runs", `{"nodes": 1, "config": {"repetitions": 3}}`)
+
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, string(data))
+	submission := <-submissions
+	assert.Equal(t, []int{512}, submission.Config.BlockSizes)
+	assert.Equal(t, 3, submission.Config.Repetitions)
+	assert.Equal(t, "/opt/hpl.sqsh", submission.Config.ContainerPath)
+}
+
+func TestUnauthorized(t *testing.T) {
+	runner := func(
+		context.Context,
+		*benchmark.RunDir,
+		server.Submission,
+		*server.Progress,
+	) error {
+		t.Error("unauthorized runs must not be started")
+		return nil
+	}
+	tests := []struct {
+		name   string
+		server string
+		token  string
+	}{
+		{name: "Missing token", server: token},
+		{name: "Invalid token", server: token, token: "guess"},
+		{name: "No token configured", token: "guess"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newServerWithOptions(t, runner, server.Options{Token: tt.server})
+
+			for _, route := range []struct{ method, path string }{
+				{http.MethodPost, "/runs"},
+				{http.MethodGet, "/runs"},
+				{http.MethodGet, "/runs/unknown"},
+				{http.MethodGet, "/runs/unknown/results"},
+				{http.MethodPost, "/runs/unknown/cancel"},
+			} {
+				resp, data := doWithToken(t, tt.token, route.method, ts.URL+route.path, `{"nodes": 1}`)
+
+				assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, route.path)
+				assert.Equal(t, `Bearer realm="hpl-ai"`, resp.Header.Get("WWW-Authenticate"))
+				assert.Contains(t, string(data), "missing or invalid bearer token")
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package stats
+
+import (
+	"errors"
+	"math"
+	"slices"
+)
+
+// maxExactProduct is the largest product of the sample sizes for which the
+// exact distribution of U is computed, when there are no ties.
+const maxExactProduct = 400
+
+// MannWhitney is the result of a two-sided Mann–Whitney U test.
+type MannWhitney struct {
+	// U is the statistic of the first sample: the number of pairs in which
+	// it is greater, ties counting for one half
+	U float64 `json:"u"`
+	// P is the probability of a difference at least as large if both samples
+	// came from the same distribution
+	P float64 `json:"p"`
+	// Exact is set when P comes from the exact distribution of U, rather than
+	// its normal approximation
+	Exact bool `json:"exact"`
+}
+
+// MannWhitneyU tests whether the samples come from the same distribution,
+// without assuming it is normal. P is exact for small samples without ties,
+// and uses the normal approximation with tie and continuity corrections
+// otherwise.
+func MannWhitneyU(x, y []float64) (MannWhitney, error) {
+	if len(x) == 0 || len(y) == 0 {
+		return MannWhitney{}, errors.New("the Mann-Whitney U test needs two non-empty samples")
+	}
+
+	type value struct {
+		v     float64
+		first bool
+	}
+	values := make([]value, 0, len(x)+len(y))
+	for _, v := range x {
+		values = append(values, value{v: v, first: true})
+	}
+	for _, v := range y {
+		values = append(values, value{v: v})
+	}
+	slices.SortFunc(values, func(a, b value) int {
+		switch {
+		case a.v < b.v:
+			return -1
+		case a.v > b.v:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	// Rank sum of x, ties getting the mean of their ranks
+	var rankSum, tieTerm float64
+	ties := false
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j].v == values[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if values[k].first {
+				rankSum += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieTerm += t*t*t - t
+		}
+		i = j
+	}
+
+	n1, n2 := float64(len(x)), float64(len(y))
+	u := rankSum - n1*(n1+1)/2
+
+	if !ties && len(x)*len(y) <= maxExactProduct {
+		return MannWhitney{U: u, P: exactP(u, len(x), len(y)), Exact: true}, nil
+	}
+
+	n := n1 + n2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		// All the values are equal
+		return MannWhitney{U: u, P: 1}, nil
+	}
+	z := math.Max(0, math.Abs(u-mean)-0.5) / math.Sqrt(variance)
+	return MannWhitney{U: u, P: math.Min(1, math.Erfc(z/math.Sqrt2))}, nil
+}
+
+// exactP returns the two-sided p-value of u, from the distribution of U for
+// samples of sizes m and n without ties.
+func exactP(u float64, m, n int) float64 {
+	// counts[j][k] is the number of arrangements of i values of the first
+	// sample and j of the second for which U is k, for increasing i
+	counts := make([][]float64, n+1)
+	for j := range counts {
+		counts[j] = make([]float64, m*n+1)
+		counts[j][0] = 1
+	}
+	for i := 1; i <= m; i++ {
+		next := make([][]float64, n+1)
+		for j := range next {
+			next[j] = make([]float64, m*n+1)
+		}
+		next[0][0] = 1
+		for j := 1; j <= n; j++ {
+			for k := 0; k <= i*j; k++ {
+				// The largest value is from the second sample, which adds
+				// nothing to U, or from the first one, which adds j
+				next[j][k] = next[j-1][k]
+				if k >= j {
+					next[j][k] += counts[j][k-j]
+				}
+			}
+		}
+		counts = next
+	}
+
+	var total, lower, upper float64
+	for k, count := range counts[n] {
+		total += count
+		if float64(k) <= u {
+			lower += count
+		}
+		if float64(k) >= u {
+			upper += count
+		}
+	}
+	return math.Min(1, 2*math.Min(lower, upper)/total)
+}
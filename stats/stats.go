@@ -0,0 +1,190 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Summary describes a sample of Gflops.
+type Summary struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	StdDev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	// CILow and CIHigh bound the 95% confidence interval of the mean. It is
+	// undefined for a single value, bounded by -Inf and +Inf.
+	CILow  Bound `json:"ciLow"`
+	CIHigh Bound `json:"ciHigh"`
+}
+
+// Bound is a bound of a confidence interval, infinite when undefined. It is
+// encoded as "-Inf" or "+Inf" in JSON, which has no infinite numbers.
+type Bound float64
+
+func (b Bound) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(b), 0) {
+		return json.Marshal(strconv.FormatFloat(float64(b), 'g', -1, 64))
+	}
+	return json.Marshal(float64(b))
+}
+
+func (b *Bound) UnmarshalJSON(data []byte) error {
+	var v float64
+	if err := json.Unmarshal(data, &v); err == nil {
+		*b = Bound(v)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid bound %s", data)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !math.IsInf(v, 0) {
+		return fmt.Errorf("invalid bound %s", data)
+	}
+	*b = Bound(v)
+	return nil
+}
+
+// Summarize computes the summary of values, the zero Summary when empty.
+func Summarize(values []float64) Summary {
+	if len(values) == 0 {
+		return Summary{}
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	mean := sum / float64(n)
+
+	median := sorted[n/2]
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+
+	// Sample standard deviation
+	var stddev float64
+	if n > 1 {
+		var squares float64
+		for _, v := range sorted {
+			squares += (v - mean) * (v - mean)
+		}
+		stddev = math.Sqrt(squares / float64(n-1))
+	}
+
+	// A single value says nothing of the spread
+	margin := math.Inf(1)
+	if n > 1 {
+		margin = studentT975(n-1) * stddev / math.Sqrt(float64(n))
+	}
+
+	return Summary{
+		Count:  n,
+		Mean:   mean,
+		Median: median,
+		StdDev: stddev,
+		Min:    sorted[0],
+		Max:    sorted[n-1],
+		CILow:  Bound(mean - margin),
+		CIHigh: Bound(mean + margin),
+	}
+}
+
+// tQuantiles are the 97.5% quantiles of the Student t distribution, for 1 to
+// 30 degrees of freedom.
+var tQuantiles = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// studentT975 returns the 97.5% quantile of the Student t distribution.
+func studentT975(df int) float64 {
+	switch {
+	case df <= len(tQuantiles):
+		return tQuantiles[df-1]
+	case df <= 40:
+		return 2.021
+	case df <= 60:
+		return 2.000
+	case df <= 120:
+		return 1.980
+	default:
+		return 1.960
+	}
+}
+
+// Criterion selects the best configuration from the summaries.
+type Criterion string
+
+const (
+	// CriterionMax selects the highest single result, which rewards noise
+	CriterionMax Criterion = "max"
+	// CriterionMean selects the highest mean
+	CriterionMean Criterion = "mean"
+	// CriterionMedian selects the highest median
+	CriterionMedian Criterion = "median"
+	// CriterionCILow selects the highest lower bound of the confidence
+	// interval, favouring stable configurations. Configurations which ran
+	// once rank last.
+	CriterionCILow Criterion = "ci-low"
+)
+
+// Criteria are the available criteria.
+var Criteria = []Criterion{CriterionMax, CriterionMean, CriterionMedian, CriterionCILow}
+
+// DefaultCriterion is used when no criterion is given.
+const DefaultCriterion = CriterionMedian
+
+// ParseCriterion returns the criterion with the given name, the default one
+// when empty.
+func ParseCriterion(name string) (Criterion, error) {
+	if name == "" {
+		return DefaultCriterion, nil
+	}
+	c := Criterion(name)
+	if !slices.Contains(Criteria, c) {
+		names := make([]string, 0, len(Criteria))
+		for _, c := range Criteria {
+			names = append(names, string(c))
+		}
+		return "", fmt.Errorf(
+			"unknown criterion %q, must be one of: %s",
+			name,
+			strings.Join(names, ", "),
+		)
+	}
+	return c, nil
+}
+
+// Score returns the value of the summary compared by the criterion.
+func (c Criterion) Score(s Summary) float64 {
+	switch c {
+	case CriterionMax:
+		return s.Max
+	case CriterionMean:
+		return s.Mean
+	case CriterionCILow:
+		return float64(s.CILow)
+	default:
+		return s.Median
+	}
+}
+
+// Statistical is true when the criterion needs repeated results to be better
+// than the highest single result.
+func (c Criterion) Statistical() bool {
+	return c != CriterionMax
+}
@@ -0,0 +1,197 @@
+package stats_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/squarefactory/benchmark-api/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		expected stats.Summary
+	}{
+		{
+			name:     "Empty",
+			values:   nil,
+			expected: stats.Summary{},
+		},
+		{
+			name:   "Single value",
+			values: []float64{42},
+			expected: stats.Summary{
+				Count:  1,
+				Mean:   42,
+				Median: 42,
+				Min:    42,
+				Max:    42,
+				CILow:  stats.Bound(math.Inf(-1)),
+				CIHigh: stats.Bound(math.Inf(1)),
+			},
+		},
+		{
+			name:   "Odd count",
+			values: []float64{30, 10, 20},
+			expected: stats.Summary{
+				Count:  3,
+				Mean:   20,
+				Median: 20,
+				StdDev: 10,
+				Min:    10,
+				Max:    30,
+				// t(0.975, 2) * 10 / sqrt(3)
+				CILow:  20 - 24.84338,
+				CIHigh: 20 + 24.84338,
+			},
+		},
+		{
+			name:   "Even count",
+			values: []float64{4, 1, 3, 2},
+			expected: stats.Summary{
+				Count:  4,
+				Mean:   2.5,
+				Median: 2.5,
+				StdDev: 1.290994,
+				Min:    1,
+				Max:    4,
+				CILow:  2.5 - 2.053972,
+				CIHigh: 2.5 + 2.053972,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := stats.Summarize(tt.values)
+
+			assert.Equal(t, tt.expected.Count, s.Count)
+			assert.InDeltaSlice(
+				t,
+				[]float64{
+					tt.expected.Mean,
+					tt.expected.Median,
+					tt.expected.StdDev,
+					tt.expected.Min,
+					tt.expected.Max,
+				},
+				[]float64{s.Mean, s.Median, s.StdDev, s.Min, s.Max},
+				1e-5,
+			)
+			assertBound(t, tt.expected.CILow, s.CILow)
+			assertBound(t, tt.expected.CIHigh, s.CIHigh)
+		})
+	}
+}
+
+func assertBound(t *testing.T, expected, actual stats.Bound) {
+	t.Helper()
+	if math.IsInf(float64(expected), 0) {
+		assert.Equal(t, expected, actual)
+		return
+	}
+	assert.InDelta(t, float64(expected), float64(actual), 1e-5)
+}
+
+func TestBoundJSON(t *testing.T) {
+	s := stats.Summarize([]float64{42})
+
+	data, err := json.Marshal(s)
+
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"ciLow":"-Inf","ciHigh":"+Inf"`)
+
+	var decoded stats.Summary
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, s, decoded)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"ciLow":1.5,"ciHigh":2.5}`), &decoded))
+	assert.Equal(t, stats.Bound(1.5), decoded.CILow)
+	assert.Error(t, json.Unmarshal([]byte(`{"ciLow":"low"}`), &decoded))
+}
+
+func TestCriterionScore(t *testing.T) {
+	s := stats.Summary{Mean: 2, Median: 3, Max: 4, CILow: 1}
+
+	assert.Equal(t, 4.0, stats.CriterionMax.Score(s))
+	assert.Equal(t, 2.0, stats.CriterionMean.Score(s))
+	assert.Equal(t, 3.0, stats.CriterionMedian.Score(s))
+	assert.Equal(t, 1.0, stats.CriterionCILow.Score(s))
+}
+
+func TestParseCriterion(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected stats.Criterion
+		isError  bool
+	}{
+		{name: "", expected: stats.CriterionMedian},
+		{name: "ci-low", expected: stats.CriterionCILow},
+		{name: "best", isError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			criterion, err := stats.ParseCriterion(tt.name)
+
+			if (err != nil) != tt.isError {
+				t.Fatalf("ParseCriterion() error = %v, isError %v", err, tt.isError)
+			}
+			assert.Equal(t, tt.expected, criterion)
+		})
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	tests := []struct {
+		name     string
+		x, y     []float64
+		expected stats.MannWhitney
+	}{
+		{
+			name:     "Separated",
+			x:        []float64{1, 2, 3, 4, 5},
+			y:        []float64{6, 7, 8, 9, 10},
+			expected: stats.MannWhitney{U: 0, P: 2.0 / 252, Exact: true},
+		},
+		{
+			name:     "Interleaved",
+			x:        []float64{1, 3, 5, 7, 9},
+			y:        []float64{2, 4, 6, 8, 10},
+			expected: stats.MannWhitney{U: 10, P: 0.6904761904761905, Exact: true},
+		},
+		{
+			name:     "Different sizes",
+			x:        []float64{10.1, 10.3, 9.8, 10.0, 10.2, 10.4},
+			y:        []float64{9.5, 9.7, 9.6, 9.9, 9.4, 9.3, 9.2},
+			expected: stats.MannWhitney{U: 41, P: 0.002331002331002331, Exact: true},
+		},
+		{
+			name:     "Ties",
+			x:        []float64{1, 2, 2, 3},
+			y:        []float64{2, 3, 3, 4},
+			expected: stats.MannWhitney{U: 3, P: 0.17203370892182296},
+		},
+		{
+			name:     "Equal",
+			x:        []float64{5, 5},
+			y:        []float64{5, 5, 5},
+			expected: stats.MannWhitney{U: 3, P: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := stats.MannWhitneyU(tt.x, tt.y)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected.U, result.U)
+			assert.InDelta(t, tt.expected.P, result.P, 1e-12)
+			assert.Equal(t, tt.expected.Exact, result.Exact)
+		})
+	}
+
+	_, err := stats.MannWhitneyU(nil, []float64{1})
+	assert.Error(t, err)
+}
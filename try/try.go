@@ -1,6 +1,7 @@
 package try
 
 import (
+	"context"
 	"log"
 	"time"
 )
@@ -20,3 +21,32 @@ func Do[T interface{}](
 	}
 	return result, err
 }
+
+// DoContext is Do, stopping as soon as the context is done. As fn may fail or
+// succeed because of it, the error of the context is returned then.
+func DoContext[T interface{}](
+	ctx context.Context,
+	fn func() (T, error),
+	tries int,
+	delay time.Duration,
+) (result T, err error) {
+	for try := 0; try < tries; try++ {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		result, err = fn()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return result, ctxErr
+		}
+		if err == nil {
+			break
+		}
+		log.Printf("try failed: %s", err)
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,49 @@
+package tuner
+
+import "time"
+
+// Budget limits the number of jobs and the wall-clock time of a run. Zero
+// limits are unbounded. A nil Budget is never exhausted.
+type Budget struct {
+	MaxJobs     int
+	MaxDuration time.Duration
+
+	start time.Time
+	jobs  int
+}
+
+// NewBudget starts a budget.
+func NewBudget(maxJobs int, maxDuration time.Duration) *Budget {
+	return &Budget{
+		MaxJobs:     maxJobs,
+		MaxDuration: maxDuration,
+		start:       time.Now(),
+	}
+}
+
+// Spend records jobs.
+func (b *Budget) Spend(jobs int) {
+	if b == nil {
+		return
+	}
+	b.jobs += jobs
+}
+
+// Jobs returns the number of jobs spent.
+func (b *Budget) Jobs() int {
+	if b == nil {
+		return 0
+	}
+	return b.jobs
+}
+
+// Exhausted reports whether no other job may be started.
+func (b *Budget) Exhausted() bool {
+	if b == nil {
+		return false
+	}
+	if b.MaxJobs > 0 && b.jobs >= b.MaxJobs {
+		return true
+	}
+	return b.MaxDuration > 0 && time.Since(b.start) >= b.MaxDuration
+}
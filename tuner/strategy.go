@@ -0,0 +1,228 @@
+package tuner
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+)
+
+const (
+	StrategyGrid              = "grid"
+	StrategySuccessiveHalving = "successive-halving"
+	StrategyRandom            = "random"
+)
+
+// Strategies are the names of the tuning strategies.
+var Strategies = []string{StrategyGrid, StrategySuccessiveHalving, StrategyRandom}
+
+const (
+	DefaultEta     = 2
+	DefaultSamples = 10
+)
+
+// Options configure the strategies. Zero values use the defaults.
+type Options struct {
+	// TopK is the number of best candidates refined after the first stage,
+	// no refinement when 0
+	TopK int
+	// Eta is the reduction factor of successive halving
+	Eta int
+	// Samples is the number of candidates drawn by the random search
+	Samples int
+	// Seed of the random search
+	Seed int64
+}
+
+// NewStrategy returns the strategy with the given name, grid when empty.
+func NewStrategy(name string, opts Options) (Strategy, error) {
+	switch name {
+	case "", StrategyGrid:
+		return &Grid{TopK: opts.TopK}, nil
+	case StrategySuccessiveHalving:
+		eta := opts.Eta
+		if eta == 0 {
+			eta = DefaultEta
+		}
+		return &SuccessiveHalving{Eta: eta}, nil
+	case StrategyRandom:
+		samples := opts.Samples
+		if samples == 0 {
+			samples = DefaultSamples
+		}
+		return &Random{
+			Samples: samples,
+			TopK:    opts.TopK,
+			Rand:    rand.New(rand.NewSource(opts.Seed)),
+		}, nil
+	default:
+		return nil, fmt.Errorf(
+			"unknown tuning strategy %q, must be one of: %s",
+			name,
+			strings.Join(Strategies, ", "),
+		)
+	}
+}
+
+// Grid evaluates the whole space, then refines around the TopK candidates.
+type Grid struct {
+	TopK int
+}
+
+func (g *Grid) Next(stage int, space Space, results []Result, ranking []Ranking) []Candidate {
+	switch stage {
+	case 0:
+		return space.Candidates()
+	case 1:
+		return refine(space, top(ranking, g.TopK))
+	default:
+		return nil
+	}
+}
+
+// SuccessiveHalving evaluates the whole space, then evaluates again the best
+// 1/Eta candidates of the previous stage until a single one is left. The
+// candidates are ranked over all the stages.
+type SuccessiveHalving struct {
+	Eta int
+}
+
+func (s *SuccessiveHalving) Next(stage int, space Space, results []Result, ranking []Ranking) []Candidate {
+	if stage == 0 {
+		return space.Candidates()
+	}
+
+	var previous []Candidate
+	for _, result := range results {
+		if result.Stage == stage-1 && !slices.Contains(previous, result.Candidate) {
+			previous = append(previous, result.Candidate)
+		}
+	}
+	if len(previous) <= 1 {
+		return nil
+	}
+
+	keep := (len(previous) + s.Eta - 1) / s.Eta
+	var next []Candidate
+	for _, ranked := range ranking {
+		if len(next) == keep {
+			break
+		}
+		if slices.Contains(previous, ranked.Candidate) {
+			next = append(next, ranked.Candidate)
+		}
+	}
+	return next
+}
+
+// Random evaluates Samples candidates drawn from the space, with Ns drawn
+// between the smallest and the largest N and rounded to their NB, then refines
+// around the TopK candidates.
+type Random struct {
+	Samples int
+	TopK    int
+	Rand    *rand.Rand
+}
+
+func (r *Random) Next(stage int, space Space, results []Result, ranking []Ranking) []Candidate {
+	switch stage {
+	case 0:
+		minN, maxN := slices.Min(space.ProblemSizes), slices.Max(space.ProblemSizes)
+		var candidates []Candidate
+		// Small spaces may have fewer distinct candidates than samples
+		for tries := 0; len(candidates) < r.Samples && tries < 100*r.Samples; tries++ {
+			grid := space.Grids[r.Rand.Intn(len(space.Grids))]
+			nb := space.BlockSizes[r.Rand.Intn(len(space.BlockSizes))]
+			candidate := Candidate{
+				N:  benchmark.RoundProblemSize(minN+r.Rand.Intn(maxN-minN+1), nb),
+				NB: nb,
+				P:  grid.P,
+				Q:  grid.Q,
+			}
+			if !slices.Contains(candidates, candidate) {
+				candidates = append(candidates, candidate)
+			}
+		}
+		return candidates
+	case 1:
+		return refine(space, top(ranking, r.TopK))
+	default:
+		return nil
+	}
+}
+
+// top returns the k best candidates.
+func top(ranking []Ranking, k int) []Candidate {
+	var candidates []Candidate
+	for _, ranked := range ranking {
+		if len(candidates) == k {
+			break
+		}
+		candidates = append(candidates, ranked.Candidate)
+	}
+	return candidates
+}
+
+// refine returns the neighbours of the candidates, halfway to the next Ns and
+// NBs of the space, with each N rounded down to a multiple of its NB. Ns never
+// exceed the largest N of the space, which is sized against the memory.
+func refine(space Space, candidates []Candidate) []Candidate {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	ns := slices.Clone(space.ProblemSizes)
+	slices.Sort(ns)
+	nStep := 0
+	for i := 1; i < len(ns); i++ {
+		if step := ns[i] - ns[i-1]; step > 0 && (nStep == 0 || step < nStep) {
+			nStep = step
+		}
+	}
+	if nStep == 0 {
+		// A single N is refined by 5%
+		nStep = ns[0] / 20
+	}
+	maxN := ns[len(ns)-1]
+
+	nbs := slices.Clone(space.BlockSizes)
+	slices.Sort(nbs)
+
+	var refined []Candidate
+	add := func(c Candidate) {
+		if c.N > 0 && c.N <= maxN && c.NB > 0 && !slices.Contains(refined, c) {
+			refined = append(refined, c)
+		}
+	}
+	for _, c := range candidates {
+		for _, n := range []int{c.N - nStep/2, c.N, c.N + nStep/2} {
+			for _, nb := range neighbourBlockSizes(nbs, c.NB) {
+				add(Candidate{N: benchmark.RoundProblemSize(n, nb), NB: nb, P: c.P, Q: c.Q})
+			}
+		}
+	}
+	return refined
+}
+
+// neighbourBlockSizes returns nb and the block sizes halfway to its
+// neighbours, rounded down to a multiple of 32.
+func neighbourBlockSizes(nbs []int, nb int) []int {
+	neighbours := []int{nb}
+	i := slices.Index(nbs, nb)
+	if i < 0 {
+		return neighbours
+	}
+	if i > 0 {
+		if mid := (nbs[i-1] + nb) / 2 / 32 * 32; mid > nbs[i-1] {
+			neighbours = append([]int{mid}, neighbours...)
+		}
+	}
+	if i < len(nbs)-1 {
+		if mid := (nb + nbs[i+1]) / 2 / 32 * 32; mid > nb {
+			neighbours = append(neighbours, mid)
+		}
+	}
+	return neighbours
+}
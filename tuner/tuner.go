@@ -0,0 +1,319 @@
+package tuner
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"slices"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/stats"
+)
+
+// Candidate is a configuration of HPL.
+type Candidate struct {
+	N  int `json:"n"`
+	NB int `json:"nb"`
+	P  int `json:"p"`
+	Q  int `json:"q"`
+	// Variant is the T/V column of HPL. Each job runs all the variants of the
+	// knobs, a candidate without variant stands for all of them.
+	Variant string `json:"variant"`
+}
+
+// matches is true when c is the candidate, or one of its variants when the
+// candidate has no variant.
+func (c Candidate) matches(candidate Candidate) bool {
+	if candidate.Variant == "" {
+		c.Variant = ""
+	}
+	return c == candidate
+}
+
+// Result is the performance of a candidate in one job.
+type Result struct {
+	Candidate
+	// Stage in which the candidate was evaluated
+	Stage  int     `json:"stage"`
+	Gflops float64 `json:"gflops"`
+}
+
+// Space is the product of Ns, NBs and process grids of a DAT file, which HPL
+// sweeps in a single job.
+type Space struct {
+	ProblemSizes []int
+	BlockSizes   []int
+	Grids        []benchmark.Grid
+}
+
+// Candidates returns the candidates of the space, in the order of HPL. The N
+// of each candidate is rounded down to a multiple of its NB, the candidates
+// of an N are therefore run by a job per NB.
+func (s *Space) Candidates() []Candidate {
+	var candidates []Candidate
+	for _, n := range s.ProblemSizes {
+		for _, nb := range s.BlockSizes {
+			for _, grid := range s.Grids {
+				candidate := Candidate{
+					N:  benchmark.RoundProblemSize(n, nb),
+					NB: nb,
+					P:  grid.P,
+					Q:  grid.Q,
+				}
+				if !slices.Contains(candidates, candidate) {
+					candidates = append(candidates, candidate)
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+// Evaluator runs a space in one job and returns its results.
+type Evaluator interface {
+	Evaluate(ctx context.Context, stage int, space Space) ([]Result, error)
+}
+
+// Strategy chooses the candidates of each stage from the results of the
+// previous ones, and their ranking. It returns no candidate once the tuning is
+// over.
+type Strategy interface {
+	Next(stage int, space Space, results []Result, ranking []Ranking) []Candidate
+}
+
+const (
+	// DefaultRepeats is the default number of results of the best candidates
+	// required by a statistical criterion
+	DefaultRepeats = 3
+	// RepeatedCandidates is the number of best candidates repeated
+	RepeatedCandidates = 3
+)
+
+// Tuner runs the stages of a strategy within a budget.
+type Tuner struct {
+	Strategy  Strategy
+	Evaluator Evaluator
+	Budget    *Budget
+	// Criterion ranks the candidates, the default criterion when empty
+	Criterion stats.Criterion
+	// Repeats is the number of results of the best candidates required by a
+	// statistical criterion, which are evaluated again once the strategy is
+	// over. No candidate is repeated when lower than 2.
+	Repeats int
+}
+
+// Run tunes the candidates of the space and returns the best one, along with
+// all the results. The tuning stops early when the budget is exhausted.
+func (t *Tuner) Run(ctx context.Context, space Space) (Ranking, []Result, error) {
+	criterion := t.Criterion
+	if criterion == "" {
+		criterion = stats.DefaultCriterion
+	}
+	var results []Result
+
+	stage := 0
+	for ; ; stage++ {
+		candidates := t.Strategy.Next(stage, space, results, Rank(results, criterion))
+		if len(candidates) == 0 {
+			break
+		}
+		var (
+			exhausted bool
+			err       error
+		)
+		results, exhausted, err = t.evaluate(ctx, stage, candidates, results)
+		if err != nil {
+			return Ranking{}, results, err
+		}
+		if exhausted {
+			break
+		}
+	}
+
+	if criterion.Statistical() && t.Repeats > 1 {
+		var err error
+		results, err = t.repeat(ctx, stage, results)
+		if err != nil {
+			return Ranking{}, results, err
+		}
+	}
+
+	ranking := Rank(results, criterion)
+	if len(ranking) == 0 {
+		return Ranking{}, results, errors.New("no result to select a candidate from")
+	}
+	if criterion.Statistical() && ranking[0].Count < 2 {
+		log.Printf(
+			"warning: the best candidate ran once, the %s criterion is no better than the max",
+			criterion,
+		)
+	}
+	return ranking[0], results, nil
+}
+
+// repeat evaluates the best candidates again, from stage on, until they have
+// Repeats results. The best candidates are ranked by their median, which,
+// unlike the lower bound of the confidence interval, does not rank the
+// candidates which ran once last.
+func (t *Tuner) repeat(ctx context.Context, stage int, results []Result) ([]Result, error) {
+	// Evaluations of each candidate, which may have failed to produce a result
+	evaluations := map[Candidate]int{}
+	for _, result := range results {
+		evaluations[result.Candidate]++
+	}
+
+	for ; ; stage++ {
+		var candidates []Candidate
+		for i, r := range Rank(results, stats.CriterionMedian) {
+			if i == RepeatedCandidates {
+				break
+			}
+			if evaluations[r.Candidate] < t.Repeats {
+				candidates = append(candidates, r.Candidate)
+				evaluations[r.Candidate]++
+			}
+		}
+		if len(candidates) == 0 {
+			return results, nil
+		}
+
+		var (
+			exhausted bool
+			err       error
+		)
+		results, exhausted, err = t.evaluate(ctx, stage, candidates, results)
+		if err != nil || exhausted {
+			return results, err
+		}
+	}
+}
+
+// evaluate runs the candidates of a stage in as few jobs as possible and
+// appends their results. The results of the other variants run by the jobs are
+// left out. It reports whether the budget got exhausted.
+func (t *Tuner) evaluate(
+	ctx context.Context,
+	stage int,
+	candidates []Candidate,
+	results []Result,
+) ([]Result, bool, error) {
+	batches := Pack(candidates)
+	log.Printf("tuning stage %d: %d candidates in %d jobs", stage, len(candidates), len(batches))
+	for _, batch := range batches {
+		if t.Budget.Exhausted() {
+			log.Printf("tuning budget exhausted at stage %d", stage)
+			return results, true, nil
+		}
+
+		batchResults, err := t.Evaluator.Evaluate(ctx, stage, batch)
+		t.Budget.Spend(1)
+		if err != nil {
+			return results, false, err
+		}
+		for _, result := range batchResults {
+			if !slices.ContainsFunc(candidates, result.Candidate.matches) {
+				continue
+			}
+			result.Stage = stage
+			results = append(results, result)
+		}
+	}
+	return results, false, nil
+}
+
+// Ranking is the summary of the results of a candidate.
+type Ranking struct {
+	Candidate
+	stats.Summary
+	// Score of the summary by the criterion
+	Score float64 `json:"score"`
+}
+
+// Rank summarizes the results of each candidate, the best first by the
+// criterion, then by the median for the candidates the criterion does not
+// tell apart, e.g. those which ran once by the lower bound of the confidence
+// interval.
+func Rank(results []Result, criterion stats.Criterion) []Ranking {
+	var order []Candidate
+	gflops := map[Candidate][]float64{}
+	for _, result := range results {
+		if _, ok := gflops[result.Candidate]; !ok {
+			order = append(order, result.Candidate)
+		}
+		gflops[result.Candidate] = append(gflops[result.Candidate], result.Gflops)
+	}
+
+	ranking := make([]Ranking, 0, len(order))
+	for _, candidate := range order {
+		summary := stats.Summarize(gflops[candidate])
+		ranking = append(ranking, Ranking{
+			Candidate: candidate,
+			Summary:   summary,
+			Score:     criterion.Score(summary),
+		})
+	}
+	slices.SortStableFunc(ranking, func(a, b Ranking) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(b.Median, a.Median))
+	})
+	return ranking
+}
+
+// Pack groups candidates into as few spaces as possible, without evaluating
+// any other candidate.
+func Pack(candidates []Candidate) []Space {
+	type group struct {
+		ns    []int
+		nbs   []int
+		grids []benchmark.Grid
+	}
+
+	// Ns of each NB and grid
+	type nbGrid struct {
+		nb   int
+		grid benchmark.Grid
+	}
+	var keys []nbGrid
+	ns := map[nbGrid][]int{}
+	for _, c := range candidates {
+		key := nbGrid{nb: c.NB, grid: benchmark.Grid{P: c.P, Q: c.Q}}
+		if _, ok := ns[key]; !ok {
+			keys = append(keys, key)
+		}
+		if !slices.Contains(ns[key], c.N) {
+			ns[key] = append(ns[key], c.N)
+		}
+	}
+
+	// NBs sharing the Ns of a grid
+	var byGrid []*group
+	for _, key := range keys {
+		i := slices.IndexFunc(byGrid, func(g *group) bool {
+			return g.grids[0] == key.grid && slices.Equal(g.ns, ns[key])
+		})
+		if i < 0 {
+			byGrid = append(byGrid, &group{ns: ns[key], grids: []benchmark.Grid{key.grid}})
+			i = len(byGrid) - 1
+		}
+		byGrid[i].nbs = append(byGrid[i].nbs, key.nb)
+	}
+
+	// Grids sharing the Ns and NBs
+	var groups []*group
+	for _, g := range byGrid {
+		i := slices.IndexFunc(groups, func(other *group) bool {
+			return slices.Equal(other.ns, g.ns) && slices.Equal(other.nbs, g.nbs)
+		})
+		if i < 0 {
+			groups = append(groups, g)
+			continue
+		}
+		groups[i].grids = append(groups[i].grids, g.grids...)
+	}
+
+	spaces := make([]Space, 0, len(groups))
+	for _, g := range groups {
+		spaces = append(spaces, Space{ProblemSizes: g.ns, BlockSizes: g.nbs, Grids: g.grids})
+	}
+	return spaces
+}
@@ -0,0 +1,429 @@
+package tuner_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/benchmark-api/benchmark"
+	"github.com/squarefactory/benchmark-api/stats"
+	"github.com/squarefactory/benchmark-api/tuner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// variant is the T/V column of the results of the evaluator.
+const variant = "WR01C2R4"
+
+// evaluator scores candidates with a function of their parameters, and
+// records the evaluated spaces.
+type evaluator struct {
+	gflops func(c tuner.Candidate) float64
+	spaces []tuner.Space
+}
+
+func (e *evaluator) Evaluate(_ context.Context, _ int, space tuner.Space) ([]tuner.Result, error) {
+	e.spaces = append(e.spaces, space)
+	var results []tuner.Result
+	for _, c := range space.Candidates() {
+		gflops := e.gflops(c)
+		c.Variant = variant
+		results = append(results, tuner.Result{Candidate: c, Gflops: gflops})
+	}
+	return results, nil
+}
+
+// peak scores the candidates by their distance to N=8448 NB=256 on 2x4.
+func peak(c tuner.Candidate) float64 {
+	score := 1000.0
+	score -= float64(max(c.N-8448, 8448-c.N)) / 10
+	score -= float64(max(c.NB-256, 256-c.NB)) / 10
+	if c.P != 2 {
+		score -= 50
+	}
+	return score
+}
+
+var space = tuner.Space{
+	ProblemSizes: []int{6912, 7680, 8448},
+	BlockSizes:   []int{128, 256, 384},
+	Grids:        []benchmark.Grid{{P: 2, Q: 4}, {P: 4, Q: 2}},
+}
+
+func TestPack(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []tuner.Candidate
+		expected   []tuner.Space
+	}{
+		{
+			name:       "Product",
+			candidates: space.Candidates(),
+			expected:   []tuner.Space{space},
+		},
+		{
+			name: "Irregular",
+			candidates: []tuner.Candidate{
+				{N: 7680, NB: 256, P: 2, Q: 4},
+				{N: 8448, NB: 256, P: 2, Q: 4},
+				{N: 7680, NB: 384, P: 2, Q: 4},
+				{N: 8448, NB: 384, P: 2, Q: 4},
+				{N: 8448, NB: 256, P: 4, Q: 2},
+			},
+			expected: []tuner.Space{
+				{
+					ProblemSizes: []int{7680, 8448},
+					BlockSizes:   []int{256, 384},
+					Grids:        []benchmark.Grid{{P: 2, Q: 4}},
+				},
+				{
+					ProblemSizes: []int{8448},
+					BlockSizes:   []int{256},
+					Grids:        []benchmark.Grid{{P: 4, Q: 2}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tuner.Pack(tt.candidates))
+		})
+	}
+}
+
+func TestCandidatesDefaultBlockSizes(t *testing.T) {
+	// A 16 GB GPU in fp64, and 8 80 GB GPUs in fp32
+	ns := []int{46341, 414000}
+	space := tuner.Space{
+		ProblemSizes: ns,
+		BlockSizes:   benchmark.DefaultBlockSizes,
+		Grids:        []benchmark.Grid{{P: 1, Q: 1}},
+	}
+
+	candidates := space.Candidates()
+
+	// Each N is rounded to its own NB, losing less than a block
+	require.Len(t, candidates, len(ns)*len(benchmark.DefaultBlockSizes))
+	for i, c := range candidates {
+		n := ns[i/len(benchmark.DefaultBlockSizes)]
+		assert.Zero(t, c.N%c.NB, c)
+		assert.Less(t, n-c.N, c.NB, c)
+	}
+	// The jobs run the NBs sharing their rounded Ns
+	for _, job := range tuner.Pack(candidates) {
+		for _, n := range job.ProblemSizes {
+			for _, nb := range job.BlockSizes {
+				assert.Zero(t, n%nb, job)
+			}
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	a := tuner.Candidate{N: 8448, NB: 256, P: 2, Q: 4, Variant: variant}
+	b := tuner.Candidate{N: 7680, NB: 256, P: 2, Q: 4, Variant: variant}
+	results := []tuner.Result{
+		{Candidate: a, Gflops: 100},
+		{Candidate: b, Gflops: 90},
+		{Candidate: a, Gflops: 60},
+		{Candidate: b, Gflops: 95},
+		{Candidate: a, Gflops: 98},
+		{Candidate: b, Gflops: 70},
+	}
+	tests := []struct {
+		criterion stats.Criterion
+		expected  []tuner.Candidate
+		scores    []float64
+	}{
+		{criterion: stats.CriterionMax, expected: []tuner.Candidate{a, b}, scores: []float64{100, 95}},
+		{criterion: stats.CriterionMean, expected: []tuner.Candidate{a, b}, scores: []float64{86, 85}},
+		{criterion: stats.CriterionMedian, expected: []tuner.Candidate{a, b}, scores: []float64{98, 90}},
+		{criterion: stats.CriterionCILow, expected: []tuner.Candidate{b, a}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.criterion), func(t *testing.T) {
+			ranking := tuner.Rank(results, tt.criterion)
+
+			require.Len(t, ranking, 2)
+			assert.Equal(t, tt.expected, []tuner.Candidate{ranking[0].Candidate, ranking[1].Candidate})
+			if tt.scores != nil {
+				assert.InDeltaSlice(t, tt.scores, []float64{ranking[0].Score, ranking[1].Score}, 1e-9)
+			}
+			assert.Equal(t, 3, ranking[0].Count)
+		})
+	}
+
+	// Candidates which ran once have no confidence interval, they rank after
+	// the others and by their median
+	c := tuner.Candidate{N: 8448, NB: 256, P: 1, Q: 4}
+	d := tuner.Candidate{N: 8448, NB: 256, P: 4, Q: 1}
+	ranking := tuner.Rank(append(results,
+		tuner.Result{Candidate: c, Gflops: 120},
+		tuner.Result{Candidate: d, Gflops: 130},
+	), stats.CriterionCILow)
+	require.Len(t, ranking, 4)
+	assert.Equal(t, []tuner.Candidate{b, a, d, c}, []tuner.Candidate{
+		ranking[0].Candidate,
+		ranking[1].Candidate,
+		ranking[2].Candidate,
+		ranking[3].Candidate,
+	})
+}
+
+func TestRankVariants(t *testing.T) {
+	// The variants of a configuration are ranked apart, the best single result
+	// does not make the variant with the best median
+	noisy := tuner.Candidate{N: 8448, NB: 256, P: 2, Q: 4, Variant: "WR01C2R4"}
+	steady := tuner.Candidate{N: 8448, NB: 256, P: 2, Q: 4, Variant: "WR11C2R4"}
+	results := []tuner.Result{
+		{Candidate: noisy, Gflops: 100},
+		{Candidate: steady, Gflops: 90},
+		{Candidate: noisy, Gflops: 60},
+		{Candidate: steady, Gflops: 92},
+		{Candidate: noisy, Gflops: 62},
+		{Candidate: steady, Gflops: 91},
+	}
+
+	ranking := tuner.Rank(results, stats.CriterionMedian)
+
+	require.Len(t, ranking, 2)
+	assert.Equal(t, steady, ranking[0].Candidate)
+	assert.Equal(t, 91.0, ranking[0].Median)
+	assert.Equal(t, 3, ranking[0].Count)
+	assert.Equal(t, noisy, ranking[1].Candidate)
+}
+
+func TestTunerRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy tuner.Strategy
+		expected tuner.Candidate
+		jobs     int
+	}{
+		{
+			name:     "Grid",
+			strategy: &tuner.Grid{},
+			expected: tuner.Candidate{N: 8448, NB: 256, P: 2, Q: 4, Variant: variant},
+			jobs:     1,
+		},
+		{
+			// The refined Ns are rounded to each NB, in a job per NB
+			name:     "Grid with refinement",
+			strategy: &tuner.Grid{TopK: 2},
+			expected: tuner.Candidate{N: 8448, NB: 256, P: 2, Q: 4, Variant: variant},
+			jobs:     4,
+		},
+		{
+			// 18 candidates, then 9, 5 and 3 in 2 jobs each, 2 and 1
+			name:     "Successive halving",
+			strategy: &tuner.SuccessiveHalving{Eta: 2},
+			expected: tuner.Candidate{N: 8448, NB: 256, P: 2, Q: 4, Variant: variant},
+			jobs:     8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			e := &evaluator{gflops: peak}
+			budget := tuner.NewBudget(0, 0)
+			tr := &tuner.Tuner{Strategy: tt.strategy, Evaluator: e, Budget: budget}
+
+			// Act
+			best, results, err := tr.Run(context.Background(), space)
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, best.Candidate)
+			assert.NotEmpty(t, results)
+			assert.Equal(t, tt.jobs, budget.Jobs())
+		})
+	}
+}
+
+func TestTunerRunRepeats(t *testing.T) {
+	lucky := tuner.Candidate{N: 8448, NB: 256, P: 2, Q: 4}
+	steady := tuner.Candidate{N: 8448, NB: 256, P: 4, Q: 2}
+	lucky.Variant, steady.Variant = variant, variant
+	tests := []struct {
+		name      string
+		criterion stats.Criterion
+		repeats   int
+		expected  tuner.Candidate
+		jobs      int
+	}{
+		{name: "No repeat", criterion: stats.CriterionMedian, repeats: 1, expected: lucky, jobs: 1},
+		{name: "Max", criterion: stats.CriterionMax, repeats: 3, expected: lucky, jobs: 1},
+		{name: "Median", criterion: stats.CriterionMedian, repeats: 3, expected: steady, jobs: 3},
+		{name: "CI low", criterion: stats.CriterionCILow, repeats: 3, expected: steady, jobs: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			// The lucky candidate is the best only in its first evaluation
+			evaluations := map[tuner.Candidate]int{}
+			e := &evaluator{gflops: func(c tuner.Candidate) float64 {
+				c.Variant = variant
+				evaluations[c]++
+				if c == lucky && evaluations[c] == 1 {
+					return 110
+				}
+				if c == lucky {
+					return 90
+				}
+				return 100
+			}}
+			budget := tuner.NewBudget(0, 0)
+			tr := &tuner.Tuner{
+				Strategy:  &tuner.Grid{},
+				Evaluator: e,
+				Budget:    budget,
+				Criterion: tt.criterion,
+				Repeats:   tt.repeats,
+			}
+
+			// Act
+			best, _, err := tr.Run(context.Background(), tuner.Space{
+				ProblemSizes: []int{8448},
+				BlockSizes:   []int{256},
+				Grids:        []benchmark.Grid{{P: 2, Q: 4}, {P: 4, Q: 2}},
+			})
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, best.Candidate)
+			assert.Equal(t, tt.jobs, budget.Jobs())
+		})
+	}
+}
+
+// variantsEvaluator returns the results of two variants of each candidate.
+type variantsEvaluator struct{}
+
+func (variantsEvaluator) Evaluate(_ context.Context, _ int, space tuner.Space) ([]tuner.Result, error) {
+	var results []tuner.Result
+	for _, c := range space.Candidates() {
+		gflops := 100.0
+		if c.P != 2 {
+			gflops = 90
+		}
+		c.Variant = "WR01C2R4"
+		results = append(results, tuner.Result{Candidate: c, Gflops: gflops})
+		c.Variant = "WR11C2R4"
+		results = append(results, tuner.Result{Candidate: c, Gflops: gflops - 50})
+	}
+	return results, nil
+}
+
+func TestTunerRunRepeatsVariants(t *testing.T) {
+	tr := &tuner.Tuner{Strategy: &tuner.Grid{}, Evaluator: variantsEvaluator{}, Repeats: 3}
+
+	best, results, err := tr.Run(context.Background(), tuner.Space{
+		ProblemSizes: []int{8448},
+		BlockSizes:   []int{256},
+		Grids:        []benchmark.Grid{{P: 2, Q: 4}, {P: 4, Q: 2}},
+	})
+
+	// The 3 best variants are repeated, the results of the fourth one run by
+	// the same jobs are left out
+	require.NoError(t, err)
+	assert.Equal(t, tuner.Candidate{N: 8448, NB: 256, P: 2, Q: 4, Variant: "WR01C2R4"}, best.Candidate)
+	counts := map[tuner.Candidate]int{}
+	for _, r := range tuner.Rank(results, stats.CriterionMedian) {
+		counts[r.Candidate] = r.Count
+	}
+	assert.Equal(t, map[tuner.Candidate]int{
+		{N: 8448, NB: 256, P: 2, Q: 4, Variant: "WR01C2R4"}: 3,
+		{N: 8448, NB: 256, P: 4, Q: 2, Variant: "WR01C2R4"}: 3,
+		{N: 8448, NB: 256, P: 2, Q: 4, Variant: "WR11C2R4"}: 3,
+		{N: 8448, NB: 256, P: 4, Q: 2, Variant: "WR11C2R4"}: 1,
+	}, counts)
+}
+
+func TestGridRefinement(t *testing.T) {
+	e := &evaluator{gflops: peak}
+	tr := &tuner.Tuner{Strategy: &tuner.Grid{TopK: 1}, Evaluator: e}
+
+	_, _, err := tr.Run(context.Background(), space)
+
+	require.NoError(t, err)
+	require.Len(t, e.spaces, 4)
+	var refined []tuner.Candidate
+	for _, s := range e.spaces[1:] {
+		refined = append(refined, s.Candidates()...)
+	}
+	// Ns halfway to the neighbours without exceeding the largest N, NBs
+	// halfway to the neighbours, each N rounded to its NB
+	assert.ElementsMatch(t, []tuner.Candidate{
+		{N: 8064, NB: 192, P: 2, Q: 4},
+		{N: 7936, NB: 256, P: 2, Q: 4},
+		{N: 8000, NB: 320, P: 2, Q: 4},
+		{N: 8448, NB: 192, P: 2, Q: 4},
+		{N: 8448, NB: 256, P: 2, Q: 4},
+		{N: 8320, NB: 320, P: 2, Q: 4},
+	}, refined)
+}
+
+func TestRandom(t *testing.T) {
+	strategy, err := tuner.NewStrategy(tuner.StrategyRandom, tuner.Options{Samples: 5, Seed: 1})
+	require.NoError(t, err)
+
+	candidates := strategy.Next(0, space, nil, nil)
+
+	assert.Len(t, candidates, 5)
+	for _, c := range candidates {
+		assert.GreaterOrEqual(t, c.N, 6912)
+		assert.LessOrEqual(t, c.N, 8448)
+		assert.Zero(t, c.N%c.NB, c)
+		assert.Contains(t, space.BlockSizes, c.NB)
+		assert.Contains(t, space.Grids, benchmark.Grid{P: c.P, Q: c.Q})
+	}
+	assert.Empty(t, strategy.Next(1, space, nil, nil))
+}
+
+func TestNewStrategy(t *testing.T) {
+	_, err := tuner.NewStrategy("bayesian", tuner.Options{})
+
+	assert.ErrorContains(t, err, `unknown tuning strategy "bayesian"`)
+}
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   *tuner.Budget
+		spent    int
+		expected bool
+	}{
+		{name: "Unbounded", budget: tuner.NewBudget(0, 0), spent: 100, expected: false},
+		{name: "Nil", budget: nil, spent: 100, expected: false},
+		{name: "Jobs left", budget: tuner.NewBudget(3, 0), spent: 2, expected: false},
+		{name: "No job left", budget: tuner.NewBudget(3, 0), spent: 3, expected: true},
+		{name: "Time left", budget: tuner.NewBudget(0, time.Hour), spent: 100, expected: false},
+		{name: "No time left", budget: tuner.NewBudget(0, time.Nanosecond), spent: 0, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.budget.Spend(tt.spent)
+			time.Sleep(time.Microsecond)
+
+			assert.Equal(t, tt.expected, tt.budget.Exhausted())
+		})
+	}
+}
+
+func TestTunerRunBudget(t *testing.T) {
+	// Arrange
+	e := &evaluator{gflops: peak}
+	tr := &tuner.Tuner{
+		Strategy:  &tuner.SuccessiveHalving{Eta: 2},
+		Evaluator: e,
+		Budget:    tuner.NewBudget(2, 0),
+	}
+
+	// Act
+	best, _, err := tr.Run(context.Background(), space)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Len(t, e.spaces, 2)
+	assert.Equal(t, tuner.Candidate{N: 8448, NB: 256, P: 2, Q: 4, Variant: variant}, best.Candidate)
+}